@@ -7,6 +7,7 @@ const (
 	RCODE_BESTMOVE       = "bm"
 	RCODE_ERROR          = "error"
 	RCODE_DONE           = "done"
+	RCODE_QUEUED         = "queued" // waiting for a worker, see QueuePosition
 )
 
 type RCode string
@@ -1,7 +1,10 @@
 package analyzer
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
 	"github.com/samlotti/chess_anaylzer/uci"
 	"strconv"
 	"time"
@@ -19,11 +22,20 @@ Red: blunder  -> -.9
 
 const (
 	DefaultNumPVLines        = 5
-	DefaultEngine            = "zahar"
+	DefaultEngine            = "zahak"
 	DefaultAnalyzePerMoveSec = 15
+
+	// DefaultEngineRetries - times a position is retried on a new engine
+	// when the engine process dies.
+	DefaultEngineRetries = 2
 )
 
-var Verbose = true
+// AnalysisEngine - the engine of the analyzers, set from the server configuration.
+var AnalysisEngine = DefaultEngine
+
+// EngineRetries - times a position is retried on a new engine when the engine
+// process dies, set from the server configuration.
+var EngineRetries = DefaultEngineRetries
 
 // FenAnalyzer - can analyze a position.
 type FenAnalyzer struct {
@@ -34,22 +46,26 @@ type FenAnalyzer struct {
 	MaxTimeSec int
 	NumPVLines int
 
-	// The name of the engine
+	// The name of the engine, see uci.UciManager().GetEngine
 	Engine string
 
 	// If tru, the client must close the process
 	KeepProcess bool
-	uciProcess  *uci.UciProcess
+	engine      uci.Engine
 
 	// Only set if needed, has performance impact
 	SendNewGameForEachMove bool
+
+	// MaxRetries - the number of fresh engines tried if the engine dies.
+	MaxRetries int
 }
 
 func NewFenAnalyzer() *FenAnalyzer {
 	return &FenAnalyzer{
 		MaxTimeSec: DefaultAnalyzePerMoveSec,
 		NumPVLines: DefaultNumPVLines,
-		Engine:     DefaultEngine,
+		Engine:     AnalysisEngine,
+		MaxRetries: EngineRetries,
 	}
 }
 
@@ -78,6 +94,12 @@ func AResultsError(err error) *AResults {
 // Returns Best move, Your move, top X good moves.
 // Top X the least losing moves
 func (a *FenAnalyzer) Analyze(rchan chan *AResults) {
+	a.AnalyzeContext(context.Background(), rchan)
+}
+
+// AnalyzeContext - as Analyze, ending the context stops the search,
+// the context error is sent before the done.
+func (a *FenAnalyzer) AnalyzeContext(ctx context.Context, rchan chan *AResults) {
 
 	// The best move value used as the baseline.
 	// The diff between the best move and players move
@@ -86,44 +108,88 @@ func (a *FenAnalyzer) Analyze(rchan chan *AResults) {
 
 	// fmt.Printf("MoveNum: %d\n", a.MoveNum)
 
-	if a.uciProcess == nil {
-		u, err := uci.UciManager().GetUci("zahak")
-		// u, err := uci.UciManager().GetUci("stockfish")
-		if err != nil {
-			rchan <- AResultsError(err)
+	defer sendDone(rchan)
+
+	// Closed before the done is sent
+	if !a.KeepProcess {
+		defer a.Close()
+	}
+
+	// A broken position makes the engine fail, or hang
+	if err := ai.ParseFen(ai.NewBoard(), a.Fen); err != nil {
+		rchan <- AResultsError(err)
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := a.analyzeOnce(ctx, rchan)
+		if err == nil {
 			return
 		}
-		a.uciProcess = u
-		if !a.KeepProcess {
-			defer a.Close()
+
+		// The engine died, the position is retried on a fresh engine.
+		var exitErr *uci.EngineExitError
+		if errors.As(err, &exitErr) && attempt < a.MaxRetries && ctx.Err() == nil {
+			common.Logger(ctx).Warn("engine failed, retrying", "attempt", attempt+1, "retries", a.MaxRetries, "err", err)
+			a.Close()
+			continue
+		}
+
+		// The engine did not stop, it is of no use to the next position.
+		if errors.Is(err, uci.ErrStopTimeout) {
+			a.Close()
 		}
+
+		rchan <- AResultsError(err)
+		return
 	}
+}
 
-	defer sendDone(rchan)
+// analyzeOnce - runs the analysis on the current engine, starting one if needed.
+func (a *FenAnalyzer) analyzeOnce(ctx context.Context, rchan chan *AResults) error {
+
+	if a.engine == nil {
+		e, err := uci.UciManager().GetEngine(ctx, a.Engine)
+		if err != nil {
+			return err
+		}
+		a.engine = e
+	}
 
 	var err error = nil
 
 	// This is a performance hit
 	if a.SendNewGameForEachMove {
-		err = a.uciProcess.SendUciNewGame()
+		err = a.engine.SendUciNewGame(ctx)
 		if err != nil {
-			rchan <- AResultsError(err)
-			return
+			return err
 		}
 	}
 
 	hasUserMode := false
 	priorDepth := -1
-	cbf := func(cb chan *uci.UciCallback) {
-		if Verbose {
-			println("Waiting for UCI responses")
-		}
+	cbf := func(cb chan *uci.UciCallback, quit chan struct{}, cbDone chan struct{}) {
+		defer close(cbDone)
 
 		for {
-			cbc := <-cb
-			if Verbose {
-				fmt.Printf("From UCI: %v\n", cbc)
+			var cbc *uci.UciCallback
+			select {
+			case cbc = <-cb:
+			case <-quit:
+				// Let a late send from the engine complete
+				for {
+					select {
+					case <-cb:
+					default:
+						return
+					}
+				}
 			}
+			if cbc.Info != nil && cbc.Info.Err == nil && len(cbc.Info.Moves) == 0 {
+				// No pv, ex: info currmove or info string
+				continue
+			}
+
 			answer := &AResults{}
 
 			answer.UserMove = a.UserMove
@@ -152,7 +218,7 @@ func (a *FenAnalyzer) Analyze(rchan chan *AResults) {
 				answer.Info.ScoreCP = cbc.Info.ScoreCP
 				answer.Info.MPv = cbc.Info.MPv
 				answer.Info.MateIn = cbc.Info.MateIn
-				answer.Info.IsUserMove = a.UserMove == cbc.Info.Moves[0]
+				answer.Info.IsUserMove = len(cbc.Info.Moves) > 0 && a.UserMove == cbc.Info.Moves[0]
 				hasUserMode = hasUserMode || answer.Info.IsUserMove
 			}
 
@@ -167,89 +233,79 @@ func (a *FenAnalyzer) Analyze(rchan chan *AResults) {
 
 			rchan <- answer
 
-			if answer.Done {
-				if Verbose {
-					println("analyzer done")
-				}
+			if cbc.BestMove != nil {
+				// The search is complete
 				return
 			}
 		}
 	}
 
-	err = a.uciProcess.SetPositionFen(a.Fen)
+	// runSearch - sends the go and waits for the results to be passed on.
+	runSearch := func(opts *uci.GoOptions) error {
+		hasUserMode = false
+		priorDepth = -1
+		ucicb := make(chan *uci.UciCallback, 10)
+		quit := make(chan struct{})
+		cbDone := make(chan struct{})
+		go cbf(ucicb, quit, cbDone)
+		a.engine.SetAsyncChannel(ucicb)
+
+		err := a.engine.SendGo(opts)
+		if err == nil {
+			if a.MaxTimeSec <= 0 {
+				a.MaxTimeSec = DefaultAnalyzePerMoveSec
+			}
+			_, err = a.engine.WaitMoveUpTo(ctx, time.Duration(a.MaxTimeSec)*time.Second)
+		}
+		if err == nil {
+			// The bestmove is in the callback channel, wait for it to be sent on
+			<-cbDone
+		}
+
+		a.engine.SetAsyncChannel(nil)
+		close(quit)
+		// Nothing of this search is sent after the next search, or the done, starts
+		<-cbDone
+		return err
+	}
+
+	err = a.engine.SetPositionFen(a.Fen)
 	if err != nil {
-		rchan <- AResultsError(err)
-		return
+		return err
 	}
 
-	err = a.uciProcess.SetOption("MultiPV", strconv.Itoa(a.NumPVLines))
+	err = a.engine.SetOption("MultiPV", strconv.Itoa(a.NumPVLines))
 	if err != nil {
-		rchan <- AResultsError(err)
-		return
+		return err
 	}
 
-	hasUserMode = false
-	priorDepth = -1
-	ucicb := make(chan *uci.UciCallback, 10)
-	go cbf(ucicb)
-	a.uciProcess.SetAsyncChannel(ucicb)
-	opts := &uci.GoOptions{
+	err = runSearch(&uci.GoOptions{
 		Depth:      a.Depth,
 		SearchMove: "",
 		// Fen:        a.Fen,
-	}
-	err = a.uciProcess.SendGo(opts)
+	})
 	if err != nil {
-		rchan <- AResultsError(err)
-		return
-	}
-
-	if a.MaxTimeSec <= 0 {
-		a.MaxTimeSec = DefaultAnalyzePerMoveSec
-	}
-
-	err = a.uciProcess.WaitMoveUpTo(time.Duration(a.MaxTimeSec) * time.Second)
-	if err != nil {
-		rchan <- AResultsError(err)
-		return
+		return err
 	}
 
 	if !hasUserMode && len(a.UserMove) > 0 {
-		err = a.uciProcess.SetOption("MultiPV", "1")
+		err = a.engine.SetOption("MultiPV", "1")
 		if err != nil {
-			rchan <- AResultsError(err)
-			return
+			return err
 		}
 
-		hasUserMode = false
-		priorDepth = -1
-		ucicb = make(chan *uci.UciCallback, 10)
-		go cbf(ucicb)
-		a.uciProcess.SetAsyncChannel(ucicb)
-		opts := &uci.GoOptions{
+		err = runSearch(&uci.GoOptions{
 			Depth:      a.Depth,
 			SearchMove: a.UserMove,
 			// Fen:        a.Fen,
-		}
-		err = a.uciProcess.SendGo(opts)
-		if err != nil {
-			rchan <- AResultsError(err)
-			return
-		}
-
-		if a.MaxTimeSec <= 0 {
-			a.MaxTimeSec = DefaultAnalyzePerMoveSec
-		}
-
-		err = a.uciProcess.WaitMoveUpTo(time.Duration(a.MaxTimeSec) * time.Second)
-		fmt.Println("done", err)
+		})
 		if err != nil {
-			rchan <- AResultsError(err)
-			return
+			return err
 		}
 
 	}
 
+	return nil
 }
 
 func sendDone(rchan chan *AResults) {
@@ -261,10 +317,9 @@ func sendDone(rchan chan *AResults) {
 
 // Close - close the engine
 func (a *FenAnalyzer) Close() {
-	if a.uciProcess != nil {
-		fmt.Println("Closing the process")
-		uci.UciManager().Return(a.uciProcess)
-		a.uciProcess = nil
+	if a.engine != nil {
+		uci.UciManager().Return(a.engine)
+		a.engine = nil
 	}
 
 }
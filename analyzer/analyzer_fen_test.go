@@ -1,7 +1,10 @@
 package analyzer
 
 import (
+	"errors"
 	"fmt"
+	"github.com/samlotti/chess_anaylzer/uci"
+	"github.com/samlotti/chess_anaylzer/uci/fakeengine"
 	"testing"
 )
 import "github.com/stretchr/testify/assert"
@@ -45,3 +48,149 @@ func TestAnalyzer1(t *testing.T) {
 	ar := collectFenResults(rchan)
 	assert.NotNil(t, ar)
 }
+
+const testFen = "2r3k1/p4p2/3Rp2p/1p2P1pK/8/1P4P1/P3Q2P/1q6 b - - 0 1"
+
+func scriptedFen() *fakeengine.Script {
+	script := fakeengine.DefaultScript()
+	script.Positions[testFen] = &fakeengine.Response{
+		Lines: []string{
+			"info depth 1 currmove b1h1 currmovenumber 1",
+			"info depth 1 score cp 120 multipv 1 pv b1h1",
+			"info depth 1 score cp -30 multipv 2 pv c8c1",
+			"info depth 2 score mate 2 multipv 1 pv b1h1 e2h2",
+			"bestmove b1h1 ponder e2h2",
+		},
+	}
+	return script
+}
+
+func TestAnalyzerScripted(t *testing.T) {
+	useTestScripts(t, scriptedFen())
+
+	a := NewFenAnalyzer()
+	a.Fen = testFen
+	rchan := make(chan *AResults, 10)
+	go a.Analyze(rchan)
+	ar := collectFenResults(rchan)
+
+	assert.Equal(t, []RCode{RCODE_INFO, RCODE_INFO, RCODE_INFO, RCODE_BESTMOVE, RCODE_DONE}, rcodes(ar))
+	assert.Equal(t, 120, ar[0].Info.ScoreCP)
+	assert.Equal(t, 2, ar[1].Info.MPv)
+	assert.Equal(t, 2, ar[2].Info.MateIn)
+	assert.Equal(t, "b1h1", ar[3].BestMode.BestMove)
+	assert.Equal(t, "e2h2", ar[3].BestMode.Ponder)
+
+	e := startedEngines()[0]
+	assert.Equal(t, "5", e.Option("MultiPV"))
+	assert.Contains(t, e.Commands(), "position fen "+testFen)
+}
+
+func TestAnalyzerUserMove(t *testing.T) {
+	script := scriptedFen()
+	script.Positions[testFen+" searchmoves g8g7"] = &fakeengine.Response{
+		Lines: []string{"info depth 1 score cp -400 multipv 1 pv g8g7", "bestmove g8g7"},
+	}
+	useTestScripts(t, script)
+
+	a := NewFenAnalyzer()
+	a.Fen = testFen
+	a.UserMove = "g8g7"
+	rchan := make(chan *AResults, 10)
+	go a.Analyze(rchan)
+	ar := collectFenResults(rchan)
+
+	assert.Equal(t, []RCode{RCODE_INFO, RCODE_INFO, RCODE_INFO, RCODE_BESTMOVE, RCODE_INFO, RCODE_BESTMOVE, RCODE_DONE}, rcodes(ar))
+	assert.False(t, ar[0].Info.IsUserMove)
+	assert.True(t, ar[4].Info.IsUserMove)
+	assert.Equal(t, -400, ar[4].Info.ScoreCP)
+	assert.Contains(t, startedEngines()[0].Commands(), "go searchmoves g8g7")
+}
+
+func TestAnalyzerMalformedInfo(t *testing.T) {
+	script := fakeengine.DefaultScript()
+	script.Default = &fakeengine.Response{
+		Lines: []string{"info depth x score cp 1 multipv 1 pv e2e4", "info depth 2 score cp 15 multipv 1 pv e2e4", "bestmove e2e4"},
+	}
+	useTestScripts(t, script)
+
+	a := NewFenAnalyzer()
+	a.Fen = testFen
+	rchan := make(chan *AResults, 10)
+	go a.Analyze(rchan)
+	ar := collectFenResults(rchan)
+
+	assert.Equal(t, []RCode{RCODE_ERROR, RCODE_INFO, RCODE_BESTMOVE, RCODE_DONE}, rcodes(ar))
+}
+
+func TestAnalyzerEngineCrashRetried(t *testing.T) {
+	crash := fakeengine.DefaultScript()
+	crash.Default = &fakeengine.Response{Crash: true, ExitCode: 3, Stderr: "segfault"}
+	useTestScripts(t, crash, scriptedFen())
+
+	a := NewFenAnalyzer()
+	a.Fen = testFen
+	rchan := make(chan *AResults, 10)
+	go a.Analyze(rchan)
+	ar := collectFenResults(rchan)
+
+	assert.Equal(t, []RCode{RCODE_INFO, RCODE_INFO, RCODE_INFO, RCODE_BESTMOVE, RCODE_DONE}, rcodes(ar))
+	assert.Equal(t, 2, len(startedEngines()))
+}
+
+func TestAnalyzerEngineCrashGivesUp(t *testing.T) {
+	crash := fakeengine.DefaultScript()
+	crash.Default = &fakeengine.Response{Crash: true, ExitCode: 3, Stderr: "segfault"}
+	useTestScripts(t, crash)
+
+	a := NewFenAnalyzer()
+	a.Fen = testFen
+	rchan := make(chan *AResults, 10)
+	go a.Analyze(rchan)
+	ar := collectFenResults(rchan)
+
+	assert.Equal(t, []RCode{RCODE_ERROR, RCODE_DONE}, rcodes(ar))
+	var exitErr *uci.EngineExitError
+	assert.True(t, errors.As(ar[0].Err, &exitErr))
+	assert.Equal(t, "segfault\n", exitErr.Stderr)
+	assert.Equal(t, 1+DefaultEngineRetries, len(startedEngines()))
+}
+
+func TestAnalyzerEngineRetries(t *testing.T) {
+	crash := fakeengine.DefaultScript()
+	crash.Default = &fakeengine.Response{Crash: true, ExitCode: 3, Stderr: "segfault"}
+	useTestScripts(t, crash)
+	defer func(n int) { EngineRetries = n }(EngineRetries)
+	EngineRetries = 0
+
+	a := NewFenAnalyzer()
+	a.Fen = testFen
+	rchan := make(chan *AResults, 10)
+	go a.Analyze(rchan)
+	ar := collectFenResults(rchan)
+
+	assert.Equal(t, []RCode{RCODE_ERROR, RCODE_DONE}, rcodes(ar))
+	assert.Equal(t, 1, len(startedEngines()))
+}
+
+func TestAnalyzerEngineFactory(t *testing.T) {
+	var requested []string
+	uci.UciManager().SetEngineFactory(func(engine string) (uci.Engine, error) {
+		requested = append(requested, engine)
+		return uci.NewUciTransport(engine, fakeengine.New(scriptedFen()).Transport()), nil
+	})
+	t.Cleanup(func() {
+		uci.UciManager().SetEngineFactory(nil)
+	})
+
+	a := NewFenAnalyzer()
+	a.Fen = testFen
+	a.Engine = "stockfish"
+	rchan := make(chan *AResults, 20)
+	go a.Analyze(rchan)
+	results := collectFenResults(rchan)
+
+	assert.Equal(t, []string{"stockfish"}, requested)
+	assert.Equal(t, RCode(RCODE_DONE), results[len(results)-1].RCode)
+	assert.Equal(t, "b1h1", results[len(results)-2].BestMode.BestMove)
+}
@@ -1,8 +1,9 @@
 package analyzer
 
 import (
-	"fmt"
+	"context"
 	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
 )
 
 /*
@@ -16,8 +17,6 @@ Also dont keep creating instances of the engine
 
 */
 
-var pgnChan = make(chan *PgnData, 1)
-
 // PgnResponse is from worker to consumer
 // Will send these over the channel
 type PgnResponse struct {
@@ -28,16 +27,31 @@ type PgnResponse struct {
 	MoveNum    int         `json:"moveNum"`
 	PlayedMove string      `json:"playedmove"`
 	Done       bool        `json:"done"` // The end of the messages
+
+	// The place in the queue with RCODE_QUEUED, 1 is next
+	QueuePosition int `json:"queuePosition,omitempty"`
 }
 
 type PgnData struct {
 	Pgn   string
 	Depth int
 
+	// Engine - the engine of the analysis, empty for AnalysisEngine
+	Engine string
+
 	RChannel chan *PgnResponse
 
+	// Ctx - ending it stops the analysis, nil never ends.
+	Ctx context.Context
+
 	MaxTimeSec int
 	NumLines   int
+
+	// Client - who sent it, the clients take turns in the queue.
+	Client string
+
+	// Priority - higher is served first, ex: PriorityHigh
+	Priority int
 }
 
 // PgnAnalyzer - can analyze a position.
@@ -64,11 +78,11 @@ func (f *PgnAnalyzer) DoAnalyze(msg *PgnData) {
 	wrapper := ai.NewPgnWrapper(msg.Pgn)
 	err := wrapper.Parse()
 	if err != nil {
-		msg.RChannel <- &PgnResponse{
+		msg.send(&PgnResponse{
 			RCode: RCODE_ERROR,
 			Error: err.Error(),
 			Done:  true,
-		}
+		})
 		return
 	}
 
@@ -78,11 +92,11 @@ func (f *PgnAnalyzer) DoAnalyze(msg *PgnData) {
 		ims := ai.MoveToInputString(mv)
 		err = brd.MakeMove(mv, ims)
 		if err != nil {
-			msg.RChannel <- &PgnResponse{
+			msg.send(&PgnResponse{
 				RCode: RCODE_ERROR,
 				Error: err.Error(),
 				Done:  true,
-			}
+			})
 			return
 		}
 		// fen := ai.BoardToFen(brd, i)
@@ -99,12 +113,34 @@ func (f *PgnAnalyzer) DoAnalyze(msg *PgnData) {
 
 	var fenAnalyzer = NewFenAnalyzer()
 	fenAnalyzer.KeepProcess = true
-	defer fenAnalyzer.Close()
+	if len(msg.Engine) > 0 {
+		fenAnalyzer.Engine = msg.Engine
+	}
+
+	last := f.analyzeMoves(fenAnalyzer, wrapper, msg)
+
+	// The engine is returned before the final message
+	fenAnalyzer.Close()
+	msg.send(last)
+
+}
+
+// analyzeMoves - analyzes each move of the game.
+// Returns the final message, the done or an error.
+func (f *PgnAnalyzer) analyzeMoves(fenAnalyzer *FenAnalyzer, wrapper *ai.PgnWrapper, msg *PgnData) *PgnResponse {
 
-	brd = createNewBoard(wrapper)
+	ctx := msg.context()
+	brd := createNewBoard(wrapper)
 	// The initial board fen ... will analyze using fen
 	fen := ai.BoardToFen(brd, 0)
 	for i, mv := range wrapper.InternalMoves {
+		if err := ctx.Err(); err != nil {
+			return &PgnResponse{
+				RCode: RCODE_ERROR,
+				Error: err.Error(),
+				Done:  true,
+			}
+		}
 
 		ims := ai.MoveToInputString(mv)
 		algstr := ai.MoveToString(mv)
@@ -116,45 +152,56 @@ func (f *PgnAnalyzer) DoAnalyze(msg *PgnData) {
 		fenAnalyzer.UserMove = algstr
 		fenAnalyzer.MoveNum = i + 1
 
-		fmt.Printf("In: %s = %s \n", ims, fen)
-		err = f.doAnalyzeThisMove(fenAnalyzer, msg)
+		common.Logger(ctx).Debug("analyzing move", "ply", i+1, "move", ims, "fen", fen)
+		err := f.doAnalyzeThisMove(fenAnalyzer, msg)
 		if err != nil {
-			msg.RChannel <- &PgnResponse{
+			return &PgnResponse{
 				RCode: RCODE_ERROR,
 				Error: err.Error(),
 				Done:  true,
 			}
-			return
 		}
 		// fmt.Printf("Out: %s = %s \n", ims, fen)
 
 		err = brd.MakeMove(mv, ims)
 		if err != nil {
-			msg.RChannel <- &PgnResponse{
+			return &PgnResponse{
 				RCode: RCODE_ERROR,
 				Error: err.Error(),
 				Done:  true,
 			}
-			return
 		}
 
 		fen = ai.BoardToFen(brd, i)
 
 	}
-	msg.RChannel <- &PgnResponse{
+	return &PgnResponse{
 		RCode: RCODE_DONE,
 		Done:  true,
 	}
+}
 
+// context - the context of the request.
+func (msg *PgnData) context() context.Context {
+	if msg.Ctx == nil {
+		return context.Background()
+	}
+	return msg.Ctx
+}
+
+// send - passes the response on, dropped when the request has ended
+// as nobody reads the channel any more.
+func (msg *PgnData) send(resp *PgnResponse) {
+	select {
+	case msg.RChannel <- resp:
+	case <-msg.context().Done():
+	}
 }
 
 func createNewBoard(wrapper *ai.PgnWrapper) *ai.Board {
 	brd := ai.NewBoard()
-	if len(wrapper.StartFen) > 0 {
-		ai.ParseFen(brd, wrapper.StartFen)
-	} else {
-		ai.ParseFen(brd, ai.StartFen)
-	}
+	// Checked when the pgn was parsed
+	ai.ParseFen(brd, wrapper.InitialFen())
 	return brd
 }
 
@@ -189,7 +236,7 @@ func (f *PgnAnalyzer) doAnalyzeThisMove(fenAnalyzer *FenAnalyzer, msg *PgnData)
 			// fmt.Printf("send from fen: %+v\n", fr)
 
 			if fr.RCode != RCODE_DONE {
-				msg.RChannel <- fr
+				msg.send(fr)
 			}
 
 			if m.Done {
@@ -200,7 +247,7 @@ func (f *PgnAnalyzer) doAnalyzeThisMove(fenAnalyzer *FenAnalyzer, msg *PgnData)
 		}
 	}()
 
-	fenAnalyzer.Analyze(rchan)
+	fenAnalyzer.AnalyzeContext(msg.context(), rchan)
 
 	// wait for complete
 	<-dchan
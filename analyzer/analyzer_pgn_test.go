@@ -3,7 +3,7 @@ package analyzer
 import (
 	"fmt"
 	"github.com/samlotti/chess_anaylzer/chessboard/common"
-	"github.com/samlotti/chess_anaylzer/uci"
+	"log/slog"
 	"testing"
 )
 import "github.com/stretchr/testify/assert"
@@ -57,9 +57,7 @@ Nf2 42. g4 Bd3 43. Re6 1/2-1/2
 // Takes 1min 10sec
 func TestPgn2(t *testing.T) {
 
-	Verbose = false
-	common.Verbose = false
-	uci.Verbose = false
+	common.LogLevel.Set(slog.LevelWarn)
 
 	a := &PgnData{}
 	assert.NotNil(t, a)
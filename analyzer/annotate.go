@@ -0,0 +1,136 @@
+package analyzer
+
+import (
+	"fmt"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"io"
+	"sort"
+	"strings"
+)
+
+// The numeric annotation glyphs of the classes
+var classNags = map[MoveClass]string{
+	ClassInaccuracy: "$6", // ?!
+	ClassMistake:    "$2", // ?
+	ClassBlunder:    "$4", // ??
+}
+
+// The tags written first, in this order
+var pgnRosterTags = []string{"Event", "Site", "Date", "Round", "White", "Black", "Result"}
+
+// pgnLineWidth - the movetext is wrapped at this width
+const pgnLineWidth = 79
+
+// WriteAnnotatedPgn
+// Writes the game of the report with the analysis: an eval comment for each move,
+// the glyph of the inaccuracies, mistakes and blunders and the engine line.
+// The line is in the comment, not a variation, so the PgnWrapper reads the game back.
+func WriteAnnotatedPgn(w io.Writer, report *GameReport) error {
+	sb := strings.Builder{}
+
+	tags := make(map[string]string)
+	for key, val := range report.Attributes {
+		tags[key] = val
+	}
+	for _, key := range pgnRosterTags {
+		if _, ok := tags[key]; !ok {
+			tags[key] = "?"
+		}
+	}
+	if tags["Result"] == "?" {
+		tags["Result"] = "*"
+	}
+	if _, ok := tags["FEN"]; !ok && report.InitialFen != ai.StartFen {
+		tags["SetUp"] = "1"
+		tags["FEN"] = report.InitialFen
+	}
+	tags["Annotator"] = "chessanalyzer"
+	tags["WhiteAccuracy"] = fmt.Sprintf("%.1f", report.White.Accuracy)
+	tags["BlackAccuracy"] = fmt.Sprintf("%.1f", report.Black.Accuracy)
+
+	others := make([]string, 0)
+	for key := range tags {
+		if !isRosterTag(key) {
+			others = append(others, key)
+		}
+	}
+	sort.Strings(others)
+	for _, key := range append(append([]string{}, pgnRosterTags...), others...) {
+		fmt.Fprintf(&sb, "[%s \"%s\"]\n", key, strings.ReplaceAll(tags[key], `"`, `\"`))
+	}
+	sb.WriteString("\n")
+
+	tokens := make([]string, 0)
+	needNumber := true
+	for _, rm := range report.Moves {
+		if rm.White {
+			tokens = append(tokens, fmt.Sprintf("%d.", rm.Number))
+		} else if needNumber {
+			tokens = append(tokens, fmt.Sprintf("%d...", rm.Number))
+		}
+		needNumber = false
+		tokens = append(tokens, rm.San)
+		if !rm.Analyzed {
+			continue
+		}
+
+		if nag, ok := classNags[rm.Class]; ok {
+			tokens = append(tokens, nag)
+		}
+		comment := fmt.Sprintf("[%%eval %.2f]", float64(rm.EvalCP)/100)
+		if _, ok := classNags[rm.Class]; ok && len(rm.BestSan) > 0 {
+			class := string(rm.Class)
+			comment += fmt.Sprintf(" %s. %s was best.", strings.ToUpper(class[:1])+class[1:], rm.BestSan)
+		}
+		if len(rm.Line) > 0 {
+			comment += " Line: " + strings.Join(lineTokens(rm, rm.Line), " ")
+		}
+		tokens = append(tokens, "{ "+comment+" }")
+		needNumber = true
+	}
+	tokens = append(tokens, tags["Result"])
+
+	line := ""
+	for _, tk := range tokens {
+		if len(line) > 0 && len(line)+1+len(tk) > pgnLineWidth {
+			sb.WriteString(line + "\n")
+			line = ""
+		}
+		if len(line) > 0 {
+			line += " "
+		}
+		line += tk
+	}
+	sb.WriteString(line + "\n\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// lineTokens - the moves of the line with their numbers, the line replaces the move
+func lineTokens(rm *ReportMove, line []string) []string {
+	res := make([]string, 0, len(line)*2)
+	number, white := rm.Number, rm.White
+	for idx, san := range line {
+		if white {
+			res = append(res, fmt.Sprintf("%d.", number))
+		} else if idx == 0 {
+			res = append(res, fmt.Sprintf("%d...", number))
+		}
+		res = append(res, san)
+		if !white {
+			number++
+		}
+		white = !white
+	}
+	return res
+}
+
+func isRosterTag(key string) bool {
+	for _, tag := range pgnRosterTags {
+		if tag == key {
+			return true
+		}
+	}
+	return false
+}
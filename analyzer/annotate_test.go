@@ -0,0 +1,44 @@
+package analyzer
+
+import (
+	"bytes"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestWriteAnnotatedPgn(t *testing.T) {
+	pgn := "[Event \"Test\"]\n[White \"Anna\"]\n[Black \"Ben\"]\n[Result \"1-0\"]\n\n1. e4 f6 2. d4 g5 3. Qh5# 1-0\n"
+	report, err := BuildReport(pgn, &GameSummary{Moves: []*MoveSummary{
+		{MoveNum: 1, PlayedMove: "e2e4", BestMove: "e2e4", ScoreCP: 30, HasPlayed: true, PlayedScore: 30},
+		{MoveNum: 2, PlayedMove: "f7f6", BestMove: "e7e5", Line: []string{"e7e5", "g1f3"}, ScoreCP: -30, HasPlayed: true, PlayedScore: -70},
+		{MoveNum: 4, PlayedMove: "g7g5", BestMove: "e7e6", Line: []string{"e7e6", "b1c3", "f8b4"}, ScoreCP: -90, HasPlayed: true, PlayedScore: -14999},
+	}})
+	assert.Nil(t, err)
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, WriteAnnotatedPgn(buf, report))
+	out := buf.String()
+
+	assert.True(t, strings.HasPrefix(out, "[Event \"Test\"]\n[Site \"?\"]\n[Date \"?\"]\n[Round \"?\"]\n[White \"Anna\"]\n[Black \"Ben\"]\n[Result \"1-0\"]\n[Annotator \"chessanalyzer\"]\n"))
+	assert.Contains(t, out, "[BlackAccuracy \"")
+	assert.Contains(t, out, "\n\n1. e4 { [%eval 0.30] } 1... f6 $2\n{ [%eval 0.70] Mistake. e5 was best. Line: 1... e5 2. Nf3 } 2. d4 g5 $4\n")
+	assert.Contains(t, out, "{ [%eval 15.00] Blunder. e6 was best. Line: 2... e6 3. Nc3 Bb4 }")
+	assert.True(t, strings.HasSuffix(out, "3. Qh5# 1-0\n\n"))
+	for _, line := range strings.Split(out, "\n") {
+		assert.LessOrEqual(t, len(line), pgnLineWidth)
+	}
+
+	// The annotated game reads back
+	pw := ai.NewPgnWrapper(out)
+	assert.Nil(t, pw.Parse())
+	assert.Equal(t, []string{"e2e4", "f7f6", "d2d4", "g7g5", "d1h5"}, pw.Moves)
+	assert.Equal(t, "chessanalyzer", pw.Attributes["Annotator"])
+
+	// A position without the tag gets the fen
+	report.InitialFen = "4k3/8/8/8/8/8/8/4K2R w K - 0 1"
+	buf.Reset()
+	assert.Nil(t, WriteAnnotatedPgn(buf, report))
+	assert.Contains(t, buf.String(), "[FEN \"4k3/8/8/8/8/8/8/4K2R w K - 0 1\"]\n[SetUp \"1\"]\n")
+}
@@ -0,0 +1,213 @@
+package analyzer
+
+/**
+Batch fen analysis.
+
+The positions of a batch are fed to the fen workers a few at a time, so a
+batch neither takes all the workers nor fills the queue, and the result of
+each position is sent as it completes, tagged with its index and id.
+*/
+
+import (
+	"context"
+	"errors"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BatchRetryInterval - how long a batch waits to queue again when the queue is full.
+var BatchRetryInterval = 100 * time.Millisecond
+
+// BatchPosition - a position of a batch
+type BatchPosition struct {
+	Id   string `json:"id,omitempty" doc:"returned with the result, the index identifies it otherwise"`
+	Fen  string `json:"fen"`
+	Move string `json:"move,omitempty" doc:"a move in uci notation, its line is returned as move"`
+}
+
+// BatchData - positions analyzed with the same settings
+type BatchData struct {
+	Positions []*BatchPosition
+
+	Depth      int
+	MaxTimeSec int
+	NumLines   int
+
+	// RChannel - a BatchResult per position, in the order completed, then one with Done
+	RChannel chan *BatchResult
+
+	// Ctx - ending it stops the batch. nil never ends.
+	Ctx context.Context
+
+	Client   string
+	Priority int
+
+	// Parallel - the positions queued at once, 0 is one per fen worker.
+	Parallel int
+}
+
+// FenResult - the analysis of a position
+type FenResult struct {
+	Fen      string    `json:"fen"`
+	BestMove string    `json:"bestMove"`
+	Ponder   string    `json:"ponder,omitempty"`
+	Depth    int       `json:"depth" doc:"the deepest line"`
+	Lines    []*ARInfo `json:"lines" doc:"the deepest search of each pv, best first"`
+	Move     *ARInfo   `json:"move,omitempty" doc:"the line of the requested move"`
+}
+
+// BatchResult - the result of a position of the batch
+type BatchResult struct {
+	Index  int        `json:"index" doc:"of the position in the batch"`
+	Id     string     `json:"id,omitempty"`
+	Result *FenResult `json:"result,omitempty"`
+	Error  string     `json:"error,omitempty"`
+	Done   bool       `json:"done,omitempty" doc:"the last, after all the positions"`
+
+	// Err - the error of Error, ex: ErrQueueFull
+	Err error `json:"-"`
+}
+
+// context - the context of the batch.
+func (bd *BatchData) context() context.Context {
+	if bd.Ctx == nil {
+		return context.Background()
+	}
+	return bd.Ctx
+}
+
+// AnalyzeBatch - analyzes the positions, the results are sent to the RChannel.
+// Returns at once, the batch runs until the last result or the context ends.
+func AnalyzeBatch(bd *BatchData) {
+	parallel := bd.Parallel
+	if parallel <= 0 {
+		parallel = fenQueue.workerCount()
+	}
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	go func() {
+		ctx := bd.context()
+		sem := make(chan struct{}, parallel)
+		var wg sync.WaitGroup
+
+		for idx, pos := range bd.Positions {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			wg.Add(1)
+			go func(idx int, pos *BatchPosition) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				res := analyzeBatchPosition(common.WithLogAttrs(ctx, "position", idx), bd, pos)
+				res.Index = idx
+				res.Id = pos.Id
+				bd.send(ctx, res)
+			}(idx, pos)
+		}
+		wg.Wait()
+		bd.send(ctx, &BatchResult{Index: len(bd.Positions), Done: true})
+	}()
+}
+
+// send - passes the result on, dropped when the batch has ended.
+func (bd *BatchData) send(ctx context.Context, res *BatchResult) {
+	select {
+	case bd.RChannel <- res:
+	case <-ctx.Done():
+	}
+}
+
+// analyzeBatchPosition - queues the position, waiting while the queue is full.
+func analyzeBatchPosition(ctx context.Context, bd *BatchData, pos *BatchPosition) *BatchResult {
+	fd := &FenData{
+		Fen:        pos.Fen,
+		UserMove:   pos.Move,
+		Depth:      bd.Depth,
+		NumLines:   bd.NumLines,
+		MaxTimeSec: bd.MaxTimeSec,
+		RChannel:   make(chan *FenResponse),
+		Ctx:        ctx,
+		Client:     bd.Client,
+		Priority:   bd.Priority,
+	}
+
+	for {
+		err := QueueFen(fd)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrQueueFull) && !errors.Is(err, ErrClientLimit) {
+			return &BatchResult{Error: err.Error(), Err: err}
+		}
+		select {
+		case <-time.After(BatchRetryInterval):
+		case <-ctx.Done():
+			return &BatchResult{Error: ctx.Err().Error(), Err: ctx.Err()}
+		}
+	}
+
+	result, err := CollectFen(ctx, fd)
+	if err != nil {
+		return &BatchResult{Error: err.Error(), Err: err}
+	}
+	return &BatchResult{Result: result}
+}
+
+// CollectFen - waits for the analysis of the queued fen.
+// The lines are those of the deepest search, the move is the line of the
+// user move, which may be a search of its own.
+func CollectFen(ctx context.Context, fd *FenData) (*FenResult, error) {
+	result := &FenResult{Fen: fd.Fen, Lines: make([]*ARInfo, 0)}
+	lines := make(map[int]*ARInfo)
+	var failed error
+	for {
+		var resp *FenResponse
+		select {
+		case resp = <-fd.RChannel:
+		case <-ctx.Done():
+			RemoveQueuedFen(fd)
+			return nil, ctx.Err()
+		}
+
+		switch {
+		case resp.RCode == RCODE_ERROR:
+			failed = errors.New(resp.Error)
+		case resp.ARInfo != nil:
+			info := resp.ARInfo
+			if info.IsUserMove && (result.Move == nil || info.Depth >= result.Move.Depth) {
+				result.Move = info
+			}
+			if prior, ok := lines[info.MPv]; (!ok || info.Depth >= prior.Depth) && result.BestMove == "" {
+				// The lines of the first search, a search of the move may follow
+				lines[info.MPv] = info
+				if info.Depth > result.Depth {
+					result.Depth = info.Depth
+				}
+			}
+		case resp.ARBestMove != nil && result.BestMove == "":
+			result.BestMove = resp.ARBestMove.BestMove
+			result.Ponder = resp.ARBestMove.Ponder
+		}
+
+		if resp.Done {
+			break
+		}
+	}
+	if failed != nil {
+		return nil, failed
+	}
+
+	for _, info := range lines {
+		result.Lines = append(result.Lines, info)
+	}
+	sort.Slice(result.Lines, func(i, j int) bool { return result.Lines[i].MPv < result.Lines[j].MPv })
+	return result, nil
+}
@@ -0,0 +1,70 @@
+package analyzer
+
+import (
+	"github.com/samlotti/chess_anaylzer/uci/fakeengine"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAnalyzeBatch(t *testing.T) {
+	script := fakeengine.DefaultScript()
+	script.Positions["4k3/8/8/8/8/8/8/4K2R w K - 0 1"] = &fakeengine.Response{
+		Lines: []string{
+			"info depth 1 score cp 500 multipv 1 pv h1h8",
+			"info depth 1 score cp 400 multipv 2 pv e1g1",
+			"info depth 2 score cp 510 multipv 1 pv h1h8 e8d7",
+			"bestmove h1h8",
+		},
+	}
+	useTestScripts(t, script)
+	fenCancelWorkers.Do(func() {
+		CreateFenWorkers(1)
+	})
+
+	// One queued a client, the batch waits for room
+	SetQueueLimits(DefaultQueueDepth, 1)
+	defer SetQueueLimits(DefaultQueueDepth, DefaultQueuePerClient)
+	defer func(d time.Duration) { BatchRetryInterval = d }(BatchRetryInterval)
+	BatchRetryInterval = time.Millisecond
+
+	bd := &BatchData{
+		Positions: []*BatchPosition{
+			{Id: "rook", Fen: "4k3/8/8/8/8/8/8/4K2R w K - 0 1"},
+			{Fen: "2r3k1/p4p2/3Rp2p/1p2P1pK/8/1P4P1/P3Q2P/1q6 b - - 0 1"},
+			{Id: "bad", Fen: "4k3/8/8/8/8/8/8/8 w - - 0 1"},
+		},
+		NumLines:   2,
+		MaxTimeSec: 30,
+		RChannel:   make(chan *BatchResult),
+		Client:     "a",
+		Parallel:   3,
+	}
+	AnalyzeBatch(bd)
+
+	results := make(map[int]*BatchResult)
+	for res := range bd.RChannel {
+		if res.Done {
+			assert.Equal(t, 3, res.Index)
+			break
+		}
+		results[res.Index] = res
+	}
+	assert.Equal(t, 3, len(results))
+
+	rook := results[0]
+	assert.Equal(t, "rook", rook.Id)
+	assert.Nil(t, rook.Err)
+	assert.Equal(t, "h1h8", rook.Result.BestMove)
+	assert.Equal(t, 2, rook.Result.Depth)
+	assert.Equal(t, 2, len(rook.Result.Lines))
+	assert.Equal(t, []string{"h1h8", "e8d7"}, rook.Result.Lines[0].Moves)
+	assert.Equal(t, []string{"e1g1"}, rook.Result.Lines[1].Moves)
+
+	assert.Equal(t, "e2e4", results[1].Result.BestMove)
+
+	bad := results[2]
+	assert.Equal(t, "bad", bad.Id)
+	assert.Nil(t, bad.Result)
+	assert.Contains(t, bad.Error, "invalid fen position")
+}
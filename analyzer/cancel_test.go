@@ -0,0 +1,108 @@
+package analyzer
+
+import (
+	"context"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"github.com/samlotti/chess_anaylzer/uci/fakeengine"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+var fenCancelWorkers sync.Once
+
+// hangScript - the searches run until stopped.
+func hangScript() *fakeengine.Script {
+	script := fakeengine.DefaultScript()
+	script.Default.Hang = true
+	return script
+}
+
+// waitFor - polls the condition for a few seconds.
+func waitFor(cond func() bool) bool {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFenWorkerCancelled(t *testing.T) {
+	useTestScripts(t, hangScript())
+	fenCancelWorkers.Do(func() {
+		CreateFenWorkers(1)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fd := &FenData{
+		Fen:        "2r3k1/p4p2/3Rp2p/1p2P1pK/8/1P4P1/P3Q2P/1q6 b - - 0 1",
+		Depth:      10,
+		NumLines:   1,
+		MaxTimeSec: 60,
+		RChannel:   make(chan *FenResponse),
+		Ctx:        ctx,
+	}
+	assert.Nil(t, QueueFen(fd))
+
+	// The first info, then the client goes away without reading on
+	resp := <-fd.RChannel
+	assert.Equal(t, RCode(RCODE_INFO), resp.RCode)
+	cancel()
+
+	// The search is stopped and the worker is free again
+	assert.True(t, waitFor(func() bool {
+		engines := startedEngines()
+		return len(engines) > 0 && contains(engines[0].Commands(), "stop")
+	}))
+	assert.True(t, waitFor(func() bool {
+		return common.Utils.GetFenWorkers() == 1
+	}))
+
+	// A request cancelled while queued is passed by
+	fd.RChannel = make(chan *FenResponse)
+	assert.Nil(t, QueueFen(fd))
+	assert.True(t, waitFor(func() bool {
+		return fenQueue.length() == 0
+	}))
+	assert.Equal(t, 1, len(startedEngines()))
+}
+
+func TestPgnCancelled(t *testing.T) {
+	useTestScripts(t, hangScript())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pd := &PgnData{
+		Pgn:        "[Event \"Test\"]\n\n1. e4 e5 2. Nf3 Nc6 *\n",
+		NumLines:   1,
+		MaxTimeSec: 60,
+		RChannel:   make(chan *PgnResponse),
+		Ctx:        ctx,
+	}
+	done := make(chan struct{})
+	go func() {
+		NewPgnAnalyzer().DoAnalyze(pd)
+		close(done)
+	}()
+
+	resp := <-pd.RChannel
+	assert.Equal(t, 1, resp.MoveNum)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "the analysis did not stop")
+	}
+	assert.True(t, contains(startedEngines()[0].Commands(), "stop"))
+}
+
+func contains(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
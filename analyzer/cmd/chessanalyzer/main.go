@@ -0,0 +1,409 @@
+package main
+
+/**
+chessanalyzer - analyzes games and positions without the server.
+
+	chessanalyzer analyze game.pgn --depth 20 --engine stockfish --out annotated.pgn
+	chessanalyzer analyze games.pgn --parallel 4 --out report.csv
+	chessanalyzer analyze positions.epd --format json
+
+A pgn file may hold many games, they are analyzed in parallel.  A .fen or
+.epd file holds a position per line.  Games and positions are analyzed in
+separate runs.  The format of the output is --format, else that of the
+extension of --out: pgn (annotated), json or csv, else pgn for games and
+json for positions.
+The progress is written to stderr.
+*/
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/samlotti/chess_anaylzer/analyzer"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The output formats
+const (
+	formatPgn  = "pgn"
+	formatJson = "json"
+	formatCsv  = "csv"
+)
+
+type options struct {
+	depth    int
+	tsec     int
+	lines    int
+	engine   string
+	engines  string
+	parallel int
+	out      string
+	format   string
+	verbose  bool
+}
+
+// gameResult - the analysis of a game of the input
+type gameResult struct {
+	Index  int                  `json:"index"` // 1 is the first game
+	File   string               `json:"file"`
+	Report *analyzer.GameReport `json:"report,omitempty"`
+	Error  string               `json:"error,omitempty"`
+
+	pgn string
+}
+
+// positionResult - the analysis of a position of the input
+type positionResult struct {
+	Index  int                 `json:"index"`
+	File   string              `json:"file"`
+	Id     string              `json:"id,omitempty"`
+	Fen    string              `json:"fen"`
+	Result *analyzer.FenResult `json:"result,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "analyze" {
+		usage()
+	}
+
+	opts := &options{}
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	fs.IntVar(&opts.depth, "depth", 15, "the depth of the search of each move")
+	fs.IntVar(&opts.tsec, "tsec", analyzer.DefaultAnalyzePerMoveSec, "the most seconds of the search of each move")
+	fs.IntVar(&opts.lines, "lines", 3, "the lines searched, the played move is searched on its own when not among them")
+	fs.StringVar(&opts.engine, "engine", analyzer.DefaultEngine, "the engine")
+	fs.StringVar(&opts.engines, "engines", common.Environment.EnginePath, "the directory of the engine binaries")
+	fs.IntVar(&opts.parallel, "parallel", 2, "the games or positions analyzed at once, an engine each")
+	fs.StringVar(&opts.out, "out", "", "the output file, stdout when empty")
+	fs.StringVar(&opts.format, "format", "", "pgn, json or csv, the default is the extension of --out else pgn for games and json for positions")
+	fs.BoolVar(&opts.verbose, "v", false, "show the engine communication")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: chessanalyzer analyze [flags] file...")
+		fs.PrintDefaults()
+	}
+
+	// The flags may follow the files
+	files := make([]string, 0)
+	args := os.Args[2:]
+	for len(args) > 0 {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) > 0 {
+			files = append(files, args[0])
+			args = args[1:]
+		}
+	}
+	if len(files) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := opts.check(); err != nil {
+		fail(err.Error())
+	}
+	// The progress is on stderr, the log only shows the problems
+	common.LogLevel.Set(slog.LevelWarn)
+	if opts.verbose {
+		common.LogLevel.Set(slog.LevelDebug)
+	}
+	common.Environment.EnginePath = opts.engines
+	if !strings.HasSuffix(common.Environment.EnginePath, "/") {
+		common.Environment.EnginePath += "/"
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	games, positions, err := readInputs(files)
+	if err != nil {
+		fail(err.Error())
+	}
+	if err := opts.setFormat(len(games) > 0, len(positions) > 0); err != nil {
+		fail(err.Error())
+	}
+
+	out := io.Writer(os.Stdout)
+	if len(opts.out) > 0 {
+		f, err := os.Create(opts.out)
+		if err != nil {
+			fail(err.Error())
+		}
+		defer f.Close()
+		out = f
+	}
+
+	failed := false
+	if len(games) > 0 {
+		results := analyzeGames(ctx, opts, games)
+		failed = writeGames(out, opts.format, results) != nil
+		for _, res := range results {
+			failed = failed || len(res.Error) > 0
+		}
+	}
+	if len(positions) > 0 {
+		results := analyzePositions(ctx, opts, positions)
+		failed = writePositions(out, opts.format, results) != nil || failed
+		for _, res := range results {
+			failed = failed || len(res.Error) > 0
+		}
+	}
+	if ctx.Err() != nil || failed {
+		stop()
+		os.Exit(1)
+	}
+}
+
+// check - the options are valid
+func (o *options) check() error {
+	if o.depth <= 0 || o.tsec <= 0 || o.lines <= 0 {
+		return fmt.Errorf("depth, tsec and lines must be over 0")
+	}
+	if o.parallel <= 0 {
+		o.parallel = 1
+	}
+	switch o.format {
+	case "", formatPgn, formatJson, formatCsv:
+		return nil
+	}
+	return fmt.Errorf("unknown format %s, use pgn, json or csv", o.format)
+}
+
+// setFormat - the format when not set, of the extension of --out, else pgn
+// for games and json for positions.  Positions are not written as pgn, and
+// games and positions are not written to one output, their rows differ.
+func (o *options) setFormat(games bool, positions bool) error {
+	if games && positions {
+		return fmt.Errorf("games and positions can not be written to one output, analyze the pgn files and the fen or epd files in separate runs")
+	}
+	if len(o.format) == 0 {
+		o.format = strings.TrimPrefix(strings.ToLower(filepath.Ext(o.out)), ".")
+		switch {
+		case o.format == formatPgn, o.format == formatJson, o.format == formatCsv:
+		case positions:
+			o.format = formatJson
+		default:
+			o.format = formatPgn
+		}
+	}
+	if positions && o.format == formatPgn {
+		return fmt.Errorf("the positions are written as json or csv, use --format json or csv")
+	}
+	return nil
+}
+
+// readInputs - the games of the pgn files and the positions of the fen and epd files
+func readInputs(files []string) ([]*gameResult, []*positionResult, error) {
+	games := make([]*gameResult, 0)
+	positions := make([]*positionResult, 0)
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".fen", ".epd":
+			for num, line := range strings.Split(string(data), "\n") {
+				line = strings.TrimSpace(line)
+				if len(line) == 0 || strings.HasPrefix(line, "#") {
+					continue
+				}
+				epd, err := ai.ParseEpd(line)
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s line %d: %w", file, num+1, err)
+				}
+				positions = append(positions, &positionResult{Index: len(positions) + 1, File: file, Id: epd.Id, Fen: epd.Fen})
+			}
+		default:
+			for _, pgn := range ai.SplitPgn(string(data)) {
+				games = append(games, &gameResult{Index: len(games) + 1, File: file, pgn: pgn})
+			}
+		}
+	}
+	return games, positions, nil
+}
+
+// progress - writes the state of the analysis to stderr
+type progress struct {
+	lock  sync.Mutex
+	total int
+	done  int
+	what  string
+}
+
+func (p *progress) update(format string, args ...any) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	fmt.Fprintf(os.Stderr, "\r[%d/%d %s] %-60s", p.done, p.total, p.what, fmt.Sprintf(format, args...))
+}
+
+func (p *progress) completed(format string, args ...any) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.done++
+	fmt.Fprintf(os.Stderr, "\r[%d/%d %s] %-60s\n", p.done, p.total, p.what, fmt.Sprintf(format, args...))
+}
+
+// analyzeGames - analyzes the games, opts.parallel at once
+func analyzeGames(ctx context.Context, opts *options, games []*gameResult) []*gameResult {
+	prog := &progress{total: len(games), what: "games"}
+	run(ctx, opts.parallel, len(games), func(idx int) {
+		res := games[idx]
+		pd := &analyzer.PgnData{
+			Pgn:        res.pgn,
+			Depth:      opts.depth,
+			MaxTimeSec: opts.tsec,
+			NumLines:   opts.lines,
+			Engine:     opts.engine,
+		}
+		report, err := analyzer.AnalyzeGame(ctx, pd, func(ply int) {
+			prog.update("game %d: move %d", res.Index, (ply+1)/2)
+		})
+		if err != nil {
+			res.Error = err.Error()
+			prog.completed("game %d: %s", res.Index, err)
+			return
+		}
+		res.Report = report
+		prog.completed("game %d: accuracy white %.1f, black %.1f", res.Index, report.White.Accuracy, report.Black.Accuracy)
+	})
+	return games
+}
+
+// analyzePositions - analyzes the positions, opts.parallel at once
+func analyzePositions(ctx context.Context, opts *options, positions []*positionResult) []*positionResult {
+	prog := &progress{total: len(positions), what: "positions"}
+	run(ctx, opts.parallel, len(positions), func(idx int) {
+		res := positions[idx]
+		fa := analyzer.NewFenAnalyzer()
+		fa.Fen = res.Fen
+		fa.Depth = opts.depth
+		fa.MaxTimeSec = opts.tsec
+		fa.NumPVLines = opts.lines
+		fa.Engine = opts.engine
+
+		result, err := analyzer.AnalyzePosition(ctx, fa)
+		if err != nil {
+			res.Error = err.Error()
+			prog.completed("position %d: %s", res.Index, err)
+			return
+		}
+		res.Result = result
+		prog.completed("position %d: %s", res.Index, result.BestMove)
+	})
+	return positions
+}
+
+// run - calls fn with each index, parallel at once, until the context ends
+func run(ctx context.Context, parallel int, count int, fn func(idx int)) {
+	sem := make(chan struct{}, parallel)
+	wg := sync.WaitGroup{}
+	for idx := 0; idx < count && ctx.Err() == nil; idx++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			continue
+		}
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(idx)
+		}(idx)
+	}
+	wg.Wait()
+}
+
+func writeGames(w io.Writer, format string, games []*gameResult) error {
+	switch format {
+	case formatJson:
+		return writeJSON(w, games)
+	case formatCsv:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"game", "white", "black", "ply", "move", "side", "san", "uci", "best", "class", "loss", "eval", "accuracy"})
+		for _, game := range games {
+			if game.Report == nil {
+				continue
+			}
+			for _, m := range game.Report.Moves {
+				side := "b"
+				if m.White {
+					side = "w"
+				}
+				cw.Write([]string{
+					strconv.Itoa(game.Index), game.Report.White.Name, game.Report.Black.Name,
+					strconv.Itoa(m.Ply), strconv.Itoa(m.Number), side, m.San, m.Uci, m.BestSan,
+					string(m.Class), strconv.Itoa(m.LossCP), strconv.Itoa(m.EvalCP), strconv.FormatFloat(m.Accuracy, 'f', 1, 64),
+				})
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		for _, game := range games {
+			if game.Report == nil {
+				fmt.Fprintf(os.Stderr, "game %d of %s is left out: %s\n", game.Index, game.File, game.Error)
+				continue
+			}
+			if err := analyzer.WriteAnnotatedPgn(w, game.Report); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func writePositions(w io.Writer, format string, positions []*positionResult) error {
+	if format == formatJson {
+		return writeJSON(w, positions)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"position", "id", "fen", "best", "ponder", "depth", "score", "mate", "line", "error"})
+	for _, pos := range positions {
+		row := []string{strconv.Itoa(pos.Index), pos.Id, pos.Fen, "", "", "", "", "", "", pos.Error}
+		if pos.Result != nil {
+			row[3] = pos.Result.BestMove
+			row[4] = pos.Result.Ponder
+		}
+		if pos.Result != nil && len(pos.Result.Lines) > 0 {
+			best := pos.Result.Lines[0]
+			row[5] = strconv.Itoa(best.Depth)
+			row[6] = strconv.Itoa(best.ScoreCP)
+			row[7] = strconv.Itoa(best.MateIn)
+			row[8] = strings.Join(best.Moves, " ")
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: chessanalyzer analyze [flags] file...")
+	fmt.Fprintln(os.Stderr, "       chessanalyzer analyze -h for the flags")
+	os.Exit(2)
+}
+
+func fail(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+	os.Exit(1)
+}
@@ -0,0 +1,62 @@
+package analyzer
+
+import (
+	"github.com/samlotti/chess_anaylzer/uci"
+	"github.com/samlotti/chess_anaylzer/uci/fakeengine"
+	"os"
+	"sync"
+	"testing"
+)
+
+// The tests run against the scripted engine, no engine binary is needed.
+func TestMain(m *testing.M) {
+	useScripts(fakeengine.DefaultScript())
+	os.Exit(m.Run())
+}
+
+var fakeEngines = struct {
+	lock    sync.Mutex
+	started []*fakeengine.Engine
+}{}
+
+// useScripts - engines play the scripts in order, the last one is repeated.
+func useScripts(scripts ...*fakeengine.Script) {
+	fakeEngines.lock.Lock()
+	fakeEngines.started = nil
+	fakeEngines.lock.Unlock()
+
+	uci.UciManager().SetTransportFactory(func(engine string) (uci.Transport, error) {
+		fakeEngines.lock.Lock()
+		defer fakeEngines.lock.Unlock()
+		idx := len(fakeEngines.started)
+		if idx >= len(scripts) {
+			idx = len(scripts) - 1
+		}
+		e := fakeengine.New(scripts[idx])
+		fakeEngines.started = append(fakeEngines.started, e)
+		return e.Transport(), nil
+	})
+}
+
+// useTestScripts - as useScripts, the default is restored after the test.
+func useTestScripts(t *testing.T, scripts ...*fakeengine.Script) {
+	useScripts(scripts...)
+	t.Cleanup(func() {
+		useScripts(fakeengine.DefaultScript())
+	})
+}
+
+// startedEngines - the engines started since the scripts were set.
+func startedEngines() []*fakeengine.Engine {
+	fakeEngines.lock.Lock()
+	defer fakeEngines.lock.Unlock()
+	return append([]*fakeengine.Engine{}, fakeEngines.started...)
+}
+
+func rcodes(results []*AResults) []RCode {
+	r := make([]RCode, 0)
+	for _, res := range results {
+		r = append(r, res.RCode)
+	}
+	return r
+}
@@ -1,9 +1,9 @@
 package analyzer
 
 import (
-	"fmt"
+	"context"
 	"github.com/samlotti/chess_anaylzer/chessboard/common"
-	"sync"
+	"time"
 )
 
 // FenResponse is from worker to consumer
@@ -13,6 +13,9 @@ type FenResponse struct {
 	ARBestMove *ARBestMove `json:"bestMove"`
 	ARInfo     *ARInfo     `json:"info"`
 	Done       bool        `json:"done"` // The end of the messages
+
+	// The place in the queue with RCODE_QUEUED, 1 is next
+	QueuePosition int `json:"queuePosition,omitempty"`
 }
 
 type FenData struct {
@@ -22,26 +25,65 @@ type FenData struct {
 
 	RChannel chan *FenResponse
 
+	// Ctx - ending it stops the analysis, ex: the client went away. nil never ends.
+	Ctx context.Context
+
 	MaxTimeSec int
 	NumLines   int
+
+	// Client - who sent it, the clients take turns in the queue.
+	Client string
+
+	// Priority - higher is served first, ex: PriorityHigh
+	Priority int
+}
+
+// context - the context of the request.
+func (fd *FenData) context() context.Context {
+	if fd.Ctx == nil {
+		return context.Background()
+	}
+	return fd.Ctx
+}
+
+// send - passes the response on, dropped when the request has ended.
+func (fd *FenData) send(resp *FenResponse) {
+	select {
+	case fd.RChannel <- resp:
+	case <-fd.context().Done():
+	}
+}
+
+// fenResponseOf - the response of the analyzer result
+func fenResponseOf(m *AResults) *FenResponse {
+	fr := &FenResponse{}
+	fr.ARInfo = m.Info
+	fr.ARBestMove = m.BestMode
+	if m.Err != nil {
+		fr.Error = m.Err.Error()
+	}
+
+	fr.Done = m.Done
+	fr.RCode = m.RCode
+	return fr
 }
 
-var mutex = sync.Mutex{}
+// RemoveQueuedFen - takes the request out of the queue, false when not queued.
+func RemoveQueuedFen(fd *FenData) bool {
+	return fenQueue.remove(fd)
+}
 
-var fenChan = make(chan *FenData, 1)
+// QueueFen - queue the data for the fen workers.
+// Returns ErrQueueFull or ErrClientLimit when there is no room.
+func QueueFen(fd *FenData) error {
+	return fenQueue.push(fd, fd.Client, fd.Priority)
+}
 
 // AnalyzeFenChannelSender - analyze this data.
 // Returns false if the queue are busy
 // true if the fen was sent
 func AnalyzeFenChannelSender(fd *FenData) bool {
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	if len(fenChan) == cap(fenChan) {
-		return false
-	}
-	fenChan <- fd
-	return true
+	return QueueFen(fd) == nil
 }
 
 type FenWorker struct {
@@ -57,6 +99,7 @@ func CreateFenWorkers(num int) {
 		var fw = &FenWorker{
 			seq: common.Utils.NextSeq(),
 		}
+		fenQueue.addWorker()
 		go fw.runLoop()
 		fenWorkers = append(fenWorkers, fw)
 	}
@@ -64,21 +107,26 @@ func CreateFenWorkers(num int) {
 
 // runLoop -- Worker that waits for fen requests
 func (f *FenWorker) runLoop() {
-	println("Fen worker started: ", f.seq)
+	common.Log().Debug("fen worker started", common.LogWorker, f.seq)
 	common.Utils.AdjustFenWorker(1)
 	f.analyzer = NewFenAnalyzer()
 	for {
-		msg := <-fenChan
-		common.Utils.AdjustFenWorker(-1)
-		if Verbose {
-			fmt.Printf("Fen worker started %d: %s\n", f.seq, msg.Fen)
+		msg := fenQueue.pop()
+		if msg.context().Err() != nil {
+			// The client left while queued
+			continue
 		}
+		common.Utils.AdjustFenWorker(-1)
+		start := time.Now()
+		log := common.Logger(msg.context()).With(common.LogWorker, f.seq)
+		log.Info("fen analysis started", "fen", msg.Fen, "move", msg.UserMove)
 
 		f.analyzer.NumPVLines = msg.NumLines
 		f.analyzer.MaxTimeSec = msg.MaxTimeSec
 		f.analyzer.Depth = msg.Depth
 		f.analyzer.Fen = msg.Fen
 		f.analyzer.UserMove = msg.UserMove
+		f.analyzer.MaxRetries = EngineRetries
 
 		dchan := make(chan struct{}, 10)
 		rchan := make(chan *AResults, 10)
@@ -86,16 +134,7 @@ func (f *FenWorker) runLoop() {
 			for {
 				m := <-rchan
 
-				fr := &FenResponse{}
-				fr.ARInfo = m.Info
-				fr.ARBestMove = m.BestMode
-				if m.Err != nil {
-					fr.Error = m.Err.Error()
-				}
-
-				fr.Done = m.Done
-				fr.RCode = m.RCode
-				msg.RChannel <- fr
+				msg.send(fenResponseOf(m))
 
 				if m.Done {
 					dchan <- struct{}{}
@@ -105,12 +144,14 @@ func (f *FenWorker) runLoop() {
 			}
 		}()
 
-		f.analyzer.Analyze(rchan)
+		f.analyzer.AnalyzeContext(msg.context(), rchan)
 
 		// wait for complete
 		<-dchan
 
+		fenQueue.completed(time.Since(start))
 		common.Utils.AdjustFenWorker(1)
+		log.Info("fen analysis ended", "duration", time.Since(start))
 
 	}
 
@@ -1,6 +1,6 @@
 module github.com/samlotti/chess_anaylzer/analyzer
 
-go 1.18
+go 1.21
 
 require github.com/stretchr/testify v1.8.4
 
@@ -0,0 +1,253 @@
+package analyzer
+
+/**
+Pgn analysis as jobs.
+
+A job is queued to the pgn workers and the responses are kept, so the
+client polls for the progress instead of holding a connection open for
+the whole game.  Finished jobs are kept for JobRetention.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"sync"
+	"time"
+)
+
+// JobStatus - the state of a job
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobDone      JobStatus = "done"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// JobRetention - how long a finished job is kept.
+var JobRetention = 30 * time.Minute
+
+// Job - a pgn being analyzed.
+type Job struct {
+	ID string
+
+	data     *PgnData
+	lock     sync.Mutex
+	status   JobStatus
+	ply      int
+	plies    int
+	results  []*PgnResponse
+	err      string
+	created  time.Time
+	finished time.Time
+	cancel   context.CancelFunc
+	changed  chan struct{}
+}
+
+// JobView - the state of the job as sent to the client.
+type JobView struct {
+	ID      string         `json:"id"`
+	Status  JobStatus      `json:"status"`
+	Ply     int            `json:"ply"`                     // the ply being analyzed, 1 based
+	Plies   int            `json:"plies"`                   // the plies of the game
+	Queue   int            `json:"queuePosition,omitempty"` // the place in the queue while queued, 1 is next
+	Error   string         `json:"error,omitempty"`
+	Results []*PgnResponse `json:"results"`
+	Next    int            `json:"next"` // pass as since to get only the newer results
+}
+
+// View - the state of the job with the results from since on.
+func (j *Job) View(since int) *JobView {
+	queue := 0
+	if j.Status() == JobQueued {
+		queue = PgnQueuePosition(j.data)
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if since < 0 || since > len(j.results) {
+		since = len(j.results)
+	}
+	return &JobView{
+		ID:      j.ID,
+		Status:  j.status,
+		Ply:     j.ply,
+		Plies:   j.plies,
+		Queue:   queue,
+		Error:   j.err,
+		Results: append([]*PgnResponse{}, j.results[since:]...),
+		Next:    len(j.results),
+	}
+}
+
+// Status - the state of the job
+func (j *Job) Status() JobStatus {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.status
+}
+
+// Pgn - the game of the job
+func (j *Job) Pgn() string {
+	return j.data.Pgn
+}
+
+// Changed - closed when the job next gets a result, get it before the View
+// so no result is missed.
+func (j *Job) Changed() <-chan struct{} {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.changed
+}
+
+// isFinished - the job has ended, needs the lock.
+func (j *Job) isFinished() bool {
+	return j.status == JobDone || j.status == JobFailed || j.status == JobCancelled
+}
+
+// collect - keeps the responses of the worker until the done.
+func (j *Job) collect(ctx context.Context, rchan chan *PgnResponse) {
+	for {
+		var resp *PgnResponse
+		select {
+		case resp = <-rchan:
+		case <-ctx.Done():
+			// Cancelled, the worker drops what is left
+			resp = &PgnResponse{
+				RCode: RCODE_ERROR,
+				Error: "cancelled",
+				Done:  true,
+			}
+		}
+
+		j.lock.Lock()
+		if j.status == JobQueued && !resp.Done {
+			j.status = JobRunning
+		}
+		if resp.MoveNum > j.ply {
+			j.ply = resp.MoveNum
+		}
+		if resp.Done {
+			switch {
+			case ctx.Err() != nil:
+				j.status = JobCancelled
+				j.err = "cancelled"
+			case resp.RCode == RCODE_ERROR:
+				j.status = JobFailed
+				j.err = resp.Error
+			default:
+				j.status = JobDone
+				j.ply = j.plies
+			}
+			j.finished = time.Now()
+		}
+		j.results = append(j.results, resp)
+		close(j.changed)
+		j.changed = make(chan struct{})
+		j.lock.Unlock()
+
+		if resp.Done {
+			common.Logger(ctx).Info("job finished", "status", j.Status(), "err", resp.Error)
+			j.cancel()
+			return
+		}
+	}
+}
+
+type _JobManager struct {
+	lock sync.Mutex
+	jobs map[string]*Job
+}
+
+var _jobManager = &_JobManager{jobs: make(map[string]*Job)}
+
+func JobManager() *_JobManager {
+	return _jobManager
+}
+
+// StartPgnJob - queues the pgn, the RChannel and Ctx of the data are set by the job.
+// The logger of the Ctx, if set, is kept by the job, ex: the request id.
+// Returns the error of QueuePgn when there is no room, or the error of an invalid pgn.
+func (m *_JobManager) StartPgnJob(pd *PgnData) (*Job, error) {
+	wrapper := ai.NewPgnWrapper(pd.Pgn)
+	if err := wrapper.Parse(); err != nil {
+		return nil, err
+	}
+
+	// The job outlives the request, it keeps the logger of the request
+	id := newJobID()
+	log := common.Logger(pd.Ctx).With(common.LogJob, id)
+	ctx, cancel := context.WithCancel(common.WithLogger(context.Background(), log))
+	job := &Job{
+		ID:      id,
+		data:    pd,
+		status:  JobQueued,
+		plies:   len(wrapper.InternalMoves),
+		created: time.Now(),
+		cancel:  cancel,
+		changed: make(chan struct{}),
+	}
+	pd.Ctx = ctx
+	pd.RChannel = make(chan *PgnResponse, 10)
+
+	if err := QueuePgn(pd); err != nil {
+		cancel()
+		return nil, err
+	}
+	go job.collect(ctx, pd.RChannel)
+	log.Info("job queued", "plies", job.plies)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.expire()
+	m.jobs[job.ID] = job
+	return job, nil
+}
+
+// GetJob - the job, nil if unknown or expired.
+func (m *_JobManager) GetJob(id string) *Job {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.expire()
+	return m.jobs[id]
+}
+
+// CancelJob - stops the job, a finished job is left as is.
+// The status is cancelled once the job has stopped.
+// Returns nil if the job is unknown.
+func (m *_JobManager) CancelJob(id string) *Job {
+	job := m.GetJob(id)
+	if job == nil {
+		return nil
+	}
+	// Out of the queue, a running job stops at the cancel
+	pgnQueue.remove(job.data)
+	job.cancel()
+	return job
+}
+
+// expire - drops the jobs finished more than JobRetention ago, needs the lock.
+func (m *_JobManager) expire() {
+	for id, job := range m.jobs {
+		job.lock.Lock()
+		expired := job.isFinished() && time.Since(job.finished) > JobRetention
+		job.lock.Unlock()
+		if expired {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,107 @@
+package analyzer
+
+import (
+	"github.com/samlotti/chess_anaylzer/uci/fakeengine"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+var jobWorkers sync.Once
+
+// startJob - queues the pgn to a pgn worker.
+func startJob(t *testing.T, pgn string) *Job {
+	jobWorkers.Do(func() {
+		CreatePgnWorkers(1)
+	})
+	job, err := JobManager().StartPgnJob(&PgnData{Pgn: pgn, NumLines: 1, MaxTimeSec: 30})
+	assert.Nil(t, err)
+	return job
+}
+
+// waitJob - polls the job until the status.
+func waitJob(t *testing.T, job *Job, status JobStatus) *JobView {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if job.Status() == status {
+			break
+		}
+	}
+	view := job.View(0)
+	assert.Equal(t, status, view.Status)
+	return view
+}
+
+func TestJobDone(t *testing.T) {
+	job := startJob(t, "[Event \"Test\"]\n\n1. e4 e5 2. Nf3 *\n")
+	assert.Equal(t, job, JobManager().GetJob(job.ID))
+
+	view := waitJob(t, job, JobDone)
+	assert.Equal(t, 3, view.Plies)
+	assert.Equal(t, 3, view.Ply)
+	assert.Equal(t, "", view.Error)
+	last := view.Results[len(view.Results)-1]
+	assert.True(t, last.Done)
+	assert.Equal(t, RCode(RCODE_DONE), last.RCode)
+	assert.Equal(t, len(view.Results), view.Next)
+
+	// Only the newer results
+	assert.Equal(t, 1, len(job.View(view.Next-1).Results))
+	assert.Equal(t, 0, len(job.View(view.Next).Results))
+}
+
+func TestJobCancel(t *testing.T) {
+	script := fakeengine.DefaultScript()
+	script.Default.Hang = true
+	useTestScripts(t, script)
+
+	job := startJob(t, "[Event \"Test\"]\n\n1. e4 e5 *\n")
+	waitJob(t, job, JobRunning)
+
+	assert.Equal(t, job, JobManager().CancelJob(job.ID))
+	view := waitJob(t, job, JobCancelled)
+	assert.Equal(t, 1, view.Ply)
+	assert.Equal(t, "cancelled", view.Error)
+	assert.True(t, view.Results[len(view.Results)-1].Done)
+
+	assert.Nil(t, JobManager().CancelJob("unknown"))
+}
+
+func TestJobInvalidPgn(t *testing.T) {
+	_, err := JobManager().StartPgnJob(&PgnData{Pgn: "[Event \"Test\"]\n\n1. e4 e4 *\n"})
+	assert.NotNil(t, err)
+}
+
+func TestJobExpire(t *testing.T) {
+	job := startJob(t, "[Event \"Test\"]\n\n1. d4 *\n")
+	waitJob(t, job, JobDone)
+
+	JobRetention = 0
+	defer func() {
+		JobRetention = 30 * time.Minute
+	}()
+	assert.Nil(t, JobManager().GetJob(job.ID))
+}
+
+func TestJobCancelQueued(t *testing.T) {
+	script := fakeengine.DefaultScript()
+	script.Default.Hang = true
+	useTestScripts(t, script)
+
+	// The worker is held by the first job
+	first := startJob(t, "[Event \"Test\"]\n\n1. e4 *\n")
+	waitJob(t, first, JobRunning)
+	second := startJob(t, "[Event \"Test\"]\n\n1. d4 *\n")
+	view := second.View(0)
+	assert.Equal(t, JobQueued, view.Status)
+	assert.Equal(t, 1, view.Queue)
+
+	JobManager().CancelJob(second.ID)
+	view = waitJob(t, second, JobCancelled)
+	assert.Equal(t, 0, view.Queue)
+	assert.Equal(t, 0, pgnQueue.length())
+	assert.True(t, view.Results[0].Done)
+
+	JobManager().CancelJob(first.ID)
+	waitJob(t, first, JobCancelled)
+}
@@ -0,0 +1,72 @@
+package analyzer
+
+/**
+Analysis without the workers, ex: from the command line.
+
+The analyzers are run on the calling goroutine, so the caller decides how
+many run at once.
+*/
+
+import (
+	"context"
+	"errors"
+)
+
+// AnalyzeGame - analyzes each move of the pgn, the report is built from the summary.
+// progress is called with the ply when the analysis of a move starts, it may be nil.
+func AnalyzeGame(ctx context.Context, pd *PgnData, progress func(ply int)) (*GameReport, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pd.Ctx = ctx
+	pd.RChannel = make(chan *PgnResponse, 10)
+
+	pa := NewPgnAnalyzer()
+	go pa.DoAnalyze(pd)
+
+	builder := NewSummaryBuilder()
+	ply := 0
+	for {
+		var resp *PgnResponse
+		select {
+		case resp = <-pd.RChannel:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if resp.RCode == RCODE_ERROR {
+			return nil, errors.New(resp.Error)
+		}
+		if resp.MoveNum > ply {
+			ply = resp.MoveNum
+			if progress != nil {
+				progress(ply)
+			}
+		}
+		builder.Add(resp)
+		if resp.Done {
+			break
+		}
+	}
+	builder.Finish()
+	return BuildReport(pd.Pgn, builder.Summary())
+}
+
+// AnalyzePosition - analyzes the position of the analyzer, see CollectFen for the result.
+func AnalyzePosition(ctx context.Context, fa *FenAnalyzer) (*FenResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	fd := &FenData{Fen: fa.Fen, RChannel: make(chan *FenResponse, 10), Ctx: ctx}
+	rchan := make(chan *AResults, 10)
+	go fa.AnalyzeContext(ctx, rchan)
+	go func() {
+		for {
+			m := <-rchan
+			fd.send(fenResponseOf(m))
+			if m.Done {
+				return
+			}
+		}
+	}()
+	return CollectFen(ctx, fd)
+}
@@ -0,0 +1,59 @@
+package analyzer
+
+import (
+	"context"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"github.com/samlotti/chess_anaylzer/uci"
+	"github.com/samlotti/chess_anaylzer/uci/fakeengine"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestAnalyzeGame(t *testing.T) {
+	var lock sync.Mutex
+	engines := make([]string, 0)
+	uci.UciManager().SetTransportFactory(func(engine string) (uci.Transport, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		engines = append(engines, engine)
+		return fakeengine.New(fakeengine.DefaultScript()).Transport(), nil
+	})
+	t.Cleanup(func() {
+		useScripts(fakeengine.DefaultScript())
+	})
+
+	plies := make([]int, 0)
+	pd := &PgnData{Pgn: "[Event \"Test\"]\n\n1. e4 e5 2. Nf3 *\n", Depth: 5, NumLines: 1, MaxTimeSec: 5, Engine: "stockfish"}
+	report, err := AnalyzeGame(context.Background(), pd, func(ply int) {
+		plies = append(plies, ply)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2, 3}, plies)
+	assert.Equal(t, 3, len(report.Moves))
+	assert.Equal(t, ClassBest, report.Moves[0].Class)
+	assert.Equal(t, []string{"stockfish"}, engines)
+
+	_, err = AnalyzeGame(context.Background(), &PgnData{Pgn: "[Event \"Test\"]\n\n1. e4 e4 *\n"}, nil)
+	assert.NotNil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = AnalyzeGame(ctx, &PgnData{Pgn: pd.Pgn}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestAnalyzePosition(t *testing.T) {
+	fa := NewFenAnalyzer()
+	fa.Fen = ai.StartFen
+	fa.Depth = 5
+	fa.NumPVLines = 1
+	result, err := AnalyzePosition(context.Background(), fa)
+	assert.Nil(t, err)
+	assert.Equal(t, "e2e4", result.BestMove)
+	assert.Equal(t, 10, result.Lines[0].ScoreCP)
+
+	fa.Fen = "8/8/8 w - - 0 1"
+	_, err = AnalyzePosition(context.Background(), fa)
+	assert.NotNil(t, err)
+}
@@ -1,22 +1,26 @@
 package analyzer
 
 import (
-	"fmt"
 	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"time"
 )
 
+// RemoveQueuedPgn - takes the request out of the queue, false when not queued.
+func RemoveQueuedPgn(pd *PgnData) bool {
+	return pgnQueue.remove(pd)
+}
+
+// QueuePgn - queue the data for the pgn workers.
+// Returns ErrQueueFull or ErrClientLimit when there is no room.
+func QueuePgn(pd *PgnData) error {
+	return pgnQueue.push(pd, pd.Client, pd.Priority)
+}
+
 // AnalyzePgnChannelSender - analyze this data.
 // Returns false if the queue are busy
 // true if the fen was sent
 func AnalyzePgnChannelSender(fd *PgnData) bool {
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	if len(pgnChan) == cap(pgnChan) {
-		return false
-	}
-	pgnChan <- fd
-	return true
+	return QueuePgn(fd) == nil
 }
 
 type PgnWorker struct {
@@ -31,6 +35,7 @@ func CreatePgnWorkers(num int) {
 		var fw = &PgnWorker{
 			seq: common.Utils.NextSeq(),
 		}
+		pgnQueue.addWorker()
 		go fw.runLoop()
 		pgnWorkers = append(pgnWorkers, fw)
 	}
@@ -38,19 +43,26 @@ func CreatePgnWorkers(num int) {
 
 // runLoop -- Worker that waits for fen requests
 func (f *PgnWorker) runLoop() {
-	println("Pgn worker started: ", f.seq)
+	common.Log().Debug("pgn worker started", common.LogWorker, f.seq)
 	common.Utils.AdjustPgnWorker(1)
 	f.analyzer = NewPgnAnalyzer()
 	for {
-		msg := <-pgnChan
+		msg := pgnQueue.pop()
+		if msg.context().Err() != nil {
+			// The client left while queued
+			continue
+		}
+		start := time.Now()
 
 		common.Utils.AdjustPgnWorker(-1)
-		if Verbose {
-			fmt.Printf("Pgn worker started %d: %s\n", f.seq, msg.Pgn)
-		}
+		log := common.Logger(msg.context()).With(common.LogWorker, f.seq)
+		log.Info("pgn analysis started")
+		log.Debug("pgn", "pgn", msg.Pgn)
 
 		f.analyzer.DoAnalyze(msg)
+		pgnQueue.completed(time.Since(start))
 		common.Utils.AdjustPgnWorker(1)
+		log.Info("pgn analysis ended", "duration", time.Since(start))
 
 	}
 }
@@ -0,0 +1,274 @@
+package analyzer
+
+/**
+The requests waiting for a worker.
+
+The queue is bounded, in total and per client.  Higher priorities are
+served first, within a priority the clients take turns: the client served
+longest ago goes next, so one client queueing many games does not hold up
+the others.
+*/
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// The request priorities, higher is served first.
+const (
+	PriorityLow    = -1
+	PriorityNormal = 0
+	PriorityHigh   = 1
+)
+
+// Defaults of the queue limits, see SetQueueLimits.
+const (
+	DefaultQueueDepth     = 100
+	DefaultQueuePerClient = 10
+)
+
+// ErrQueueFull - the queue has no room, try again later.
+var ErrQueueFull = errors.New("server busy, the queue is full")
+
+// ErrClientLimit - the client has too many requests waiting.
+var ErrClientLimit = errors.New("too many requests queued")
+
+// queuedItem - a request and who sent it.
+type queuedItem[T comparable] struct {
+	item     T
+	client   string
+	priority int
+	seq      int64
+}
+
+// requestQueue - the bounded fair priority queue of the workers.
+type requestQueue[T comparable] struct {
+	lock sync.Mutex
+	cond *sync.Cond
+
+	maxDepth     int
+	maxPerClient int
+
+	items   []*queuedItem[T]
+	served  map[string]int64 // the turn the client was last served
+	turn    int64
+	seq     int64
+	workers int
+
+	// The moving average of the time a request takes, for RetryAfter.
+	avgService time.Duration
+}
+
+func newRequestQueue[T comparable](defaultService time.Duration) *requestQueue[T] {
+	q := &requestQueue[T]{
+		maxDepth:     DefaultQueueDepth,
+		maxPerClient: DefaultQueuePerClient,
+		served:       make(map[string]int64),
+		avgService:   defaultService,
+	}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+// push - queues the request, the error when there is no room.
+func (q *requestQueue[T]) push(item T, client string, priority int) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.items) >= q.maxDepth {
+		return ErrQueueFull
+	}
+	if q.maxPerClient > 0 {
+		count := 0
+		for _, qi := range q.items {
+			if qi.client == client {
+				count++
+			}
+		}
+		if count >= q.maxPerClient {
+			return ErrClientLimit
+		}
+	}
+
+	q.seq++
+	q.items = append(q.items, &queuedItem[T]{item: item, client: client, priority: priority, seq: q.seq})
+	q.cond.Signal()
+	return nil
+}
+
+// pop - waits for the next request.
+func (q *requestQueue[T]) pop() T {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for len(q.items) == 0 {
+		q.cond.Wait()
+	}
+	idx := nextIndex(q.items, q.served)
+	qi := q.items[idx]
+	q.items = append(q.items[:idx], q.items[idx+1:]...)
+
+	q.turn++
+	q.served[qi.client] = q.turn
+	q.forgetIdle()
+	return qi.item
+}
+
+// nextIndex - the request served next: the highest priority, then the
+// client served longest ago, then the oldest.
+func nextIndex[T comparable](items []*queuedItem[T], served map[string]int64) int {
+	best := 0
+	for idx, qi := range items[1:] {
+		b := items[best]
+		switch {
+		case qi.priority != b.priority:
+			if qi.priority > b.priority {
+				best = idx + 1
+			}
+		case served[qi.client] != served[b.client]:
+			if served[qi.client] < served[b.client] {
+				best = idx + 1
+			}
+		case qi.seq < b.seq:
+			best = idx + 1
+		}
+	}
+	return best
+}
+
+// forgetIdle - drops the turns of clients with nothing queued, needs the lock.
+func (q *requestQueue[T]) forgetIdle() {
+	waiting := make(map[string]bool)
+	for _, qi := range q.items {
+		waiting[qi.client] = true
+	}
+	for client := range q.served {
+		if !waiting[client] {
+			delete(q.served, client)
+		}
+	}
+}
+
+// remove - takes the request out of the queue, false if not queued.
+func (q *requestQueue[T]) remove(item T) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for idx, qi := range q.items {
+		if qi.item == item {
+			q.items = append(q.items[:idx], q.items[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// position - the place of the request in the queue, 1 is next, 0 when not queued.
+// The turns are played out, new requests may still go ahead of it.
+func (q *requestQueue[T]) position(item T) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	items := append([]*queuedItem[T]{}, q.items...)
+	served := make(map[string]int64, len(q.served))
+	for client, turn := range q.served {
+		served[client] = turn
+	}
+	turn := q.turn
+	for pos := 1; len(items) > 0; pos++ {
+		idx := nextIndex(items, served)
+		if items[idx].item == item {
+			return pos
+		}
+		turn++
+		served[items[idx].client] = turn
+		items = append(items[:idx], items[idx+1:]...)
+	}
+	return 0
+}
+
+// length - the requests waiting.
+func (q *requestQueue[T]) length() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.items)
+}
+
+// setLimits - the max requests queued, in total and per client (0 = no client limit).
+func (q *requestQueue[T]) setLimits(depth int, perClient int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.maxDepth = depth
+	q.maxPerClient = perClient
+}
+
+// addWorker - one more worker takes from the queue.
+func (q *requestQueue[T]) addWorker() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.workers++
+}
+
+// workerCount - the workers taking from the queue.
+func (q *requestQueue[T]) workerCount() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.workers
+}
+
+// completed - a worker completed a request in the time.
+func (q *requestQueue[T]) completed(d time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.avgService = (q.avgService*7 + d) / 8
+}
+
+// retryAfter - the estimated wait until there is room for the request refused with the error, at least a second.
+func (q *requestQueue[T]) retryAfter(err error) time.Duration {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	workers := q.workers
+	if workers < 1 {
+		workers = 1
+	}
+	// The queue moves one request per worker each service time
+	wait := q.avgService * time.Duration(len(q.items)+1) / time.Duration(workers)
+	if errors.Is(err, ErrClientLimit) && q.maxPerClient > 0 {
+		// A client over its limit waits for its own requests only
+		wait = q.avgService * time.Duration(q.maxPerClient) / time.Duration(workers)
+	}
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait.Round(time.Second)
+}
+
+var fenQueue = newRequestQueue[*FenData](DefaultAnalyzePerMoveSec * time.Second)
+var pgnQueue = newRequestQueue[*PgnData](40 * DefaultAnalyzePerMoveSec * time.Second)
+
+// SetQueueLimits - the max requests waiting for the fen and pgn workers,
+// and the max of a client in each.  0 per client is no client limit.
+func SetQueueLimits(depth int, perClient int) {
+	fenQueue.setLimits(depth, perClient)
+	pgnQueue.setLimits(depth, perClient)
+}
+
+// FenQueuePosition - the place of the request in the queue, 1 is next, 0 when not queued.
+func FenQueuePosition(fd *FenData) int {
+	return fenQueue.position(fd)
+}
+
+// PgnQueuePosition - the place of the request in the queue, 1 is next, 0 when not queued.
+func PgnQueuePosition(pd *PgnData) int {
+	return pgnQueue.position(pd)
+}
+
+// FenRetryAfter - the estimated time until a fen request refused with the error can be queued.
+func FenRetryAfter(err error) time.Duration {
+	return fenQueue.retryAfter(err)
+}
+
+// PgnRetryAfter - the estimated time until a pgn request refused with the error can be queued.
+func PgnRetryAfter(err error) time.Duration {
+	return pgnQueue.retryAfter(err)
+}
@@ -0,0 +1,87 @@
+package analyzer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestQueueFairness(t *testing.T) {
+	q := newRequestQueue[string](time.Second)
+
+	// One client queues many, the other clients still take turns
+	for _, item := range []string{"a1", "a2", "a3", "a4"} {
+		assert.Nil(t, q.push(item, "a", PriorityNormal))
+	}
+	assert.Nil(t, q.push("b1", "b", PriorityNormal))
+	assert.Nil(t, q.push("c1", "c", PriorityNormal))
+	assert.Nil(t, q.push("b2", "b", PriorityNormal))
+
+	assert.Equal(t, 1, q.position("a1"))
+	assert.Equal(t, 2, q.position("b1"))
+	assert.Equal(t, 3, q.position("c1"))
+	assert.Equal(t, 5, q.position("b2"))
+	assert.Equal(t, 7, q.position("a4"))
+	assert.Equal(t, 0, q.position("x"))
+
+	var order []string
+	for q.length() > 0 {
+		order = append(order, q.pop())
+	}
+	assert.Equal(t, []string{"a1", "b1", "c1", "a2", "b2", "a3", "a4"}, order)
+}
+
+func TestQueuePriority(t *testing.T) {
+	q := newRequestQueue[string](time.Second)
+	assert.Nil(t, q.push("low", "a", PriorityLow))
+	assert.Nil(t, q.push("normal", "a", PriorityNormal))
+	assert.Nil(t, q.push("high", "b", PriorityHigh))
+
+	assert.Equal(t, 3, q.position("low"))
+	assert.Equal(t, "high", q.pop())
+	assert.Equal(t, "normal", q.pop())
+	assert.Equal(t, "low", q.pop())
+}
+
+func TestQueueLimits(t *testing.T) {
+	q := newRequestQueue[string](10 * time.Second)
+	q.setLimits(3, 2)
+
+	assert.Nil(t, q.push("a1", "a", PriorityNormal))
+	assert.Nil(t, q.push("a2", "a", PriorityNormal))
+	assert.Equal(t, ErrClientLimit, q.push("a3", "a", PriorityNormal))
+	assert.Nil(t, q.push("b1", "b", PriorityNormal))
+	assert.Equal(t, ErrQueueFull, q.push("c1", "c", PriorityNormal))
+
+	assert.True(t, q.remove("a1"))
+	assert.False(t, q.remove("a1"))
+	assert.Nil(t, q.push("c1", "c", PriorityNormal))
+
+	// Two per client for one worker at 10s each
+	q.addWorker()
+	assert.Equal(t, 20*time.Second, q.retryAfter(ErrClientLimit))
+	// A full queue waits for the whole queue, not the client's share
+	assert.Equal(t, 40*time.Second, q.retryAfter(ErrQueueFull))
+	q.setLimits(3, 0)
+	assert.Equal(t, 40*time.Second, q.retryAfter(ErrQueueFull))
+
+	q.completed(2 * time.Second)
+	assert.Equal(t, 36*time.Second, q.retryAfter(ErrQueueFull))
+}
+
+func TestQueuePopWaits(t *testing.T) {
+	q := newRequestQueue[string](time.Second)
+	got := make(chan string)
+	go func() {
+		got <- q.pop()
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, q.push("a", "a", PriorityNormal))
+	select {
+	case item := <-got:
+		assert.Equal(t, "a", item)
+	case <-time.After(time.Second):
+		assert.Fail(t, "pop did not return")
+	}
+}
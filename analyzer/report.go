@@ -0,0 +1,257 @@
+package analyzer
+
+import (
+	"errors"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"math"
+	"sort"
+)
+
+// MoveClass - how good the played move was, by the score lost against the best move.
+type MoveClass string
+
+const (
+	ClassBest       MoveClass = "best"
+	ClassGood       MoveClass = "good"
+	ClassInaccuracy MoveClass = "inaccuracy"
+	ClassMistake    MoveClass = "mistake"
+	ClassBlunder    MoveClass = "blunder"
+)
+
+// The losses in centipawns over which a move is classed lower.
+// Green: good, Yellow: inaccuracy, Orange: mistake, Red: blunder
+var (
+	GoodLossCP       = 10
+	InaccuracyLossCP = 30
+	MistakeLossCP    = 90
+)
+
+// ReportCriticalMoves - the most critical moments shown in a report
+var ReportCriticalMoves = 5
+
+// The scores are capped here, a mate is only worth so much on a graph
+const reportMaxCP = 1500
+
+// ReportMove - a move of the game with its analysis.
+type ReportMove struct {
+	Ply       int       `json:"ply"`    // 1 is the first move of the pgn
+	Number    int       `json:"number"` // the full move number
+	White     bool      `json:"white"`  // played by white
+	San       string    `json:"san"`
+	Uci       string    `json:"uci"`
+	BestSan   string    `json:"bestSan"`
+	BestUci   string    `json:"bestUci"`
+	Class     MoveClass `json:"class"`
+	LossCP    int       `json:"loss"`
+	EvalCP    int       `json:"eval"`     // after the move, from the view of white
+	Accuracy  float64   `json:"accuracy"` // 0 to 100
+	FenBefore string    `json:"fenBefore"`
+	FenAfter  string    `json:"fenAfter"`
+	Line      []string  `json:"line"` // the engine line, san, for the moves that lose
+	Analyzed  bool      `json:"analyzed"`
+}
+
+// SideReport - the totals of a player.
+type SideReport struct {
+	Name         string  `json:"name"`
+	Accuracy     float64 `json:"accuracy"`
+	AverageLoss  int     `json:"averageLoss"` // centipawns per move
+	Inaccuracies int     `json:"inaccuracies"`
+	Mistakes     int     `json:"mistakes"`
+	Blunders     int     `json:"blunders"`
+}
+
+// GameReport - the analysis of a game.
+type GameReport struct {
+	InitialFen string            `json:"initialFen"`
+	Attributes map[string]string `json:"attributes"`
+	White      SideReport        `json:"white"`
+	Black      SideReport        `json:"black"`
+	Moves      []*ReportMove     `json:"moves"`
+	Critical   []*ReportMove     `json:"critical"` // the worst mistakes and blunders, in the order played
+}
+
+// BuildReport - the report of the game from the summary of its analysis,
+// see SummaryBuilder. Moves without a summary are left unclassified.
+func BuildReport(pgn string, summary *GameSummary) (*GameReport, error) {
+	wrapper := ai.NewPgnWrapper(pgn)
+	if err := wrapper.Parse(); err != nil {
+		return nil, err
+	}
+	initial, plies, err := ai.PgnPositions(pgn)
+	if err != nil {
+		return nil, err
+	}
+	if summary == nil {
+		return nil, errors.New("no analysis for the game")
+	}
+
+	byPly := make(map[int]*MoveSummary)
+	for _, m := range summary.Moves {
+		byPly[m.MoveNum] = m
+	}
+
+	report := &GameReport{
+		InitialFen: initial,
+		Attributes: wrapper.Attributes,
+		White:      SideReport{Name: wrapper.Attributes["White"]},
+		Black:      SideReport{Name: wrapper.Attributes["Black"]},
+		Moves:      make([]*ReportMove, 0, len(plies)),
+		Critical:   make([]*ReportMove, 0),
+	}
+
+	type totals struct {
+		moves    int
+		loss     int
+		accuracy float64
+	}
+	var white, black totals
+
+	fen := initial
+	eval := 0
+	for idx, ply := range plies {
+		rm := &ReportMove{
+			Ply:       idx + 1,
+			Number:    (ply.Ply-1)/2 + 1,
+			White:     ply.Ply%2 == 1,
+			San:       ply.San,
+			Uci:       ply.Uci,
+			FenBefore: fen,
+			FenAfter:  ply.Fen,
+			EvalCP:    eval,
+		}
+		report.Moves = append(report.Moves, rm)
+		fen = ply.Fen
+
+		m := byPly[rm.Ply]
+		if m == nil || len(m.BestMove) == 0 {
+			continue
+		}
+		rm.Analyzed = true
+		rm.BestUci = m.BestMove
+		if best, err := ai.PlayMoves(rm.FenBefore, []string{m.BestMove}); err == nil {
+			rm.BestSan = best[0].San
+		}
+
+		before := capScore(m.ScoreCP)
+		after := before
+		if m.PlayedMove != m.BestMove && m.HasPlayed {
+			after = capScore(m.PlayedScore)
+		}
+		rm.LossCP = max0(before - after)
+		rm.Class = classOf(rm.Uci == rm.BestUci, rm.LossCP)
+		rm.Accuracy = moveAccuracy(before, after)
+
+		eval = after
+		if !rm.White {
+			eval = -after
+		}
+		rm.EvalCP = eval
+
+		side, sideTotals := &report.White, &white
+		if !rm.White {
+			side, sideTotals = &report.Black, &black
+		}
+		sideTotals.moves++
+		sideTotals.loss += rm.LossCP
+		sideTotals.accuracy += rm.Accuracy
+
+		switch rm.Class {
+		case ClassInaccuracy:
+			side.Inaccuracies++
+		case ClassMistake:
+			side.Mistakes++
+		case ClassBlunder:
+			side.Blunders++
+		}
+		if rm.Class != ClassBest && rm.Class != ClassGood {
+			// The engine line, as far as it is legal
+			line, _ := ai.PlayMoves(rm.FenBefore, m.Line)
+			for _, p := range line {
+				rm.Line = append(rm.Line, p.San)
+			}
+		}
+		if rm.Class == ClassMistake || rm.Class == ClassBlunder {
+			report.Critical = append(report.Critical, rm)
+		}
+	}
+
+	for _, st := range []struct {
+		side *SideReport
+		t    totals
+	}{{&report.White, white}, {&report.Black, black}} {
+		if st.t.moves > 0 {
+			st.side.Accuracy = math.Round(st.t.accuracy/float64(st.t.moves)*10) / 10
+			st.side.AverageLoss = st.t.loss / st.t.moves
+		}
+	}
+
+	sort.SliceStable(report.Critical, func(i, j int) bool {
+		return report.Critical[i].LossCP > report.Critical[j].LossCP
+	})
+	if len(report.Critical) > ReportCriticalMoves {
+		report.Critical = report.Critical[:ReportCriticalMoves]
+	}
+	sort.SliceStable(report.Critical, func(i, j int) bool {
+		return report.Critical[i].Ply < report.Critical[j].Ply
+	})
+	return report, nil
+}
+
+// classOf - the class of a move losing loss
+func classOf(isBest bool, loss int) MoveClass {
+	switch {
+	case isBest:
+		return ClassBest
+	case loss <= GoodLossCP:
+		return ClassGood
+	case loss <= InaccuracyLossCP:
+		return ClassInaccuracy
+	case loss <= MistakeLossCP:
+		return ClassMistake
+	default:
+		return ClassBlunder
+	}
+}
+
+func capScore(cp int) int {
+	if cp > reportMaxCP {
+		return reportMaxCP
+	}
+	if cp < -reportMaxCP {
+		return -reportMaxCP
+	}
+	return cp
+}
+
+func max0(v int) int {
+	if v < 0 {
+		return 0
+	}
+	return v
+}
+
+// winPercent - the chance to win of the player with the score, 0 to 100
+func winPercent(cp int) float64 {
+	return 50 + 50*(2/(1+math.Exp(-0.00368208*float64(cp)))-1)
+}
+
+// moveAccuracy - from the drop of the chance to win, 100 when nothing is lost.
+func moveAccuracy(before, after int) float64 {
+	drop := winPercent(before) - winPercent(after)
+	if drop < 0 {
+		drop = 0
+	}
+	acc := 103.1668*math.Exp(-0.04354*drop) - 3.1669
+	return math.Max(0, math.Min(100, acc))
+}
+
+// Summarize - the summary of the responses of a pgn analysis, as kept by a job.
+func Summarize(results []*PgnResponse) *GameSummary {
+	b := NewSummaryBuilder()
+	for _, resp := range results {
+		b.Add(resp)
+	}
+	b.Finish()
+	return b.Summary()
+}
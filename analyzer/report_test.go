@@ -0,0 +1,64 @@
+package analyzer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBuildReport(t *testing.T) {
+	pgn := "[Event \"Test\"]\n[White \"Anna\"]\n[Black \"Ben\"]\n\n1. e4 f6 2. d4 g5 3. Qh5# 1-0\n"
+	summary := Summarize([]*PgnResponse{
+		{MoveNum: 1, PlayedMove: "e2e4", ARInfo: &ARInfo{Depth: 5, MPv: 1, ScoreCP: 30, Moves: []string{"e2e4", "e7e5"}, IsUserMove: true}},
+		{MoveNum: 1, PlayedMove: "e2e4", ARBestMove: &ARBestMove{BestMove: "e2e4"}},
+		{MoveNum: 2, PlayedMove: "f7f6", ARInfo: &ARInfo{Depth: 5, MPv: 1, ScoreCP: -30, Moves: []string{"e7e5", "g1f3"}}},
+		{MoveNum: 2, PlayedMove: "f7f6", ARInfo: &ARInfo{Depth: 5, MPv: 2, ScoreCP: -70, Moves: []string{"f7f6"}, IsUserMove: true}},
+		{MoveNum: 2, PlayedMove: "f7f6", ARBestMove: &ARBestMove{BestMove: "e7e5"}},
+		{MoveNum: 3, PlayedMove: "d2d4", ARInfo: &ARInfo{Depth: 5, MPv: 1, ScoreCP: 80, Moves: []string{"d2d4"}, IsUserMove: true}},
+		{MoveNum: 3, PlayedMove: "d2d4", ARBestMove: &ARBestMove{BestMove: "d2d4"}},
+		{MoveNum: 4, PlayedMove: "g7g5", ARInfo: &ARInfo{Depth: 5, MPv: 1, ScoreCP: -90, Moves: []string{"e7e6", "b1c3"}}},
+		{MoveNum: 4, PlayedMove: "g7g5", ARInfo: &ARInfo{Depth: 5, MPv: 2, ScoreCP: -14999, MateIn: -1, Moves: []string{"g7g5", "d1h5"}, IsUserMove: true}},
+		{MoveNum: 4, PlayedMove: "g7g5", ARBestMove: &ARBestMove{BestMove: "e7e6"}},
+		{RCode: RCODE_DONE, Done: true},
+	})
+
+	report, err := BuildReport(pgn, summary)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(report.Moves))
+	assert.Equal(t, "Anna", report.White.Name)
+	assert.Equal(t, "Ben", report.Black.Name)
+
+	m := report.Moves[1]
+	assert.Equal(t, 1, m.Number)
+	assert.False(t, m.White)
+	assert.Equal(t, "f6", m.San)
+	assert.Equal(t, "e5", m.BestSan)
+	assert.Equal(t, 40, m.LossCP)
+	assert.Equal(t, ClassMistake, m.Class)
+	assert.Equal(t, []string{"e5", "Nf3"}, m.Line)
+	assert.Equal(t, 70, m.EvalCP)
+
+	m = report.Moves[3]
+	assert.Equal(t, ClassBlunder, m.Class)
+	assert.Equal(t, 1500-90, m.LossCP)
+	assert.Equal(t, 1500, m.EvalCP)
+	assert.Less(t, m.Accuracy, 20.0)
+
+	assert.Equal(t, ClassBest, report.Moves[0].Class)
+	assert.Equal(t, []string(nil), report.Moves[0].Line)
+	assert.False(t, report.Moves[4].Analyzed)
+
+	assert.Equal(t, 100.0, report.White.Accuracy)
+	assert.Equal(t, 1, report.Black.Mistakes)
+	assert.Equal(t, 1, report.Black.Blunders)
+	assert.Equal(t, 0, report.White.Blunders)
+	assert.Equal(t, []int{2, 4}, []int{report.Critical[0].Ply, report.Critical[1].Ply})
+
+	ReportCriticalMoves = 1
+	defer func() { ReportCriticalMoves = 5 }()
+	report, _ = BuildReport(pgn, summary)
+	assert.Equal(t, 1, len(report.Critical))
+	assert.Equal(t, 4, report.Critical[0].Ply)
+
+	_, err = BuildReport("[Event \"Test\"]\n\n1. e4 e4 *\n", summary)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,103 @@
+package analyzer
+
+// MoveSummary - the analysis of one move, the scores are from the view
+// of the player to move.
+type MoveSummary struct {
+	MoveNum     int      `json:"moveNum"`
+	PlayedMove  string   `json:"playedMove"`
+	BestMove    string   `json:"bestMove"`
+	Line        []string `json:"line"`  // the best line, uci
+	ScoreCP     int      `json:"score"` // the best line
+	MateIn      int      `json:"mateIn"`
+	Depth       int      `json:"depth"`
+	HasPlayed   bool     `json:"hasPlayed"`   // the played move was searched
+	PlayedScore int      `json:"playedScore"` // the line of the played move
+	LossCP      int      `json:"loss"`        // the best score less the played score
+}
+
+// GameSummary - the moves analyzed.
+type GameSummary struct {
+	Moves []*MoveSummary `json:"moves"`
+}
+
+// SummaryBuilder - collects the responses of an analysis into move summaries.
+type SummaryBuilder struct {
+	current     *MoveSummary
+	bestDone    bool
+	playedDepth int
+	summary     *GameSummary
+}
+
+func NewSummaryBuilder() *SummaryBuilder {
+	return &SummaryBuilder{summary: &GameSummary{Moves: make([]*MoveSummary, 0)}}
+}
+
+// Add - adds the response, returns the summary of the previous move
+// when the response is for the next move.
+func (b *SummaryBuilder) Add(resp *PgnResponse) *MoveSummary {
+	if resp.ARInfo == nil && resp.ARBestMove == nil {
+		return nil
+	}
+
+	var completed *MoveSummary
+	if b.current != nil && resp.MoveNum != b.current.MoveNum {
+		completed = b.complete()
+	}
+	if b.current == nil {
+		b.current = &MoveSummary{MoveNum: resp.MoveNum, PlayedMove: resp.PlayedMove}
+		b.bestDone = false
+		b.playedDepth = -1
+	}
+	m := b.current
+
+	if info := resp.ARInfo; info != nil {
+		// The best line, until the first search ends, a search of the played move may follow
+		if !b.bestDone && info.MPv <= 1 && info.Depth >= m.Depth {
+			m.Depth = info.Depth
+			m.ScoreCP = info.ScoreCP
+			m.MateIn = info.MateIn
+			if len(info.Moves) > 0 {
+				m.BestMove = info.Moves[0]
+				m.Line = info.Moves
+			}
+		}
+		if info.IsUserMove && info.Depth >= b.playedDepth {
+			b.playedDepth = info.Depth
+			m.HasPlayed = true
+			m.PlayedScore = info.ScoreCP
+		}
+	}
+	if resp.ARBestMove != nil && !b.bestDone {
+		b.bestDone = true
+		if len(resp.ARBestMove.BestMove) > 0 {
+			m.BestMove = resp.ARBestMove.BestMove
+		}
+	}
+	return completed
+}
+
+// Finish - the summary of the last move, nil when there is none.
+func (b *SummaryBuilder) Finish() *MoveSummary {
+	if b.current == nil {
+		return nil
+	}
+	return b.complete()
+}
+
+// Summary - the moves completed so far.
+func (b *SummaryBuilder) Summary() *GameSummary {
+	return b.summary
+}
+
+func (b *SummaryBuilder) complete() *MoveSummary {
+	m := b.current
+	if m.HasPlayed {
+		m.LossCP = m.ScoreCP - m.PlayedScore
+		if m.LossCP < 0 || m.PlayedMove == m.BestMove {
+			m.LossCP = 0
+		}
+	}
+	b.summary.Moves = append(b.summary.Moves, m)
+	b.current = nil
+	return m
+}
@@ -0,0 +1,31 @@
+package analyzer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSummaryBuilder(t *testing.T) {
+	b := NewSummaryBuilder()
+
+	// Move 1, the played move is the second line
+	assert.Nil(t, b.Add(&PgnResponse{MoveNum: 1, PlayedMove: "d2d4", ARInfo: &ARInfo{Depth: 1, MPv: 1, ScoreCP: 30, Moves: []string{"e2e4"}}}))
+	assert.Nil(t, b.Add(&PgnResponse{MoveNum: 1, PlayedMove: "d2d4", ARInfo: &ARInfo{Depth: 1, MPv: 2, ScoreCP: 20, Moves: []string{"d2d4"}, IsUserMove: true}}))
+	assert.Nil(t, b.Add(&PgnResponse{MoveNum: 1, PlayedMove: "d2d4", ARInfo: &ARInfo{Depth: 2, MPv: 1, ScoreCP: 35, Moves: []string{"e2e4"}}}))
+	assert.Nil(t, b.Add(&PgnResponse{MoveNum: 1, PlayedMove: "d2d4", ARBestMove: &ARBestMove{BestMove: "e2e4"}}))
+
+	// Move 2, the played move needed its own search
+	m := b.Add(&PgnResponse{MoveNum: 2, PlayedMove: "f7f6", ARInfo: &ARInfo{Depth: 3, MPv: 1, ScoreCP: -10, Moves: []string{"e7e5"}}})
+	assert.Equal(t, &MoveSummary{MoveNum: 1, PlayedMove: "d2d4", BestMove: "e2e4", Line: []string{"e2e4"}, ScoreCP: 35, Depth: 2, HasPlayed: true, PlayedScore: 20, LossCP: 15}, m)
+	assert.Nil(t, b.Add(&PgnResponse{MoveNum: 2, PlayedMove: "f7f6", ARBestMove: &ARBestMove{BestMove: "e7e5"}}))
+	assert.Nil(t, b.Add(&PgnResponse{MoveNum: 2, PlayedMove: "f7f6", ARInfo: &ARInfo{Depth: 3, MPv: 1, ScoreCP: -150, Moves: []string{"f7f6"}, IsUserMove: true}}))
+	assert.Nil(t, b.Add(&PgnResponse{MoveNum: 2, PlayedMove: "f7f6", ARBestMove: &ARBestMove{BestMove: "f7f6"}}))
+	assert.Nil(t, b.Add(&PgnResponse{RCode: RCODE_DONE, Done: true}))
+
+	m = b.Finish()
+	assert.Equal(t, "e7e5", m.BestMove)
+	assert.Equal(t, -10, m.ScoreCP)
+	assert.Equal(t, 140, m.LossCP)
+	assert.Nil(t, b.Finish())
+	assert.Equal(t, 2, len(b.Summary().Moves))
+}
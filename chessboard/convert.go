@@ -0,0 +1,107 @@
+package ai
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LegalMove - a move in both notations
+type LegalMove struct {
+	Uci string `json:"uci"`
+	San string `json:"san"`
+}
+
+// PlyPosition - the position after a ply of a game
+type PlyPosition struct {
+	Ply int    `json:"ply"` // 1 is the first move of the game
+	Uci string `json:"uci"`
+	San string `json:"san"`
+	Fen string `json:"fen"`
+}
+
+// PlyOfFen - the plies played before the fen, from the move number and side.
+func PlyOfFen(fen string) int {
+	sects := strings.Fields(fen)
+	ply := 0
+	if len(sects) >= 6 {
+		if full, err := strconv.Atoi(sects[5]); err == nil && full > 0 {
+			ply = 2 * (full - 1)
+		}
+	}
+	if len(sects) >= 2 && sects[1] == "b" {
+		ply++
+	}
+	return ply
+}
+
+// LegalMoves - the moves of the side to move
+func LegalMoves(b *Board) []LegalMove {
+	// Reset the ply ...
+	b.ply = 0
+
+	res := make([]LegalMove, 0)
+	for _, mv := range GetAllValidMoves(b) {
+		res = append(res, LegalMove{Uci: MoveToString(mv), San: SanForMove(b, mv)})
+	}
+	return res
+}
+
+// ParseMove - the legal move, in coordinates (e7e8q) or SAN (Nf3).
+func ParseMove(b *Board, str string) (Move, error) {
+	if mv, err := MoveFromUci(b, str); err == nil {
+		return mv, nil
+	}
+	return MoveFromSAN(b, str)
+}
+
+// PlayMoves - the moves, in coordinates or SAN, played from the fen.
+// Returns the position after each.
+func PlayMoves(fen string, moves []string) ([]PlyPosition, error) {
+	brd := NewBoard()
+	if err := ParseFen(brd, fen); err != nil {
+		return nil, err
+	}
+	return playMoves(brd, PlyOfFen(fen), moves)
+}
+
+// PgnPositions - the position after each move of the pgn, with the initial fen.
+func PgnPositions(pgn string) (string, []PlyPosition, error) {
+	wrapper := NewPgnWrapper(pgn)
+	if err := wrapper.Parse(); err != nil {
+		return "", nil, err
+	}
+
+	initial := wrapper.InitialFen()
+	brd := NewBoard()
+	if err := ParseFen(brd, initial); err != nil {
+		return "", nil, err
+	}
+	moves := make([]string, 0, len(wrapper.InternalMoves))
+	for _, mv := range wrapper.InternalMoves {
+		moves = append(moves, MoveToString(mv))
+	}
+	positions, err := playMoves(brd, PlyOfFen(initial), moves)
+	return initial, positions, err
+}
+
+func playMoves(brd *Board, startPly int, moves []string) ([]PlyPosition, error) {
+	res := make([]PlyPosition, 0, len(moves))
+	for idx, str := range moves {
+		mv, err := ParseMove(brd, str)
+		if err != nil {
+			return res, fmt.Errorf("move %d: %w", idx+1, err)
+		}
+		san := SanForMove(brd, mv)
+		if err = brd.MakeMove(mv, str); err != nil {
+			return res, fmt.Errorf("move %d: %w", idx+1, err)
+		}
+		res = append(res, PlyPosition{
+			Ply: startPly + idx + 1,
+			Uci: MoveToString(mv),
+			San: san,
+			Fen: BoardToFen(brd, startPly+idx+1),
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,55 @@
+package ai
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const startFen = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+func TestPlyOfFen(t *testing.T) {
+	assert.Equal(t, 0, PlyOfFen(startFen))
+	assert.Equal(t, 1, PlyOfFen("rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1"))
+	assert.Equal(t, 20, PlyOfFen("8/8/8/8/8/4k3/8/4K2R w K - 0 11"))
+	assert.Equal(t, 1, PlyOfFen("8/8/8/8/8/4k3/8/4K2R b - -"))
+}
+
+func TestLegalMoves(t *testing.T) {
+	brd := NewBoard()
+	assert.Nil(t, ParseFen(brd, startFen))
+	moves := LegalMoves(brd)
+	assert.Equal(t, 20, len(moves))
+	assert.Contains(t, moves, LegalMove{Uci: "g1f3", San: "Nf3"})
+	assert.Contains(t, moves, LegalMove{Uci: "e2e4", San: "e4"})
+
+	assert.Nil(t, ParseFen(brd, "4k3/P7/8/8/8/8/8/4K3 w - - 0 1"))
+	assert.Contains(t, LegalMoves(brd), LegalMove{Uci: "a7a8q", San: "a8=Q+"})
+}
+
+func TestPlayMoves(t *testing.T) {
+	plies, err := PlayMoves(startFen, []string{"e2e4", "e5", "Qh5", "Nc6", "f1c4", "Nf6", "Qxf7"})
+	assert.Nil(t, err)
+	assert.Equal(t, 7, len(plies))
+	assert.Equal(t, PlyPosition{Ply: 1, Uci: "e2e4", San: "e4", Fen: "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1"}, plies[0])
+	assert.Equal(t, "e7e5", plies[1].Uci)
+	assert.Equal(t, "Qxf7#", plies[6].San)
+	assert.Equal(t, "r1bqkb1r/pppp1Qpp/2n2n2/4p3/2B1P3/8/PPPP1PPP/RNB1K1NR b KQkq - 0 4", plies[6].Fen)
+
+	plies, err = PlayMoves(startFen, []string{"e4", "e4"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "move 2: ")
+	assert.Equal(t, 1, len(plies))
+
+	_, err = PlayMoves("8/8/8 w - - 0 1", []string{"e4"})
+	assert.NotNil(t, err)
+}
+
+func TestPgnPositions(t *testing.T) {
+	initial, plies, err := PgnPositions("[Event \"Test\"]\n\n1. e4 e5 2. Nf3 Nc6 *\n")
+	assert.Nil(t, err)
+	assert.Equal(t, startFen, initial)
+	assert.Equal(t, 4, len(plies))
+	assert.Equal(t, "Nc6", plies[3].San)
+	assert.Equal(t, 4, plies[3].Ply)
+	assert.Equal(t, "r1bqkbnr/pppp1ppp/2n5/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq - 2 3", plies[3].Fen)
+}
@@ -1,7 +1,6 @@
 package ai
 
 import (
-	"fmt"
 	"math/rand"
 	"time"
 )
@@ -456,7 +455,6 @@ func initFileRanks() {
 This is static, done once
 */
 func init() {
-	fmt.Println("Init called in definitions")
 	NoMove = make([]int, 0)
 	randx = rand.New(rand.NewSource(time.Now().Unix()))
 	initFileRanks()
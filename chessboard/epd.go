@@ -0,0 +1,192 @@
+package ai
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Epd - a position of a test suite, a fen with operations.
+//
+//	r1b1k2r/... w kq - bm Qxf7+; id "WAC.005"; c0 "mate in 2";
+type Epd struct {
+	Fen        string // the position with the move counters, from hmvc and fmvn when given
+	Operations map[string][]string
+
+	Id         string   // id
+	BestMoves  []string // bm, in SAN
+	AvoidMoves []string // am, in SAN
+	Comment    string   // c0
+}
+
+// ParseEpd - reads an epd line.
+// A full fen, with the move counters, is accepted before the operations.
+func ParseEpd(line string) (*Epd, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("invalid epd, missing fen fields: %s", line)
+	}
+	if err := ParseFen(NewBoard(), strings.Join(fields[:4], " ")); err != nil {
+		return nil, fmt.Errorf("invalid epd, %w", err)
+	}
+
+	rest := line
+	for idx := 0; idx < 4; idx++ {
+		rest = strings.TrimLeft(rest, " \t")
+		rest = rest[len(fields[idx]):]
+	}
+
+	e := &Epd{Operations: map[string][]string{}}
+	half, full := "0", "1"
+	if len(fields) >= 6 && isEpdNumber(fields[4]) && isEpdNumber(fields[5]) {
+		half, full = fields[4], fields[5]
+		for idx := 4; idx < 6; idx++ {
+			rest = strings.TrimLeft(rest, " \t")
+			rest = rest[len(fields[idx]):]
+		}
+	}
+
+	ops, err := splitEpdOperations(rest)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		e.Operations[op[0]] = op[1:]
+		switch op[0] {
+		case "id":
+			e.Id = strings.Join(op[1:], " ")
+		case "c0":
+			e.Comment = strings.Join(op[1:], " ")
+		case "bm":
+			e.BestMoves = op[1:]
+		case "am":
+			e.AvoidMoves = op[1:]
+		case "hmvc":
+			if len(op) > 1 && isEpdNumber(op[1]) {
+				half = op[1]
+			}
+		case "fmvn":
+			if len(op) > 1 && isEpdNumber(op[1]) {
+				full = op[1]
+			}
+		}
+	}
+	e.Fen = strings.Join(append(fields[:4:4], half, full), " ")
+	return e, nil
+}
+
+// ReadEpd - the positions of an epd suite, one per line.
+// Empty lines and lines starting with # are skipped.
+func ReadEpd(text string) ([]*Epd, error) {
+	var res []*Epd
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	num := 0
+	for scanner.Scan() {
+		num++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		e, err := ParseEpd(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", num, err)
+		}
+		res = append(res, e)
+	}
+	return res, scanner.Err()
+}
+
+// Board - a new board with the position.
+func (e *Epd) Board() *Board {
+	brd := NewBoard()
+	// Checked by ParseEpd
+	ParseFen(brd, e.Fen)
+	return brd
+}
+
+// Solves - the coordinate move (ex: e2e4) is one of the best moves and none of the moves to avoid.
+// Without bm or am operations there is nothing to solve, an error is returned.
+func (e *Epd) Solves(move string) (bool, error) {
+	if len(e.BestMoves) == 0 && len(e.AvoidMoves) == 0 {
+		return false, fmt.Errorf("epd %s has no bm or am", e.Id)
+	}
+	brd := e.Board()
+	mv, err := MoveFromUci(brd, move)
+	if err != nil {
+		return false, err
+	}
+
+	matches := func(sans []string) (bool, error) {
+		for _, san := range sans {
+			want, err := MoveFromSAN(brd, san)
+			if err != nil {
+				return false, fmt.Errorf("epd %s: %w", e.Id, err)
+			}
+			if want == mv {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	if len(e.BestMoves) > 0 {
+		if ok, err := matches(e.BestMoves); !ok || err != nil {
+			return false, err
+		}
+	}
+	avoid, err := matches(e.AvoidMoves)
+	return !avoid && err == nil, err
+}
+
+// splitEpdOperations - the operations, the opcode then the operands.
+// Quoted operands keep their spaces and semicolons.
+func splitEpdOperations(text string) ([][]string, error) {
+	var ops [][]string
+	var op []string
+	var sb strings.Builder
+	inToken, inQuote := false, false
+
+	endToken := func() {
+		if inToken {
+			op = append(op, sb.String())
+			sb.Reset()
+			inToken = false
+		}
+	}
+	endOp := func() {
+		endToken()
+		if len(op) > 0 {
+			ops = append(ops, op)
+			op = nil
+		}
+	}
+
+	for _, c := range text {
+		switch {
+		case inQuote && c == '"':
+			inQuote = false
+		case inQuote:
+			sb.WriteRune(c)
+		case c == '"':
+			inQuote, inToken = true, true
+		case c == ';':
+			endOp()
+		case c == ' ' || c == '\t':
+			endToken()
+		default:
+			sb.WriteRune(c)
+			inToken = true
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("invalid epd, unterminated string")
+	}
+	endOp()
+	return ops, nil
+}
+
+func isEpdNumber(str string) bool {
+	_, err := strconv.Atoi(str)
+	return err == nil
+}
@@ -0,0 +1,69 @@
+package ai
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseEpd(t *testing.T) {
+	e, err := ParseEpd(`2rr3k/pp3pp1/1nnqbN1p/3pN3/2pP4/2P3Q1/PPB4P/R4RK1 w - - bm Qg6; id "WAC.001"; c0 "mate; in 3";`)
+	assert.Nil(t, err)
+	assert.Equal(t, "2rr3k/pp3pp1/1nnqbN1p/3pN3/2pP4/2P3Q1/PPB4P/R4RK1 w - - 0 1", e.Fen)
+	assert.Equal(t, "WAC.001", e.Id)
+	assert.Equal(t, []string{"Qg6"}, e.BestMoves)
+	assert.Equal(t, "mate; in 3", e.Comment)
+	assert.Equal(t, []string{"WAC.001"}, e.Operations["id"])
+
+	e, err = ParseEpd(`r1bqkb1r/pppp1ppp/2n2n2/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - 4 4 bm Qxf7# Qxf7+; am Qh4`)
+	assert.Nil(t, err)
+	assert.Equal(t, "r1bqkb1r/pppp1ppp/2n2n2/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - 4 4", e.Fen)
+	assert.Equal(t, []string{"Qxf7#", "Qxf7+"}, e.BestMoves)
+	assert.Equal(t, []string{"Qh4"}, e.AvoidMoves)
+
+	e, err = ParseEpd(`8/8/8/8/8/8/8/K6k b - - hmvc 12; fmvn 40;`)
+	assert.Nil(t, err)
+	assert.Equal(t, "8/8/8/8/8/8/8/K6k b - - 12 40", e.Fen)
+
+	for _, line := range []string{
+		"8/8/8 w - -",
+		"8/8/8/8/8/8/8/K6k x - -",
+		"8/8/8/8/8/8/8/K6x w - -",
+		`8/8/8/8/8/8/8/K6k w - - id "open`,
+	} {
+		_, err = ParseEpd(line)
+		assert.NotNil(t, err, line)
+	}
+}
+
+func TestEpdSolves(t *testing.T) {
+	e, err := ParseEpd(`r1bqkb1r/pppp1ppp/2n2n2/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - bm Qxf7#; id "scholar";`)
+	assert.Nil(t, err)
+
+	ok, err := e.Solves("h5f7")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = e.Solves("h5e5")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, err = e.Solves("h5h8")
+	assert.NotNil(t, err)
+
+	e, _ = ParseEpd(`r1bqkb1r/pppp1ppp/2n2n2/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - am Qxe5+;`)
+	ok, _ = e.Solves("h5e5")
+	assert.False(t, ok)
+	ok, _ = e.Solves("h5f7")
+	assert.True(t, ok)
+}
+
+func TestReadEpd(t *testing.T) {
+	epds, err := ReadEpd("# suite\n\n8/8/8/8/8/8/8/K6k w - - id \"a\";\n8/8/8/8/8/8/8/K6k b - - id \"b\";\n")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(epds))
+	assert.Equal(t, "b", epds[1].Id)
+
+	_, err = ReadEpd("8/8/8/8/8/8/8/K6k w - -\nbad\n")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+}
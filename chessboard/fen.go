@@ -2,7 +2,9 @@ package ai
 
 import (
 	"bytes"
+	"fmt"
 	"strconv"
+	"strings"
 )
 
 func BoardToFen(board *Board, numMoves int) string {
@@ -85,146 +87,293 @@ func BoardToFen(board *Board, numMoves int) string {
 
 }
 
+// The fields of a fen, for FenError
+const (
+	FenFieldPlacement = "placement"
+	FenFieldSide      = "side"
+	FenFieldCastling  = "castling"
+	FenFieldEnPassant = "enPassant"
+	FenFieldHalfmove  = "halfmove"
+	FenFieldFullmove  = "fullmove"
+	FenFieldPosition  = "position" // the pieces can not stand like this
+)
+
+// FenMode - how much of a fen must be right.
+type FenMode int
+
+const (
+	// FenLenient - the fields after the placement may be left out, castling
+	// rights and an en passant square the position does not allow are dropped
+	// and bad move counters are ignored.
+	FenLenient FenMode = iota
+	// FenStrict - all six fields, each as the position allows.
+	FenStrict
+)
+
+// FenError - what is wrong with a fen
+type FenError struct {
+	Fen   string
+	Field string // ex: FenFieldCastling
+	Msg   string
+}
+
+func (e *FenError) Error() string {
+	return fmt.Sprintf("invalid fen %s: %s", e.Field, e.Msg)
+}
+
+// ParseFen - sets up the board, lenient, see ParseFenMode.
 // rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1
-func ParseFen(board *Board, fen string) {
+func ParseFen(board *Board, fen string) error {
+	return ParseFenMode(board, fen, FenLenient)
+}
 
-	board.ResetBoard()
+// ParseFenStrict - sets up the board, see ParseFenMode.
+func ParseFenStrict(board *Board, fen string) error {
+	return ParseFenMode(board, fen, FenStrict)
+}
 
-	var rank = RANK_8
-	var file = FILE_A
-	var piece = 0
-	var count = 0
-	var i = 0
-	var sq120 = 0
-	var fenCnt = 0
-
-	//fmt.Println("8 = ", '8')
-	//fmt.Println("1 = ", '1')
-
-	for rank = RANK_8; rank >= RANK_1 && fenCnt < len(fen); {
-		count = 1
-
-		//fmt.Println("Process:" , fen[fenCnt:fenCnt+1], "  fen pos: " , fenCnt,  )
-		switch fen[fenCnt] {
-		case 'p':
-			piece = Piece_BPAWN
-		case 'r':
-			piece = Piece_BROOK
-		case 'n':
-			piece = Piece_BKNIGHT
-		case 'b':
-			piece = Piece_BBISHOP
-		case 'k':
-			piece = Piece_BKING
-		case 'q':
-			piece = Piece_BQUEEN
-
-		case 'P':
-			piece = Piece_WPAWN
-		case 'R':
-			piece = Piece_WROOK
-		case 'N':
-			piece = Piece_WKNIGHT
-		case 'B':
-			piece = Piece_WBISHOP
-		case 'K':
-			piece = Piece_WKING
-		case 'Q':
-			piece = Piece_WQUEEN
-
-		case '1', '2', '3', '4', '5', '6', '7', '8':
-			//fmt.Println("Here")
-			piece = Piece_EMPTY
-			count = int(fen[fenCnt] - '0')
-			//fmt.Println("for ", fen[fenCnt], " = " , count)
-
-		case '/', ' ':
-			//fmt.Println("process /")
-			rank--
-			file = FILE_A
-			fenCnt++
-			count = 0
-			continue
-		default:
-			//fmt.Println("Fen error", fen)
-			return
-		}
+// ParseFenMode - sets up the board, the error is a *FenError.
+// Both modes reject a position an engine can not search: a bad placement,
+// not one king a side, pawns on the back rank or the side not to move in check.
+// The board is set up when only the position is wrong, it is empty otherwise.
+func ParseFenMode(board *Board, fen string, mode FenMode) error {
+	_, err := parseFen(board, fen, mode)
+	return err
+}
 
-		//fmt.Println("---- " , count)
-		for i = 0; i < count; i++ {
-			sq120 = FR2SQ(file, rank)
-			//fmt.Println("Set piece: ", piece, " into", sq120, "File:", file, " Rank:", rank)
-			board.pieces[sq120] = piece
-			file++
-		}
-		fenCnt++
+// NormalizeFen - the fen as BoardToFen writes it, what the lenient mode
+// dropped is left out.
+func NormalizeFen(fen string, mode FenMode) (string, error) {
+	brd := NewBoard()
+	fullMove, err := parseFen(brd, fen, mode)
+	if err != nil {
+		return "", err
 	}
+	ply := (fullMove - 1) * 2
+	if brd.side == Color_BLACK {
+		ply++
+	}
+	return BoardToFen(brd, ply), nil
+}
 
-	if fenCnt < len(fen) {
-		// w or b
-		switch fen[fenCnt] {
-		case 'w':
-			board.side = Color_WHITE
-		case 'b':
-			board.side = Color_BLACK
-		}
+// parseFen - sets up the board, returns the full move number.
+func parseFen(board *Board, fen string, mode FenMode) (int, error) {
+	board.ResetBoard()
+	fail := func(field string, format string, args ...any) (int, error) {
+		return 0, &FenError{Fen: fen, Field: field, Msg: fmt.Sprintf(format, args...)}
 	}
 
-	fenCnt += 2
+	fields := strings.Fields(fen)
+	if len(fields) == 0 {
+		return fail(FenFieldPlacement, "the fen is empty")
+	}
+	if mode == FenStrict && len(fields) != 6 {
+		return fail(FenFieldPlacement, "needs 6 fields, has %d", len(fields))
+	}
+	if len(fields) > 6 {
+		return fail(FenFieldPlacement, "has %d fields, at most 6", len(fields))
+	}
 
-	var brk = false
-	for i = 0; (i < 4) && !brk; i++ {
-		if fenCnt >= len(fen) {
-			break
+	// The pieces
+	ranks := strings.Split(fields[0], "/")
+	if len(ranks) != 8 {
+		return fail(FenFieldPlacement, "needs 8 ranks, has %d", len(ranks))
+	}
+	var pieces [64]Piece
+	for idx, text := range ranks {
+		rank := RANK_8 - idx
+		file := FILE_A
+		for _, c := range text {
+			count := 1
+			piece := Piece_EMPTY
+			switch {
+			case c >= '1' && c <= '8':
+				count = int(c - '0')
+			case c != '.' && strings.ContainsRune(PceCharLetter, c):
+				piece = strings.IndexRune(PceCharLetter, c)
+			default:
+				return fail(FenFieldPlacement, "unknown piece %q on rank %d", c, rank+1)
+			}
+			if file+count > 8 {
+				return fail(FenFieldPlacement, "rank %d is more than 8 squares: %s", rank+1, text)
+			}
+			for ; count > 0; count-- {
+				pieces[rank*8+file] = piece
+				file++
+			}
+		}
+		if file != 8 {
+			return fail(FenFieldPlacement, "rank %d is %d squares, needs 8: %s", rank+1, file, text)
 		}
-		// println("Castle fen ${fenChar[fenCnt]}")
-		switch fen[fenCnt] {
-		case 'K':
-			board.castlePermFlag |= WKCA
-		case 'Q':
-			board.castlePermFlag |= WQCA
-		case 'k':
-			board.castlePermFlag |= BKCA
-		case 'q':
-			board.castlePermFlag |= BQCA
+	}
+
+	// The side
+	side := Color_WHITE
+	if len(fields) > 1 {
+		switch fields[1] {
+		case "w":
+		case "b":
+			side = Color_BLACK
 		default:
-			brk = true
-			// fenCnt += 1
-			// break
+			return fail(FenFieldSide, "must be w or b, is %s", fields[1])
 		}
+	}
 
-		if !brk {
-			fenCnt += 1
+	// The castling, checked against the pieces further down
+	castle := 0
+	if len(fields) > 2 && fields[2] != "-" {
+		for _, c := range fields[2] {
+			var flag int
+			switch c {
+			case 'K':
+				flag = WKCA
+			case 'Q':
+				flag = WQCA
+			case 'k':
+				flag = BKCA
+			case 'q':
+				flag = BQCA
+			default:
+				return fail(FenFieldCastling, "unknown right %q in %s, use KQkq or -", c, fields[2])
+			}
+			if castle&flag != 0 && mode == FenStrict {
+				return fail(FenFieldCastling, "right %q given twice", c)
+			}
+			castle |= flag
 		}
+	}
 
+	// The en passant square
+	epFile, epRank := -1, -1
+	if len(fields) > 3 && fields[3] != "-" {
+		ep := fields[3]
+		if len(ep) != 2 || ep[0] < 'a' || ep[0] > 'h' || ep[1] < '1' || ep[1] > '8' {
+			return fail(FenFieldEnPassant, "must be a square or -, is %s", ep)
+		}
+		epFile, epRank = int(ep[0]-'a'), int(ep[1]-'1')
 	}
 
-	for fenCnt < len(fen) {
-		if fen[fenCnt] == ' ' {
-			fenCnt++
-			break
+	// The move counters
+	halfMove, fullMove := 0, 1
+	if len(fields) > 4 {
+		n, err := strconv.Atoi(fields[4])
+		switch {
+		case err == nil && n >= 0:
+			halfMove = n
+		case mode == FenStrict:
+			return fail(FenFieldHalfmove, "must be a number of 0 or more, is %s", fields[4])
+		}
+	}
+	if len(fields) > 5 {
+		n, err := strconv.Atoi(fields[5])
+		switch {
+		case err == nil && n >= 1:
+			fullMove = n
+		case mode == FenStrict:
+			return fail(FenFieldFullmove, "must be a number of 1 or more, is %s", fields[5])
 		}
-		fenCnt++
 	}
 
-	// fenCnt++
+	// The board is set up, what follows checks it
+	for sq64, piece := range pieces {
+		board.pieces[FR2SQ(sq64%8, sq64/8)] = piece
+	}
+	board.side = side
+	board.fiftyMove = halfMove
 
-	if fenCnt < len(fen) {
-		// En Passant
-		//fmt.Println("At " , fenCnt, "    ", fen[fenCnt], "   ", fen)
-		//fmt.Println("At " , fenCnt, "    ", fen[fenCnt], "   ", fen[fenCnt:])
-		if fen[fenCnt] != '-' {
-			file = int(fen[fenCnt] - 'a')
-			fenCnt++
-			rank = int(fen[fenCnt] - '1')
-			board.enPassantSq120 = fr_to_SQ120(file, rank)
+	at := func(file File, rank Rank) Piece {
+		return pieces[rank*8+file]
+	}
+
+	for _, right := range []struct {
+		flag        int
+		name        string
+		king, rook  Piece
+		rank, rookF int
+	}{
+		{WKCA, "K", Piece_WKING, Piece_WROOK, RANK_1, FILE_H},
+		{WQCA, "Q", Piece_WKING, Piece_WROOK, RANK_1, FILE_A},
+		{BKCA, "k", Piece_BKING, Piece_BROOK, RANK_8, FILE_H},
+		{BQCA, "q", Piece_BKING, Piece_BROOK, RANK_8, FILE_A},
+	} {
+		if castle&right.flag == 0 || (at(FILE_E, right.rank) == right.king && at(right.rookF, right.rank) == right.rook) {
+			continue
 		}
-		fenCnt += 2
+		if mode == FenStrict {
+			return fail(FenFieldCastling, "right %s needs the king and rook on their squares", right.name)
+		}
+		castle &^= right.flag
+	}
+	board.castlePermFlag = castle
 
+	if epFile >= 0 {
+		// The pawn that moved two squares is in front of the square, which it passed
+		wantRank, pawnRank, fromRank, pawn := RANK_6, RANK_5, RANK_7, Piece_BPAWN
+		if side == Color_BLACK {
+			wantRank, pawnRank, fromRank, pawn = RANK_3, RANK_4, RANK_2, Piece_WPAWN
+		}
+		switch {
+		case epRank != wantRank:
+			if mode == FenStrict {
+				return fail(FenFieldEnPassant, "%s is not on rank %d", fields[3], wantRank+1)
+			}
+		case at(epFile, pawnRank) != pawn || at(epFile, epRank) != Piece_EMPTY || at(epFile, fromRank) != Piece_EMPTY:
+			if mode == FenStrict {
+				return fail(FenFieldEnPassant, "no pawn can have passed %s", fields[3])
+			}
+		default:
+			board.enPassantSq120 = FR2SQ(epFile, epRank)
+		}
 	}
 
 	board.posKey = board.generatePosKey()
-
 	board.updateListsMaterial()
 
+	// The position
+	counts := make([]int, 13)
+	for _, piece := range pieces {
+		counts[piece]++
+	}
+	if counts[Piece_WKING] != 1 || counts[Piece_BKING] != 1 {
+		return fail(FenFieldPosition, "needs one king a side, has %d white and %d black", counts[Piece_WKING], counts[Piece_BKING])
+	}
+	for file := FILE_A; file <= FILE_H; file++ {
+		for _, rank := range []Rank{RANK_1, RANK_8} {
+			if piece := at(file, rank); piece == Piece_WPAWN || piece == Piece_BPAWN {
+				return fail(FenFieldPosition, "pawn on %c%d", 'a'+file, rank+1)
+			}
+		}
+	}
+	other := Color_BLACK
+	otherKing := Piece_BKING
+	if side == Color_BLACK {
+		other, otherKing = Color_WHITE, Piece_WKING
+	}
+	if board.isSqAttacked(board.pList[pieceIndex(otherKing, 0)], side) {
+		return fail(FenFieldPosition, "%c is in check but it is not to move", SideChar[other])
+	}
+	if mode == FenStrict {
+		for _, color := range []struct {
+			name                              string
+			pawn, knight, bishop, rook, queen int
+		}{
+			{"white", Piece_WPAWN, Piece_WKNIGHT, Piece_WBISHOP, Piece_WROOK, Piece_WQUEEN},
+			{"black", Piece_BPAWN, Piece_BKNIGHT, Piece_BBISHOP, Piece_BROOK, Piece_BQUEEN},
+		} {
+			// The pieces over the first are promoted pawns
+			promoted := max0(counts[color.knight]-2) + max0(counts[color.bishop]-2) + max0(counts[color.rook]-2) + max0(counts[color.queen]-1)
+			if counts[color.pawn]+promoted > 8 {
+				return fail(FenFieldPosition, "%s has more pawns and promoted pieces than 8 pawns allow", color.name)
+			}
+		}
+	}
+
+	return fullMove, nil
+}
+
+func max0(n int) int {
+	if n < 0 {
+		return 0
+	}
+	return n
 }
@@ -134,3 +134,68 @@ func TestSQAttackedKnight(t *testing.T) {
 	expectAttacked(brd, sq120, Color_BLACK, t, false)
 
 }
+
+func TestParseFenErrors(t *testing.T) {
+	for _, tc := range []struct {
+		fen   string
+		mode  FenMode
+		field string
+	}{
+		{"", FenLenient, FenFieldPlacement},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP w KQkq - 0 1", FenLenient, FenFieldPlacement},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNRR w KQkq - 0 1", FenLenient, FenFieldPlacement},
+		{"rnbqkbnr/ppppxppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", FenLenient, FenFieldPlacement},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 x", FenLenient, FenFieldPlacement},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -", FenStrict, FenFieldPlacement},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR W KQkq - 0 1", FenLenient, FenFieldSide},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkz - 0 1", FenLenient, FenFieldCastling},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KKQkq - 0 1", FenStrict, FenFieldCastling},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBN1 w KQkq - 0 1", FenStrict, FenFieldCastling},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq e9 0 1", FenLenient, FenFieldEnPassant},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq e6 0 1", FenStrict, FenFieldEnPassant},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq e3 0 1", FenStrict, FenFieldEnPassant},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - x 1", FenStrict, FenFieldHalfmove},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 0", FenStrict, FenFieldFullmove},
+		{"rnbqqbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQ - 0 1", FenLenient, FenFieldPosition},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKKNR w kq - 0 1", FenLenient, FenFieldPosition},
+		{"rnbqkbnp/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQq - 0 1", FenLenient, FenFieldPosition},
+		{"4k3/8/8/8/8/8/8/r3K3 w - - 0 1", FenLenient, ""},
+		{"4k3/8/8/8/8/8/8/r3K3 b - - 0 1", FenLenient, FenFieldPosition},
+		{"rnbqkbnr/pppppppp/8/8/8/QQQ5/PPPPPPPP/RNBQKBNR w KQkq - 0 1", FenStrict, FenFieldPosition},
+		{"rnbqkbnr/pppppppp/8/8/8/QQQ5/PPPPPPPP/RNBQKBNR w KQkq - 0 1", FenLenient, ""},
+	} {
+		err := ParseFenMode(NewBoard(), tc.fen, tc.mode)
+		if len(tc.field) == 0 {
+			assert.Nil(t, err, tc.fen)
+			continue
+		}
+		var fenErr *FenError
+		if assert.ErrorAs(t, err, &fenErr, tc.fen) {
+			assert.Equal(t, tc.field, fenErr.Field, tc.fen)
+			assert.Equal(t, tc.fen, fenErr.Fen)
+		}
+	}
+}
+
+func TestParseFenLenient(t *testing.T) {
+	// The missing fields take their defaults
+	fen, err := NormalizeFen("rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR", FenLenient)
+	assert.Nil(t, err)
+	assert.Equal(t, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w - - 0 1", fen)
+
+	// The rights the rooks do not allow, the en passant no pawn passed and bad counters are dropped
+	fen, err = NormalizeFen("r3k3/8/8/8/8/8/8/4K2R b KQkq e3 x 0", FenLenient)
+	assert.Nil(t, err)
+	assert.Equal(t, "r3k3/8/8/8/8/8/8/4K2R b Kq - 0 1", fen)
+
+	fen, err = NormalizeFen("rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2", FenStrict)
+	assert.Nil(t, err)
+	assert.Equal(t, "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2", fen)
+
+	fen, err = NormalizeFen("4k3/8/8/8/8/8/8/4K3 b - - 37 60", FenStrict)
+	assert.Nil(t, err)
+	assert.Equal(t, "4k3/8/8/8/8/8/8/4K3 b - - 37 60", fen)
+
+	_, err = NormalizeFen("4k3/8/8/8/8/8/8/4K3 w K - 0 1", FenStrict)
+	assert.EqualError(t, err, "invalid fen castling: right K needs the king and rook on their squares")
+}
@@ -0,0 +1,113 @@
+package ai
+
+// GameStatus - the state of the game in a position.
+type GameStatus int
+
+const (
+	StatusOngoing GameStatus = iota
+	StatusCheckmate
+	StatusStalemate
+	StatusRepetition
+	StatusFiftyMove
+	StatusInsufficientMaterial
+)
+
+func (s GameStatus) String() string {
+	switch s {
+	case StatusCheckmate:
+		return "checkmate"
+	case StatusStalemate:
+		return "stalemate"
+	case StatusRepetition:
+		return "threefold repetition"
+	case StatusFiftyMove:
+		return "fifty move rule"
+	case StatusInsufficientMaterial:
+		return "insufficient material"
+	}
+	return "ongoing"
+}
+
+// IsDraw - the status ends the game in a draw.
+func (s GameStatus) IsDraw() bool {
+	return s != StatusOngoing && s != StatusCheckmate
+}
+
+// IsWhiteToMove
+func (this *Board) IsWhiteToMove() bool {
+	return this.side == Color_WHITE
+}
+
+// Status
+// Returns if the game has ended in the position.
+// Repetitions are only seen for the moves made on this board.
+func (this *Board) Status() GameStatus {
+
+	// Reset the ply ...
+	this.ply = 0
+
+	if len(GetAllValidMoves(this)) == 0 {
+		if this.IsInCheck() {
+			return StatusCheckmate
+		}
+		return StatusStalemate
+	}
+	if this.IsThreefoldRepetition() {
+		return StatusRepetition
+	}
+	if this.IsFiftyMove() {
+		return StatusFiftyMove
+	}
+	if this.IsInsufficientMaterial() {
+		return StatusInsufficientMaterial
+	}
+	return StatusOngoing
+}
+
+// IsThreefoldRepetition
+// The position has been on the board twice before, since the last capture or pawn move.
+func (this *Board) IsThreefoldRepetition() bool {
+	count := 0
+	start := this.hisPly - this.fiftyMove
+	if start < 0 {
+		start = 0
+	}
+	for idx := start; idx < this.hisPly; idx++ {
+		if this.posKey == this.history[idx].posKey {
+			count++
+		}
+	}
+	return count >= 2
+}
+
+// IsInsufficientMaterial
+// Only kings, or a king and one minor piece against a king.
+func (this *Board) IsInsufficientMaterial() bool {
+	for _, pce := range []Piece{Piece_WPAWN, Piece_BPAWN, Piece_WROOK, Piece_BROOK, Piece_WQUEEN, Piece_BQUEEN} {
+		if this.pceNum[pce] > 0 {
+			return false
+		}
+	}
+	minors := this.pceNum[Piece_WKNIGHT] + this.pceNum[Piece_WBISHOP] +
+		this.pceNum[Piece_BKNIGHT] + this.pceNum[Piece_BBISHOP]
+	return minors <= 1
+}
+
+// SanForMove
+// Returns the SAN of the move with + or # for check and mate.
+// Note: This must be before the move is made, as PgnForMove
+func SanForMove(b *Board, m Move) string {
+	san := PgnForMove(b, m)
+	if !b.makeMove(m) {
+		return san
+	}
+	if b.IsInCheck() {
+		if len(GetAllValidMoves(b)) == 0 {
+			san += "#"
+		} else {
+			san += "+"
+		}
+	}
+	b.takeMove()
+	return san
+}
@@ -0,0 +1,81 @@
+package ai
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// playUci - plays the coordinate moves from the fen.
+func playUci(t *testing.T, fen string, moves ...string) *Board {
+	brd := NewBoard()
+	ParseFen(brd, fen)
+	for _, str := range moves {
+		mv, err := MoveFromUci(brd, str)
+		assert.Nil(t, err, str)
+		assert.Nil(t, brd.MakeMove(mv, str))
+	}
+	return brd
+}
+
+func TestStatusCheckmate(t *testing.T) {
+	brd := playUci(t, StartFen, "f2f3", "e7e5", "g2g4")
+
+	mv, err := MoveFromUci(brd, "d8h4")
+	assert.Nil(t, err)
+	assert.Equal(t, "Qh4#", SanForMove(brd, mv))
+	assert.Nil(t, brd.MakeMove(mv, "d8h4"))
+
+	assert.Equal(t, StatusCheckmate, brd.Status())
+	assert.False(t, brd.Status().IsDraw())
+	assert.True(t, brd.IsWhiteToMove())
+}
+
+func TestStatusDraws(t *testing.T) {
+	brd := playUci(t, "7k/5Q2/6K1/8/8/8/8/8 b - - 0 1")
+	assert.Equal(t, StatusStalemate, brd.Status())
+
+	brd = playUci(t, "7k/8/6K1/8/8/8/8/5N2 w - - 0 1")
+	assert.Equal(t, StatusInsufficientMaterial, brd.Status())
+
+	brd = playUci(t, "7k/8/6K1/8/8/8/8/5NN1 w - - 0 1")
+	assert.Equal(t, StatusOngoing, brd.Status())
+
+	brd = playUci(t, StartFen, "g1f3", "g8f6", "f3g1", "f6g8", "g1f3", "g8f6", "f3g1")
+	assert.Equal(t, StatusOngoing, brd.Status())
+	mv, _ := MoveFromUci(brd, "f6g8")
+	assert.Nil(t, brd.MakeMove(mv, "f6g8"))
+	assert.Equal(t, StatusRepetition, brd.Status())
+	assert.True(t, brd.Status().IsDraw())
+
+}
+
+func TestSanForMoveCheck(t *testing.T) {
+	brd := playUci(t, "7k/8/6K1/8/8/8/8/R7 w - - 0 1")
+	mv, _ := MoveFromUci(brd, "a1a8")
+	assert.Equal(t, "Ra8#", SanForMove(brd, mv))
+	mv, _ = MoveFromUci(brd, "a1h1")
+	assert.Equal(t, "Rh1+", SanForMove(brd, mv))
+	mv, _ = MoveFromUci(brd, "a1a2")
+	assert.Equal(t, "Ra2", SanForMove(brd, mv))
+}
+
+func TestPgnFenTag(t *testing.T) {
+	pw := NewPgnWrapper(`[Event "Ending"]
+[SetUp "1"]
+[FEN "7k/8/6K1/8/8/8/8/R7 w - - 0 1"]
+
+1. Ra8# 1-0
+`)
+	assert.Nil(t, pw.Parse())
+	assert.Equal(t, "7k/8/6K1/8/8/8/8/R7 w - - 0 1", pw.InitialFen())
+	assert.Equal(t, []string{"a1a8"}, pw.Moves)
+}
+
+func TestPgnUnfinished(t *testing.T) {
+	pw := NewPgnWrapper(`[Event "Unfinished"]
+
+1. e4 e5 2. Nf3 *
+`)
+	assert.Nil(t, pw.Parse())
+	assert.Equal(t, []string{"e2e4", "e7e5", "g1f3"}, pw.Moves)
+}
@@ -0,0 +1,39 @@
+package ai
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MoveFromSAN
+// Returns the legal move for the SAN string in the position.
+// Check and annotation marks are ignored, 0-0 is accepted for O-O.
+func MoveFromSAN(b *Board, san string) (Move, error) {
+	str := strings.TrimRight(san, "+#!?")
+	str = strings.ReplaceAll(str, "0", "O")
+
+	// Reset the ply ...
+	b.ply = 0
+
+	for _, mv := range GetAllValidMoves(b) {
+		if PgnForMove(b, mv) == str {
+			return mv, nil
+		}
+	}
+	return NOMOVE, fmt.Errorf("Invalid move: %s", san)
+}
+
+// MoveFromUci
+// Returns the legal move for the coordinate string, ex: e2e4 or e7e8q
+func MoveFromUci(b *Board, str string) (Move, error) {
+
+	// Reset the ply ...
+	b.ply = 0
+
+	for _, mv := range GetAllValidMoves(b) {
+		if strings.EqualFold(MoveToString(mv), str) {
+			return mv, nil
+		}
+	}
+	return NOMOVE, fmt.Errorf("Invalid move: %s", str)
+}
@@ -0,0 +1,39 @@
+package ai
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMoveFromSAN(t *testing.T) {
+	brd := NewBoard()
+	ParseFen(brd, "r3k2r/1P6/8/8/8/5N2/8/R3K2R w KQkq - 0 1")
+
+	for san, expected := range map[string]string{
+		"Nd4":    "f3d4",
+		"Ng5+":   "f3g5",
+		"O-O":    "e1g1",
+		"0-0-0":  "e1c1",
+		"bxa8=Q": "b7a8q",
+		"b8=N!":  "b7b8n",
+	} {
+		mv, err := MoveFromSAN(brd, san)
+		assert.Nil(t, err, san)
+		assert.Equal(t, expected, MoveToString(mv), san)
+	}
+
+	_, err := MoveFromSAN(brd, "Nf4")
+	assert.NotNil(t, err)
+}
+
+func TestMoveFromUci(t *testing.T) {
+	brd := NewBoard()
+	ParseFen(brd, "r3k2r/1P6/8/8/8/5N2/8/R3K2R w KQkq - 0 1")
+
+	mv, err := MoveFromUci(brd, "b7a8q")
+	assert.Nil(t, err)
+	assert.Equal(t, "bxa8=Q", PgnForMove(brd, mv))
+
+	_, err = MoveFromUci(brd, "e1e3")
+	assert.NotNil(t, err)
+}
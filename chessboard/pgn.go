@@ -11,9 +11,9 @@ const (
 	comment    = "\\{(.|\\n)*?\\}" // Capture comments as a unit
 	resumption = "\\d+\\.\\.\\."   // Resume moves after comment
 	moveNumber = "\\d+\\."
-	endOfGame  = "0-1|1-0|0-0|1/2-1/2"
-	nag        = "\\$\\d+"           //  Numeric annotation glyph
-	move       = "[-+\\w\\.(=.)?/]+" // # Anything else is a move
+	endOfGame  = "0-1|1-0|0-0|1/2-1/2|\\*"
+	nag        = "\\$\\d+"            //  Numeric annotation glyph
+	move       = "[-+#\\w\\.(=.)?/]+" // # Anything else is a move
 	newline    = "\n"
 	//whitespace = "\\s+"
 )
@@ -173,10 +173,8 @@ func (p *PgnWrapper) loadAttributes() error {
 // UP until the end or a newline, read all data.
 func (p *PgnWrapper) loadMoves() error {
 
-	if len(p.StartFen) > 0 {
-		ParseFen(p.board, p.StartFen)
-	} else {
-		ParseFen(p.board, StartFen)
+	if err := ParseFen(p.board, p.InitialFen()); err != nil {
+		return err
 	}
 
 	for {
@@ -237,8 +235,9 @@ func (p *PgnWrapper) loadMoves() error {
 func (p *PgnWrapper) applyMoveSAN(sanMove string, debug bool) error {
 	// fmt.Printf("Move: %s\n", sanMove)
 
-	// Get rid of check indicator
+	// Get rid of check and mate indicator
 	sanMove = strings.TrimSuffix(sanMove, "+")
+	sanMove = strings.TrimSuffix(sanMove, "#")
 
 	// Check for available moves.
 	// vmoves := GetAllValidMoves(p.board)
@@ -258,21 +257,28 @@ func (p *PgnWrapper) applyMoveSAN(sanMove string, debug bool) error {
 		}
 	}
 
-	p.board.PrintBoard(fmt.Sprintf("Move not found for: %s", sanMove))
-	//if !debug {
-	//	// So we can see it.
-	//	p.applyMoveSAN(sanMove, true)
-	//}
-
 	return fmt.Errorf(fmt.Sprintf("Move not found for: %s", sanMove))
 }
 
+// InitialFen
+// The position of the first move, from the FEN tag, the StartFen or the start position.
+func (p *PgnWrapper) InitialFen() string {
+	if fen, ok := p.Attributes["FEN"]; ok && len(fen) > 0 {
+		return fen
+	}
+	if len(p.StartFen) > 0 {
+		return p.StartFen
+	}
+	return StartFen
+}
+
 func (p *PgnWrapper) IsEof() bool {
 	return p.lex.IsEOF()
 }
 
 func (p *PgnWrapper) resetForNextPgn() {
 	p.Moves = make([]string, 0)
+	p.InternalMoves = make([]Move, 0)
 	p.Attributes = make(map[string]string)
 	p.board = NewBoard()
 
@@ -450,3 +456,35 @@ func asMoveString(b *Board, m Move, isAmbigRank bool, isAmbigFile bool) string {
 	m.to.toString().toLowerCase() + trail + promote;
 	*/
 }
+
+// SplitPgn
+// The games of a pgn file, each with its tags and moves.  A game starts
+// at the first tag after the moves of the prior game.
+func SplitPgn(text string) []string {
+	games := make([]string, 0)
+	lines := make([]string, 0)
+	inMoves := false
+
+	flush := func() {
+		game := strings.TrimSpace(strings.Join(lines, "\n"))
+		if len(game) > 0 {
+			games = append(games, game+"\n")
+		}
+		lines = lines[:0]
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && inMoves {
+			flush()
+			inMoves = false
+		}
+		if len(trimmed) > 0 && !strings.HasPrefix(trimmed, "[") {
+			inMoves = true
+		}
+		lines = append(lines, line)
+	}
+	flush()
+	return games
+}
@@ -121,3 +121,21 @@ func TestLoadManyPgn(t *testing.T) {
 	assert.Equal(t, 45, cnt)
 
 }
+
+func TestSplitPgn(t *testing.T) {
+	pgns, err := ioutil.ReadFile("../pgnFiles/warsawrap23.pgn")
+	assert.Nil(t, err)
+
+	games := SplitPgn(string(pgns))
+	assert.Equal(t, 45, len(games))
+	for _, game := range games {
+		pw := NewPgnWrapper(game)
+		assert.Nil(t, pw.Parse())
+		assert.True(t, len(pw.Moves) > 0)
+	}
+	assert.Contains(t, games[1], `[White "Wojtaszek,R"]`)
+
+	assert.Equal(t, []string{"[Event \"A\"]\n\n1. e4 *\n", "[Event \"B\"]\n\n1. d4 *\n"},
+		SplitPgn("\r\n[Event \"A\"]\r\n\r\n1. e4 *\r\n[Event \"B\"]\n\n1. d4 *"))
+	assert.Equal(t, 0, len(SplitPgn("  \n")))
+}
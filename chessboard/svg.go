@@ -0,0 +1,195 @@
+package ai
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Arrow colors of the diagram
+const (
+	SvgArrowBest   = "#15781b" // the engine move
+	SvgArrowPlayed = "#882020" // the move of the game
+)
+
+// SvgArrow - an arrow from a square to another, the move as uci (e2e4)
+type SvgArrow struct {
+	Move  string
+	Color string // the default is SvgArrowBest
+}
+
+// SvgOptions - how the board is drawn
+type SvgOptions struct {
+	Size        int    // the width and height in pixels, the default is 400
+	Flip        bool   // black at the bottom
+	Coordinates bool   // the files and ranks in the squares of the border
+	LastMove    string // the uci move to highlight
+	Arrows      []SvgArrow
+}
+
+// The diagram colors
+const (
+	svgLight     = "#f0d9b5"
+	svgDark      = "#b58863"
+	svgHighlight = "#cdd26a"
+)
+
+// The glyphs, by the index of PceCharLetter. The solid shapes are used for both sides,
+// the fill sets the color.
+var svgGlyph = []string{"", "♟", "♞", "♝", "♜", "♛", "♚", "♟", "♞", "♝", "♜", "♛", "♚"}
+
+// BoardToSvg - the diagram of the board
+func BoardToSvg(b *Board, opts *SvgOptions) string {
+	sb := strings.Builder{}
+	WriteSvg(&sb, b, opts)
+	return sb.String()
+}
+
+// WriteSvg
+// Writes the diagram of the board, the board is drawn in a 800 unit square
+// scaled to the size.
+func WriteSvg(w io.Writer, b *Board, opts *SvgOptions) error {
+	if opts == nil {
+		opts = &SvgOptions{}
+	}
+	size := opts.Size
+	if size <= 0 {
+		size = 400
+	}
+
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 800 800">`, size, size)
+	sb.WriteString("\n")
+	sb.WriteString(`<defs>`)
+	for _, color := range svgArrowColors(opts.Arrows) {
+		fmt.Fprintf(&sb, `<marker id="head%s" markerWidth="4" markerHeight="4" refX="2" refY="2" orient="auto"><path d="M0,0 L4,2 L0,4 z" fill="%s"/></marker>`,
+			strings.TrimPrefix(color, "#"), color)
+	}
+	sb.WriteString("</defs>\n")
+
+	highlight := map[string]bool{}
+	if from, to, ok := svgMoveSquares(opts.LastMove); ok {
+		highlight[from] = true
+		highlight[to] = true
+	}
+
+	for rank := RANK_1; rank <= RANK_8; rank++ {
+		for file := FILE_A; file <= FILE_H; file++ {
+			x, y := svgSquareXY(file, rank, opts.Flip)
+			color := svgLight
+			if (int(file)+int(rank))%2 == 0 {
+				color = svgDark
+			}
+			if highlight[svgSquareName(file, rank)] {
+				color = svgHighlight
+			}
+			fmt.Fprintf(&sb, `<rect x="%d" y="%d" width="100" height="100" fill="%s"/>`, x, y, color)
+			sb.WriteString("\n")
+		}
+	}
+
+	if opts.Coordinates {
+		for idx := 0; idx < 8; idx++ {
+			// The files along the bottom row and the ranks along the left column
+			x, _ := svgSquareXY(File(idx), RANK_1, opts.Flip)
+			fmt.Fprintf(&sb, `<text x="%d" y="794" font-size="18" font-family="sans-serif" fill="#333">%c</text>`, x+86, 'a'+idx)
+			sb.WriteString("\n")
+
+			_, y := svgSquareXY(FILE_A, Rank(idx), opts.Flip)
+			fmt.Fprintf(&sb, `<text x="4" y="%d" font-size="18" font-family="sans-serif" fill="#333">%c</text>`, y+20, '1'+idx)
+			sb.WriteString("\n")
+		}
+	}
+
+	for rank := RANK_1; rank <= RANK_8; rank++ {
+		for file := FILE_A; file <= FILE_H; file++ {
+			piece := b.pieces[fr_to_SQ120(file, rank)]
+			if piece == Piece_EMPTY {
+				continue
+			}
+			fill, stroke := "#fff", "#000"
+			if PieceCol[piece] == Color_BLACK {
+				fill, stroke = "#000", "#000"
+			}
+			x, y := svgSquareXY(file, rank, opts.Flip)
+			fmt.Fprintf(&sb, `<text x="%d" y="%d" font-size="84" text-anchor="middle" fill="%s" stroke="%s" stroke-width="2" class="%c">%s</text>`,
+				x+50, y+80, fill, stroke, PceCharLetter[piece], svgGlyph[piece])
+			sb.WriteString("\n")
+		}
+	}
+
+	for _, arrow := range opts.Arrows {
+		from, to, ok := svgMoveSquares(arrow.Move)
+		if !ok {
+			continue
+		}
+		color := svgArrowColor(arrow)
+		x1, y1 := svgSquareCenter(from, opts.Flip)
+		x2, y2 := svgSquareCenter(to, opts.Flip)
+		fmt.Fprintf(&sb, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="16" stroke-opacity="0.8" marker-end="url(#head%s)"/>`,
+			x1, y1, x2, y2, color, strings.TrimPrefix(color, "#"))
+		sb.WriteString("\n")
+	}
+
+	sb.WriteString("</svg>\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// svgSquareXY - the top left of the square
+func svgSquareXY(file File, rank Rank, flip bool) (int, int) {
+	if flip {
+		return int(FILE_H-file) * 100, int(rank) * 100
+	}
+	return int(file) * 100, int(RANK_8-rank) * 100
+}
+
+func svgSquareCenter(sq string, flip bool) (int, int) {
+	x, y := svgSquareXY(File(sq[0]-'a'), Rank(sq[1]-'1'), flip)
+	return x + 50, y + 50
+}
+
+func svgSquareName(file File, rank Rank) string {
+	return string([]byte{'a' + byte(file), '1' + byte(rank)})
+}
+
+// svgMoveSquares - the squares of the uci move
+func svgMoveSquares(move string) (string, string, bool) {
+	if len(move) < 4 {
+		return "", "", false
+	}
+	from, to := move[0:2], move[2:4]
+	for _, sq := range []string{from, to} {
+		if sq[0] < 'a' || sq[0] > 'h' || sq[1] < '1' || sq[1] > '8' {
+			return "", "", false
+		}
+	}
+	return from, to, from != to
+}
+
+// svgArrowColor - the color of the arrow, a name or #rgb, else the default
+func svgArrowColor(arrow SvgArrow) string {
+	if len(arrow.Color) == 0 || len(arrow.Color) > 20 {
+		return SvgArrowBest
+	}
+	for idx, c := range arrow.Color {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '#' && idx == 0) {
+			return SvgArrowBest
+		}
+	}
+	return arrow.Color
+}
+
+// svgArrowColors - the distinct colors, one arrow head marker each
+func svgArrowColors(arrows []SvgArrow) []string {
+	res := make([]string, 0)
+	seen := map[string]bool{}
+	for _, arrow := range arrows {
+		color := svgArrowColor(arrow)
+		if !seen[color] {
+			seen[color] = true
+			res = append(res, color)
+		}
+	}
+	return res
+}
@@ -0,0 +1,36 @@
+package ai
+
+import (
+	"encoding/xml"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestBoardToSvg(t *testing.T) {
+	brd := NewBoard()
+	assert.Nil(t, ParseFen(brd, startFen))
+
+	svg := BoardToSvg(brd, &SvgOptions{Coordinates: true, LastMove: "e2e4", Arrows: []SvgArrow{{Move: "g1f3"}, {Move: "d2d4", Color: SvgArrowPlayed}}})
+	assert.Nil(t, xml.Unmarshal([]byte(svg), new(struct{})))
+	assert.Contains(t, svg, `width="400"`)
+	assert.Equal(t, 64, strings.Count(svg, "<rect "))
+	assert.Equal(t, 2, strings.Count(svg, svgHighlight))
+	assert.Equal(t, 32, strings.Count(svg, `font-size="84"`))
+	assert.Equal(t, 16, strings.Count(svg, `font-size="18"`))
+	assert.Equal(t, 2, strings.Count(svg, "<marker "))
+	// The arrow from g1 to f3, white at the bottom
+	assert.Contains(t, svg, `<line x1="650" y1="750" x2="550" y2="550"`)
+	// The white king on e1
+	assert.Contains(t, svg, `x="450" y="780" font-size="84" text-anchor="middle" fill="#fff" stroke="#000" stroke-width="2" class="K"`)
+
+	flipped := BoardToSvg(brd, &SvgOptions{Flip: true, Size: 200, Arrows: []SvgArrow{{Move: "g1f3", Color: `"><script>`}}})
+	assert.Contains(t, flipped, `width="200"`)
+	assert.Contains(t, flipped, `<line x1="150" y1="50" x2="250" y2="250" stroke="`+SvgArrowBest+`"`)
+	assert.Contains(t, flipped, `x="350" y="80" font-size="84" text-anchor="middle" fill="#fff" stroke="#000" stroke-width="2" class="K"`)
+	assert.NotContains(t, flipped, "script")
+	assert.NotContains(t, flipped, `font-size="18"`)
+
+	// A move that is not on the board is left out
+	assert.NotContains(t, BoardToSvg(brd, &SvgOptions{Arrows: []SvgArrow{{Move: "z9a1"}}}), "<line ")
+}
@@ -1,3 +1,3 @@
 module github.com/samlotti/chess_anaylzer/chessboard/common
 
-go 1.18
+go 1.21
@@ -0,0 +1,82 @@
+package common
+
+/**
+The logging of the packages, leveled and structured with log/slog.
+
+The logger of a request is carried by its context, its attributes are on
+every line logged for the request:
+
+	ctx = common.WithLogAttrs(ctx, common.LogRequest, id)
+	common.Logger(ctx).Info("analysis started", "fen", fen)
+
+The http service adds the request id, the pgn jobs the job id, the workers
+their worker and the engines their engine and transcript.  An analysis is
+traced from the request to the engine by the request id.
+*/
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+)
+
+// The attribute keys of the trace
+const (
+	LogRequest    = "request"
+	LogJob        = "job"
+	LogWorker     = "worker"
+	LogEngine     = "engine"
+	LogTranscript = "transcript"
+)
+
+// LogLevel - the level of the loggers of NewLogger, info unless set.
+var LogLevel = new(slog.LevelVar)
+
+var logger atomic.Pointer[slog.Logger]
+
+func init() {
+	logger.Store(NewLogger(os.Stderr, false))
+}
+
+// NewLogger - a logger of text, or json, lines to the writer at the LogLevel.
+func NewLogger(w io.Writer, json bool) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: LogLevel}
+	if json {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// Log - the logger of the packages, for what is not part of a request.
+func Log() *slog.Logger {
+	return logger.Load()
+}
+
+// SetLogger - replaces the logger of the packages.
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+}
+
+type logKey struct{}
+
+// WithLogger - the context carrying the logger.
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, logKey{}, l)
+}
+
+// WithLogAttrs - the context carrying its logger with the attributes added.
+func WithLogAttrs(ctx context.Context, args ...any) context.Context {
+	return WithLogger(ctx, Logger(ctx).With(args...))
+}
+
+// Logger - the logger of the context, Log() if it has none.
+func Logger(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(logKey{}).(*slog.Logger); ok {
+			return l
+		}
+	}
+	return Log()
+}
@@ -7,8 +7,6 @@ import (
 	"sync/atomic"
 )
 
-var Verbose = true
-
 type utils struct {
 	seq            int64
 	availFenWorker int64
@@ -26,11 +24,9 @@ func (u *utils) NextSeq() int64 {
 }
 
 func (u *utils) debug() {
-	if Verbose {
-		p := atomic.LoadInt64(&u.availPgnWorker)
-		f := atomic.LoadInt64(&u.availFenWorker)
-		fmt.Printf("Num workers: Pgn %d,  Fen: %d\n", p, f)
-	}
+	p := atomic.LoadInt64(&u.availPgnWorker)
+	f := atomic.LoadInt64(&u.availFenWorker)
+	Log().Debug("available workers", "pgn", p, "fen", f)
 }
 
 // AdjustPgnWorker -- inc or dec the number of available fen workers
@@ -22,6 +22,10 @@ cd httpservice
 go mod tidy
 cd ..
 
+cd match
+go mod tidy
+cd ..
+
 cd main
 go mod tidy
 cd ..
\ No newline at end of file
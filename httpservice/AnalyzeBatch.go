@@ -0,0 +1,102 @@
+package httpservice
+
+import (
+	"fmt"
+	"github.com/samlotti/chess_anaylzer/analyzer"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"net/http"
+	"strings"
+)
+
+// AnalyzeBatch
+// Analyzes many positions with the same settings, the result of each is
+// written as it completes, one json per line tagged with its index and id.
+// The last line has done.
+//
+// args:  fens  required, a fen or an epd per line, the id of an epd is its id
+//
+//	depth, tsec, lines optional, the defaults of Limits
+//	strict optional, true to reject a fen with any fault
+func AnalyzeBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	positions := make([]*analyzer.BatchPosition, 0)
+	for num, line := range strings.Split(r.PostFormValue("fens"), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		epd, err := ai.ParseEpd(line)
+		if err == nil {
+			err = checkFen(epd.Fen, r.PostFormValue("strict") == "true")
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("line %d: %s", num+1, err), http.StatusBadRequest)
+			return
+		}
+		positions = append(positions, &analyzer.BatchPosition{Id: epd.Id, Fen: epd.Fen})
+	}
+	if len(positions) == 0 {
+		http.Error(w, "please enter the fens, one per line", http.StatusBadRequest)
+		return
+	}
+	if len(positions) > Limits.MaxBatch {
+		http.Error(w, fmt.Sprintf("at most %d positions", Limits.MaxBatch), http.StatusBadRequest)
+		return
+	}
+
+	settings, ok := argSettings(w, r.PostFormValue)
+	if !ok {
+		return
+	}
+
+	bd := &analyzer.BatchData{
+		Positions:  positions,
+		Depth:      settings.Depth,
+		NumLines:   settings.Lines,
+		MaxTimeSec: settings.TimeSec,
+		RChannel:   make(chan *analyzer.BatchResult),
+		Ctx:        r.Context(),
+		Client:     clientID(r),
+		Priority:   analyzer.PriorityNormal,
+		Parallel:   Limits.BatchParallel,
+	}
+	analyzer.AnalyzeBatch(bd)
+
+	streamResponses(r.Context(), w, bd.RChannel,
+		func(res *analyzer.BatchResult) bool { return res.Done },
+		func() int { return 0 },
+		func(pos int) *analyzer.BatchResult { return nil },
+		func() {})
+}
+
+// argSettings - the depth, tsec and lines of the form or url arguments,
+// the defaults of Limits when missing.  The bad request is written when
+// not valid or out of bounds.
+func argSettings(w http.ResponseWriter, arg func(string) string) (*AnalysisSettings, bool) {
+	settings := &AnalysisSettings{}
+	for _, a := range []struct {
+		name string
+		val  *int
+	}{
+		{"depth", &settings.Depth},
+		{"tsec", &settings.TimeSec},
+		{"lines", &settings.Lines},
+	} {
+		n, err := common.Utils.AToI(arg(a.name), 0)
+		if err != nil {
+			http.Error(w, a.name+" invalid, please enter a valid number", http.StatusBadRequest)
+			return nil, false
+		}
+		*a.val = n
+	}
+	if err := Limits.apply(settings); err != nil {
+		http.Error(w, err.Message, http.StatusBadRequest)
+		return nil, false
+	}
+	return settings, true
+}
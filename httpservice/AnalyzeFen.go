@@ -1,10 +1,9 @@
 package httpservice
 
 import (
-	"encoding/json"
 	"github.com/samlotti/chess_anaylzer/analyzer"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
 	"github.com/samlotti/chess_anaylzer/chessboard/common"
-	"log"
 	"net/http"
 )
 
@@ -13,35 +12,11 @@ import (
 //
 // args:  fen  required
 //
-//	depth optional
+//	depth, tsec, lines optional, the defaults of Limits
+//	strict optional, true to reject a fen with any fault, see ai.FenStrict
 func AnalyzeFen(w http.ResponseWriter, r *http.Request) {
-	fen, ok := r.URL.Query()["fen"]
-	if ok {
-		// w.Write([]byte(fen[0]))
-	} else {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("please enter ?fen= on the url"))
-		return
-	}
-
-	depth, err := common.Utils.ArgInt(r.URL.Query(), "depth", 15)
-	if err != nil || depth < 1 {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("depth invalid, please enter a valid number"))
-		return
-	}
-
-	tsec, err := common.Utils.ArgInt(r.URL.Query(), "tsec", 0)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("tsec invalid, please enter a valid number"))
-		return
-	}
-
-	pvlines, err := common.Utils.ArgInt(r.URL.Query(), "lines", 0)
-	if err != nil || pvlines < 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("line invalid, please enter a valid number"))
+	fd, ok := fenDataFromQuery(w, r)
+	if !ok {
 		return
 	}
 
@@ -49,35 +24,55 @@ func AnalyzeFen(w http.ResponseWriter, r *http.Request) {
 		if err := recover(); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("error processing request"))
-			log.Printf("error %s\n", err)
+			common.Logger(r.Context()).Error("request failed", "panic", err)
 		}
 	}()
 
-	fd := &analyzer.FenData{
-		Fen:        fen[0],
-		UserMove:   "",
-		Depth:      depth,
-		NumLines:   pvlines,
-		MaxTimeSec: tsec,
-		RChannel:   make(chan *analyzer.FenResponse),
-	}
-
-	wasSent := analyzer.AnalyzeFenChannelSender(fd)
-	if !wasSent {
-		w.Write([]byte("server busy"))
+	if err := analyzer.QueueFen(fd); err != nil {
+		writeQueueError(w, err, analyzer.FenRetryAfter(err))
 		return
 	}
 
-	for {
-		fresp := <-fd.RChannel
+	streamResponses(r.Context(), w, fd.RChannel,
+		func(resp *analyzer.FenResponse) bool { return resp.Done },
+		func() int { return analyzer.FenQueuePosition(fd) },
+		func(pos int) *analyzer.FenResponse {
+			return &analyzer.FenResponse{RCode: analyzer.RCODE_QUEUED, QueuePosition: pos}
+		},
+		func() { analyzer.RemoveQueuedFen(fd) })
+}
 
-		json.NewEncoder(w).Encode(fresp)
+// fenDataFromQuery - the fen request of the url arguments.
+// The bad request is written when not valid.
+func fenDataFromQuery(w http.ResponseWriter, r *http.Request) (*analyzer.FenData, bool) {
+	fen, ok := r.URL.Query()["fen"]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("please enter ?fen= on the url"))
+		return nil, false
+	}
 
-		if fresp.Done {
-			//w.Write([]byte("request completed"))
-			return
-		}
+	if err := checkFen(fen[0], r.URL.Query().Get("strict") == "true"); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return nil, false
+	}
 
+	settings, ok := argSettings(w, r.URL.Query().Get)
+	if !ok {
+		return nil, false
 	}
 
+	fd := fenDataOf(r, fen[0], "", settings)
+	fd.Priority = analyzer.PriorityHigh
+	return fd, true
+}
+
+// checkFen - the error of the fen, a *ai.FenError, nil when it can be analyzed.
+func checkFen(fen string, strict bool) error {
+	mode := ai.FenLenient
+	if strict {
+		mode = ai.FenStrict
+	}
+	return ai.ParseFenMode(ai.NewBoard(), fen, mode)
 }
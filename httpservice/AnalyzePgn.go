@@ -1,10 +1,8 @@
 package httpservice
 
 import (
-	"encoding/json"
 	"github.com/samlotti/chess_anaylzer/analyzer"
 	"github.com/samlotti/chess_anaylzer/chessboard/common"
-	"log"
 	"net/http"
 )
 
@@ -13,31 +11,8 @@ import (
 //
 //	depth optional
 func AnalyzePgn(w http.ResponseWriter, r *http.Request) {
-	pgn := r.PostFormValue("pgn")
-	if len(pgn) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("please enter a pgn"))
-		return
-	}
-
-	depth, err := common.Utils.AToI(r.PostFormValue("depth"), 15)
-	if err != nil || depth < 1 {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("depth invalid, please enter a valid number"))
-		return
-	}
-
-	tsec, err := common.Utils.AToI(r.PostFormValue("tsec"), 15)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("tsec invalid, please enter a valid number"))
-		return
-	}
-
-	pvlines, err := common.Utils.AToI(r.PostFormValue("lines"), 5)
-	if err != nil || pvlines < 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("line invalid, please enter a valid number"))
+	fd, ok := pgnDataFromForm(w, r)
+	if !ok {
 		return
 	}
 
@@ -45,34 +20,51 @@ func AnalyzePgn(w http.ResponseWriter, r *http.Request) {
 		if err := recover(); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("error processing request"))
-			log.Printf("error %s\n", err)
+			common.Logger(r.Context()).Error("request failed", "panic", err)
 		}
 	}()
 
-	fd := &analyzer.PgnData{
-		Pgn:        pgn,
-		Depth:      depth,
-		NumLines:   pvlines,
-		MaxTimeSec: tsec,
-		RChannel:   make(chan *analyzer.PgnResponse),
-	}
+	fd.RChannel = make(chan *analyzer.PgnResponse)
+	fd.Ctx = r.Context()
 
-	wasSent := analyzer.AnalyzePgnChannelSender(fd)
-	if !wasSent {
-		w.Write([]byte("server busy"))
+	if err := analyzer.QueuePgn(fd); err != nil {
+		writeQueueError(w, err, analyzer.PgnRetryAfter(err))
 		return
 	}
 
-	for {
-		fresp := <-fd.RChannel
-
-		json.NewEncoder(w).Encode(fresp)
+	streamResponses(r.Context(), w, fd.RChannel,
+		func(resp *analyzer.PgnResponse) bool { return resp.Done },
+		func() int { return analyzer.PgnQueuePosition(fd) },
+		func(pos int) *analyzer.PgnResponse {
+			return &analyzer.PgnResponse{RCode: analyzer.RCODE_QUEUED, QueuePosition: pos}
+		},
+		func() { analyzer.RemoveQueuedPgn(fd) })
+}
 
-		if fresp.Done {
-			//w.Write([]byte("request completed"))
-			return
-		}
+// pgnDataFromForm - the pgn request of the posted form.
+// The bad request is written when not valid.
+//
+// args:  pgn  required
+//
+//	depth, tsec, lines optional, the defaults of Limits
+func pgnDataFromForm(w http.ResponseWriter, r *http.Request) (*analyzer.PgnData, bool) {
+	pgn := r.PostFormValue("pgn")
+	if len(pgn) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("please enter a pgn"))
+		return nil, false
+	}
 
+	settings, ok := argSettings(w, r.PostFormValue)
+	if !ok {
+		return nil, false
 	}
 
+	return &analyzer.PgnData{
+		Pgn:        pgn,
+		Depth:      settings.Depth,
+		NumLines:   settings.Lines,
+		MaxTimeSec: settings.TimeSec,
+		Client:     clientID(r),
+	}, true
 }
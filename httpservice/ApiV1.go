@@ -0,0 +1,405 @@
+package httpservice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samlotti/chess_anaylzer/analyzer"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+The versioned api, json in and json out.
+
+	POST   /api/v1/fen            FenRequest   -> FenResult
+	POST   /api/v1/batch          BatchRequest -> BatchResult, one json per line as each position completes
+	POST   /api/v1/pgn            PgnRequest   -> JobView, 202 with the Location of the job
+	GET    /api/v1/jobs/{id}      JobView, since=N for the results from N on
+	DELETE /api/v1/jobs/{id}      cancels the job
+	GET    /api/v1/openapi.json   the OpenAPI document
+
+The settings left out, or 0, take the defaults of Limits.  Errors are an
+ErrorBody with a code the client can test for.
+*/
+
+// ApiV1Path - the root of the api, register with the trailing /
+const ApiV1Path = "/api/v1"
+
+// Error codes of the api
+const (
+	ErrCodeInvalidJson      = "invalid_json"
+	ErrCodeMissingField     = "missing_field"
+	ErrCodeInvalidField     = "invalid_field"
+	ErrCodeInvalidFen       = "invalid_fen"
+	ErrCodeTooLarge         = "too_large"
+	ErrCodeQueueFull        = "queue_full"
+	ErrCodeClientLimit      = "client_limit"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodeAnalysisFailed   = "analysis_failed"
+)
+
+// AnalysisLimits - the defaults and bounds of the api settings.
+type AnalysisLimits struct {
+	DefaultDepth   int
+	MaxDepth       int
+	DefaultTimeSec int
+	MaxTimeSec     int
+	DefaultLines   int
+	MaxLines       int
+	MaxBatch       int   // positions in a batch
+	BatchParallel  int   // positions of a batch analyzed at once
+	MaxBodyBytes   int64 // the request body
+}
+
+// Limits - of the api
+var Limits = AnalysisLimits{
+	DefaultDepth:   15,
+	MaxDepth:       40,
+	DefaultTimeSec: 15,
+	MaxTimeSec:     120,
+	DefaultLines:   3,
+	MaxLines:       10,
+	MaxBatch:       100,
+	BatchParallel:  4,
+	MaxBodyBytes:   1 << 20,
+}
+
+// ApiError - what went wrong
+type ApiError struct {
+	Code    string `json:"code" doc:"one of the error codes, ex: invalid_field"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty" doc:"the field of the request at fault"`
+}
+
+func (e *ApiError) Error() string {
+	return e.Message
+}
+
+// ErrorBody - the body of an error response
+type ErrorBody struct {
+	Error *ApiError `json:"error"`
+}
+
+// AnalysisSettings - shared by the requests
+type AnalysisSettings struct {
+	Depth   int `json:"depth,omitempty" doc:"search depth"`
+	TimeSec int `json:"timeSec,omitempty" doc:"max search seconds per position"`
+	Lines   int `json:"lines,omitempty" doc:"principal variations"`
+}
+
+// FenRequest - analyze a position
+type FenRequest struct {
+	Fen    string `json:"fen" doc:"the position"`
+	Move   string `json:"move,omitempty" doc:"a move in uci notation, its line is returned as move"`
+	Strict bool   `json:"strict,omitempty" doc:"reject a fen with any fault, not only one that stops the analysis"`
+	AnalysisSettings
+}
+
+// PgnRequest - analyze a game as a job
+type PgnRequest struct {
+	Pgn string `json:"pgn" doc:"the game, with its tags"`
+	AnalysisSettings
+}
+
+// BatchRequest - analyze the positions with the same settings
+type BatchRequest struct {
+	Positions []*analyzer.BatchPosition `json:"positions"`
+	Strict    bool                      `json:"strict,omitempty" doc:"reject a fen with any fault, not only one that stops the analysis"`
+	AnalysisSettings
+}
+
+// BatchResult - a position of the batch, in the order completed
+type BatchResult struct {
+	Index  int                 `json:"index" doc:"of the position in the request"`
+	Id     string              `json:"id,omitempty"`
+	Result *analyzer.FenResult `json:"result,omitempty"`
+	Error  *ApiError           `json:"error,omitempty"`
+}
+
+// apply - the defaults of the missing settings, an error when out of bounds.
+func (l *AnalysisLimits) apply(s *AnalysisSettings) *ApiError {
+	for _, f := range []struct {
+		name     string
+		val      *int
+		def, max int
+	}{
+		{"depth", &s.Depth, l.DefaultDepth, l.MaxDepth},
+		{"timeSec", &s.TimeSec, l.DefaultTimeSec, l.MaxTimeSec},
+		{"lines", &s.Lines, l.DefaultLines, l.MaxLines},
+	} {
+		if *f.val == 0 {
+			*f.val = f.def
+		}
+		if *f.val < 1 || *f.val > f.max {
+			return &ApiError{
+				Code:    ErrCodeInvalidField,
+				Message: fmt.Sprintf("%s must be between 1 and %d", f.name, f.max),
+				Field:   f.name,
+			}
+		}
+	}
+	return nil
+}
+
+// ApiV1
+// The handler of the paths under ApiV1Path.
+func ApiV1(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, ApiV1Path), "/")
+
+	switch {
+	case path == "fen":
+		if allowMethod(w, r, http.MethodPost) {
+			apiFen(w, r)
+		}
+	case path == "batch":
+		if allowMethod(w, r, http.MethodPost) {
+			apiBatch(w, r)
+		}
+	case path == "pgn":
+		if allowMethod(w, r, http.MethodPost) {
+			apiPgn(w, r)
+		}
+	case path == "openapi.json":
+		if allowMethod(w, r, http.MethodGet) {
+			writeJSON(w, http.StatusOK, OpenAPI())
+		}
+	case strings.HasPrefix(path, "jobs/"):
+		apiJob(w, r, strings.TrimPrefix(path, "jobs/"))
+	default:
+		writeApiError(w, http.StatusNotFound, &ApiError{Code: ErrCodeNotFound, Message: "no such path: " + r.URL.Path})
+	}
+}
+
+// allowMethod - writes the error when the method is not the one allowed.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeApiError(w, http.StatusMethodNotAllowed, &ApiError{Code: ErrCodeMethodNotAllowed, Message: "use " + method})
+	return false
+}
+
+// writeApiError - the error as the json response
+func writeApiError(w http.ResponseWriter, status int, err *ApiError) {
+	writeJSON(w, status, &ErrorBody{Error: err})
+}
+
+// writeApiQueueError - the error when the request could not be queued, see writeQueueError
+func writeApiQueueError(w http.ResponseWriter, err error, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	if errors.Is(err, analyzer.ErrClientLimit) {
+		writeApiError(w, http.StatusTooManyRequests, &ApiError{Code: ErrCodeClientLimit, Message: err.Error()})
+		return
+	}
+	writeApiError(w, http.StatusServiceUnavailable, &ApiError{Code: ErrCodeQueueFull, Message: err.Error()})
+}
+
+// decodeRequest - the json body into v, the error is written when not valid.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, Limits.MaxBodyBytes))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil {
+		return true
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case strings.Contains(err.Error(), "request body too large"):
+		writeApiError(w, http.StatusRequestEntityTooLarge, &ApiError{
+			Code:    ErrCodeTooLarge,
+			Message: fmt.Sprintf("the body is over %d bytes", Limits.MaxBodyBytes),
+		})
+	case errors.As(err, &typeErr):
+		writeApiError(w, http.StatusBadRequest, &ApiError{
+			Code:    ErrCodeInvalidField,
+			Message: fmt.Sprintf("%s must be a %s", typeErr.Field, typeErr.Type),
+			Field:   typeErr.Field,
+		})
+	case errors.Is(err, io.EOF):
+		writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeInvalidJson, Message: "the body is empty"})
+	default:
+		writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeInvalidJson, Message: err.Error()})
+	}
+	return false
+}
+
+func apiFen(w http.ResponseWriter, r *http.Request) {
+	req := &FenRequest{}
+	if !decodeRequest(w, r, req) {
+		return
+	}
+	if len(req.Fen) == 0 {
+		writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeMissingField, Message: "fen is required", Field: "fen"})
+		return
+	}
+	if err := checkFen(req.Fen, req.Strict); err != nil {
+		writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeInvalidFen, Message: err.Error(), Field: "fen"})
+		return
+	}
+	if err := Limits.apply(&req.AnalysisSettings); err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	fd := fenDataOf(r, req.Fen, req.Move, &req.AnalysisSettings)
+	fd.Priority = analyzer.PriorityHigh
+	if err := analyzer.QueueFen(fd); err != nil {
+		writeApiQueueError(w, err, analyzer.FenRetryAfter(err))
+		return
+	}
+
+	result, err := analyzer.CollectFen(r.Context(), fd)
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, &ApiError{Code: ErrCodeAnalysisFailed, Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func apiBatch(w http.ResponseWriter, r *http.Request) {
+	req := &BatchRequest{}
+	if !decodeRequest(w, r, req) {
+		return
+	}
+	if len(req.Positions) == 0 {
+		writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeMissingField, Message: "positions is required", Field: "positions"})
+		return
+	}
+	if len(req.Positions) > Limits.MaxBatch {
+		writeApiError(w, http.StatusBadRequest, &ApiError{
+			Code:    ErrCodeTooLarge,
+			Message: fmt.Sprintf("at most %d positions", Limits.MaxBatch),
+			Field:   "positions",
+		})
+		return
+	}
+	for i, p := range req.Positions {
+		if p == nil || len(p.Fen) == 0 {
+			field := fmt.Sprintf("positions[%d].fen", i)
+			writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeMissingField, Message: field + " is required", Field: field})
+			return
+		}
+		if err := checkFen(p.Fen, req.Strict); err != nil {
+			field := fmt.Sprintf("positions[%d].fen", i)
+			writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeInvalidFen, Message: err.Error(), Field: field})
+			return
+		}
+	}
+	if err := Limits.apply(&req.AnalysisSettings); err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	bd := &analyzer.BatchData{
+		Positions:  req.Positions,
+		Depth:      req.Depth,
+		NumLines:   req.Lines,
+		MaxTimeSec: req.TimeSec,
+		RChannel:   make(chan *analyzer.BatchResult),
+		Ctx:        r.Context(),
+		Client:     clientID(r),
+		Priority:   analyzer.PriorityNormal,
+		Parallel:   Limits.BatchParallel,
+	}
+	analyzer.AnalyzeBatch(bd)
+
+	streamResponseViews(r.Context(), w, bd.RChannel,
+		func(res *analyzer.BatchResult) bool { return res.Done },
+		func() int { return 0 },
+		func(pos int) *analyzer.BatchResult { return nil },
+		func() {},
+		func(res *analyzer.BatchResult) any {
+			if res == nil || res.Done {
+				return nil
+			}
+			out := &BatchResult{Index: res.Index, Id: res.Id, Result: res.Result}
+			if res.Err != nil {
+				out.Error = &ApiError{Code: ErrCodeAnalysisFailed, Message: res.Error}
+			}
+			return out
+		})
+}
+
+func apiPgn(w http.ResponseWriter, r *http.Request) {
+	req := &PgnRequest{}
+	if !decodeRequest(w, r, req) {
+		return
+	}
+	if len(req.Pgn) == 0 {
+		writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeMissingField, Message: "pgn is required", Field: "pgn"})
+		return
+	}
+	if err := Limits.apply(&req.AnalysisSettings); err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := analyzer.JobManager().StartPgnJob(&analyzer.PgnData{
+		Pgn:        req.Pgn,
+		Depth:      req.Depth,
+		NumLines:   req.Lines,
+		MaxTimeSec: req.TimeSec,
+		Client:     clientID(r),
+		Priority:   analyzer.PriorityLow,
+		Ctx:        r.Context(), // the job keeps the request id
+	})
+	if errors.Is(err, analyzer.ErrQueueFull) || errors.Is(err, analyzer.ErrClientLimit) {
+		writeApiQueueError(w, err, analyzer.PgnRetryAfter(err))
+		return
+	}
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeInvalidField, Message: err.Error(), Field: "pgn"})
+		return
+	}
+
+	w.Header().Set("Location", ApiV1Path+"/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job.View(0))
+}
+
+func apiJob(w http.ResponseWriter, r *http.Request, id string) {
+	var job *analyzer.Job
+	switch r.Method {
+	case http.MethodGet:
+		job = analyzer.JobManager().GetJob(id)
+	case http.MethodDelete:
+		job = analyzer.JobManager().CancelJob(id)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeApiError(w, http.StatusMethodNotAllowed, &ApiError{Code: ErrCodeMethodNotAllowed, Message: "use GET or DELETE"})
+		return
+	}
+	if job == nil {
+		writeApiError(w, http.StatusNotFound, &ApiError{Code: ErrCodeNotFound, Message: "job not found"})
+		return
+	}
+
+	since, err := common.Utils.ArgInt(r.URL.Query(), "since", 0)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeInvalidField, Message: "since must be a number", Field: "since"})
+		return
+	}
+	writeJSON(w, http.StatusOK, job.View(since))
+}
+
+// fenDataOf - the request for the fen workers.
+func fenDataOf(r *http.Request, fen string, move string, s *AnalysisSettings) *analyzer.FenData {
+	return &analyzer.FenData{
+		Fen:        fen,
+		UserMove:   move,
+		Depth:      s.Depth,
+		NumLines:   s.Lines,
+		MaxTimeSec: s.TimeSec,
+		RChannel:   make(chan *analyzer.FenResponse),
+		Client:     clientID(r),
+		Priority:   analyzer.PriorityNormal,
+		Ctx:        r.Context(),
+	}
+}
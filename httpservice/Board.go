@@ -0,0 +1,169 @@
+package httpservice
+
+import (
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"net/http"
+	"strings"
+)
+
+// BoardPath - the board utilities, only the diagram with the best move uses the engine
+const BoardPath = "/chess/board"
+
+// BoardPosition - a position with its state
+type BoardPosition struct {
+	Fen     string `json:"fen"`
+	Side    string `json:"side"`
+	Status  string `json:"status"`
+	InCheck bool   `json:"inCheck"`
+}
+
+// LegalMovesResponse - the moves of a position
+type LegalMovesResponse struct {
+	BoardPosition
+	Moves []ai.LegalMove `json:"moves"`
+}
+
+// MoveResponse - the position after the move
+type MoveResponse struct {
+	BoardPosition
+	Move ai.LegalMove `json:"move"`
+}
+
+// PositionsResponse - the positions after each ply
+type PositionsResponse struct {
+	InitialFen string           `json:"initialFen"`
+	Plies      []ai.PlyPosition `json:"plies"`
+}
+
+// Board
+// The stateless board utilities, the response is json.
+//
+//	GET  moves?fen=             the legal moves in uci and san
+//	GET  move?fen=&move=        plays the move (uci or san), the new position
+//	GET  san?fen=&moves=        the moves (space or comma separated) in san, with the position after each
+//	POST pgn   form pgn         the position after each ply of the game
+//	GET  svg?fen=               the diagram, see boardSvg
+func Board(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, BoardPath+"/") {
+	case "moves":
+		if allowMethod(w, r, http.MethodGet) {
+			boardMoves(w, r)
+		}
+	case "move":
+		if allowMethod(w, r, http.MethodGet) {
+			boardMove(w, r)
+		}
+	case "san":
+		if allowMethod(w, r, http.MethodGet) {
+			boardSan(w, r)
+		}
+	case "svg":
+		if allowMethod(w, r, http.MethodGet) {
+			boardSvg(w, r)
+		}
+	case "pgn":
+		if allowMethod(w, r, http.MethodPost) {
+			boardPgn(w, r)
+		}
+	default:
+		writeApiError(w, http.StatusNotFound, &ApiError{Code: ErrCodeNotFound, Message: "no board utility " + r.URL.Path})
+	}
+}
+
+// boardOf - the board of the fen query arg, false after writing the error
+func boardOf(w http.ResponseWriter, r *http.Request) (*ai.Board, bool) {
+	fen := r.URL.Query().Get("fen")
+	if len(fen) == 0 {
+		writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeMissingField, Message: "please enter the fen", Field: "fen"})
+		return nil, false
+	}
+	if err := checkFen(fen, r.URL.Query().Get("strict") == "true"); err != nil {
+		writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeInvalidFen, Message: err.Error(), Field: "fen"})
+		return nil, false
+	}
+	brd := ai.NewBoard()
+	ai.ParseFen(brd, fen)
+	return brd, true
+}
+
+func positionOf(brd *ai.Board, fen string) BoardPosition {
+	side := "b"
+	if brd.IsWhiteToMove() {
+		side = "w"
+	}
+	return BoardPosition{
+		Fen:     fen,
+		Side:    side,
+		Status:  brd.Status().String(),
+		InCheck: brd.IsInCheck(),
+	}
+}
+
+func boardMoves(w http.ResponseWriter, r *http.Request) {
+	brd, ok := boardOf(w, r)
+	if !ok {
+		return
+	}
+	fen := r.URL.Query().Get("fen")
+	writeJSON(w, http.StatusOK, &LegalMovesResponse{
+		BoardPosition: positionOf(brd, ai.BoardToFen(brd, ai.PlyOfFen(fen))),
+		Moves:         ai.LegalMoves(brd),
+	})
+}
+
+func boardMove(w http.ResponseWriter, r *http.Request) {
+	brd, ok := boardOf(w, r)
+	if !ok {
+		return
+	}
+	fen := r.URL.Query().Get("fen")
+	move := r.URL.Query().Get("move")
+	if len(move) == 0 {
+		writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeMissingField, Message: "please enter the move", Field: "move"})
+		return
+	}
+	mv, err := ai.ParseMove(brd, move)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeInvalidField, Message: err.Error(), Field: "move"})
+		return
+	}
+	played := ai.LegalMove{Uci: ai.MoveToString(mv), San: ai.SanForMove(brd, mv)}
+	if err = brd.MakeMove(mv, move); err != nil {
+		writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeInvalidField, Message: err.Error(), Field: "move"})
+		return
+	}
+	writeJSON(w, http.StatusOK, &MoveResponse{
+		BoardPosition: positionOf(brd, ai.BoardToFen(brd, ai.PlyOfFen(fen)+1)),
+		Move:          played,
+	})
+}
+
+func boardSan(w http.ResponseWriter, r *http.Request) {
+	if _, ok := boardOf(w, r); !ok {
+		return
+	}
+	moves := strings.FieldsFunc(r.URL.Query().Get("moves"), func(c rune) bool {
+		return c == ' ' || c == ','
+	})
+	plies, err := ai.PlayMoves(r.URL.Query().Get("fen"), moves)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeInvalidField, Message: err.Error(), Field: "moves"})
+		return
+	}
+	writeJSON(w, http.StatusOK, &PositionsResponse{InitialFen: r.URL.Query().Get("fen"), Plies: plies})
+}
+
+func boardPgn(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, Limits.MaxBodyBytes)
+	pgn := r.PostFormValue("pgn")
+	if len(pgn) == 0 {
+		writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeMissingField, Message: "please enter the pgn", Field: "pgn"})
+		return
+	}
+	initial, plies, err := ai.PgnPositions(pgn)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeInvalidField, Message: err.Error(), Field: "pgn"})
+		return
+	}
+	writeJSON(w, http.StatusOK, &PositionsResponse{InitialFen: initial, Plies: plies})
+}
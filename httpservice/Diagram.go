@@ -0,0 +1,94 @@
+package httpservice
+
+import (
+	"github.com/samlotti/chess_anaylzer/analyzer"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DiagramMaxSize - the largest diagram in pixels
+var DiagramMaxSize = 1600
+
+// boardSvg
+// The diagram of the fen as svg.
+//
+// args:  fen  required
+//
+//	size      optional, pixels, the default is 400
+//	flip      optional, true for black at the bottom
+//	coords    optional, false to leave out the files and ranks
+//	lastmove  optional, the uci move to highlight
+//	arrows    optional, uci moves, comma separated
+//	played    optional, the uci move of the game, drawn as a red arrow
+//	best      optional, true to draw the engine move, depth and tsec as for the analysis
+func boardSvg(w http.ResponseWriter, r *http.Request) {
+	brd, ok := boardOf(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+
+	opts := &ai.SvgOptions{
+		Flip:        query.Get("flip") == "true",
+		Coordinates: query.Get("coords") != "false",
+		LastMove:    query.Get("lastmove"),
+	}
+	if size := query.Get("size"); len(size) > 0 {
+		val, err := strconv.Atoi(size)
+		if err != nil || val <= 0 || val > DiagramMaxSize {
+			writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeInvalidField,
+				Message: "size must be 1 to " + strconv.Itoa(DiagramMaxSize), Field: "size"})
+			return
+		}
+		opts.Size = val
+	}
+	for _, move := range strings.Split(query.Get("arrows"), ",") {
+		if move = strings.TrimSpace(move); len(move) > 0 {
+			opts.Arrows = append(opts.Arrows, ai.SvgArrow{Move: move})
+		}
+	}
+	if played := query.Get("played"); len(played) > 0 {
+		opts.Arrows = append(opts.Arrows, ai.SvgArrow{Move: played, Color: ai.SvgArrowPlayed})
+	}
+
+	if query.Get("best") == "true" {
+		settings := &AnalysisSettings{Lines: 1}
+		for _, arg := range []struct {
+			name string
+			val  *int
+		}{
+			{"depth", &settings.Depth},
+			{"tsec", &settings.TimeSec},
+		} {
+			if v := query.Get(arg.name); len(v) > 0 {
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					writeApiError(w, http.StatusBadRequest, &ApiError{Code: ErrCodeInvalidField, Message: arg.name + " must be a number", Field: arg.name})
+					return
+				}
+				*arg.val = n
+			}
+		}
+		if err := Limits.apply(settings); err != nil {
+			writeApiError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		fd := fenDataOf(r, query.Get("fen"), "", settings)
+		if err := analyzer.QueueFen(fd); err != nil {
+			writeApiQueueError(w, err, analyzer.FenRetryAfter(err))
+			return
+		}
+		result, err := analyzer.CollectFen(r.Context(), fd)
+		if err != nil {
+			writeApiError(w, http.StatusInternalServerError, &ApiError{Code: ErrCodeAnalysisFailed, Message: err.Error()})
+			return
+		}
+		opts.Arrows = append(opts.Arrows, ai.SvgArrow{Move: result.BestMove, Color: ai.SvgArrowBest})
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	ai.WriteSvg(w, brd, opts)
+}
@@ -0,0 +1,297 @@
+package httpservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samlotti/chess_anaylzer/analyzer"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+Server-sent events of the analysis.
+
+The responses are sent as typed events so a browser EventSource can listen
+for what it needs:
+
+	queued         the place in the queue, while waiting for a worker
+	info           a line of the search
+	bestmove       the search of a position ended
+	move-complete  the summary of a move, pgn only
+	summary        the summary of the fen or the game
+	error          the analysis failed
+	done           the last event
+	heartbeat      sent when quiet for SSEHeartbeat, keeps proxies from closing
+
+The events of a job carry the index of the result as the id, a reconnect with
+Last-Event-ID continues after that result.
+*/
+
+// Event paths, the job events are at JobsPath/{id}/events
+const (
+	FenEventsPath = "/chess/ai/fen/events"
+	PgnEventsPath = "/chess/ai/pgn/events"
+	eventsSuffix  = "/events"
+)
+
+// SSEHeartbeat - how long the stream may be quiet before a heartbeat is sent.
+var SSEHeartbeat = 15 * time.Second
+
+// sseWriter - writes the events, each is flushed.
+type sseWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+// newSSEWriter - starts the event stream, writes the error when the
+// connection can not stream.
+func newSSEWriter(w http.ResponseWriter) (*sseWriter, bool) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return nil, false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	return &sseWriter{w: w, f: f}, true
+}
+
+// event - writes the value as the json data of the event, id is left out when empty.
+func (s *sseWriter) event(name string, id string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		common.Log().Error("cannot encode event", "event", name, "err", err)
+		return
+	}
+	if len(id) > 0 {
+		fmt.Fprintf(s.w, "id: %s\n", id)
+	}
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, data)
+	s.f.Flush()
+}
+
+func (s *sseWriter) heartbeat() {
+	s.event("heartbeat", "", struct{}{})
+}
+
+func (s *sseWriter) queued(pos int) {
+	s.event("queued", "", map[string]int{"queuePosition": pos})
+}
+
+// rcodeEvent - the event name of the response code.
+func rcodeEvent(rcode analyzer.RCode) string {
+	switch rcode {
+	case analyzer.RCODE_INFO:
+		return "info"
+	case analyzer.RCODE_BESTMOVE:
+		return "bestmove"
+	case analyzer.RCODE_ERROR:
+		return "error"
+	case analyzer.RCODE_QUEUED:
+		return "queued"
+	default:
+		return "done"
+	}
+}
+
+// AnalyzeFenEvents
+// The fen analysis as server-sent events, args as AnalyzeFen.
+//
+//	GET /chess/ai/fen/events?fen=
+func AnalyzeFenEvents(w http.ResponseWriter, r *http.Request) {
+	fd, ok := fenDataFromQuery(w, r)
+	if !ok {
+		return
+	}
+
+	if err := analyzer.QueueFen(fd); err != nil {
+		writeQueueError(w, err, analyzer.FenRetryAfter(err))
+		return
+	}
+
+	sse, ok := newSSEWriter(w)
+	if !ok {
+		analyzer.RemoveQueuedFen(fd)
+		return
+	}
+
+	builder := analyzer.NewSummaryBuilder()
+	s := &responseStream[*analyzer.FenResponse]{
+		rchan:    fd.RChannel,
+		isDone:   func(resp *analyzer.FenResponse) bool { return resp.Done },
+		position: func() int { return analyzer.FenQueuePosition(fd) },
+		remove:   func() { analyzer.RemoveQueuedFen(fd) },
+		write: func(resp *analyzer.FenResponse) {
+			builder.Add(&analyzer.PgnResponse{RCode: resp.RCode, ARInfo: resp.ARInfo, ARBestMove: resp.ARBestMove})
+			if resp.Done && resp.RCode != analyzer.RCODE_ERROR {
+				if m := builder.Finish(); m != nil {
+					sse.event("summary", "", m)
+				}
+			}
+			sse.event(rcodeEvent(resp.RCode), "", resp)
+			if resp.Done && resp.RCode == analyzer.RCODE_ERROR {
+				sse.event("done", "", struct{}{})
+			}
+		},
+		writeQueued:       sse.queued,
+		heartbeat:         sse.heartbeat,
+		heartbeatInterval: SSEHeartbeat,
+	}
+	s.run(r.Context())
+}
+
+// AnalyzePgnEvents
+// The pgn analysis as server-sent events, args as AnalyzePgn.
+// The analysis runs as a job, the first event is the job with its id,
+// the stream can be picked up again at JobsPath/{id}/events.
+//
+//	POST /chess/ai/pgn/events
+func AnalyzePgnEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fd, ok := pgnDataFromForm(w, r)
+	if !ok {
+		return
+	}
+
+	// The job keeps the request id
+	fd.Ctx = r.Context()
+	job, err := analyzer.JobManager().StartPgnJob(fd)
+	if errors.Is(err, analyzer.ErrQueueFull) || errors.Is(err, analyzer.ErrClientLimit) {
+		writeQueueError(w, err, analyzer.PgnRetryAfter(err))
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sse, ok := newSSEWriter(w)
+	if !ok {
+		analyzer.JobManager().CancelJob(job.ID)
+		return
+	}
+	sse.event("job", "", map[string]string{"id": job.ID, "events": JobsPath + "/" + job.ID + eventsSuffix})
+	streamJob(r.Context(), sse, job, 0)
+}
+
+// jobEvents - the events of the job, resumes after the Last-Event-ID
+// header or the lastEventId argument.
+func jobEvents(w http.ResponseWriter, r *http.Request, id string) {
+	job := analyzer.JobManager().GetJob(id)
+	if job == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	since := 0
+	last := r.Header.Get("Last-Event-ID")
+	if len(last) == 0 {
+		last = r.URL.Query().Get("lastEventId")
+	}
+	if len(last) > 0 {
+		n, err := strconv.Atoi(strings.TrimSpace(last))
+		if err != nil || n < 0 {
+			http.Error(w, "Last-Event-ID invalid", http.StatusBadRequest)
+			return
+		}
+		since = n + 1
+	}
+
+	sse, ok := newSSEWriter(w)
+	if !ok {
+		return
+	}
+	streamJob(r.Context(), sse, job, since)
+}
+
+// streamJob - the results of the job from since on, until the job ends or
+// the client goes away.  The job is left running when the client goes,
+// it can reconnect.
+func streamJob(ctx context.Context, sse *sseWriter, job *analyzer.Job, since int) {
+	// The summary needs the results already sent
+	builder := analyzer.NewSummaryBuilder()
+	sent := job.View(0).Results
+	if since > len(sent) {
+		since = len(sent)
+	}
+	for _, resp := range sent[:since] {
+		builder.Add(resp)
+	}
+
+	// The heartbeat is sent when nothing was written for SSEHeartbeat
+	beat := time.NewTimer(SSEHeartbeat)
+	defer beat.Stop()
+	wrote := func() {
+		if !beat.Stop() {
+			select {
+			case <-beat.C:
+			default:
+			}
+		}
+		beat.Reset(SSEHeartbeat)
+	}
+
+	lastPos := 0
+	for {
+		changed := job.Changed()
+		view := job.View(since)
+		if view.Queue > 0 && view.Queue != lastPos {
+			lastPos = view.Queue
+			sse.queued(view.Queue)
+			wrote()
+		}
+		for i, resp := range view.Results {
+			writeJobEvent(sse, builder, resp, strconv.Itoa(since+i))
+			if resp.Done {
+				return
+			}
+			wrote()
+		}
+		since = view.Next
+
+		poll := time.NewTimer(QueuePollInterval)
+		select {
+		case <-changed:
+		case <-poll.C:
+		case <-beat.C:
+			sse.heartbeat()
+			beat.Reset(SSEHeartbeat)
+		case <-ctx.Done():
+			poll.Stop()
+			return
+		}
+		poll.Stop()
+	}
+}
+
+// writeJobEvent - the event of the result, preceded by the summaries it completes.
+func writeJobEvent(sse *sseWriter, builder *analyzer.SummaryBuilder, resp *analyzer.PgnResponse, id string) {
+	if m := builder.Add(resp); m != nil {
+		sse.event("move-complete", "", m)
+	}
+	switch {
+	case resp.Done && resp.RCode == analyzer.RCODE_ERROR:
+		sse.event("error", id, resp)
+		sse.event("done", "", struct{}{})
+	case resp.Done:
+		if m := builder.Finish(); m != nil {
+			sse.event("move-complete", "", m)
+		}
+		sse.event("summary", "", builder.Summary())
+		sse.event("done", id, resp)
+	default:
+		sse.event(rcodeEvent(resp.RCode), id, resp)
+	}
+}
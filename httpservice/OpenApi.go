@@ -0,0 +1,206 @@
+package httpservice
+
+import (
+	"github.com/samlotti/chess_anaylzer/analyzer"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// The OpenAPI document of ApiV1, the schemas are made from the go types
+// so they can not drift from what the handlers read and write.
+// A doc tag on a field is its description.
+
+// openAPIOperation - a path and method of the api
+type openAPIOperation struct {
+	path, method, summary string
+	request               any // nil when there is no body
+	status                int
+	response              any
+	contentType           string // of the response, default application/json
+	params                []map[string]any
+}
+
+var idParam = map[string]any{"name": "id", "in": "path", "required": true, "schema": map[string]any{"type": "string"}}
+var sinceParam = map[string]any{"name": "since", "in": "query", "schema": map[string]any{"type": "integer"},
+	"description": "only the results from this index on, the next of the prior view"}
+
+func openAPIOperations() []*openAPIOperation {
+	return []*openAPIOperation{
+		{path: "/fen", method: "post", summary: "Analyze a position",
+			request: FenRequest{}, status: http.StatusOK, response: analyzer.FenResult{}},
+		{path: "/batch", method: "post", summary: "Analyze positions, a BatchResult per line as each completes",
+			request: BatchRequest{}, status: http.StatusOK, response: BatchResult{}, contentType: "application/x-ndjson"},
+		{path: "/pgn", method: "post", summary: "Analyze a game as a job",
+			request: PgnRequest{}, status: http.StatusAccepted, response: analyzer.JobView{}},
+		{path: "/jobs/{id}", method: "get", summary: "The progress and results of a job",
+			status: http.StatusOK, response: analyzer.JobView{}, params: []map[string]any{idParam, sinceParam}},
+		{path: "/jobs/{id}", method: "delete", summary: "Cancel a job",
+			status: http.StatusOK, response: analyzer.JobView{}, params: []map[string]any{idParam}},
+	}
+}
+
+// OpenAPI - the document of the api
+func OpenAPI() map[string]any {
+	schemas := make(map[string]any)
+	paths := make(map[string]any)
+
+	for _, op := range openAPIOperations() {
+		item, ok := paths[ApiV1Path+op.path].(map[string]any)
+		if !ok {
+			item = make(map[string]any)
+			paths[ApiV1Path+op.path] = item
+		}
+
+		contentType := op.contentType
+		if len(contentType) == 0 {
+			contentType = "application/json"
+		}
+		operation := map[string]any{
+			"summary": op.summary,
+			"responses": map[string]any{
+				strconv.Itoa(op.status): map[string]any{
+					"description": http.StatusText(op.status),
+					"content": map[string]any{
+						contentType: map[string]any{"schema": schemaOf(reflect.TypeOf(op.response), schemas)},
+					},
+				},
+				"default": map[string]any{
+					"description": "an error, see the code",
+					"content": map[string]any{
+						"application/json": map[string]any{"schema": schemaOf(reflect.TypeOf(ErrorBody{}), schemas)},
+					},
+				},
+			},
+		}
+		if op.request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": schemaOf(reflect.TypeOf(op.request), schemas)},
+				},
+			}
+		}
+		if len(op.params) > 0 {
+			operation["parameters"] = op.params
+		}
+		item[op.method] = operation
+	}
+	paths[ApiV1Path+"/openapi.json"] = map[string]any{
+		"get": map[string]any{
+			"summary":   "This document",
+			"responses": map[string]any{"200": map[string]any{"description": "OK"}},
+		},
+	}
+
+	settingsLimits(schemas)
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Chess analyzer",
+			"version": "1",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas},
+	}
+}
+
+// settingsLimits - the defaults and bounds of Limits on the settings.
+func settingsLimits(schemas map[string]any) {
+	props := schemas["AnalysisSettings"].(map[string]any)["properties"].(map[string]any)
+	for name, bounds := range map[string][2]int{
+		"depth":   {Limits.DefaultDepth, Limits.MaxDepth},
+		"timeSec": {Limits.DefaultTimeSec, Limits.MaxTimeSec},
+		"lines":   {Limits.DefaultLines, Limits.MaxLines},
+	} {
+		prop := props[name].(map[string]any)
+		prop["default"] = bounds[0]
+		prop["minimum"] = 0
+		prop["maximum"] = bounds[1]
+	}
+	positions := schemas["BatchRequest"].(map[string]any)["allOf"].([]any)[1].(map[string]any)["properties"].(map[string]any)["positions"].(map[string]any)
+	positions["maxItems"] = Limits.MaxBatch
+}
+
+// schemaOf - the schema of the type, the structs are added to schemas
+// by name and referenced.
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if _, ok := schemas[t.Name()]; !ok {
+			// Placed first, a type may refer to itself
+			schemas[t.Name()] = map[string]any{}
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		return map[string]any{}
+	}
+}
+
+// structSchema - the properties of the json fields, the embedded structs
+// are combined with allOf.
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	props := make(map[string]any)
+	required := make([]string, 0)
+	embedded := make([]any, 0)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			embedded = append(embedded, schemaOf(f.Type, schemas))
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+
+		prop := schemaOf(f.Type, schemas)
+		if doc := f.Tag.Get("doc"); len(doc) > 0 {
+			if _, isRef := prop["$ref"]; isRef {
+				// A $ref takes no siblings in 3.0
+				prop = map[string]any{"allOf": []any{prop}}
+			}
+			prop["description"] = doc
+		}
+		props[name] = prop
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	if len(embedded) > 0 {
+		return map[string]any{"allOf": append(embedded, schema)}
+	}
+	return schema
+}
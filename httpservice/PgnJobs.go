@@ -0,0 +1,84 @@
+package httpservice
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/samlotti/chess_anaylzer/analyzer"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"net/http"
+	"strings"
+)
+
+// JobsPath - the path of the job api, register with and without the trailing /
+const JobsPath = "/chess/ai/jobs"
+
+// PgnJobs
+// The pgn analysis as a job, the client polls for the results.
+//
+//	POST   /chess/ai/jobs          args as AnalyzePgn, returns the job id
+//	GET    /chess/ai/jobs/{id}     the status, progress and results, since=N for the results from N on
+//	GET    /chess/ai/jobs/{id}/events  the results as server-sent events, see AnalyzePgnEvents
+//	DELETE /chess/ai/jobs/{id}     cancels the job
+func PgnJobs(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, JobsPath), "/")
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(id, eventsSuffix):
+		jobEvents(w, r, strings.TrimSuffix(id, eventsSuffix))
+	case r.Method == http.MethodPost && len(id) == 0:
+		createPgnJob(w, r)
+	case r.Method == http.MethodGet && len(id) > 0:
+		job := analyzer.JobManager().GetJob(id)
+		if job == nil {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+		since, err := common.Utils.ArgInt(r.URL.Query(), "since", 0)
+		if err != nil {
+			http.Error(w, "since invalid, please enter a valid number", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, job.View(since))
+	case r.Method == http.MethodDelete && len(id) > 0:
+		job := analyzer.JobManager().CancelJob(id)
+		if job == nil {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, job.View(0))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func createPgnJob(w http.ResponseWriter, r *http.Request) {
+	fd, ok := pgnDataFromForm(w, r)
+	if !ok {
+		return
+	}
+
+	// Nobody is waiting on the connection
+	fd.Priority = analyzer.PriorityLow
+	// The job keeps the request id
+	fd.Ctx = r.Context()
+
+	job, err := analyzer.JobManager().StartPgnJob(fd)
+	if errors.Is(err, analyzer.ErrQueueFull) || errors.Is(err, analyzer.ErrClientLimit) {
+		writeQueueError(w, err, analyzer.PgnRetryAfter(err))
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Location", JobsPath+"/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job.View(0))
+}
+
+// writeJSON - the value as the json response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,155 @@
+package httpservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/samlotti/chess_anaylzer/analyzer"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// QueuePollInterval - how often a waiting request is told its place in the queue.
+var QueuePollInterval = time.Second
+
+// clientID - the client for the queue fairness, the remote host.
+func clientID(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// writeQueueError - the response when the request could not be queued,
+// 429 when the client has too many waiting, 503 when the queue is full.
+func writeQueueError(w http.ResponseWriter, err error, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	switch {
+	case errors.Is(err, analyzer.ErrClientLimit):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	default:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	}
+}
+
+// streamResponses - writes the responses, one json per line, until the done.
+// While the request waits for a worker its place in the queue is written
+// as an RCODE_QUEUED response when it changes.
+// When the client goes away (the context ends) the request is taken out of
+// the queue, a running analysis stops on the same context.
+func streamResponses[T any](ctx context.Context, w http.ResponseWriter, rchan chan T, isDone func(T) bool,
+	position func() int, queued func(int) T, remove func()) {
+	streamResponseViews(ctx, w, rchan, isDone, position, queued, remove, func(resp T) any { return resp })
+}
+
+// streamResponseViews - streamResponses writing the view of each response,
+// nothing for a nil view.  The stream stops as when the client goes away
+// once a line can not be written.
+func streamResponseViews[T any](ctx context.Context, w http.ResponseWriter, rchan chan T, isDone func(T) bool,
+	position func() int, queued func(int) T, remove func(), view func(T) any) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	encode := func(v any) {
+		if v == nil || ctx.Err() != nil {
+			return
+		}
+		if err := enc.Encode(v); err != nil {
+			common.Logger(ctx).Debug("response not written", "err", err)
+			cancel()
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	s := &responseStream[T]{
+		rchan:    rchan,
+		isDone:   isDone,
+		position: position,
+		remove:   remove,
+		write: func(resp T) {
+			encode(view(resp))
+		},
+		writeQueued: func(pos int) {
+			encode(view(queued(pos)))
+		},
+	}
+	s.run(ctx)
+}
+
+// responseStream - the responses of a queued request, written as they come.
+type responseStream[T any] struct {
+	rchan       chan T
+	isDone      func(T) bool
+	position    func() int // the place in the queue, 0 when not queued
+	remove      func()     // takes the request out of the queue
+	write       func(T)
+	writeQueued func(int)
+
+	heartbeat         func() // optional, called when nothing was written for heartbeatInterval
+	heartbeatInterval time.Duration
+}
+
+// run - writes until the done or the context ends.
+func (s *responseStream[T]) run(ctx context.Context) {
+	lastPos := 0
+	report := func() {
+		if pos := s.position(); pos > 0 && pos != lastPos {
+			lastPos = pos
+			s.writeQueued(pos)
+		}
+	}
+	report()
+
+	ticker := time.NewTicker(QueuePollInterval)
+	defer ticker.Stop()
+
+	var beat <-chan time.Time
+	var beatTimer *time.Timer
+	if s.heartbeat != nil {
+		beatTimer = time.NewTimer(s.heartbeatInterval)
+		defer beatTimer.Stop()
+		beat = beatTimer.C
+	}
+	wrote := func() {
+		if beatTimer != nil {
+			if !beatTimer.Stop() {
+				select {
+				case <-beatTimer.C:
+				default:
+				}
+			}
+			beatTimer.Reset(s.heartbeatInterval)
+		}
+	}
+
+	for {
+		select {
+		case resp := <-s.rchan:
+			s.write(resp)
+			wrote()
+			if s.isDone(resp) {
+				return
+			}
+			lastPos = -1
+		case <-ticker.C:
+			if lastPos >= 0 {
+				report()
+			}
+		case <-beat:
+			s.heartbeat()
+			beatTimer.Reset(s.heartbeatInterval)
+		case <-ctx.Done():
+			s.remove()
+			return
+		}
+	}
+}
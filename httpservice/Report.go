@@ -0,0 +1,167 @@
+package httpservice
+
+import (
+	"embed"
+	"fmt"
+	"github.com/samlotti/chess_anaylzer/analyzer"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// ReportPath - the report of a pgn job, /chess/ai/report/{id}
+const ReportPath = "/chess/ai/report/"
+
+// The report is an html/template, not a blip template as the index page of
+// the server.  Blip generates its templates into the main package with
+// go:generate, the service is a library and its tests run without the
+// generator, so the page is embedded and parsed here.
+//
+//go:embed templates/report.html
+var reportFiles embed.FS
+
+var reportTemplate = template.Must(template.New("report.html").Funcs(template.FuncMap{
+	"pawns": func(cp int) float64 { return float64(cp) / 100 },
+}).ParseFS(reportFiles, "templates/report.html"))
+
+// The eval graph, the evals are capped at graphMaxCP
+const (
+	graphWidth  = 800
+	graphHeight = 200
+	graphMaxCP  = 1000
+)
+
+// reportPage - the values of the report template
+type reportPage struct {
+	Title    string
+	Running  bool
+	Ply      int
+	Plies    int
+	Error    string
+	Report   *analyzer.GameReport
+	Sides    []analyzer.SideReport
+	Graph    *evalGraph
+	Critical []*criticalMoment
+}
+
+type evalGraph struct {
+	Width  int
+	Height int
+	Middle int
+	Points string
+	Marks  []graphMark
+}
+
+type graphMark struct {
+	X, Y  int
+	Class analyzer.MoveClass
+	Title string
+}
+
+type criticalMoment struct {
+	Move *analyzer.ReportMove
+	Svg  template.HTML
+}
+
+// GameReport
+// The report of a pgn analyzed as a job, see PgnJobs.  The page refreshes
+// while the job is running.
+//
+//	GET /chess/ai/report/{id}
+func GameReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, ReportPath), "/")
+	job := analyzer.JobManager().GetJob(id)
+	if job == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	view := job.View(0)
+	page := &reportPage{Title: "Game " + id, Ply: view.Ply, Plies: view.Plies, Error: view.Error}
+	switch view.Status {
+	case analyzer.JobQueued, analyzer.JobRunning:
+		page.Running = true
+	default:
+		report, err := analyzer.BuildReport(job.Pgn(), analyzer.Summarize(view.Results))
+		if err != nil {
+			page.Error = err.Error()
+			break
+		}
+		page.setReport(report)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := reportTemplate.Execute(w, page); err != nil {
+		common.Logger(r.Context()).Error("report failed", common.LogJob, id, "err", err)
+	}
+}
+
+func (p *reportPage) setReport(report *analyzer.GameReport) {
+	p.Report = report
+	if white, black := report.Attributes["White"], report.Attributes["Black"]; len(white) > 0 && len(black) > 0 {
+		p.Title = white + " - " + black
+	}
+	p.Sides = []analyzer.SideReport{report.White, report.Black}
+	for idx, name := range []string{"White", "Black"} {
+		if len(p.Sides[idx].Name) == 0 {
+			p.Sides[idx].Name = name
+		}
+	}
+	p.Graph = newEvalGraph(report.Moves)
+
+	for _, move := range report.Critical {
+		brd := ai.NewBoard()
+		ai.ParseFen(brd, move.FenBefore)
+		opts := &ai.SvgOptions{
+			Size:        280,
+			Flip:        !move.White,
+			Coordinates: true,
+			Arrows: []ai.SvgArrow{
+				{Move: move.Uci, Color: ai.SvgArrowPlayed},
+				{Move: move.BestUci, Color: ai.SvgArrowBest},
+			},
+		}
+		if move.Ply > 1 {
+			opts.LastMove = report.Moves[move.Ply-2].Uci
+		}
+		// The svg is generated here, nothing of the request is in it
+		p.Critical = append(p.Critical, &criticalMoment{Move: move, Svg: template.HTML(ai.BoardToSvg(brd, opts))})
+	}
+}
+
+// newEvalGraph - the evals from the view of white, white winning is up
+func newEvalGraph(moves []*analyzer.ReportMove) *evalGraph {
+	g := &evalGraph{Width: graphWidth, Height: graphHeight, Middle: graphHeight / 2}
+	if len(moves) == 0 {
+		return g
+	}
+
+	step := float64(graphWidth) / float64(len(moves))
+	points := make([]string, 0, len(moves)+1)
+	points = append(points, fmt.Sprintf("0,%d", g.Middle))
+	for idx, move := range moves {
+		eval := move.EvalCP
+		if eval > graphMaxCP {
+			eval = graphMaxCP
+		}
+		if eval < -graphMaxCP {
+			eval = -graphMaxCP
+		}
+		x := int(step * float64(idx+1))
+		y := g.Middle - eval*g.Middle/graphMaxCP
+		points = append(points, fmt.Sprintf("%d,%d", x, y))
+
+		switch move.Class {
+		case analyzer.ClassInaccuracy, analyzer.ClassMistake, analyzer.ClassBlunder:
+			g.Marks = append(g.Marks, graphMark{X: x, Y: y, Class: move.Class, Title: fmt.Sprintf("%d. %s %s", move.Number, move.San, move.Class)})
+		}
+	}
+	g.Points = strings.Join(points, " ")
+	return g
+}
@@ -0,0 +1,139 @@
+package httpservice
+
+/**
+The log of the requests.
+
+Every request has an id, the X-Request-Id of the client when it is usable,
+else a new one.  The id is sent back in the X-Request-Id of the response and
+is on every line logged for the request, see common.Logger.  The jobs of the
+request keep it, so the analysis is traced to the engine.
+
+	http.ListenAndServe(addr, httpservice.RequestLog(http.DefaultServeMux))
+*/
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RequestIdHeader - the header of the request id
+const RequestIdHeader = "X-Request-Id"
+
+// requestIdMaxLen - a longer id of the client is replaced
+const requestIdMaxLen = 64
+
+// RequestLog - the handler with the request id in the context, the request is logged when it ends.
+func RequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIdHeader)
+		if !validRequestId(id) {
+			id = newRequestId()
+		}
+		w.Header().Set(RequestIdHeader, id)
+
+		ctx := common.WithLogAttrs(r.Context(), common.LogRequest, id)
+		log := common.Logger(ctx)
+		log.Debug("request started", "method", r.Method, "path", r.URL.Path, "client", clientID(r))
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		log.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.statusCode(),
+			"bytes", sw.bytes,
+			"duration", time.Since(start),
+			"client", clientID(r))
+	})
+}
+
+// validRequestId - an id of the client is logged, keep it to a plain token
+func validRequestId(id string) bool {
+	if len(id) == 0 || len(id) > requestIdMaxLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// statusWriter - keeps the status and size of the response.
+// The event streams flush and the sessions hijack the connection.
+type statusWriter struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the connection can not be hijacked")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap - for the http.ResponseController
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusCode - of the response, switching protocols once hijacked
+func (w *statusWriter) statusCode() int {
+	switch {
+	case w.hijacked:
+		return http.StatusSwitchingProtocols
+	case w.status == 0:
+		return http.StatusOK
+	}
+	return w.status
+}
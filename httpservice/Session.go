@@ -0,0 +1,286 @@
+package httpservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/samlotti/chess_anaylzer/analyzer"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/**
+An analysis board over a websocket.
+
+The session holds one engine for as long as the client is connected.  Each
+position message stops the running search and starts a new one, the
+responses carry the id of the position so the client can drop the late
+ones of an earlier position.
+
+	-> {"type":"position", "fen":"...", "move":"e2e4", "depth":0, "tsec":30, "lines":3}
+	-> {"type":"multipv", "lines":5}      restarts the running search with the lines
+	-> {"type":"stop"}
+	<- {"type":"ready"}
+	<- {"type":"info", "id":1, "info":{...}}
+	<- {"type":"bestmove", "id":1, "bestMove":{...}}
+	<- {"type":"stopped", "id":1}
+	<- {"type":"error", "id":1, "error":"..."}
+*/
+
+// SessionPath - the path of the analysis session
+const SessionPath = "/chess/ai/session"
+
+// MaxSessions - the sessions open at once, each holds an engine.
+var MaxSessions = 10
+
+// SessionMaxTimeSec - the search time when the position does not give one.
+var SessionMaxTimeSec = 30
+
+// sessionWriteWait - how long a write to the client may take.
+const sessionWriteWait = 10 * time.Second
+
+// SessionRequest - a message of the client
+type SessionRequest struct {
+	Type  string `json:"type"` // position, multipv, stop
+	Fen   string `json:"fen"`
+	Move  string `json:"move"` // optional, the move played in the position, it is scored too
+	Depth int    `json:"depth"`
+	TSec  int    `json:"tsec"`
+	Lines int    `json:"lines"`
+}
+
+// SessionResponse - a message to the client
+type SessionResponse struct {
+	Type     string               `json:"type"` // ready, info, bestmove, stopped, error
+	Id       int                  `json:"id"`   // the position, counts the position messages
+	Info     *analyzer.ARInfo     `json:"info,omitempty"`
+	BestMove *analyzer.ARBestMove `json:"bestMove,omitempty"`
+	Error    string               `json:"error,omitempty"`
+}
+
+var sessionUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+var sessionCount = struct {
+	sync.Mutex
+	n int
+}{}
+
+// AnalysisSession
+// The analysis board session, upgrades to a websocket.
+//
+//	GET /chess/ai/session
+func AnalysisSession(w http.ResponseWriter, r *http.Request) {
+	sessionCount.Lock()
+	if sessionCount.n >= MaxSessions {
+		sessionCount.Unlock()
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "too many analysis sessions", http.StatusServiceUnavailable)
+		return
+	}
+	sessionCount.n++
+	sessionCount.Unlock()
+	defer func() {
+		sessionCount.Lock()
+		sessionCount.n--
+		sessionCount.Unlock()
+	}()
+
+	conn, err := sessionUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader wrote the error
+		return
+	}
+	defer conn.Close()
+
+	// The session outlives the upgrade request, it keeps the request id
+	ctx, cancel := context.WithCancel(common.WithLogger(context.Background(), common.Logger(r.Context())))
+	s := &analysisSession{
+		ctx:      ctx,
+		out:      make(chan *SessionResponse, 100),
+		analyzer: analyzer.NewFenAnalyzer(),
+		lines:    analyzer.DefaultNumPVLines,
+	}
+	s.analyzer.KeepProcess = true
+
+	written := make(chan struct{})
+	go s.writer(conn, written)
+
+	s.send(&SessionResponse{Type: "ready"})
+	s.read(conn)
+
+	// The client went away
+	s.stop()
+	s.analyzer.Close()
+	cancel()
+	<-written
+}
+
+// analysisSession - the state of a connected client.
+type analysisSession struct {
+	ctx      context.Context
+	out      chan *SessionResponse
+	analyzer *analyzer.FenAnalyzer
+
+	lines   int
+	id      int             // the current position
+	current *SessionRequest // the current position, nil when stopped
+	cancel  context.CancelFunc
+	done    chan struct{} // closed when the search has ended
+}
+
+// read - handles the messages until the connection closes.
+func (s *analysisSession) read(conn *websocket.Conn) {
+	conn.SetReadLimit(64 * 1024)
+	for {
+		req := &SessionRequest{}
+		if err := conn.ReadJSON(req); err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				common.Logger(s.ctx).Warn("session read failed", "err", err)
+			}
+			return
+		}
+
+		switch req.Type {
+		case "position":
+			if len(req.Fen) == 0 {
+				s.send(&SessionResponse{Type: "error", Id: s.id, Error: "please enter a fen"})
+				continue
+			}
+			if err := checkFen(req.Fen, false); err != nil {
+				s.send(&SessionResponse{Type: "error", Id: s.id, Error: err.Error()})
+				continue
+			}
+			if req.Lines > 0 {
+				s.lines = req.Lines
+			}
+			s.start(req)
+		case "multipv":
+			if req.Lines < 1 {
+				s.send(&SessionResponse{Type: "error", Id: s.id, Error: "lines invalid, please enter a valid number"})
+				continue
+			}
+			s.lines = req.Lines
+			if s.isSearching() {
+				s.start(s.current)
+			}
+		case "stop":
+			if s.stop() {
+				s.send(&SessionResponse{Type: "stopped", Id: s.id})
+			}
+		default:
+			s.send(&SessionResponse{Type: "error", Id: s.id, Error: fmt.Sprintf("unknown message type: %s", req.Type)})
+		}
+	}
+}
+
+// writer - writes the responses, the only writer of the connection.
+func (s *analysisSession) writer(conn *websocket.Conn, written chan struct{}) {
+	defer close(written)
+	for {
+		select {
+		case resp := <-s.out:
+			conn.SetWriteDeadline(time.Now().Add(sessionWriteWait))
+			if err := conn.WriteJSON(resp); err != nil {
+				// The read fails too and ends the session
+				conn.Close()
+				return
+			}
+		case <-s.ctx.Done():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			return
+		}
+	}
+}
+
+// send - queues the response, dropped once the session has ended.
+func (s *analysisSession) send(resp *SessionResponse) {
+	select {
+	case s.out <- resp:
+	case <-s.ctx.Done():
+	}
+}
+
+// isSearching - a search is running
+func (s *analysisSession) isSearching() bool {
+	if s.done == nil {
+		return false
+	}
+	select {
+	case <-s.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// start - stops the running search and searches the position.
+func (s *analysisSession) start(req *SessionRequest) {
+	s.stop()
+
+	s.id++
+	s.current = req
+	a := s.analyzer
+	a.Fen = req.Fen
+	a.UserMove = req.Move
+	a.Depth = req.Depth
+	a.MaxTimeSec = req.TSec
+	if a.MaxTimeSec <= 0 {
+		a.MaxTimeSec = SessionMaxTimeSec
+	}
+	a.NumPVLines = s.lines
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	rchan := make(chan *analyzer.AResults)
+	go a.AnalyzeContext(ctx, rchan)
+	go s.forward(ctx, s.id, rchan, s.done)
+}
+
+// forward - passes the results of the search on until the done.
+func (s *analysisSession) forward(ctx context.Context, id int, rchan chan *analyzer.AResults, done chan struct{}) {
+	defer close(done)
+	for res := range rchan {
+		if ctx.Err() != nil {
+			// Stopped, the rest is of no use to the client
+			if res.Done {
+				return
+			}
+			continue
+		}
+		switch res.RCode {
+		case analyzer.RCODE_INFO:
+			s.send(&SessionResponse{Type: "info", Id: id, Info: res.Info})
+		case analyzer.RCODE_BESTMOVE:
+			s.send(&SessionResponse{Type: "bestmove", Id: id, BestMove: res.BestMode})
+		case analyzer.RCODE_ERROR:
+			if res.Err != nil {
+				s.send(&SessionResponse{Type: "error", Id: id, Error: res.Err.Error()})
+			}
+		}
+		if res.Done {
+			return
+		}
+	}
+}
+
+// stop - stops the running search and waits for it to end, false if none was running.
+func (s *analysisSession) stop() bool {
+	if s.done == nil {
+		return false
+	}
+	running := s.isSearching()
+	s.cancel()
+	<-s.done
+	s.done = nil
+	s.cancel = nil
+	return running
+}
@@ -0,0 +1,576 @@
+package config
+
+/**
+The configuration of the server.
+
+Each setting is taken from, the later wins:
+	the defaults
+	the config file, -config or CHESS_CONFIG, yaml (.yaml, .yml) or json (.json)
+	the environment, CHESS_ and the flag in upper case, ex: CHESS_FEN_WORKERS
+	the flags, ex: -fen-workers 8
+
+The engines of the flag and environment are a comma separated list of
+name=binary:protocol, the binary and protocol may be left out, ex:
+	-engines zahak=mac/zahak-darwin-amd64-8.0-avx,crafty=/usr/games/crafty:cecp
+
+An engine of an engine host (see uci/enginehost) has the remote address and
+token of the host, it is set in the config file.
+
+The configuration is validated as a whole, the error lists every problem.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/samlotti/chess_anaylzer/analyzer"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"github.com/samlotti/chess_anaylzer/httpservice"
+	"github.com/samlotti/chess_anaylzer/uci"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// EnvConfig - the environment variable of the config file
+const EnvConfig = "CHESS_CONFIG"
+
+// envPrefix - of the environment variables of the flags
+const envPrefix = "CHESS_"
+
+// The log levels
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
+)
+
+// The log formats
+const (
+	LogText = "text"
+	LogJson = "json"
+)
+
+// The protocols of the engines
+const (
+	ProtocolUci  = "uci"
+	ProtocolCecp = "cecp"
+)
+
+// Config - of the server
+type Config struct {
+	Listen    string  `yaml:"listen" json:"listen"`
+	PublicDir string  `yaml:"publicDir" json:"publicDir"`
+	TLS       TLS     `yaml:"tls" json:"tls"`
+	Workers   Workers `yaml:"workers" json:"workers"`
+	Engines   Engines `yaml:"engines" json:"engines"`
+	Queue     Queue   `yaml:"queue" json:"queue"`
+	Limits    Limits  `yaml:"limits" json:"limits"`
+	LogLevel  string  `yaml:"logLevel" json:"logLevel"`
+	LogFormat string  `yaml:"logFormat" json:"logFormat"`
+
+	TranscriptDir string `yaml:"transcriptDir" json:"transcriptDir"` // every engine session is recorded here when set
+	EngineRetries int    `yaml:"engineRetries" json:"engineRetries"` // times a position is retried when its engine dies
+}
+
+// TLS - the server is https when both files are set
+type TLS struct {
+	CertFile string `yaml:"certFile" json:"certFile"`
+	KeyFile  string `yaml:"keyFile" json:"keyFile"`
+}
+
+// Workers - the analysis run at once
+type Workers struct {
+	Fen int `yaml:"fen" json:"fen"`
+	Pgn int `yaml:"pgn" json:"pgn"`
+}
+
+// Engines - the registry of the engines
+type Engines struct {
+	Dir     string   `yaml:"dir" json:"dir"`         // the binaries are relative to it
+	Default string   `yaml:"default" json:"default"` // the engine of the analysis
+	List    []Engine `yaml:"list" json:"list"`
+}
+
+// Engine - a binary the server may run, or an engine of an engine host
+type Engine struct {
+	Name     string `yaml:"name" json:"name"`
+	Binary   string `yaml:"binary" json:"binary"`     // relative to the Dir unless absolute, empty for the name
+	Protocol string `yaml:"protocol" json:"protocol"` // uci or cecp, empty for uci
+	Remote   string `yaml:"remote" json:"remote"`     // host:port of the engine host, the binary is not local
+	Token    string `yaml:"token" json:"token"`       // of the engine host
+}
+
+// Queue - the requests waiting for the workers
+type Queue struct {
+	Depth     int `yaml:"depth" json:"depth"`
+	PerClient int `yaml:"perClient" json:"perClient"` // 0 = no client limit
+}
+
+// Limits - the defaults and bounds of the analysis, see httpservice.AnalysisLimits
+type Limits struct {
+	DefaultDepth      int   `yaml:"defaultDepth" json:"defaultDepth"`
+	MaxDepth          int   `yaml:"maxDepth" json:"maxDepth"`
+	DefaultTimeSec    int   `yaml:"defaultTimeSec" json:"defaultTimeSec"`
+	MaxTimeSec        int   `yaml:"maxTimeSec" json:"maxTimeSec"`
+	DefaultLines      int   `yaml:"defaultLines" json:"defaultLines"`
+	MaxLines          int   `yaml:"maxLines" json:"maxLines"`
+	MaxBatch          int   `yaml:"maxBatch" json:"maxBatch"`
+	BatchParallel     int   `yaml:"batchParallel" json:"batchParallel"`
+	MaxBodyBytes      int64 `yaml:"maxBodyBytes" json:"maxBodyBytes"`
+	MaxSessions       int   `yaml:"maxSessions" json:"maxSessions"`
+	SessionMaxTimeSec int   `yaml:"sessionMaxTimeSec" json:"sessionMaxTimeSec"`
+}
+
+// Default - the configuration when nothing is set
+func Default() *Config {
+	l := httpservice.Limits
+	return &Config{
+		Listen:    ":8181",
+		PublicDir: "../public",
+		Workers:   Workers{Fen: 5, Pgn: 5},
+		Engines: Engines{
+			Dir:     "../engines/",
+			Default: analyzer.DefaultEngine,
+			List: []Engine{
+				{Name: analyzer.DefaultEngine, Binary: "mac/zahak-darwin-amd64-8.0-avx", Protocol: ProtocolUci},
+			},
+		},
+		Queue: Queue{Depth: analyzer.DefaultQueueDepth, PerClient: analyzer.DefaultQueuePerClient},
+		Limits: Limits{
+			DefaultDepth:      l.DefaultDepth,
+			MaxDepth:          l.MaxDepth,
+			DefaultTimeSec:    l.DefaultTimeSec,
+			MaxTimeSec:        l.MaxTimeSec,
+			DefaultLines:      l.DefaultLines,
+			MaxLines:          l.MaxLines,
+			MaxBatch:          l.MaxBatch,
+			BatchParallel:     l.BatchParallel,
+			MaxBodyBytes:      l.MaxBodyBytes,
+			MaxSessions:       httpservice.MaxSessions,
+			SessionMaxTimeSec: httpservice.SessionMaxTimeSec,
+		},
+		LogLevel:      LogInfo,
+		LogFormat:     LogText,
+		EngineRetries: analyzer.DefaultEngineRetries,
+	}
+}
+
+// Load - the configuration of the args (without the program name) and the environment.
+// getenv is os.Getenv except in the tests.  The configuration is validated.
+// flag.ErrHelp is returned for -h, the usage has been written to out.
+func Load(args []string, getenv func(string) string, out io.Writer) (*Config, error) {
+	c := Default()
+
+	file := configFile(args, getenv)
+	if len(file) > 0 {
+		if err := c.ReadFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.String("config", file, "the config file, yaml or json")
+	c.flags(fs)
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := EnvName(f.Name)
+		val := getenv(name)
+		if err != nil || f.Name == "config" || len(val) == 0 {
+			return
+		}
+		if e := f.Value.Set(val); e != nil {
+			err = fmt.Errorf("%s: %v", name, e)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument: %s", fs.Arg(0))
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// EnvName - the environment variable of the flag
+func EnvName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// configFile - the -config of the args, else of the environment
+func configFile(args []string, getenv func(string) string) string {
+	for idx, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if name == "config" && idx+1 < len(args) {
+			return args[idx+1]
+		}
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+	}
+	return getenv(EnvConfig)
+}
+
+// ReadFile - sets the configuration of the file, the settings left out are kept.
+// Unknown settings are an error, they are likely misspelt.
+func (c *Config) ReadFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("config file: %v", err)
+	}
+
+	// The engines of the file replace the list, json would merge them into the entries
+	engines := c.Engines.List
+	c.Engines.List = nil
+	defer func() {
+		if c.Engines.List == nil {
+			c.Engines.List = engines
+		}
+	}()
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(c)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	default:
+		return fmt.Errorf("config file %s: not .yaml, .yml or .json", file)
+	}
+
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("config file %s: %v", file, err)
+	}
+	return nil
+}
+
+// flags - of the settings, the defaults are the current values
+func (c *Config) flags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Listen, "listen", c.Listen, "the address of the server, host:port")
+	fs.StringVar(&c.PublicDir, "public-dir", c.PublicDir, "the directory of the static pages")
+	fs.StringVar(&c.TLS.CertFile, "tls-cert", c.TLS.CertFile, "the certificate file, https when set with -tls-key")
+	fs.StringVar(&c.TLS.KeyFile, "tls-key", c.TLS.KeyFile, "the private key file of the certificate")
+	fs.IntVar(&c.Workers.Fen, "fen-workers", c.Workers.Fen, "the positions analyzed at once")
+	fs.IntVar(&c.Workers.Pgn, "pgn-workers", c.Workers.Pgn, "the games analyzed at once")
+	fs.StringVar(&c.Engines.Dir, "engines-dir", c.Engines.Dir, "the directory of the engine binaries")
+	fs.StringVar(&c.Engines.Default, "engine", c.Engines.Default, "the engine of the analysis")
+	fs.Var((*engineList)(&c.Engines.List), "engines", "the engines, name=binary:protocol,...")
+	fs.IntVar(&c.Queue.Depth, "queue-depth", c.Queue.Depth, "the requests waiting for the workers")
+	fs.IntVar(&c.Queue.PerClient, "queue-per-client", c.Queue.PerClient, "the requests of a client waiting, 0 = no limit")
+	fs.IntVar(&c.Limits.DefaultDepth, "default-depth", c.Limits.DefaultDepth, "the depth when the request has none")
+	fs.IntVar(&c.Limits.MaxDepth, "max-depth", c.Limits.MaxDepth, "the most depth of a request")
+	fs.IntVar(&c.Limits.DefaultTimeSec, "default-tsec", c.Limits.DefaultTimeSec, "the search seconds when the request has none")
+	fs.IntVar(&c.Limits.MaxTimeSec, "max-tsec", c.Limits.MaxTimeSec, "the most search seconds of a request")
+	fs.IntVar(&c.Limits.DefaultLines, "default-lines", c.Limits.DefaultLines, "the lines when the request has none")
+	fs.IntVar(&c.Limits.MaxLines, "max-lines", c.Limits.MaxLines, "the most lines of a request")
+	fs.IntVar(&c.Limits.MaxBatch, "max-batch", c.Limits.MaxBatch, "the most positions of a batch")
+	fs.IntVar(&c.Limits.BatchParallel, "batch-parallel", c.Limits.BatchParallel, "the positions of a batch analyzed at once")
+	fs.Int64Var(&c.Limits.MaxBodyBytes, "max-body-bytes", c.Limits.MaxBodyBytes, "the most bytes of a request body")
+	fs.IntVar(&c.Limits.MaxSessions, "max-sessions", c.Limits.MaxSessions, "the analysis sessions open at once")
+	fs.IntVar(&c.Limits.SessionMaxTimeSec, "session-tsec", c.Limits.SessionMaxTimeSec, "the search seconds of a session position")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "debug, info, warn or error, debug shows the engine communication")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "text or json lines")
+	fs.StringVar(&c.TranscriptDir, "transcript-dir", c.TranscriptDir, "records every engine session to a file in the directory when set")
+	fs.IntVar(&c.EngineRetries, "engine-retries", c.EngineRetries, "times a position is retried on a new engine when its engine dies")
+}
+
+// Validate - the error lists all the problems of the configuration
+func (c *Config) Validate() error {
+	problems := make([]string, 0)
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if host, port, err := net.SplitHostPort(c.Listen); err != nil {
+		add("listen %q: not host:port", c.Listen)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		add("listen %q: bad port %q", c.Listen, port)
+	} else if len(host) > 0 && strings.ContainsAny(host, " /") {
+		add("listen %q: bad host %q", c.Listen, host)
+	}
+
+	if !isDir(c.PublicDir) {
+		add("publicDir %q: not a directory", c.PublicDir)
+	}
+
+	if len(c.TLS.CertFile) > 0 != (len(c.TLS.KeyFile) > 0) {
+		add("tls: certFile and keyFile must be set together")
+	}
+	for _, file := range []string{c.TLS.CertFile, c.TLS.KeyFile} {
+		if len(file) > 0 && !isFile(file) {
+			add("tls %q: no such file", file)
+		}
+	}
+
+	if c.Workers.Fen < 1 {
+		add("workers.fen %d: must be at least 1", c.Workers.Fen)
+	}
+	if c.Workers.Pgn < 1 {
+		add("workers.pgn %d: must be at least 1", c.Workers.Pgn)
+	}
+
+	c.validateEngines(add)
+	if len(c.TranscriptDir) > 0 && !isDir(c.TranscriptDir) {
+		add("transcriptDir %q: not a directory", c.TranscriptDir)
+	}
+	if c.EngineRetries < 0 {
+		add("engineRetries %d: must not be negative", c.EngineRetries)
+	}
+
+	if c.Queue.Depth < 1 {
+		add("queue.depth %d: must be at least 1", c.Queue.Depth)
+	}
+	if c.Queue.PerClient < 0 {
+		add("queue.perClient %d: must not be negative", c.Queue.PerClient)
+	}
+
+	l := c.Limits
+	bounds := []struct {
+		name         string
+		value, bound int
+	}{
+		{"defaultDepth", l.DefaultDepth, l.MaxDepth},
+		{"defaultTimeSec", l.DefaultTimeSec, l.MaxTimeSec},
+		{"defaultLines", l.DefaultLines, l.MaxLines},
+		{"batchParallel", l.BatchParallel, l.MaxBatch},
+	}
+	for _, b := range bounds {
+		if b.value < 1 {
+			add("limits.%s %d: must be at least 1", b.name, b.value)
+		} else if b.value > b.bound {
+			add("limits.%s %d: over its max %d", b.name, b.value, b.bound)
+		}
+	}
+	if l.MaxBodyBytes < 1 {
+		add("limits.maxBodyBytes %d: must be at least 1", l.MaxBodyBytes)
+	}
+	if l.MaxSessions < 1 {
+		add("limits.maxSessions %d: must be at least 1", l.MaxSessions)
+	}
+	if l.SessionMaxTimeSec < 1 {
+		add("limits.sessionMaxTimeSec %d: must be at least 1", l.SessionMaxTimeSec)
+	}
+
+	switch c.LogLevel {
+	case LogDebug, LogInfo, LogWarn, LogError:
+	default:
+		add("logLevel %q: not debug, info, warn or error", c.LogLevel)
+	}
+	if c.LogFormat != LogText && c.LogFormat != LogJson {
+		add("logFormat %q: not text or json", c.LogFormat)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+}
+
+func (c *Config) validateEngines(add func(format string, a ...interface{})) {
+	if !isDir(c.Engines.Dir) {
+		add("engines.dir %q: not a directory", c.Engines.Dir)
+	}
+
+	names := make(map[string]bool)
+	for idx, e := range c.Engines.List {
+		if len(e.Name) == 0 {
+			add("engines.list[%d]: no name", idx)
+			continue
+		}
+		if names[e.Name] {
+			add("engine %s: listed twice", e.Name)
+		}
+		names[e.Name] = true
+
+		switch e.Protocol {
+		case "", ProtocolUci, ProtocolCecp:
+		default:
+			add("engine %s: protocol %q is not uci or cecp", e.Name, e.Protocol)
+		}
+		if len(e.Remote) > 0 {
+			if _, _, err := net.SplitHostPort(e.Remote); err != nil {
+				add("engine %s: remote %q is not host:port", e.Name, e.Remote)
+			}
+			if strings.ContainsAny(e.Token, " \t\n") {
+				add("engine %s: token has spaces", e.Name)
+			}
+			continue
+		}
+		if len(e.Token) > 0 {
+			add("engine %s: token without a remote", e.Name)
+		}
+		if binary := c.binaryPath(e); !isFile(binary) {
+			add("engine %s: no binary %s", e.Name, binary)
+		}
+	}
+
+	if len(c.Engines.Default) == 0 {
+		add("engines.default: not set")
+	} else if len(c.Engines.List) > 0 && !names[c.Engines.Default] {
+		add("engines.default %s: not in the engines", c.Engines.Default)
+	} else if len(c.Engines.List) == 0 {
+		if binary := c.binaryPath(Engine{Name: c.Engines.Default}); !isFile(binary) {
+			add("engine %s: no binary %s", c.Engines.Default, binary)
+		}
+	}
+}
+
+// binaryPath - of the engine, as the uci.UciManager finds it
+func (c *Config) binaryPath(e Engine) string {
+	binary := e.Binary
+	if len(binary) == 0 {
+		binary = e.Name
+	}
+	if filepath.IsAbs(binary) {
+		return binary
+	}
+	return filepath.Join(c.Engines.Dir, binary)
+}
+
+// Apply - sets the configuration of the packages, the workers are left to the caller.
+func (c *Config) Apply() {
+	dir := c.Engines.Dir
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	common.Environment.EnginePath = dir
+
+	remotes := make(map[string]Engine)
+	for _, e := range c.Engines.List {
+		if len(e.Remote) > 0 {
+			remotes[e.Name] = e
+		} else {
+			uci.UciManager().SetBinary(e.Name, e.Binary)
+		}
+		if e.Protocol == ProtocolCecp {
+			uci.UciManager().SetProtocol(e.Name, uci.ProtocolCecp)
+		} else {
+			uci.UciManager().SetProtocol(e.Name, uci.ProtocolUci)
+		}
+	}
+	// The engines of the engine hosts, the others run the local binary
+	var transports uci.TransportFactory
+	if len(remotes) > 0 {
+		transports = func(engine string) (uci.Transport, error) {
+			if e, ok := remotes[engine]; ok {
+				return uci.NewRemoteTransport(e.Remote, e.Token, e.Name), nil
+			}
+			return nil, nil
+		}
+	}
+	uci.UciManager().SetTransportFactory(transports)
+	uci.UciManager().SetTranscriptDir(c.TranscriptDir)
+	analyzer.AnalysisEngine = c.Engines.Default
+	analyzer.EngineRetries = c.EngineRetries
+
+	analyzer.SetQueueLimits(c.Queue.Depth, c.Queue.PerClient)
+
+	l := c.Limits
+	httpservice.Limits = httpservice.AnalysisLimits{
+		DefaultDepth:   l.DefaultDepth,
+		MaxDepth:       l.MaxDepth,
+		DefaultTimeSec: l.DefaultTimeSec,
+		MaxTimeSec:     l.MaxTimeSec,
+		DefaultLines:   l.DefaultLines,
+		MaxLines:       l.MaxLines,
+		MaxBatch:       l.MaxBatch,
+		BatchParallel:  l.BatchParallel,
+		MaxBodyBytes:   l.MaxBodyBytes,
+	}
+	httpservice.MaxSessions = l.MaxSessions
+	httpservice.SessionMaxTimeSec = l.SessionMaxTimeSec
+
+	var level slog.Level
+	_ = level.UnmarshalText([]byte(c.LogLevel))
+	common.LogLevel.Set(level)
+	common.SetLogger(common.NewLogger(os.Stderr, c.LogFormat == LogJson))
+}
+
+// IsTLS - if the server is https
+func (c *Config) IsTLS() bool {
+	return len(c.TLS.CertFile) > 0 && len(c.TLS.KeyFile) > 0
+}
+
+// engineList - the engines as a flag, name=binary:protocol,...
+type engineList []Engine
+
+func (l *engineList) String() string {
+	if l == nil {
+		return ""
+	}
+	items := make([]string, 0, len(*l))
+	for _, e := range *l {
+		item := e.Name
+		if len(e.Binary) > 0 {
+			item += "=" + e.Binary
+		}
+		if len(e.Protocol) > 0 {
+			item += ":" + e.Protocol
+		}
+		items = append(items, item)
+	}
+	return strings.Join(items, ",")
+}
+
+// Set - replaces the engines
+func (l *engineList) Set(val string) error {
+	res := make([]Engine, 0)
+	for _, item := range strings.Split(val, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		e := Engine{}
+		e.Name, e.Binary, _ = strings.Cut(item, "=")
+		if idx := strings.LastIndex(e.Binary, ":"); idx >= 0 {
+			e.Binary, e.Protocol = e.Binary[:idx], e.Binary[idx+1:]
+		} else if e.Binary == "" {
+			if idx := strings.LastIndex(e.Name, ":"); idx >= 0 {
+				e.Name, e.Protocol = e.Name[:idx], e.Name[idx+1:]
+			}
+		}
+		if len(e.Name) == 0 {
+			return fmt.Errorf("engine %q: no name", item)
+		}
+		res = append(res, e)
+	}
+	*l = res
+	return nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
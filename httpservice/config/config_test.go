@@ -0,0 +1,283 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/samlotti/chess_anaylzer/analyzer"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"github.com/samlotti/chess_anaylzer/httpservice"
+	"github.com/samlotti/chess_anaylzer/uci"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testTree - a public and engines dir with the engines zahak and crafty
+func testTree(t *testing.T) string {
+	dir := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "public"), 0755))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "engines", "mac"), 0755))
+	for _, name := range []string{"mac/zahak-darwin-amd64-8.0-avx", "crafty"} {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, "engines", name), []byte{}, 0755))
+	}
+	return dir
+}
+
+func testArgs(dir string, args ...string) []string {
+	return append([]string{
+		"-public-dir", filepath.Join(dir, "public"),
+		"-engines-dir", filepath.Join(dir, "engines"),
+	}, args...)
+}
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	dir := testTree(t)
+	c, err := Load(testArgs(dir), env(nil), &bytes.Buffer{})
+	assert.Nil(t, err)
+	assert.Equal(t, ":8181", c.Listen)
+	assert.Equal(t, Workers{Fen: 5, Pgn: 5}, c.Workers)
+	assert.Equal(t, "zahak", c.Engines.Default)
+	assert.Equal(t, analyzer.DefaultQueueDepth, c.Queue.Depth)
+	assert.Equal(t, httpservice.Limits.MaxDepth, c.Limits.MaxDepth)
+	assert.Equal(t, LogInfo, c.LogLevel)
+	assert.False(t, c.IsTLS())
+}
+
+func TestLoadOrder(t *testing.T) {
+	dir := testTree(t)
+	file := filepath.Join(dir, "chess.yaml")
+	assert.Nil(t, os.WriteFile(file, []byte(`
+listen: ":9000"
+workers:
+  fen: 2
+  pgn: 3
+queue:
+  depth: 50
+limits:
+  maxDepth: 30
+logLevel: warn
+`), 0644))
+
+	vars := map[string]string{
+		EnvConfig:           file,
+		"CHESS_PGN_WORKERS": "4",
+		"CHESS_MAX_DEPTH":   "25",
+	}
+	c, err := Load(testArgs(dir, "-max-depth", "20"), env(vars), &bytes.Buffer{})
+	assert.Nil(t, err)
+	assert.Equal(t, ":9000", c.Listen)                  // file
+	assert.Equal(t, Workers{Fen: 2, Pgn: 4}, c.Workers) // env over file
+	assert.Equal(t, 20, c.Limits.MaxDepth)              // flag over env
+	assert.Equal(t, 50, c.Queue.Depth)
+	assert.Equal(t, analyzer.DefaultQueuePerClient, c.Queue.PerClient) // default kept
+	assert.Equal(t, LogWarn, c.LogLevel)
+}
+
+func TestLoadJson(t *testing.T) {
+	dir := testTree(t)
+	file := filepath.Join(dir, "chess.json")
+	assert.Nil(t, os.WriteFile(file, []byte(`{
+		"engines": {
+			"default": "crafty",
+			"list": [{"name": "crafty", "protocol": "cecp"}]
+		}
+	}`), 0644))
+
+	c, err := Load(testArgs(dir, "-config", file), env(nil), &bytes.Buffer{})
+	assert.Nil(t, err)
+	assert.Equal(t, "crafty", c.Engines.Default)
+	assert.Equal(t, []Engine{{Name: "crafty", Protocol: ProtocolCecp}}, c.Engines.List)
+}
+
+func TestLoadUnknownSetting(t *testing.T) {
+	dir := testTree(t)
+	file := filepath.Join(dir, "chess.yml")
+	assert.Nil(t, os.WriteFile(file, []byte("workers:\n  fens: 2\n"), 0644))
+	_, err := Load(testArgs(dir, "--config="+file), env(nil), &bytes.Buffer{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "fens")
+
+	file = filepath.Join(dir, "chess.json")
+	assert.Nil(t, os.WriteFile(file, []byte(`{"listen": ":80", "port": 80}`), 0644))
+	_, err = Load(testArgs(dir, "-config", file), env(nil), &bytes.Buffer{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "port")
+
+	_, err = Load(testArgs(dir, "-config", filepath.Join(dir, "chess.toml")), env(nil), &bytes.Buffer{})
+	assert.NotNil(t, err)
+}
+
+func TestLoadBadValues(t *testing.T) {
+	dir := testTree(t)
+	_, err := Load(testArgs(dir), env(map[string]string{"CHESS_FEN_WORKERS": "many"}), &bytes.Buffer{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "CHESS_FEN_WORKERS")
+
+	_, err = Load(testArgs(dir, "-fen-workers", "many"), env(nil), &bytes.Buffer{})
+	assert.NotNil(t, err)
+
+	_, err = Load(testArgs(dir, "extra"), env(nil), &bytes.Buffer{})
+	assert.NotNil(t, err)
+
+	out := &bytes.Buffer{}
+	_, err = Load([]string{"-h"}, env(nil), out)
+	assert.True(t, errors.Is(err, flag.ErrHelp))
+	assert.Contains(t, out.String(), "-fen-workers")
+}
+
+func TestEngineList(t *testing.T) {
+	dir := testTree(t)
+	c, err := Load(testArgs(dir,
+		"-engines", "zahak=mac/zahak-darwin-amd64-8.0-avx, crafty:cecp",
+		"-engine", "crafty",
+	), env(nil), &bytes.Buffer{})
+	assert.Nil(t, err)
+	assert.Equal(t, []Engine{
+		{Name: "zahak", Binary: "mac/zahak-darwin-amd64-8.0-avx"},
+		{Name: "crafty", Protocol: ProtocolCecp},
+	}, c.Engines.List)
+	assert.Equal(t, "zahak=mac/zahak-darwin-amd64-8.0-avx,crafty:cecp", (*engineList)(&c.Engines.List).String())
+}
+
+func TestValidate(t *testing.T) {
+	dir := testTree(t)
+	c, err := Load(testArgs(dir), env(nil), &bytes.Buffer{})
+	assert.Nil(t, err)
+
+	c.Listen = "8181"
+	c.TLS.CertFile = filepath.Join(dir, "cert.pem")
+	c.Workers.Fen = 0
+	c.Engines.List = append(c.Engines.List,
+		Engine{Name: "zahak"},
+		Engine{Name: "stockfish", Protocol: "xboard"},
+	)
+	c.Engines.Default = "komodo"
+	c.Queue.Depth = 0
+	c.Limits.DefaultDepth = 50
+	c.Limits.DefaultLines = 0
+	c.LogLevel = "trace"
+	c.LogFormat = "xml"
+	c.TranscriptDir = filepath.Join(dir, "transcripts")
+	c.EngineRetries = -1
+	c.Engines.List = append(c.Engines.List,
+		Engine{Name: "remote", Remote: "enginehost:7070", Token: "secret"},
+		Engine{Name: "badremote", Remote: "enginehost", Token: "two words"},
+		Engine{Name: "crafty", Token: "secret"},
+	)
+
+	err = c.Validate()
+	assert.NotNil(t, err)
+	for _, problem := range []string{
+		`listen "8181"`,
+		"certFile and keyFile",
+		"cert.pem",
+		"workers.fen 0",
+		"engine zahak: listed twice",
+		"engine zahak: no binary",
+		`engine stockfish: protocol "xboard"`,
+		"engine stockfish: no binary",
+		"engines.default komodo",
+		"queue.depth 0",
+		"limits.defaultDepth 50: over its max 40",
+		"limits.defaultLines 0",
+		`logLevel "trace"`,
+		`logFormat "xml"`,
+		"transcripts",
+		"engineRetries -1",
+		`engine badremote: remote "enginehost" is not host:port`,
+		"engine badremote: token has spaces",
+		"engine crafty: token without a remote",
+	} {
+		assert.Contains(t, err.Error(), problem)
+	}
+	assert.NotContains(t, err.Error(), "workers.pgn")
+	assert.NotContains(t, err.Error(), "engine remote:")
+}
+
+func TestApply(t *testing.T) {
+	path, engine := common.Environment.EnginePath, analyzer.AnalysisEngine
+	limits, sessions := httpservice.Limits, httpservice.MaxSessions
+	level, log := common.LogLevel.Level(), common.Log()
+	retries := analyzer.EngineRetries
+	defer func() {
+		analyzer.EngineRetries = retries
+		uci.UciManager().SetTransportFactory(nil)
+		uci.UciManager().SetTranscriptDir("")
+		common.Environment.EnginePath, analyzer.AnalysisEngine = path, engine
+		httpservice.Limits, httpservice.MaxSessions = limits, sessions
+		common.LogLevel.Set(level)
+		common.SetLogger(log)
+		analyzer.SetQueueLimits(analyzer.DefaultQueueDepth, analyzer.DefaultQueuePerClient)
+	}()
+
+	// The engine host of the remote engine refuses it
+	host, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer host.Close()
+	handshake := make(chan string, 1)
+	go func() {
+		conn, err := host.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		handshake <- strings.TrimSpace(line)
+		fmt.Fprintln(conn, "error no such engine")
+	}()
+
+	dir := testTree(t)
+	file := filepath.Join(dir, "chess.yaml")
+	assert.Nil(t, os.WriteFile(file, []byte(`
+engines:
+  list:
+    - name: zahak
+      binary: mac/zahak-darwin-amd64-8.0-avx
+    - name: crafty
+      protocol: cecp
+    - name: stockfish
+      remote: `+host.Addr().String()+`
+      token: secret
+`), 0644))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "transcripts"), 0755))
+	c, err := Load(testArgs(dir,
+		"-config", file,
+		"-max-lines", "5",
+		"-max-sessions", "2",
+		"-log-level", "debug",
+		"-transcript-dir", filepath.Join(dir, "transcripts"),
+		"-engine-retries", "4",
+	), env(nil), &bytes.Buffer{})
+	assert.Nil(t, err)
+	c.Apply()
+
+	assert.Equal(t, filepath.Join(dir, "engines")+"/", common.Environment.EnginePath)
+	assert.Equal(t, filepath.Join(dir, "engines", "mac/zahak-darwin-amd64-8.0-avx"), uci.UciManager().BinaryPath("zahak"))
+	assert.Equal(t, uci.ProtocolCecp, uci.UciManager().GetProtocol("crafty"))
+	assert.Equal(t, "zahak", analyzer.AnalysisEngine)
+	assert.Equal(t, 5, httpservice.Limits.MaxLines)
+	assert.Equal(t, 2, httpservice.MaxSessions)
+	assert.Equal(t, slog.LevelDebug, common.LogLevel.Level())
+	assert.Equal(t, filepath.Join(dir, "transcripts"), uci.UciManager().TranscriptDir())
+	assert.Equal(t, 4, analyzer.EngineRetries)
+
+	uci.UciManager().SetTranscriptDir("")
+	_, err = uci.UciManager().GetUci(context.Background(), "stockfish")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no such engine")
+	assert.Equal(t, uci.RemoteHandshake("secret", "stockfish"), <-handshake)
+}
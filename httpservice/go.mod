@@ -1,7 +1,18 @@
 module github.com/samlotti/chess_anaylzer/httpservice
 
-go 1.18
+go 1.21
 
 replace github.com/samlotti/chess_anaylzer/analyzer => ../analyzer
 
 require github.com/samlotti/chess_anaylzer/analyzer v0.0.0-00010101000000-000000000000
+
+require (
+	github.com/gorilla/websocket v1.5.3
+	github.com/stretchr/testify v1.8.4
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+)
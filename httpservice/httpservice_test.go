@@ -0,0 +1,1022 @@
+package httpservice
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/samlotti/chess_anaylzer/analyzer"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"github.com/samlotti/chess_anaylzer/uci"
+	"github.com/samlotti/chess_anaylzer/uci/fakeengine"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testFen = "2r3k1/p4p2/3Rp2p/1p2P1pK/8/1P4P1/P3Q2P/1q6 b - - 0 1"
+
+// hangFen - searched until stopped
+const hangFen = "8/8/8/8/8/4k3/8/4K2R w K - 0 1"
+
+// The handlers run against workers using the scripted engine.
+func TestMain(m *testing.M) {
+	script := fakeengine.DefaultScript()
+	script.Positions[testFen] = &fakeengine.Response{
+		Lines: []string{
+			"info depth 1 score cp 120 multipv 1 pv b1h1",
+			"info depth 1 score cp -30 multipv 2 pv c8c1",
+			"bestmove b1h1",
+		},
+	}
+	script.Positions[hangFen] = &fakeengine.Response{
+		Lines: []string{"info depth 1 score cp 500 multipv 1 pv h1h3", "bestmove h1h3"},
+		Hang:  true,
+	}
+	testScript = script
+	uci.UciManager().SetTransportFactory(func(engine string) (uci.Transport, error) {
+		return fakeengine.New(script).Transport(), nil
+	})
+	analyzer.CreateFenWorkers(1)
+	analyzer.CreatePgnWorkers(1)
+	os.Exit(m.Run())
+}
+
+var testScript *fakeengine.Script
+
+// decodeLines - the handlers write one json object per line.
+// The queue positions, written while waiting for a worker, are skipped.
+func decodeLines[T any](t *testing.T, body string) []*T {
+	r := make([]*T, 0)
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		if strings.Contains(scanner.Text(), `"rcode":"queued"`) {
+			continue
+		}
+		v := new(T)
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), v))
+		r = append(r, v)
+	}
+	return r
+}
+
+func TestAnalyzeFen(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/chess/ai/fen?fen="+url.QueryEscape(testFen), nil)
+	w := httptest.NewRecorder()
+	AnalyzeFen(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp := decodeLines[analyzer.FenResponse](t, w.Body.String())
+	assert.Equal(t, 4, len(resp))
+	assert.Equal(t, analyzer.RCode(analyzer.RCODE_INFO), resp[0].RCode)
+	assert.Equal(t, 120, resp[0].ARInfo.ScoreCP)
+	assert.Equal(t, "c8c1", resp[1].ARInfo.Moves[0])
+	assert.Equal(t, "b1h1", resp[2].ARBestMove.BestMove)
+	assert.True(t, resp[3].Done)
+}
+
+func TestAnalyzeFenInvalid(t *testing.T) {
+	for fen, msg := range map[string]string{
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBN w KQkq - 0 1":                 "invalid fen placement: rank 1 is 7 squares",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR x KQkq - 0 1":                "invalid fen side",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkx - 0 1":                "invalid fen castling",
+		"4k3/8/8/8/8/8/8/R3K2R b KQ - 0 1&strict=true":                            "",
+		"4k3/8/8/8/8/8/8/4K3 w KQ - 0 1&strict=true":                              "invalid fen castling: right K",
+		"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e3 0 1":             "",
+		"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1&strict=true": "",
+		"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e6 0 1&strict=true": "invalid fen enPassant",
+		"4k3/8/8/8/8/8/8/3KK3 w - - 0 1":                                          "invalid fen position: needs one king a side",
+		"4k3/8/8/8/8/8/8/P3K3 w - - 0 1":                                          "invalid fen position: pawn on a1",
+		"4k3/8/8/8/8/8/8/4K2R w - - 0 1":                                          "",
+		"4k3/8/8/8/8/8/8/4R2K w - - 0 1":                                          "invalid fen position: b is in check",
+	} {
+		parts := strings.SplitN(fen, "&", 2)
+		path := "/chess/ai/fen?fen=" + url.QueryEscape(parts[0])
+		if len(parts) > 1 {
+			path += "&" + parts[1]
+		}
+		w := httptest.NewRecorder()
+		fd, ok := fenDataFromQuery(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if len(msg) == 0 {
+			assert.True(t, ok, fen)
+			assert.NotNil(t, fd, fen)
+			continue
+		}
+		assert.False(t, ok, fen)
+		assert.Equal(t, http.StatusBadRequest, w.Code, fen)
+		assert.True(t, strings.HasPrefix(w.Body.String(), msg), w.Body.String())
+	}
+}
+
+func TestLegacyArgsLimits(t *testing.T) {
+	w := httptest.NewRecorder()
+	fd, ok := fenDataFromQuery(w, httptest.NewRequest(http.MethodGet, "/chess/ai/fen?fen="+url.QueryEscape(testFen), nil))
+	assert.True(t, ok)
+	assert.Equal(t, Limits.DefaultDepth, fd.Depth)
+	assert.Equal(t, Limits.DefaultTimeSec, fd.MaxTimeSec)
+	assert.Equal(t, Limits.DefaultLines, fd.NumLines)
+
+	form := url.Values{}
+	form.Set("pgn", "[Event \"Test\"]\n\n1. e4 1-0\n")
+	req := httptest.NewRequest(http.MethodPost, "/chess/ai/pgn", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	pd, ok := pgnDataFromForm(httptest.NewRecorder(), req)
+	assert.True(t, ok)
+	assert.Equal(t, Limits.DefaultDepth, pd.Depth)
+	assert.Equal(t, Limits.DefaultTimeSec, pd.MaxTimeSec)
+	assert.Equal(t, Limits.DefaultLines, pd.NumLines)
+
+	for _, arg := range [][2]string{{"depth", "1000"}, {"tsec", "100000"}, {"lines", "500"}, {"lines", "-1"}, {"depth", "deep"}} {
+		w := httptest.NewRecorder()
+		_, ok := fenDataFromQuery(w, httptest.NewRequest(http.MethodGet, "/chess/ai/fen?fen="+url.QueryEscape(testFen)+"&"+arg[0]+"="+arg[1], nil))
+		assert.False(t, ok, arg)
+		assert.Equal(t, http.StatusBadRequest, w.Code, arg)
+
+		form.Set(arg[0], arg[1])
+		req := httptest.NewRequest(http.MethodPost, JobsPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w = httptest.NewRecorder()
+		_, ok = pgnDataFromForm(w, req)
+		assert.False(t, ok, arg)
+		assert.Equal(t, http.StatusBadRequest, w.Code, arg)
+		form.Del(arg[0])
+	}
+}
+
+func TestAnalyzeFenMissingFen(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/chess/ai/fen", nil)
+	w := httptest.NewRecorder()
+	AnalyzeFen(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAnalyzePgn(t *testing.T) {
+	form := url.Values{}
+	form.Set("pgn", "[Event \"Test\"]\n\n1. e4 e5 2. Nf3 1-0\n")
+	form.Set("lines", "1")
+	req := httptest.NewRequest(http.MethodPost, "/chess/ai/pgn", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	AnalyzePgn(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp := decodeLines[analyzer.PgnResponse](t, w.Body.String())
+	last := resp[len(resp)-1]
+	assert.True(t, last.Done)
+	assert.Equal(t, analyzer.RCode(analyzer.RCODE_DONE), last.RCode)
+
+	// every move is analyzed, e2e4 is also the scripted best move
+	moves := map[int]bool{}
+	userMoves := map[int]bool{}
+	for _, r := range resp {
+		if r.ARInfo != nil {
+			moves[r.MoveNum] = true
+			if r.ARInfo.IsUserMove {
+				userMoves[r.MoveNum] = true
+			}
+		}
+	}
+	assert.Equal(t, map[int]bool{1: true, 2: true, 3: true}, moves)
+	assert.True(t, userMoves[1])
+}
+
+func TestAnalyzePgnInvalid(t *testing.T) {
+	form := url.Values{}
+	form.Set("pgn", "[Event \"Test\"]\n\n1. e4 e4 1-0\n")
+	req := httptest.NewRequest(http.MethodPost, "/chess/ai/pgn", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	AnalyzePgn(w, req)
+
+	resp := decodeLines[analyzer.PgnResponse](t, w.Body.String())
+	assert.Equal(t, 1, len(resp))
+	assert.Equal(t, analyzer.RCode(analyzer.RCODE_ERROR), resp[0].RCode)
+}
+
+// jobRequest - runs the request on the job api.
+func jobRequest(method string, path string, form url.Values) *httptest.ResponseRecorder {
+	var body *strings.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	} else {
+		body = strings.NewReader("")
+	}
+	req := httptest.NewRequest(method, path, body)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	PgnJobs(w, req)
+	return w
+}
+
+func TestPgnJob(t *testing.T) {
+	form := url.Values{}
+	form.Set("pgn", "[Event \"Test\"]\n\n1. e4 e5 2. Nf3 1-0\n")
+	form.Set("lines", "1")
+	w := jobRequest(http.MethodPost, JobsPath, form)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	created := &analyzer.JobView{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), created))
+	assert.Equal(t, 3, created.Plies)
+	assert.Equal(t, JobsPath+"/"+created.ID, w.Header().Get("Location"))
+
+	view := &analyzer.JobView{}
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		w = jobRequest(http.MethodGet, JobsPath+"/"+created.ID, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), view))
+		if view.Status == analyzer.JobDone {
+			break
+		}
+	}
+	assert.Equal(t, analyzer.JobDone, view.Status)
+	assert.Equal(t, 3, view.Ply)
+	assert.True(t, view.Results[len(view.Results)-1].Done)
+
+	w = jobRequest(http.MethodGet, fmt.Sprintf("%s/%s?since=%d", JobsPath, created.ID, view.Next), nil)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), view))
+	assert.Equal(t, 0, len(view.Results))
+
+	// Cancelling a finished job leaves it done
+	w = jobRequest(http.MethodDelete, JobsPath+"/"+created.ID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), view))
+	assert.Equal(t, analyzer.JobDone, view.Status)
+}
+
+func TestPgnJobErrors(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, jobRequest(http.MethodGet, JobsPath+"/nope", nil).Code)
+	assert.Equal(t, http.StatusNotFound, jobRequest(http.MethodDelete, JobsPath+"/nope", nil).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, jobRequest(http.MethodGet, JobsPath, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, jobRequest(http.MethodPost, JobsPath, url.Values{}).Code)
+
+	form := url.Values{}
+	form.Set("pgn", "[Event \"Test\"]\n\n1. e4 e4 1-0\n")
+	w := jobRequest(http.MethodPost, JobsPath, form)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestQueueFull(t *testing.T) {
+	analyzer.SetQueueLimits(0, 0)
+	defer analyzer.SetQueueLimits(analyzer.DefaultQueueDepth, analyzer.DefaultQueuePerClient)
+
+	req := httptest.NewRequest(http.MethodGet, "/chess/ai/fen?fen="+url.QueryEscape(testFen), nil)
+	w := httptest.NewRecorder()
+	AnalyzeFen(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	full := int(analyzer.FenRetryAfter(analyzer.ErrQueueFull).Seconds())
+	assert.Equal(t, strconv.Itoa(full), w.Header().Get("Retry-After"))
+
+	form := url.Values{}
+	form.Set("pgn", "[Event \"Test\"]\n\n1. e4 1-0\n")
+	w = jobRequest(http.MethodPost, JobsPath, form)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	w = httptest.NewRecorder()
+	writeQueueError(w, analyzer.ErrClientLimit, 12*time.Second)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "12", w.Header().Get("Retry-After"))
+}
+
+func TestStreamQueuePosition(t *testing.T) {
+	QueuePollInterval = 5 * time.Millisecond
+	defer func() {
+		QueuePollInterval = time.Second
+	}()
+
+	positions := make(chan int, 10)
+	for _, pos := range []int{3, 3, 2, 1, 0} {
+		positions <- pos
+	}
+	rchan := make(chan *analyzer.FenResponse)
+	go func() {
+		for len(positions) > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		rchan <- &analyzer.FenResponse{RCode: analyzer.RCODE_DONE, Done: true}
+	}()
+
+	w := httptest.NewRecorder()
+	streamResponses(context.Background(), w, rchan,
+		func(resp *analyzer.FenResponse) bool { return resp.Done },
+		func() int {
+			select {
+			case pos := <-positions:
+				return pos
+			default:
+				return 0
+			}
+		},
+		func(pos int) *analyzer.FenResponse {
+			return &analyzer.FenResponse{RCode: analyzer.RCODE_QUEUED, QueuePosition: pos}
+		},
+		func() {})
+
+	var got []int
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		resp := &analyzer.FenResponse{}
+		assert.Nil(t, json.Unmarshal([]byte(line), resp))
+		got = append(got, resp.QueuePosition)
+	}
+	assert.Equal(t, []int{3, 2, 1, 0}, got)
+}
+
+// failingWriter - a client gone, every write fails
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestStreamWriteFails(t *testing.T) {
+	rchan := make(chan *analyzer.FenResponse)
+	go func() {
+		rchan <- &analyzer.FenResponse{RCode: analyzer.RCODE_INFO}
+	}()
+
+	removed := make(chan bool, 1)
+	done := make(chan bool)
+	go func() {
+		streamResponses(context.Background(), failingWriter{httptest.NewRecorder()}, rchan,
+			func(resp *analyzer.FenResponse) bool { return resp.Done },
+			func() int { return 0 },
+			func(pos int) *analyzer.FenResponse { return nil },
+			func() { removed <- true })
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream did not stop")
+	}
+	assert.True(t, <-removed)
+}
+
+func TestAnalyzeFenClientGone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/chess/ai/fen?fen="+url.QueryEscape(testFen), nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		AnalyzeFen(w, req)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "the handler did not return")
+	}
+
+	// The workers are free for the next request
+	TestAnalyzeFen(t)
+}
+
+// sseEvent - an event of the stream
+type sseEvent struct {
+	id, name, data string
+}
+
+// readEvents - the events of the server-sent stream.
+func readEvents(body string) []*sseEvent {
+	r := make([]*sseEvent, 0)
+	ev := &sseEvent{}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case len(line) == 0:
+			if len(ev.name) > 0 {
+				r = append(r, ev)
+			}
+			ev = &sseEvent{}
+		case strings.HasPrefix(line, "id: "):
+			ev.id = line[4:]
+		case strings.HasPrefix(line, "event: "):
+			ev.name = line[7:]
+		case strings.HasPrefix(line, "data: "):
+			ev.data = line[6:]
+		}
+	}
+	return r
+}
+
+// eventNames - the names, the queue and heartbeat events left out.
+func eventNames(events []*sseEvent) []string {
+	r := make([]string, 0)
+	for _, ev := range events {
+		if ev.name != "queued" && ev.name != "heartbeat" {
+			r = append(r, ev.name)
+		}
+	}
+	return r
+}
+
+func TestAnalyzeFenEvents(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, FenEventsPath+"?fen="+url.QueryEscape(testFen)+"&lines=2", nil)
+	w := httptest.NewRecorder()
+	AnalyzeFenEvents(w, req)
+
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	events := readEvents(w.Body.String())
+	assert.Equal(t, []string{"info", "info", "bestmove", "summary", "done"}, eventNames(events))
+
+	summary := &analyzer.MoveSummary{}
+	assert.Nil(t, json.Unmarshal([]byte(events[len(events)-2].data), summary))
+	assert.Equal(t, "b1h1", summary.BestMove)
+	assert.Equal(t, 120, summary.ScoreCP)
+}
+
+func TestPgnEventsResume(t *testing.T) {
+	form := url.Values{}
+	form.Set("pgn", "[Event \"Test\"]\n\n1. e4 e5 1-0\n")
+	form.Set("lines", "1")
+	req := httptest.NewRequest(http.MethodPost, PgnEventsPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	AnalyzePgnEvents(w, req)
+
+	events := readEvents(w.Body.String())
+	names := eventNames(events)
+	assert.Equal(t, "job", names[0])
+	assert.Equal(t, []string{"bestmove", "move-complete", "summary", "done"}, names[len(names)-4:])
+
+	job := map[string]string{}
+	assert.Nil(t, json.Unmarshal([]byte(events[0].data), &job))
+	summary := &analyzer.GameSummary{}
+	assert.Nil(t, json.Unmarshal([]byte(events[len(events)-2].data), summary))
+	assert.Equal(t, 2, len(summary.Moves))
+
+	// Resume after the first result, the rest is sent again with the same ids
+	req = httptest.NewRequest(http.MethodGet, job["events"], nil)
+	req.Header.Set("Last-Event-ID", "0")
+	w = httptest.NewRecorder()
+	PgnJobs(w, req)
+	resumed := readEvents(w.Body.String())
+	assert.Equal(t, "1", resumed[0].id)
+	assert.Equal(t, names[2:], eventNames(resumed))
+	assert.Equal(t, events[len(events)-2].data, resumed[len(resumed)-2].data)
+
+	req = httptest.NewRequest(http.MethodGet, job["events"], nil)
+	req.Header.Set("Last-Event-ID", "x")
+	w = httptest.NewRecorder()
+	PgnJobs(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestJobEventsHeartbeat(t *testing.T) {
+	defer func(d time.Duration) { SSEHeartbeat = d }(SSEHeartbeat)
+	SSEHeartbeat = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	w := httptest.NewRecorder()
+	sse, ok := newSSEWriter(w)
+	assert.True(t, ok)
+	job, err := analyzer.JobManager().StartPgnJob(&analyzer.PgnData{Pgn: "[Event \"Test\"]\n\n1. e4 1-0\n", Depth: 1, NumLines: 1})
+	assert.Nil(t, err)
+	// Everything already sent, only heartbeats until the client goes
+	for job.View(0).Status != analyzer.JobDone {
+		time.Sleep(10 * time.Millisecond)
+	}
+	streamJob(ctx, sse, job, job.View(0).Next)
+	events := readEvents(w.Body.String())
+	assert.True(t, len(events) > 1)
+	assert.Equal(t, 0, len(eventNames(events)))
+}
+
+func TestJobEventsNoHeartbeatWhileStreaming(t *testing.T) {
+	defer func(d time.Duration) { SSEHeartbeat = d }(SSEHeartbeat)
+	SSEHeartbeat = 150 * time.Millisecond
+
+	// A result every few ms, for longer than the heartbeat
+	script := fakeengine.DefaultScript()
+	script.Default.DelayMs = 5
+	uci.UciManager().SetTransportFactory(func(engine string) (uci.Transport, error) {
+		return fakeengine.New(script).Transport(), nil
+	})
+	defer uci.UciManager().SetTransportFactory(func(engine string) (uci.Transport, error) {
+		return fakeengine.New(testScript).Transport(), nil
+	})
+
+	pgn := "[Event \"Test\"]\n\n1. e4 e5 2. Nf3 Nc6 3. Bb5 a6 4. Ba4 Nf6 5. O-O Be7 6. Re1 b5 7. Bb3 d6 8. c3 O-O 9. h3 Nb8 10. d4 Nbd7 1-0\n"
+	job, err := analyzer.JobManager().StartPgnJob(&analyzer.PgnData{Pgn: pgn, Depth: 1, NumLines: 1})
+	assert.Nil(t, err)
+	w := httptest.NewRecorder()
+	sse, ok := newSSEWriter(w)
+	assert.True(t, ok)
+	start := time.Now()
+	streamJob(context.Background(), sse, job, 0)
+
+	assert.True(t, time.Since(start) > SSEHeartbeat, "the job ended before a heartbeat was due")
+	for _, ev := range readEvents(w.Body.String()) {
+		assert.NotEqual(t, "heartbeat", ev.name)
+	}
+}
+
+// readSession - the next response of the position, the late ones of earlier positions skipped.
+func readSession(t *testing.T, conn *websocket.Conn, id int) *SessionResponse {
+	for {
+		resp := &SessionResponse{}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if !assert.Nil(t, conn.ReadJSON(resp)) {
+			return resp
+		}
+		if resp.Id == id {
+			return resp
+		}
+	}
+}
+
+func TestAnalysisSession(t *testing.T) {
+	// The engine of the session, to see it ended
+	var transport *fakeengine.Transport
+	uci.UciManager().SetTransportFactory(func(engine string) (uci.Transport, error) {
+		transport = fakeengine.New(testScript).Transport()
+		return transport, nil
+	})
+	defer uci.UciManager().SetTransportFactory(func(engine string) (uci.Transport, error) {
+		return fakeengine.New(testScript).Transport(), nil
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(AnalysisSession))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+SessionPath, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "ready", readSession(t, conn, 0).Type)
+
+	// A new position stops the running search
+	assert.Nil(t, conn.WriteJSON(&SessionRequest{Type: "position", Fen: hangFen}))
+	resp := readSession(t, conn, 1)
+	assert.Equal(t, "info", resp.Type)
+	assert.Equal(t, []string{"h1h3"}, resp.Info.Moves)
+
+	assert.Nil(t, conn.WriteJSON(&SessionRequest{Type: "position", Fen: testFen, Lines: 2}))
+	resp = readSession(t, conn, 2)
+	assert.Equal(t, "info", resp.Type)
+	assert.Equal(t, []string{"b1h1"}, resp.Info.Moves)
+	readSession(t, conn, 2)
+	resp = readSession(t, conn, 2)
+	assert.Equal(t, "bestmove", resp.Type)
+	assert.Equal(t, "b1h1", resp.BestMove.BestMove)
+
+	// The multipv restarts the search
+	assert.Nil(t, conn.WriteJSON(&SessionRequest{Type: "position", Fen: hangFen}))
+	assert.Equal(t, "info", readSession(t, conn, 3).Type)
+	assert.Nil(t, conn.WriteJSON(&SessionRequest{Type: "multipv", Lines: 3}))
+	assert.Equal(t, "info", readSession(t, conn, 4).Type)
+
+	assert.Nil(t, conn.WriteJSON(&SessionRequest{Type: "stop"}))
+	resp = readSession(t, conn, 4)
+	assert.Equal(t, "stopped", resp.Type)
+
+	assert.Nil(t, conn.WriteJSON(&SessionRequest{Type: "nope"}))
+	assert.Equal(t, "error", readSession(t, conn, 4).Type)
+	assert.Nil(t, conn.WriteJSON(&SessionRequest{Type: "position"}))
+	assert.Equal(t, "error", readSession(t, conn, 4).Type)
+
+	// The engine is ended when the client goes away
+	assert.Nil(t, conn.WriteJSON(&SessionRequest{Type: "position", Fen: hangFen}))
+	assert.Equal(t, "info", readSession(t, conn, 5).Type)
+	conn.Close()
+
+	ended := make(chan struct{})
+	go func() {
+		transport.Wait()
+		close(ended)
+	}()
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "the engine was not ended")
+	}
+}
+
+func TestAnalysisSessionEngineNotStopping(t *testing.T) {
+	const stuckFen = "4k3/8/8/8/8/8/8/4K2R w K - 0 1"
+	script := fakeengine.DefaultScript()
+	for fen, resp := range testScript.Positions {
+		script.Positions[fen] = resp
+	}
+	script.Positions[stuckFen] = &fakeengine.Response{
+		Lines:      []string{"info depth 1 score cp 500 multipv 1 pv h1h8"},
+		Hang:       true,
+		NoBestMove: true,
+	}
+	var engines atomic.Int32
+	uci.UciManager().SetTransportFactory(func(engine string) (uci.Transport, error) {
+		engines.Add(1)
+		return fakeengine.New(script).Transport(), nil
+	})
+	defer uci.UciManager().SetTransportFactory(func(engine string) (uci.Transport, error) {
+		return fakeengine.New(testScript).Transport(), nil
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(AnalysisSession))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+SessionPath, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	assert.Equal(t, "ready", readSession(t, conn, 0).Type)
+
+	assert.Nil(t, conn.WriteJSON(&SessionRequest{Type: "position", Fen: stuckFen}))
+	assert.Equal(t, "info", readSession(t, conn, 1).Type)
+
+	// The engine does not answer the stop, the next position gets a new one
+	assert.Nil(t, conn.WriteJSON(&SessionRequest{Type: "position", Fen: testFen}))
+	resp := readSession(t, conn, 2)
+	if assert.Equal(t, "info", resp.Type, resp.Error) {
+		assert.Equal(t, []string{"b1h1"}, resp.Info.Moves)
+	}
+	assert.Equal(t, int32(2), engines.Load())
+}
+
+func TestAnalysisSessionLimit(t *testing.T) {
+	defer func(n int) { MaxSessions = n }(MaxSessions)
+	MaxSessions = 0
+
+	req := httptest.NewRequest(http.MethodGet, SessionPath, nil)
+	w := httptest.NewRecorder()
+	AnalysisSession(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+// apiRequest - runs the request on the api
+func apiRequest(method string, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, ApiV1Path+path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ApiV1(w, req)
+	return w
+}
+
+// apiError - the error of the response
+func apiError(t *testing.T, w *httptest.ResponseRecorder) *ApiError {
+	body := &ErrorBody{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), body))
+	if body.Error == nil {
+		return &ApiError{}
+	}
+	return body.Error
+}
+
+func TestApiFen(t *testing.T) {
+	w := apiRequest(http.MethodPost, "/fen", `{"fen":"`+testFen+`", "lines":2}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	result := &analyzer.FenResult{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), result))
+	assert.Equal(t, "b1h1", result.BestMove)
+	assert.Equal(t, 1, result.Depth)
+	assert.Equal(t, 2, len(result.Lines))
+	assert.Equal(t, 120, result.Lines[0].ScoreCP)
+	assert.Equal(t, []string{"c8c1"}, result.Lines[1].Moves)
+}
+
+func TestApiErrors(t *testing.T) {
+	for _, tc := range []struct {
+		method, path, body string
+		status             int
+		code, field        string
+	}{
+		{http.MethodPost, "/fen", `{"fen":`, http.StatusBadRequest, ErrCodeInvalidJson, ""},
+		{http.MethodPost, "/fen", ``, http.StatusBadRequest, ErrCodeInvalidJson, ""},
+		{http.MethodPost, "/fen", `{"fen":"x", "dept":3}`, http.StatusBadRequest, ErrCodeInvalidJson, ""},
+		{http.MethodPost, "/fen", `{"depth":3}`, http.StatusBadRequest, ErrCodeMissingField, "fen"},
+		{http.MethodPost, "/fen", `{"fen":"x", "depth":"3"}`, http.StatusBadRequest, ErrCodeInvalidField, "depth"},
+		{http.MethodPost, "/fen", `{"fen":"` + testFen + `", "depth":99}`, http.StatusBadRequest, ErrCodeInvalidField, "depth"},
+		{http.MethodPost, "/fen", `{"fen":"` + testFen + `", "lines":-1}`, http.StatusBadRequest, ErrCodeInvalidField, "lines"},
+		{http.MethodPost, "/fen", `{"fen":"x"}`, http.StatusBadRequest, ErrCodeInvalidFen, "fen"},
+		{http.MethodPost, "/fen", `{"fen":"4k3/8/8/8/8/8/8/4K3 w KQ - 0 1", "strict":true}`, http.StatusBadRequest, ErrCodeInvalidFen, "fen"},
+		{http.MethodPost, "/batch", `{"positions":[{"fen":"` + testFen + `"},{"fen":"8/8/8/8/8/8/8/8 w - -"}]}`, http.StatusBadRequest, ErrCodeInvalidFen, "positions[1].fen"},
+		{http.MethodGet, "/fen", ``, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, ""},
+		{http.MethodPost, "/batch", `{"positions":[]}`, http.StatusBadRequest, ErrCodeMissingField, "positions"},
+		{http.MethodPost, "/batch", `{"positions":[{"id":"a"}]}`, http.StatusBadRequest, ErrCodeMissingField, "positions[0].fen"},
+		{http.MethodPost, "/pgn", `{"pgn":"1. e4 e4"}`, http.StatusBadRequest, ErrCodeInvalidField, "pgn"},
+		{http.MethodGet, "/jobs/nope", ``, http.StatusNotFound, ErrCodeNotFound, ""},
+		{http.MethodGet, "/nope", ``, http.StatusNotFound, ErrCodeNotFound, ""},
+	} {
+		w := apiRequest(tc.method, tc.path, tc.body)
+		assert.Equal(t, tc.status, w.Code, tc.body)
+		err := apiError(t, w)
+		assert.Equal(t, tc.code, err.Code, tc.body)
+		assert.Equal(t, tc.field, err.Field, tc.body)
+	}
+
+	defer func(n int64) { Limits.MaxBodyBytes = n }(Limits.MaxBodyBytes)
+	Limits.MaxBodyBytes = 10
+	w := apiRequest(http.MethodPost, "/fen", `{"fen":"`+testFen+`"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, ErrCodeTooLarge, apiError(t, w).Code)
+}
+
+func TestApiBatch(t *testing.T) {
+	w := apiRequest(http.MethodPost, "/batch",
+		`{"positions":[{"id":"a","fen":"`+testFen+`"},{"fen":"`+testFen+`"},{"id":"c","fen":"`+testFen+`"}], "lines":1}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	results := decodeLines[BatchResult](t, w.Body.String())
+	assert.Equal(t, 3, len(results))
+	ids := make(map[int]string)
+	for _, res := range results {
+		ids[res.Index] = res.Id
+		assert.Nil(t, res.Error)
+		assert.Equal(t, "b1h1", res.Result.BestMove)
+	}
+	assert.Equal(t, map[int]string{0: "a", 1: "", 2: "c"}, ids)
+}
+
+func TestApiPgnJob(t *testing.T) {
+	w := apiRequest(http.MethodPost, "/pgn", `{"pgn":"[Event \"Test\"]\n\n1. e4 1-0\n", "lines":1}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	created := &analyzer.JobView{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), created))
+	assert.Equal(t, ApiV1Path+"/jobs/"+created.ID, w.Header().Get("Location"))
+
+	view := &analyzer.JobView{}
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		w = apiRequest(http.MethodGet, "/jobs/"+created.ID, "")
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), view))
+		if view.Status == analyzer.JobDone {
+			break
+		}
+	}
+	assert.Equal(t, analyzer.JobDone, view.Status)
+	assert.Equal(t, http.StatusOK, apiRequest(http.MethodDelete, "/jobs/"+created.ID, "").Code)
+}
+
+func TestOpenAPI(t *testing.T) {
+	w := apiRequest(http.MethodGet, "/openapi.json", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	doc := map[string]any{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	paths := doc["paths"].(map[string]any)
+	for _, path := range []string{"/api/v1/fen", "/api/v1/batch", "/api/v1/pgn", "/api/v1/jobs/{id}"} {
+		assert.Contains(t, paths, path)
+	}
+	assert.Contains(t, paths["/api/v1/jobs/{id}"], "delete")
+
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+	for _, name := range []string{"FenRequest", "FenResult", "ARInfo", "BatchRequest", "BatchResult", "JobView", "PgnResponse", "ErrorBody", "ApiError"} {
+		assert.Contains(t, schemas, name)
+	}
+
+	fenRequest := schemas["FenRequest"].(map[string]any)["allOf"].([]any)[1].(map[string]any)
+	assert.Equal(t, []any{"fen"}, fenRequest["required"])
+	assert.Equal(t, "the position", fenRequest["properties"].(map[string]any)["fen"].(map[string]any)["description"])
+
+	depth := schemas["AnalysisSettings"].(map[string]any)["properties"].(map[string]any)["depth"].(map[string]any)
+	assert.Equal(t, float64(Limits.DefaultDepth), depth["default"])
+	assert.Equal(t, float64(Limits.MaxDepth), depth["maximum"])
+}
+
+func TestAnalyzeBatch(t *testing.T) {
+	form := url.Values{}
+	form.Set("fens", testFen+"\n# a comment\n\n"+testFen+" id \"second\";\n")
+	form.Set("lines", "1")
+	req := httptest.NewRequest(http.MethodPost, "/chess/ai/batch", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	AnalyzeBatch(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	results := decodeLines[analyzer.BatchResult](t, w.Body.String())
+	assert.Equal(t, 3, len(results))
+	assert.True(t, results[2].Done)
+	ids := map[int]string{}
+	for _, res := range results[:2] {
+		ids[res.Index] = res.Id
+		assert.Equal(t, "b1h1", res.Result.BestMove)
+	}
+	assert.Equal(t, map[int]string{0: "", 1: "second"}, ids)
+
+	form.Set("fens", testFen+"\n8/8/8/8/8/8/8/8 w - -\n")
+	req = httptest.NewRequest(http.MethodPost, "/chess/ai/batch", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	AnalyzeBatch(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "line 2: ")
+}
+
+func TestBoard(t *testing.T) {
+	get := func(target string) (*httptest.ResponseRecorder, map[string]any) {
+		w := httptest.NewRecorder()
+		Board(w, httptest.NewRequest(http.MethodGet, target, nil))
+		body := map[string]any{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w, body
+	}
+	start := url.QueryEscape("rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+
+	w, body := get(BoardPath + "/moves?fen=" + start)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 20, len(body["moves"].([]any)))
+	assert.Equal(t, "w", body["side"])
+	assert.Equal(t, "ongoing", body["status"])
+
+	w, body = get(BoardPath + "/move?fen=" + url.QueryEscape("r1bqkb1r/pppp1ppp/2n2n2/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - 4 4") + "&move=Qxf7")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, map[string]any{"uci": "h5f7", "san": "Qxf7#"}, body["move"])
+	assert.Equal(t, "r1bqkb1r/pppp1Qpp/2n2n2/4p3/2B1P3/8/PPPP1PPP/RNB1K1NR b KQkq - 0 4", body["fen"])
+	assert.Equal(t, "checkmate", body["status"])
+	assert.Equal(t, true, body["inCheck"])
+
+	w, body = get(BoardPath + "/move?fen=" + start + "&move=e2e5")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "move", body["error"].(map[string]any)["field"])
+
+	w, body = get(BoardPath + "/moves?fen=" + url.QueryEscape("8/8/8/8 w - - 0 1"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ErrCodeInvalidFen, body["error"].(map[string]any)["code"])
+
+	w, body = get(BoardPath + "/san?fen=" + start + "&moves=e2e4,e7e5+g1f3")
+	assert.Equal(t, http.StatusOK, w.Code)
+	plies := body["plies"].([]any)
+	assert.Equal(t, 3, len(plies))
+	assert.Equal(t, "Nf3", plies[2].(map[string]any)["san"])
+
+	form := url.Values{}
+	form.Set("pgn", "[Event \"Test\"]\n\n1. e4 e5 2. Nf3 *\n")
+	req := httptest.NewRequest(http.MethodPost, BoardPath+"/pgn", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	Board(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	positions := &PositionsResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), positions))
+	assert.Equal(t, 3, len(positions.Plies))
+	assert.Equal(t, "rnbqkbnr/pppp1ppp/8/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - 1 2", positions.Plies[2].Fen)
+
+	w, _ = get(BoardPath + "/pgn")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w, _ = get(BoardPath + "/nothing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestBoardSvg(t *testing.T) {
+	w := httptest.NewRecorder()
+	Board(w, httptest.NewRequest(http.MethodGet, BoardPath+"/svg?fen="+url.QueryEscape(testFen)+"&flip=true&size=300&played=c8c1&best=true&depth=5", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+	svg := w.Body.String()
+	assert.Contains(t, svg, `width="300"`)
+	assert.Contains(t, svg, ai.SvgArrowPlayed)
+	// b1h1, flipped
+	assert.Contains(t, svg, `<line x1="650" y1="50" x2="50" y2="50" stroke="`+ai.SvgArrowBest+`"`)
+
+	w = httptest.NewRecorder()
+	Board(w, httptest.NewRequest(http.MethodGet, BoardPath+"/svg?fen="+url.QueryEscape(testFen)+"&size=9999", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGameReport(t *testing.T) {
+	form := url.Values{}
+	form.Set("pgn", "[Event \"Test\"]\n[White \"Anna\"]\n[Black \"Ben <b>\"]\n\n1. e4 e5 2. Nf3 1-0\n")
+	form.Set("lines", "1")
+	w := jobRequest(http.MethodPost, JobsPath, form)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	created := &analyzer.JobView{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), created))
+
+	report := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		GameReport(w, httptest.NewRequest(http.MethodGet, ReportPath+created.ID, nil))
+		return w
+	}
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if analyzer.JobManager().GetJob(created.ID).Status() == analyzer.JobDone {
+			break
+		}
+		assert.Contains(t, report().Body.String(), `http-equiv="refresh"`)
+	}
+
+	w = report()
+	assert.Equal(t, http.StatusOK, w.Code)
+	page := w.Body.String()
+	assert.NotContains(t, page, `http-equiv="refresh"`)
+	assert.Contains(t, page, "Anna - Ben &lt;b&gt;")
+	assert.Contains(t, page, "<polyline points=\"0,100 ")
+	assert.Contains(t, page, "Nf3")
+	assert.Contains(t, page, `<td class="best">e4</td>`)
+
+	w = httptest.NewRecorder()
+	GameReport(w, httptest.NewRequest(http.MethodGet, ReportPath+"nope", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestReportCritical(t *testing.T) {
+	report, err := analyzer.BuildReport("[Event \"Test\"]\n\n1. e4 f6 *\n", &analyzer.GameSummary{Moves: []*analyzer.MoveSummary{
+		{MoveNum: 2, PlayedMove: "f7f6", BestMove: "e7e5", Line: []string{"e7e5", "g1f3"}, ScoreCP: -20, HasPlayed: true, PlayedScore: -300},
+	}})
+	assert.Nil(t, err)
+
+	page := &reportPage{}
+	page.setReport(report)
+	assert.Equal(t, "White", page.Sides[0].Name)
+	assert.Equal(t, 1, len(page.Critical))
+	assert.Equal(t, 1, len(page.Graph.Marks))
+	svg := string(page.Critical[0].Svg)
+	assert.Contains(t, svg, ai.SvgArrowPlayed)
+	assert.Contains(t, svg, ai.SvgArrowBest)
+
+	sb := &strings.Builder{}
+	assert.Nil(t, reportTemplate.Execute(sb, page))
+	assert.Contains(t, sb.String(), "1... f6 <span class=\"blunder\">blunder</span>")
+	assert.Contains(t, sb.String(), "Engine line: e5 Nf3")
+}
+
+// logBuffer - the log is written from the workers and engines
+type logBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func TestRequestLog(t *testing.T) {
+	defer common.SetLogger(common.Log())
+	out := &logBuffer{}
+	common.SetLogger(slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	// The id of the client is kept, a job of the request is traced to the engine
+	form := url.Values{}
+	form.Set("pgn", "[Event \"Test\"]\n\n1. e4 e5 1-0\n")
+	form.Set("lines", "1")
+	req := httptest.NewRequest(http.MethodPost, JobsPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(RequestIdHeader, "trace-1")
+	w := httptest.NewRecorder()
+	RequestLog(http.HandlerFunc(PgnJobs)).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "trace-1", w.Header().Get(RequestIdHeader))
+
+	created := &analyzer.JobView{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), created))
+	job := "request=trace-1 job=" + created.ID
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if strings.Contains(out.String(), `msg="job finished" `+job) {
+			break
+		}
+	}
+	log := out.String()
+	assert.Contains(t, log, `msg=request request=trace-1 method=POST path=/chess/ai/jobs status=202`)
+	assert.Contains(t, log, `msg="job queued" `+job+" plies=2")
+	assert.Contains(t, log, `msg="pgn analysis started" `+job+" worker=")
+	assert.Contains(t, log, `msg="engine started" `+job+" engine=zahak")
+	assert.Contains(t, log, `msg="to engine" `+job+" engine=zahak line=uci")
+	assert.Contains(t, log, `msg="job finished" `+job+" status=done")
+
+	// A bad id is replaced
+	req = httptest.NewRequest(http.MethodGet, BoardPath+"/moves?fen="+url.QueryEscape(ai.StartFen), nil)
+	req.Header.Set(RequestIdHeader, "a b")
+	w = httptest.NewRecorder()
+	RequestLog(http.HandlerFunc(Board)).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 16, len(w.Header().Get(RequestIdHeader)))
+	assert.Contains(t, out.String(), "request="+w.Header().Get(RequestIdHeader)+" method=GET path=/chess/board/moves status=200")
+
+	// The session upgrades through the log
+	server := httptest.NewServer(RequestLog(http.HandlerFunc(AnalysisSession)))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+SessionPath, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "ready", readSession(t, conn, 0).Type)
+	conn.Close()
+}
@@ -1,6 +1,6 @@
 module github.com/samlotti/chess_anaylzer/chessboard/main
 
-go 1.18
+go 1.21
 
 require (
 	github.com/samlotti/blip v0.8.10
@@ -1,29 +1,48 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/samlotti/blip/blipUtil"
 	"github.com/samlotti/chess_anaylzer/analyzer"
-	. "github.com/samlotti/chess_anaylzer/chessboard/common"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
 	"github.com/samlotti/chess_anaylzer/httpservice"
-	"log"
+	"github.com/samlotti/chess_anaylzer/httpservice/config"
 	"net/http"
+	"os"
+	"strings"
 )
 
 //go:generate blip -dir ../template
 func main() {
 	fmt.Println("Chess FenAnalyzer")
 
-	// The number of workers
-	analyzer.CreateFenWorkers(5)
-	analyzer.CreatePgnWorkers(5)
-
-	Environment.EnginePath = "../engines/"
+	// The flags, environment and config file, see config.Load
+	cfg, err := config.Load(os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	cfg.Apply()
 
-	fmt.Printf("Running the server:  http://localhost:8181\n")
+	// The number of workers
+	analyzer.CreateFenWorkers(cfg.Workers.Fen)
+	analyzer.CreatePgnWorkers(cfg.Workers.Pgn)
 
-	fmt.Printf("http://localhost:8181/chess/ai/fen?fen=" +
-		"2r3k1/p4p2/3Rp2p/1p2P1pK/8/1P4P1/P3Q2P/1q6 b - - 0 1" + "\n")
+	scheme := "http"
+	if cfg.IsTLS() {
+		scheme = "https"
+	}
+	host := cfg.Listen
+	if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
+	}
+	common.Log().Info("running the server", "url", scheme+"://"+host,
+		"example", scheme+"://"+host+"/chess/ai/fen?fen=2r3k1/p4p2/3Rp2p/1p2P1pK/8/1P4P1/P3Q2P/1q6 b - - 0 1")
 
 	// Show timings of the individual template renders
 	blipUtil.Instance().SetMonitor(&blipUtil.DebugBlipMonitor{})
@@ -36,12 +55,29 @@ func main() {
 
 	http.HandleFunc("/chess/ai/pgn", httpservice.AnalyzePgn)
 	http.HandleFunc("/chess/ai/fen", httpservice.AnalyzeFen)
+	http.HandleFunc("/chess/ai/batch", httpservice.AnalyzeBatch)
+	http.HandleFunc(httpservice.PgnEventsPath, httpservice.AnalyzePgnEvents)
+	http.HandleFunc(httpservice.FenEventsPath, httpservice.AnalyzeFenEvents)
+	http.HandleFunc(httpservice.SessionPath, httpservice.AnalysisSession)
+	http.HandleFunc(httpservice.ApiV1Path+"/", httpservice.ApiV1)
+	http.HandleFunc(httpservice.BoardPath+"/", httpservice.Board)
+	http.HandleFunc(httpservice.ReportPath, httpservice.GameReport)
+	http.HandleFunc(httpservice.JobsPath, httpservice.PgnJobs)
+	http.HandleFunc(httpservice.JobsPath+"/", httpservice.PgnJobs)
 
-	http.Handle("/", http.FileServer(http.Dir("../public")))
+	http.Handle("/", http.FileServer(http.Dir(cfg.PublicDir)))
 	// http.HandleFunc("/", httpservice.Index)
 
-	if err := http.ListenAndServe(":8181", nil); err != nil {
-		log.Fatal(err)
+	// Every request has an id in the log, see httpservice.RequestLog
+	handler := httpservice.RequestLog(http.DefaultServeMux)
+	if cfg.IsTLS() {
+		err = http.ListenAndServeTLS(cfg.Listen, cfg.TLS.CertFile, cfg.TLS.KeyFile, handler)
+	} else {
+		err = http.ListenAndServe(cfg.Listen, handler)
+	}
+	if err != nil {
+		common.Log().Error("server ended", "err", err)
+		os.Exit(1)
 	}
 
 }
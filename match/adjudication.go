@@ -0,0 +1,80 @@
+package match
+
+// Adjudication - ends games early on the engine scores, 0 turns a rule off.
+type Adjudication struct {
+	// ResignScore - a side loses when its engine scores <= -ResignScore (cp)
+	// for ResignMoves moves in a row.
+	ResignScore int
+	ResignMoves int
+
+	// DrawScore - the game is drawn when both engines score within DrawScore (cp)
+	// for DrawMoves moves each, not before move DrawMoveNumber.
+	DrawScore      int
+	DrawMoves      int
+	DrawMoveNumber int
+
+	// MaxMoves - the game is drawn after this many moves.
+	MaxMoves int
+}
+
+// adjudicator - the scores of one game.
+type adjudicator struct {
+	adj        *Adjudication
+	resignRun  [2]int // per color, the moves in a row below the resign score
+	drawPlies  int    // the plies in a row within the draw score
+	plies      int
+	moveNumber int
+}
+
+func newAdjudicator(adj *Adjudication, moveNumber int) *adjudicator {
+	if adj == nil {
+		adj = &Adjudication{}
+	}
+	return &adjudicator{adj: adj, moveNumber: moveNumber}
+}
+
+// Move - adds the score of the move made, from the view of the side that made it.
+// A side of 0 is white.  Returns the result and reason when the game is adjudicated.
+func (a *adjudicator) Move(side int, scoreCP int, hasScore bool) (string, string) {
+	a.plies++
+	if side == 1 {
+		a.moveNumber++
+	}
+
+	if a.adj.ResignMoves > 0 {
+		if hasScore && scoreCP <= -a.adj.ResignScore {
+			a.resignRun[side]++
+		} else {
+			a.resignRun[side] = 0
+		}
+		if a.resignRun[side] >= a.adj.ResignMoves {
+			if side == 0 {
+				return Result0_1, "White resigns"
+			}
+			return Result1_0, "Black resigns"
+		}
+	}
+
+	if a.adj.DrawMoves > 0 {
+		if hasScore && abs(scoreCP) <= a.adj.DrawScore && a.moveNumber >= a.adj.DrawMoveNumber {
+			a.drawPlies++
+		} else {
+			a.drawPlies = 0
+		}
+		if a.drawPlies >= 2*a.adj.DrawMoves {
+			return ResultDraw, "Draw by adjudication"
+		}
+	}
+
+	if a.adj.MaxMoves > 0 && a.plies >= 2*a.adj.MaxMoves {
+		return ResultDraw, "Draw by move limit"
+	}
+	return "", ""
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
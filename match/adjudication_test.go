@@ -0,0 +1,58 @@
+package match
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAdjudicationResign(t *testing.T) {
+	a := newAdjudicator(&Adjudication{ResignScore: 500, ResignMoves: 2}, 1)
+
+	result, _ := a.Move(0, 100, true)
+	assert.Equal(t, "", result)
+	result, _ = a.Move(1, -600, true)
+	assert.Equal(t, "", result)
+	result, _ = a.Move(0, 600, true)
+	assert.Equal(t, "", result)
+	result, reason := a.Move(1, -700, true)
+	assert.Equal(t, Result1_0, result)
+	assert.Equal(t, "Black resigns", reason)
+
+	// A move without a score breaks the run
+	a = newAdjudicator(&Adjudication{ResignScore: 500, ResignMoves: 2}, 1)
+	a.Move(0, -600, true)
+	a.Move(1, 0, false)
+	a.Move(0, 0, false)
+	a.Move(1, 0, false)
+	result, _ = a.Move(0, -600, true)
+	assert.Equal(t, "", result)
+}
+
+func TestAdjudicationDraw(t *testing.T) {
+	a := newAdjudicator(&Adjudication{DrawScore: 10, DrawMoves: 2, DrawMoveNumber: 3}, 1)
+
+	// Not before move 3
+	for idx := 0; idx < 4; idx++ {
+		result, _ := a.Move(idx%2, 0, true)
+		assert.Equal(t, "", result)
+	}
+	a.Move(0, 5, true)
+	a.Move(1, -5, true)
+	a.Move(0, 0, true)
+	result, reason := a.Move(1, 10, true)
+	assert.Equal(t, ResultDraw, result)
+	assert.Equal(t, "Draw by adjudication", reason)
+}
+
+func TestAdjudicationMaxMoves(t *testing.T) {
+	a := newAdjudicator(&Adjudication{MaxMoves: 2}, 1)
+	a.Move(0, 0, false)
+	a.Move(1, 0, false)
+	a.Move(0, 0, false)
+	result, reason := a.Move(1, 0, false)
+	assert.Equal(t, ResultDraw, result)
+	assert.Equal(t, "Draw by move limit", reason)
+
+	result, _ = newAdjudicator(nil, 1).Move(0, -10000, true)
+	assert.Equal(t, "", result)
+}
@@ -0,0 +1,157 @@
+package main
+
+/**
+enginematch - plays two engines against each other.
+
+	enginematch -engine1 zahak -engine2 stockfish -tc 10+0.1 -openings book.pgn -rounds 5 -pgnout games.pgn
+
+Each opening is played twice with the colors swapped.  The score, Elo
+difference and SPRT are shown after every game, with -sprt the match stops
+once the test decides.  The openings are a
+pgn file or a file with a fen per line, without openings the games start
+from the start position.
+*/
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"github.com/samlotti/chess_anaylzer/match"
+	"github.com/samlotti/chess_anaylzer/uci"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+func main() {
+	engine1 := flag.String("engine1", "", "the first engine")
+	engine2 := flag.String("engine2", "", "the second engine")
+	options1 := flag.String("options1", "", "options of the first engine, ex: Hash=64,Threads=2")
+	options2 := flag.String("options2", "", "options of the second engine")
+	cecp1 := flag.Bool("cecp1", false, "the first engine is an xboard engine")
+	cecp2 := flag.Bool("cecp2", false, "the second engine is an xboard engine")
+	path := flag.String("engines", common.Environment.EnginePath, "the directory of the engine binaries")
+	openings := flag.String("openings", "", "the opening suite, pgn or fen per line")
+	rounds := flag.Int("rounds", 1, "times the openings are played, 2 games per opening each round")
+	tc := flag.String("tc", "10+0.1", "the time control: base+inc in seconds, st=<seconds per move> or depth=<depth>")
+	concurrency := flag.Int("concurrency", 1, "games played at once")
+	event := flag.String("event", "Engine match", "the pgn event")
+	pgnOut := flag.String("pgnout", "", "the file the games are written to")
+	resignScore := flag.Int("resign-score", 0, "resign at this score in cp, 0 = no resign adjudication")
+	resignMoves := flag.Int("resign-moves", 3, "moves in a row at the resign score")
+	drawScore := flag.Int("draw-score", 0, "draw when within this score in cp, 0 = no draw adjudication")
+	drawMoves := flag.Int("draw-moves", 8, "moves in a row within the draw score")
+	drawMoveNumber := flag.Int("draw-movenumber", 40, "no draw adjudication before this move")
+	maxMoves := flag.Int("maxmoves", 0, "draw after this many moves, 0 = no limit")
+	sprt := flag.String("sprt", "", "stop at the sprt decision, the elo bounds ex: 0,5")
+	alpha := flag.Float64("alpha", 0.05, "the sprt false positive rate")
+	beta := flag.Float64("beta", 0.05, "the sprt false negative rate")
+	verbose := flag.Bool("v", false, "show the engine communication")
+	flag.Parse()
+
+	if len(*engine1) == 0 || len(*engine2) == 0 {
+		fail("two engines are needed, use -engine1 and -engine2")
+	}
+	// The log only shows the problems
+	common.LogLevel.Set(slog.LevelWarn)
+	if *verbose {
+		common.LogLevel.Set(slog.LevelDebug)
+	}
+	common.Environment.EnginePath = *path
+	if !strings.HasSuffix(common.Environment.EnginePath, "/") {
+		common.Environment.EnginePath += "/"
+	}
+	if *cecp1 {
+		uci.UciManager().SetProtocol(*engine1, uci.ProtocolCecp)
+	}
+	if *cecp2 {
+		uci.UciManager().SetProtocol(*engine2, uci.ProtocolCecp)
+	}
+
+	timeControl, err := match.ParseTimeControl(*tc)
+	if err != nil {
+		fail(err.Error())
+	}
+
+	m := &match.Match{
+		Engines: [2]*match.EngineConfig{
+			{Name: *engine1, Options: parseOptions(*options1)},
+			{Name: *engine2, Options: parseOptions(*options2)},
+		},
+		Rounds:      *rounds,
+		TimeControl: timeControl,
+		Concurrency: *concurrency,
+		Event:       *event,
+		Adjudication: &match.Adjudication{
+			DrawScore:      *drawScore,
+			DrawMoveNumber: *drawMoveNumber,
+			MaxMoves:       *maxMoves,
+		},
+	}
+	if *resignScore > 0 {
+		m.Adjudication.ResignScore = *resignScore
+		m.Adjudication.ResignMoves = *resignMoves
+	}
+	if *drawScore > 0 {
+		m.Adjudication.DrawMoves = *drawMoves
+	}
+	if len(*sprt) > 0 {
+		m.SPRT = &match.SPRT{Alpha: *alpha, Beta: *beta}
+		if _, err = fmt.Sscanf(*sprt, "%g,%g", &m.SPRT.Elo0, &m.SPRT.Elo1); err != nil {
+			fail("invalid -sprt, use elo0,elo1 ex: 0,5")
+		}
+	}
+	if len(*openings) > 0 {
+		if m.Openings, err = match.LoadOpenings(*openings); err != nil {
+			fail(err.Error())
+		}
+	}
+
+	var out *os.File
+	if len(*pgnOut) > 0 {
+		if out, err = os.Create(*pgnOut); err != nil {
+			fail(err.Error())
+		}
+		defer out.Close()
+	}
+
+	m.OnGame = func(game *match.Game, result *match.Result) {
+		fmt.Printf("Game %d: %s - %s %s {%s}\n", game.Round, game.White, game.Black, game.Result, game.Reason)
+		fmt.Print(result.Report())
+		if out != nil {
+			if _, err := out.WriteString(game.PGN(m.Event)); err != nil {
+				fmt.Fprintf(os.Stderr, "cannot write %s: %s\n", *pgnOut, err)
+			}
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	result, err := m.Run(ctx)
+	if result != nil {
+		fmt.Printf("\nFinal result\n%s", result.Report())
+	}
+	if err != nil {
+		fail(err.Error())
+	}
+}
+
+// parseOptions - name=value pairs separated by commas.
+func parseOptions(str string) map[string]string {
+	options := make(map[string]string)
+	for _, opt := range strings.Split(str, ",") {
+		sects := strings.SplitN(opt, "=", 2)
+		if len(sects) == 2 {
+			options[strings.TrimSpace(sects[0])] = strings.TrimSpace(sects[1])
+		}
+	}
+	return options
+}
+
+func fail(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+	os.Exit(1)
+}
@@ -0,0 +1,92 @@
+package main
+
+/**
+epdsuite - runs an engine on an epd test suite.
+
+	epdsuite -engine zahak -epd wac.epd -tc st=1
+
+Every position is searched with the time or depth limit and the move is
+checked against the bm and am operations.
+*/
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"github.com/samlotti/chess_anaylzer/match"
+	"github.com/samlotti/chess_anaylzer/uci"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+func main() {
+	engine := flag.String("engine", "", "the engine")
+	options := flag.String("options", "", "options of the engine, ex: Hash=64,Threads=2")
+	cecp := flag.Bool("cecp", false, "the engine is an xboard engine")
+	path := flag.String("engines", common.Environment.EnginePath, "the directory of the engine binaries")
+	epd := flag.String("epd", "", "the epd suite")
+	tc := flag.String("tc", "st=1", "the limit per position: st=<seconds> or depth=<depth>")
+	verbose := flag.Bool("v", false, "show the engine communication")
+	flag.Parse()
+
+	if len(*engine) == 0 || len(*epd) == 0 {
+		fail("use -engine and -epd")
+	}
+	// The log only shows the problems
+	common.LogLevel.Set(slog.LevelWarn)
+	if *verbose {
+		common.LogLevel.Set(slog.LevelDebug)
+	}
+	common.Environment.EnginePath = *path
+	if !strings.HasSuffix(common.Environment.EnginePath, "/") {
+		common.Environment.EnginePath += "/"
+	}
+	if *cecp {
+		uci.UciManager().SetProtocol(*engine, uci.ProtocolCecp)
+	}
+
+	timeControl, err := match.ParseTimeControl(*tc)
+	if err != nil {
+		fail(err.Error())
+	}
+	positions, err := match.LoadEpd(*epd)
+	if err != nil {
+		fail(err.Error())
+	}
+
+	opts := make(map[string]string)
+	for _, opt := range strings.Split(*options, ",") {
+		sects := strings.SplitN(opt, "=", 2)
+		if len(sects) == 2 {
+			opts[strings.TrimSpace(sects[0])] = strings.TrimSpace(sects[1])
+		}
+	}
+
+	s := &match.Suite{
+		Engine:      &match.EngineConfig{Name: *engine, Options: opts},
+		Positions:   positions,
+		TimeControl: timeControl,
+		OnPosition: func(res *match.SuiteResult, report *match.SuiteReport) {
+			fmt.Printf("%d/%d %s\n", len(report.Results), len(positions), res)
+		},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	report, err := s.Run(ctx)
+	if report != nil {
+		fmt.Printf("\n%s\n", report)
+	}
+	if err != nil {
+		fail(err.Error())
+	}
+}
+
+func fail(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+	os.Exit(1)
+}
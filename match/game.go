@@ -0,0 +1,461 @@
+package match
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"github.com/samlotti/chess_anaylzer/uci"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The pgn results
+const (
+	Result1_0  = "1-0"
+	Result0_1  = "0-1"
+	ResultDraw = "1/2-1/2"
+	ResultNone = "*"
+)
+
+// The pgn Termination tag values
+const (
+	TerminationNormal       = "normal"
+	TerminationAdjudication = "adjudication"
+	TerminationTimeForfeit  = "time forfeit"
+	TerminationIllegalMove  = "rules infraction"
+	TerminationAbandoned    = "abandoned"
+)
+
+// DefaultTimeMargin - the time an engine may go over its clock, for the
+// engine to answer the stop and the moves to be sent.
+const DefaultTimeMargin = 100 * time.Millisecond
+
+// CecpMovesToGo - xboard engines analyze until stopped, with a clock each
+// move is given the time left divided by this plus the increment.
+const CecpMovesToGo = 30
+
+// DepthTimeout - max wait for a search to a depth.
+var DepthTimeout = 5 * time.Minute
+
+// EngineConfig - a player, the engine name as passed to uci.UciManager().GetEngine.
+type EngineConfig struct {
+	Name    string
+	Options map[string]string // set with setoption before the game
+}
+
+// GameMove - a move of the game.
+type GameMove struct {
+	Uci     string
+	San     string
+	Book    bool // from the opening, not searched
+	ScoreCP int  // from the view of the engine that moved
+	Score   bool // the engine sent a score
+	Depth   int
+	TimeMs  int
+}
+
+// Game - a played game.
+type Game struct {
+	White       string
+	Black       string
+	Round       int
+	Date        time.Time
+	Opening     *Opening
+	TimeControl string
+	Moves       []*GameMove
+	Result      string
+	Termination string
+	Reason      string // ex: "Black mates", "White loses on time"
+}
+
+// gamePlayer - an engine in a game.
+type gamePlayer struct {
+	config *EngineConfig
+	engine uci.Engine
+	clock  time.Duration
+
+	lock      sync.Mutex
+	last      *uci.UciInfo
+	callbacks chan *uci.UciCallback
+	bestMove  chan struct{}
+}
+
+// track - keeps the last main line info of the searches.
+func (p *gamePlayer) track(quit chan struct{}) {
+	for {
+		select {
+		case cb := <-p.callbacks:
+			if cb.Info != nil && cb.Info.Err == nil && cb.Info.MPv <= 1 && cb.Info.Depth > 0 {
+				p.lock.Lock()
+				p.last = cb.Info
+				p.lock.Unlock()
+			}
+			if cb.BestMove != nil {
+				select {
+				case p.bestMove <- struct{}{}:
+				default:
+				}
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// lastInfo - the last info of the search, waits for the bestmove
+// callback so the infos before it have been seen.
+func (p *gamePlayer) lastInfo() *uci.UciInfo {
+	select {
+	case <-p.bestMove:
+	case <-time.After(time.Second):
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.last
+}
+
+func (p *gamePlayer) reset() {
+	select {
+	case <-p.bestMove:
+	default:
+	}
+	p.lock.Lock()
+	p.last = nil
+	p.lock.Unlock()
+}
+
+// gameSetup - the settings of a game.
+type gameSetup struct {
+	white      *EngineConfig
+	black      *EngineConfig
+	round      int
+	opening    *Opening
+	tc         *TimeControl
+	adj        *Adjudication
+	timeMargin time.Duration
+}
+
+// startPlayer - gets the engine and sets the options.
+func startPlayer(ctx context.Context, config *EngineConfig, tc *TimeControl) (*gamePlayer, error) {
+	engine, err := uci.UciManager().GetEngine(ctx, config.Name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot start %s: %w", config.Name, err)
+	}
+	for name, val := range config.Options {
+		if err = engine.SetOption(name, val); err != nil {
+			uci.UciManager().Return(engine)
+			return nil, fmt.Errorf("cannot set %s option %s: %w", config.Name, name, err)
+		}
+	}
+	if err = engine.WaitReady(ctx); err != nil {
+		uci.UciManager().Return(engine)
+		return nil, fmt.Errorf("%s not ready: %w", config.Name, err)
+	}
+	p := &gamePlayer{
+		config:    config,
+		engine:    engine,
+		clock:     time.Duration(tc.BaseMs) * time.Millisecond,
+		callbacks: make(chan *uci.UciCallback, 100),
+		bestMove:  make(chan struct{}, 1),
+	}
+	engine.SetAsyncChannel(p.callbacks)
+	return p, nil
+}
+
+// playGame - plays one game, an error is returned when the game could not be played.
+// Engines failing during the game lose it.
+func playGame(ctx context.Context, setup *gameSetup) (*Game, error) {
+	game := &Game{
+		White:       setup.white.Name,
+		Black:       setup.black.Name,
+		Round:       setup.round,
+		Date:        time.Now(),
+		Opening:     setup.opening,
+		TimeControl: setup.tc.String(),
+		Result:      ResultNone,
+	}
+
+	brd := ai.NewBoard()
+	if err := ai.ParseFen(brd, setup.opening.Fen); err != nil {
+		return nil, fmt.Errorf("opening %s: %w", setup.opening.Name, err)
+	}
+	startPly := ai.PlyOfFen(setup.opening.Fen)
+	for _, str := range setup.opening.Moves {
+		mv, err := ai.MoveFromUci(brd, str)
+		if err != nil {
+			return nil, fmt.Errorf("opening %s: %w", setup.opening.Name, err)
+		}
+		game.Moves = append(game.Moves, &GameMove{Uci: str, San: ai.SanForMove(brd, mv), Book: true})
+		if err = brd.MakeMove(mv, str); err != nil {
+			return nil, fmt.Errorf("opening %s: %w", setup.opening.Name, err)
+		}
+	}
+	if status := brd.Status(); status != ai.StatusOngoing {
+		return nil, fmt.Errorf("opening %s: the game has ended, %s", setup.opening.Name, status)
+	}
+
+	var players [2]*gamePlayer
+	for idx, config := range []*EngineConfig{setup.white, setup.black} {
+		p, err := startPlayer(ctx, config, setup.tc)
+		if err != nil {
+			if players[0] != nil {
+				uci.UciManager().Return(players[0].engine)
+			}
+			return nil, err
+		}
+		players[idx] = p
+	}
+	quit := make(chan struct{})
+	for _, p := range players {
+		go p.track(quit)
+	}
+	defer func() {
+		close(quit)
+		for _, p := range players {
+			p.engine.SetAsyncChannel(nil)
+			uci.UciManager().Return(p.engine)
+		}
+	}()
+
+	adj := newAdjudicator(setup.adj, (startPly+len(game.Moves))/2+1)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		side := 0
+		if !brd.IsWhiteToMove() {
+			side = 1
+		}
+		p := players[side]
+		color := colorName(side)
+
+		move, err := search(ctx, brd, startPly+len(game.Moves), players, side, setup)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if errors.Is(err, errTimeForfeit) {
+				game.setResult(lossFor(side), TerminationTimeForfeit, color+" loses on time")
+			} else {
+				game.setResult(lossFor(side), TerminationAbandoned, fmt.Sprintf("%s %s: %s", color, p.config.Name, err))
+			}
+			return game, nil
+		}
+
+		mv, err := ai.MoveFromUci(brd, move.Uci)
+		if err != nil {
+			game.setResult(lossFor(side), TerminationIllegalMove, fmt.Sprintf("%s makes an illegal move: %s", color, move.Uci))
+			return game, nil
+		}
+		move.San = ai.SanForMove(brd, mv)
+		_ = brd.MakeMove(mv, move.Uci)
+		game.Moves = append(game.Moves, move)
+
+		switch status := brd.Status(); status {
+		case ai.StatusOngoing:
+		case ai.StatusCheckmate:
+			game.setResult(lossFor(1-side), TerminationNormal, color+" mates")
+			return game, nil
+		default:
+			game.setResult(ResultDraw, TerminationNormal, "Draw by "+status.String())
+			return game, nil
+		}
+
+		if result, reason := adj.Move(side, move.ScoreCP, move.Score); len(result) > 0 {
+			game.setResult(result, TerminationAdjudication, reason)
+			return game, nil
+		}
+	}
+}
+
+var errTimeForfeit = errors.New("time forfeit")
+
+// search - the move of the engine to play.
+func search(ctx context.Context, brd *ai.Board, ply int, players [2]*gamePlayer, side int, setup *gameSetup) (*GameMove, error) {
+	p := players[side]
+	tc := setup.tc
+
+	if err := p.engine.SetPositionFen(ai.BoardToFen(brd, ply)); err != nil {
+		return nil, err
+	}
+
+	opts := uci.NewGoOptions()
+	wait := DepthTimeout
+	switch {
+	case tc.IsClock():
+		opts.WTime = int(players[0].clock.Milliseconds())
+		opts.BTime = int(players[1].clock.Milliseconds())
+		opts.WInc = tc.IncMs
+		opts.BInc = tc.IncMs
+		wait = p.clock + setup.timeMargin
+		if _, ok := p.engine.(*uci.CecpProcess); ok {
+			// Analyze mode ignores the clock, stop at a share of it
+			wait = p.clock/CecpMovesToGo + time.Duration(tc.IncMs)*time.Millisecond
+			if wait > p.clock {
+				wait = p.clock
+			}
+		}
+	case tc.MoveTimeMs > 0:
+		opts.MoveTime = tc.MoveTimeMs
+		wait = time.Duration(tc.MoveTimeMs)*time.Millisecond + setup.timeMargin
+	default:
+		opts.Depth = tc.Depth
+	}
+
+	p.reset()
+	start := time.Now()
+	if err := p.engine.SendGo(opts); err != nil {
+		return nil, err
+	}
+	bm, err := p.engine.WaitMoveUpTo(ctx, wait)
+	elapsed := time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+
+	if tc.IsClock() {
+		if elapsed > p.clock+setup.timeMargin {
+			return nil, errTimeForfeit
+		}
+		p.clock += time.Duration(tc.IncMs)*time.Millisecond - elapsed
+	}
+	if bm == nil || len(bm.BestMove) == 0 {
+		return nil, fmt.Errorf("no move")
+	}
+
+	move := &GameMove{Uci: bm.BestMove, TimeMs: int(elapsed.Milliseconds())}
+	if info := p.lastInfo(); info != nil {
+		move.ScoreCP = info.ScoreCP
+		move.Score = true
+		move.Depth = info.Depth
+	}
+	return move, nil
+}
+
+func (g *Game) setResult(result string, termination string, reason string) {
+	g.Result = result
+	g.Termination = termination
+	g.Reason = reason
+}
+
+// lossFor - the result when the side loses.
+func lossFor(side int) string {
+	if side == 0 {
+		return Result0_1
+	}
+	return Result1_0
+}
+
+func colorName(side int) string {
+	if side == 0 {
+		return "White"
+	}
+	return "Black"
+}
+
+// Points - the points of the engine in the game, 1, 0.5 or 0.
+func (g *Game) Points(engine string) float64 {
+	switch {
+	case g.Result == ResultDraw:
+		return 0.5
+	case g.Result == Result1_0 && g.White == engine:
+		return 1
+	case g.Result == Result0_1 && g.Black == engine:
+		return 1
+	}
+	return 0
+}
+
+// PGN - the game as pgn, the searched moves have the score, depth and time as a comment.
+func (g *Game) PGN(event string) string {
+	var sb strings.Builder
+	tag := func(name string, val string) {
+		sb.WriteString(fmt.Sprintf("[%s \"%s\"]\n", name, strings.ReplaceAll(val, "\"", "'")))
+	}
+	if len(event) == 0 {
+		event = "?"
+	}
+	tag("Event", event)
+	tag("Site", "?")
+	tag("Date", g.Date.Format("2006.01.02"))
+	tag("Round", strconv.Itoa(g.Round))
+	tag("White", g.White)
+	tag("Black", g.Black)
+	tag("Result", g.Result)
+	if g.Opening.Fen != ai.StartFen {
+		tag("FEN", g.Opening.Fen)
+		tag("SetUp", "1")
+	}
+	if len(g.Opening.Name) > 0 {
+		tag("Opening", g.Opening.Name)
+	}
+	if len(g.TimeControl) > 0 {
+		tag("TimeControl", g.TimeControl)
+	}
+	tag("PlyCount", strconv.Itoa(len(g.Moves)))
+	if len(g.Termination) > 0 {
+		tag("Termination", g.Termination)
+	}
+	sb.WriteString("\n")
+
+	var tokens []string
+	ply := ai.PlyOfFen(g.Opening.Fen)
+	for idx, m := range g.Moves {
+		if ply%2 == 0 {
+			tokens = append(tokens, fmt.Sprintf("%d.", ply/2+1))
+		} else if idx == 0 {
+			tokens = append(tokens, fmt.Sprintf("%d...", ply/2+1))
+		}
+		tokens = append(tokens, m.San)
+		tokens = append(tokens, m.comment())
+		ply++
+	}
+	if len(g.Reason) > 0 {
+		tokens = append(tokens, "{"+g.Reason+"}")
+	}
+	tokens = append(tokens, g.Result)
+
+	line := 0
+	for idx, tok := range tokens {
+		if idx > 0 {
+			if line+1+len(tok) > 80 {
+				sb.WriteString("\n")
+				line = 0
+			} else {
+				sb.WriteString(" ")
+				line++
+			}
+		}
+		sb.WriteString(tok)
+		line += len(tok)
+	}
+	sb.WriteString("\n\n")
+	return sb.String()
+}
+
+// comment - ex: {+0.35/12 0.52s}
+func (m *GameMove) comment() string {
+	if m.Book {
+		return "{book}"
+	}
+	secs := strconv.FormatFloat(float64(m.TimeMs)/1000, 'f', 2, 64) + "s"
+	if !m.Score {
+		return "{" + secs + "}"
+	}
+	return fmt.Sprintf("{%s/%d %s}", scoreString(m.ScoreCP), m.Depth, secs)
+}
+
+// scoreString - the score in pawns, or mate in moves, ex: +0.35, -M3
+func scoreString(cp int) string {
+	switch {
+	case cp > 15000:
+		return fmt.Sprintf("+M%d", cp-15000)
+	case cp < -15000:
+		return fmt.Sprintf("-M%d", -cp-15000)
+	}
+	return fmt.Sprintf("%+.2f", float64(cp)/100)
+}
@@ -0,0 +1,213 @@
+package match
+
+/**
+Engine against engine matches.
+
+Each opening is played twice, the engines swap colors for the second game.
+The engines are started for every game with uci.UciManager().GetEngine, so
+remote engines, cecp engines and fakes work as set up there.
+*/
+
+import (
+	"context"
+	"fmt"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Match - the settings of a match.
+type Match struct {
+	Engines      [2]*EngineConfig
+	Openings     []*Opening // nil plays from the start position
+	Rounds       int        // times the openings are played, each round is 2 games per opening
+	TimeControl  *TimeControl
+	Adjudication *Adjudication
+	Concurrency  int           // games played at once, 0 = 1
+	TimeMargin   time.Duration // 0 = DefaultTimeMargin
+	Event        string
+
+	// SPRT - stops the match once the test decides, the games running finish.
+	SPRT *SPRT
+
+	// OnGame - called with each game when it ends, in the order they end.
+	OnGame func(game *Game, result *Result)
+}
+
+// Result - the games of the match, the score is from the view of the first engine.
+type Result struct {
+	Names  [2]string
+	Wins   int
+	Draws  int
+	Losses int
+	Games  []*Game // in the order they ended
+	SPRT   *SPRT
+}
+
+// Score - the points of the first engine.
+func (r *Result) Score() float64 {
+	return float64(r.Wins) + float64(r.Draws)/2
+}
+
+// add - counts the game.
+func (r *Result) add(game *Game) {
+	r.Games = append(r.Games, game)
+	switch game.Points(r.Names[0]) {
+	case 1:
+		r.Wins++
+	case 0.5:
+		r.Draws++
+	default:
+		r.Losses++
+	}
+}
+
+// ScoreTable - the score as text, ex:
+//
+//	zahak - stockfish: 3 - 1 - 2  [0.583] 6
+func (r *Result) ScoreTable() string {
+	games := r.Wins + r.Draws + r.Losses
+	ratio := 0.0
+	if games > 0 {
+		ratio = r.Score() / float64(games)
+	}
+	return fmt.Sprintf("%s - %s: %d - %d - %d  [%.3f] %d", r.Names[0], r.Names[1], r.Wins, r.Losses, r.Draws, ratio, games)
+}
+
+// WritePGN - writes the games.
+func (r *Result) WritePGN(w io.Writer, event string) error {
+	for _, g := range r.Games {
+		if _, err := io.WriteString(w, g.PGN(event)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run - plays the match, stops at the first game that cannot be played
+// or when the SPRT decides.
+// The games played so far are returned along with the error.
+func (m *Match) Run(ctx context.Context) (*Result, error) {
+	if m.Engines[0] == nil || m.Engines[1] == nil {
+		return nil, fmt.Errorf("a match needs two engines")
+	}
+	if m.TimeControl == nil {
+		return nil, fmt.Errorf("a match needs a time control")
+	}
+
+	if m.Engines[0].Name == m.Engines[1].Name {
+		return nil, fmt.Errorf("the engines need different names: %s", m.Engines[0].Name)
+	}
+	if m.SPRT != nil {
+		if err := m.SPRT.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	result := &Result{Names: [2]string{m.Engines[0].Name, m.Engines[1].Name}, SPRT: m.SPRT}
+
+	setups := m.setups()
+	workers := m.Concurrency
+	if workers <= 0 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var lock sync.Mutex
+	var firstErr error
+	decided := make(chan struct{})
+	jobs := make(chan *gameSetup)
+	var wg sync.WaitGroup
+	for idx := 0; idx < workers; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for setup := range jobs {
+				game, err := playGame(ctx, setup)
+
+				lock.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+				} else {
+					result.add(game)
+					if m.OnGame != nil {
+						m.OnGame(game, result)
+					}
+					if status := result.SPRTStatus(); status != nil && status.Decision != SPRTContinue {
+						select {
+						case <-decided:
+						default:
+							close(decided)
+						}
+					}
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, setup := range setups {
+		select {
+		case jobs <- setup:
+		case <-ctx.Done():
+			break feed
+		case <-decided:
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return result, firstErr
+}
+
+// setups - the games of the match, each opening with both colors.
+func (m *Match) setups() []*gameSetup {
+	openings := m.Openings
+	if len(openings) == 0 {
+		openings = []*Opening{{Fen: ai.StartFen}}
+	}
+	rounds := m.Rounds
+	if rounds <= 0 {
+		rounds = 1
+	}
+	margin := m.TimeMargin
+	if margin == 0 {
+		margin = DefaultTimeMargin
+	}
+
+	var setups []*gameSetup
+	for round := 0; round < rounds; round++ {
+		for _, o := range openings {
+			if len(strings.TrimSpace(o.Fen)) == 0 {
+				o = &Opening{Name: o.Name, Fen: ai.StartFen, Moves: o.Moves}
+			}
+			for _, swap := range []bool{false, true} {
+				white, black := m.Engines[0], m.Engines[1]
+				if swap {
+					white, black = black, white
+				}
+				setups = append(setups, &gameSetup{
+					white:      white,
+					black:      black,
+					round:      len(setups) + 1,
+					opening:    o,
+					tc:         m.TimeControl,
+					adj:        m.Adjudication,
+					timeMargin: margin,
+				})
+			}
+		}
+	}
+	return setups
+}
@@ -0,0 +1,199 @@
+package match
+
+import (
+	"bytes"
+	"context"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"github.com/samlotti/chess_anaylzer/uci"
+	"github.com/samlotti/chess_anaylzer/uci/fakeengine"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useScripts - the engines of the test are fake engines playing the scripts.
+func useScripts(t *testing.T, scripts map[string]*fakeengine.Script) {
+	uci.UciManager().SetTransportFactory(func(engine string) (uci.Transport, error) {
+		return fakeengine.New(scripts[engine]).Transport(), nil
+	})
+	t.Cleanup(func() {
+		uci.UciManager().SetTransportFactory(nil)
+	})
+}
+
+// lineScript - plays the moves of the line, for either color.
+func lineScript(t *testing.T, moves ...string) *fakeengine.Script {
+	script := fakeengine.DefaultScript()
+	brd := ai.NewBoard()
+	ai.ParseFen(brd, ai.StartFen)
+	for ply, str := range moves {
+		script.Positions[ai.BoardToFen(brd, ply)] = &fakeengine.Response{
+			Lines: []string{
+				"info depth 3 score cp -20 nodes 100 time 1 multipv 1 pv " + str,
+				"bestmove " + str,
+			},
+		}
+		mv, err := ai.MoveFromUci(brd, str)
+		assert.Nil(t, err)
+		assert.Nil(t, brd.MakeMove(mv, str))
+	}
+	return script
+}
+
+func newTestMatch(tc string) *Match {
+	timeControl, _ := ParseTimeControl(tc)
+	return &Match{
+		Engines:     [2]*EngineConfig{{Name: "one"}, {Name: "two", Options: map[string]string{"Hash": "16"}}},
+		TimeControl: timeControl,
+	}
+}
+
+func TestMatchFoolsMate(t *testing.T) {
+	script := lineScript(t, "f2f3", "e7e5", "g2g4", "d8h4")
+	useScripts(t, map[string]*fakeengine.Script{"one": script, "two": script})
+
+	var ended []string
+	m := newTestMatch("10+0.1")
+	m.Concurrency = 2
+	m.OnGame = func(game *Game, result *Result) {
+		ended = append(ended, game.White)
+	}
+	res, err := m.Run(context.Background())
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"one", "two"}, ended)
+
+	// Black wins both games
+	assert.Equal(t, 1, res.Wins)
+	assert.Equal(t, 1, res.Losses)
+	assert.Equal(t, 0, res.Draws)
+	assert.Equal(t, "one - two: 1 - 1 - 0  [0.500] 2", res.ScoreTable())
+
+	game := res.Games[0]
+	assert.Equal(t, Result0_1, game.Result)
+	assert.Equal(t, TerminationNormal, game.Termination)
+	assert.Equal(t, "Black mates", game.Reason)
+	assert.Equal(t, 4, len(game.Moves))
+	assert.Equal(t, "Qh4#", game.Moves[3].San)
+	assert.Equal(t, 3, game.Moves[3].Depth)
+
+	var buf bytes.Buffer
+	assert.Nil(t, res.WritePGN(&buf, "Test"))
+	pgn := buf.String()
+	assert.Contains(t, pgn, "[Event \"Test\"]")
+	assert.Contains(t, pgn, "[Result \"0-1\"]")
+	assert.Contains(t, pgn, "[PlyCount \"4\"]")
+	assert.Contains(t, pgn, "[TimeControl \"10+0.1\"]")
+	assert.Contains(t, pgn, "1. f3 {-0.20/3 ")
+	assert.Contains(t, pgn, "2. g4 ")
+	assert.Contains(t, pgn, "Qh4#\n{-0.20/3 ")
+	assert.Contains(t, pgn, "{Black mates} 0-1")
+	assert.NotContains(t, pgn, "[FEN")
+
+	// The games read back
+	pw := ai.NewPgnWrapper(pgn)
+	assert.Nil(t, pw.Parse())
+	assert.Equal(t, []string{"f2f3", "e7e5", "g2g4", "d8h4"}, pw.Moves)
+}
+
+func TestMatchOpeningFromFen(t *testing.T) {
+	// Black to move, mate in one from the opening
+	fen := "rnbqkbnr/pppp1ppp/8/4p3/6P1/5P2/PPPPP2P/RNBQKBNR b KQkq g3 0 2"
+	script := fakeengine.DefaultScript()
+	script.Positions[fen] = &fakeengine.Response{Lines: []string{"bestmove d8h4"}}
+	useScripts(t, map[string]*fakeengine.Script{"one": script, "two": script})
+
+	m := newTestMatch("st=0.1")
+	m.Openings = []*Opening{{Name: "Fools", Fen: fen}}
+	res, err := m.Run(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(res.Games))
+
+	pgn := res.Games[0].PGN("")
+	assert.Contains(t, pgn, "[FEN \""+fen+"\"]")
+	assert.Contains(t, pgn, "[SetUp \"1\"]")
+	assert.Contains(t, pgn, "[Opening \"Fools\"]")
+	assert.Contains(t, pgn, "2... Qh4# {0.")
+}
+
+func TestMatchIllegalMove(t *testing.T) {
+	// The default answer is e2e4, illegal for black
+	useScripts(t, map[string]*fakeengine.Script{"one": fakeengine.DefaultScript(), "two": fakeengine.DefaultScript()})
+
+	m := newTestMatch("depth=1")
+	m.Rounds = 1
+	res, err := m.Run(context.Background())
+	assert.Nil(t, err)
+	for _, game := range res.Games {
+		assert.Equal(t, Result1_0, game.Result)
+		assert.Equal(t, TerminationIllegalMove, game.Termination)
+		assert.Equal(t, "Black makes an illegal move: e2e4", game.Reason)
+	}
+}
+
+func TestMatchTimeForfeit(t *testing.T) {
+	slow := fakeengine.DefaultScript()
+	slow.Default.DelayMs = 200
+	useScripts(t, map[string]*fakeengine.Script{"one": slow, "two": fakeengine.DefaultScript()})
+
+	m := newTestMatch("0.05")
+	m.TimeMargin = 10 * time.Millisecond
+	m.Openings = []*Opening{{Fen: ai.StartFen}}
+	res, err := m.Run(context.Background())
+	assert.Nil(t, err)
+
+	game := res.Games[0]
+	assert.Equal(t, "one", game.White)
+	assert.Equal(t, Result0_1, game.Result)
+	assert.Equal(t, TerminationTimeForfeit, game.Termination)
+	assert.Equal(t, "White loses on time", game.Reason)
+	assert.Equal(t, 0, len(game.Moves))
+}
+
+func TestMatchEngineCrash(t *testing.T) {
+	crash := fakeengine.DefaultScript()
+	crash.Default.Crash = true
+	crash.Default.ExitCode = 9
+	useScripts(t, map[string]*fakeengine.Script{"one": crash, "two": fakeengine.DefaultScript()})
+
+	m := newTestMatch("st=0.1")
+	res, err := m.Run(context.Background())
+	assert.Nil(t, err)
+
+	game := res.Games[0]
+	assert.Equal(t, Result0_1, game.Result)
+	assert.Equal(t, TerminationAbandoned, game.Termination)
+	assert.True(t, strings.HasPrefix(game.Reason, "White one: "), game.Reason)
+}
+
+func TestMatchEngineCannotStart(t *testing.T) {
+	script := fakeengine.DefaultScript()
+	script.CrashOnUci = 1
+	useScripts(t, map[string]*fakeengine.Script{"one": script, "two": fakeengine.DefaultScript()})
+
+	m := newTestMatch("st=0.1")
+	m.Rounds = 3
+	res, err := m.Run(context.Background())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "cannot start one")
+	assert.Equal(t, 0, len(res.Games))
+}
+
+func TestMatchSPRTStops(t *testing.T) {
+	script := lineScript(t, "f2f3", "e7e5", "g2g4", "d8h4")
+	useScripts(t, map[string]*fakeengine.Script{"one": script, "two": script})
+
+	// Even engines, far above the -400 of H0
+	m := newTestMatch("depth=1")
+	m.Rounds = 10
+	m.SPRT = &SPRT{Elo0: -400, Elo1: -200, Alpha: 0.3, Beta: 0.3}
+	res, err := m.Run(context.Background())
+	assert.Nil(t, err)
+	assert.Less(t, len(res.Games), 20)
+	assert.Equal(t, SPRTAcceptH1, res.SPRTStatus().Decision)
+	assert.Contains(t, res.Report(), "H1 accepted")
+
+	m.SPRT = &SPRT{Elo0: 10, Elo1: 0}
+	_, err = m.Run(context.Background())
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,73 @@
+package match
+
+import (
+	"fmt"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"os"
+	"strings"
+)
+
+// Opening - the start of a game, the position and the book moves played from it.
+type Opening struct {
+	Name  string
+	Fen   string
+	Moves []string // coordinate moves, ex: e2e4
+}
+
+// LoadOpenings - reads an opening suite, a pgn file or a file with a fen (or epd) per line.
+func LoadOpenings(path string) ([]*Opening, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	openings, err := ParseOpenings(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return openings, nil
+}
+
+// ParseOpenings - the openings of a pgn or of fen (or epd) lines, the epd id is the name.
+func ParseOpenings(text string) ([]*Opening, error) {
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "1.") {
+		return parsePgnOpenings(trimmed + "\n")
+	}
+
+	var openings []*Opening
+	for num, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		e, err := ai.ParseEpd(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", num+1, err)
+		}
+		openings = append(openings, &Opening{Name: e.Id, Fen: e.Fen})
+	}
+	return openings, nil
+}
+
+func parsePgnOpenings(text string) ([]*Opening, error) {
+	var openings []*Opening
+	pw := ai.NewPgnWrapper(text)
+	for !pw.IsEof() {
+		if err := pw.Parse(); err != nil {
+			return nil, fmt.Errorf("game %d: %w", len(openings)+1, err)
+		}
+		if len(pw.Moves) == 0 && len(pw.Attributes) == 0 {
+			continue
+		}
+		name := pw.Attributes["Opening"]
+		if len(name) == 0 {
+			name = pw.Attributes["Event"]
+		}
+		openings = append(openings, &Opening{
+			Name:  name,
+			Fen:   pw.InitialFen(),
+			Moves: append([]string{}, pw.Moves...),
+		})
+	}
+	return openings, nil
+}
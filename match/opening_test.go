@@ -0,0 +1,42 @@
+package match
+
+import (
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseOpeningsFen(t *testing.T) {
+	openings, err := ParseOpenings(`# suite
+rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1
+rnbqkbnr/pppppppp/8/8/3P4/8/PPP1PPPP/RNBQKBNR b KQkq - bm d5; id "queen pawn";
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(openings))
+	assert.Equal(t, "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1", openings[0].Fen)
+	assert.Equal(t, "rnbqkbnr/pppppppp/8/8/3P4/8/PPP1PPPP/RNBQKBNR b KQkq - 0 1", openings[1].Fen)
+	assert.Equal(t, "queen pawn", openings[1].Name)
+
+	_, err = ParseOpenings("not a fen\n")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "line 1")
+}
+
+func TestParseOpeningsPgn(t *testing.T) {
+	openings, err := ParseOpenings(`[Event "a"]
+[Opening "Italian"]
+
+1. e4 e5 2. Nf3 Nc6 3. Bc4 *
+
+[Event "b"]
+
+1. d4 d5 *
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(openings))
+	assert.Equal(t, "Italian", openings[0].Name)
+	assert.Equal(t, ai.StartFen, openings[0].Fen)
+	assert.Equal(t, []string{"e2e4", "e7e5", "g1f3", "b8c6", "f1c4"}, openings[0].Moves)
+	assert.Equal(t, "b", openings[1].Name)
+	assert.Equal(t, []string{"d2d4", "d7d5"}, openings[1].Moves)
+}
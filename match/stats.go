@@ -0,0 +1,185 @@
+package match
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// SPRT - the sequential probability ratio test of a match.
+// H0: the first engine is Elo0 stronger, H1: it is Elo1 stronger.
+// The match stops when one of them is accepted.
+type SPRT struct {
+	Elo0  float64
+	Elo1  float64
+	Alpha float64 // the false positive rate, accepting H1 when H0 is true
+	Beta  float64 // the false negative rate
+}
+
+// The SPRT decisions
+const (
+	SPRTContinue = ""
+	SPRTAcceptH0 = "H0"
+	SPRTAcceptH1 = "H1"
+)
+
+// SPRTStatus - the state of the test.
+type SPRTStatus struct {
+	LLR      float64 // the log likelihood ratio
+	Lower    float64 // H0 is accepted at or below this
+	Upper    float64 // H1 is accepted at or above this
+	Decision string
+}
+
+// EloStats - the strength of the first engine from the games played.
+type EloStats struct {
+	Games     int
+	Score     float64 // the points per game
+	Elo       float64 // the Elo difference
+	EloMargin float64 // the 95% error bar of the Elo difference
+	LOS       float64 // the likelihood of superiority, 0 to 1
+	DrawRatio float64
+}
+
+// Elo - the Elo difference of the score per game, 0 < score < 1.
+func Elo(score float64) float64 {
+	return -400 * math.Log10(1/score-1)
+}
+
+// ExpectedScore - the score per game of an Elo difference.
+func ExpectedScore(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// Stats - the Elo difference, error and likelihood of superiority.
+// The Elo is infinite when one engine has all the points.
+func (r *Result) Stats() *EloStats {
+	stats := &EloStats{Games: r.Wins + r.Draws + r.Losses}
+	if stats.Games == 0 {
+		return stats
+	}
+	n := float64(stats.Games)
+	stats.Score = r.Score() / n
+	stats.DrawRatio = float64(r.Draws) / n
+	stats.Elo = Elo(stats.Score)
+
+	dev := scoreVariance(r.Wins, r.Draws, r.Losses)
+	se := math.Sqrt(dev / n)
+	low := Elo(math.Max(stats.Score-1.959964*se, 0))
+	high := Elo(math.Min(stats.Score+1.959964*se, 1))
+	stats.EloMargin = (high - low) / 2
+
+	if decisive := r.Wins + r.Losses; decisive > 0 {
+		stats.LOS = 0.5 * (1 + math.Erf(float64(r.Wins-r.Losses)/math.Sqrt(2*float64(decisive))))
+	} else {
+		stats.LOS = 0.5
+	}
+	return stats
+}
+
+// scoreVariance - the variance of the points of one game.
+func scoreVariance(wins int, draws int, losses int) float64 {
+	n := float64(wins + draws + losses)
+	mean := (float64(wins) + float64(draws)/2) / n
+	return (float64(wins)*math.Pow(1-mean, 2) +
+		float64(draws)*math.Pow(0.5-mean, 2) +
+		float64(losses)*math.Pow(mean, 2)) / n
+}
+
+// Status - the state of the test for the games, the log likelihood ratio
+// uses the normal approximation of the score (the GSPRT).
+func (s *SPRT) Status(wins int, draws int, losses int) *SPRTStatus {
+	status := &SPRTStatus{
+		Lower: math.Log(s.Beta / (1 - s.Alpha)),
+		Upper: math.Log((1 - s.Beta) / s.Alpha),
+	}
+
+	n := float64(wins + draws + losses)
+	if n == 0 {
+		return status
+	}
+	dev := scoreVariance(wins, draws, losses)
+	if dev == 0 {
+		// Every game the same result, nothing can be said yet
+		return status
+	}
+	mean := (float64(wins) + float64(draws)/2) / n
+	s0 := ExpectedScore(s.Elo0)
+	s1 := ExpectedScore(s.Elo1)
+	status.LLR = n * (s1 - s0) * (2*mean - s0 - s1) / (2 * dev)
+
+	switch {
+	case status.LLR >= status.Upper:
+		status.Decision = SPRTAcceptH1
+	case status.LLR <= status.Lower:
+		status.Decision = SPRTAcceptH0
+	}
+	return status
+}
+
+// Validate - the test settings are usable.
+func (s *SPRT) Validate() error {
+	if s.Elo1 <= s.Elo0 {
+		return fmt.Errorf("sprt elo1 (%g) must be above elo0 (%g)", s.Elo1, s.Elo0)
+	}
+	if s.Alpha <= 0 || s.Alpha >= 0.5 || s.Beta <= 0 || s.Beta >= 0.5 {
+		return fmt.Errorf("sprt alpha and beta must be between 0 and 0.5")
+	}
+	return nil
+}
+
+// String - ex: +35.2 +/- 20.1, LOS: 99.9 %, DrawRatio: 40.0 %
+func (e *EloStats) String() string {
+	if e.Games == 0 {
+		return "no games"
+	}
+	return fmt.Sprintf("%s +/- %s, LOS: %.1f %%, DrawRatio: %.1f %%",
+		eloString(e.Elo), eloString(e.EloMargin), e.LOS*100, e.DrawRatio*100)
+}
+
+func eloString(elo float64) string {
+	switch {
+	case math.IsInf(elo, 1):
+		return "inf"
+	case math.IsInf(elo, -1):
+		return "-inf"
+	case math.IsNaN(elo):
+		return "nan"
+	}
+	return fmt.Sprintf("%.1f", elo)
+}
+
+// String - ex: LLR: 1.23 (-2.94, 2.94) [0.00, 5.00]
+func (s *SPRTStatus) String() string {
+	str := fmt.Sprintf("LLR: %.2f (%.2f, %.2f)", s.LLR, s.Lower, s.Upper)
+	switch s.Decision {
+	case SPRTAcceptH0:
+		str += " H0 accepted"
+	case SPRTAcceptH1:
+		str += " H1 accepted"
+	}
+	return str
+}
+
+// Report - the score, Elo and the test, ex:
+//
+//	Score of zahak - stockfish: 30 - 20 - 50  [0.550] 100
+//	Elo difference: 34.9 +/- 48.7, LOS: 92.1 %, DrawRatio: 50.0 %
+//	SPRT [0, 5]: LLR: 0.52 (-2.94, 2.94)
+func (r *Result) Report() string {
+	var sb strings.Builder
+	sb.WriteString("Score of " + r.ScoreTable() + "\n")
+	sb.WriteString("Elo difference: " + r.Stats().String() + "\n")
+	if r.SPRT != nil {
+		sb.WriteString(fmt.Sprintf("SPRT [%g, %g]: %s\n", r.SPRT.Elo0, r.SPRT.Elo1, r.SPRTStatus()))
+	}
+	return sb.String()
+}
+
+// SPRTStatus - the test of the match, nil without a test.
+func (r *Result) SPRTStatus() *SPRTStatus {
+	if r.SPRT == nil {
+		return nil
+	}
+	return r.SPRT.Status(r.Wins, r.Draws, r.Losses)
+}
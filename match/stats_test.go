@@ -0,0 +1,70 @@
+package match
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestEloStats(t *testing.T) {
+	r := &Result{Wins: 30, Draws: 50, Losses: 20}
+	stats := r.Stats()
+	assert.Equal(t, 100, stats.Games)
+	assert.InDelta(t, 0.55, stats.Score, 1e-9)
+	assert.InDelta(t, 34.86, stats.Elo, 0.01)
+	assert.InDelta(t, 48.5, stats.EloMargin, 0.1)
+	assert.InDelta(t, 0.921, stats.LOS, 0.001)
+	assert.InDelta(t, 0.5, stats.DrawRatio, 1e-9)
+	assert.Equal(t, "34.9 +/- 48.5, LOS: 92.1 %, DrawRatio: 50.0 %", stats.String())
+
+	even := (&Result{Wins: 5, Draws: 0, Losses: 5}).Stats()
+	assert.InDelta(t, 0, even.Elo, 1e-9)
+	assert.InDelta(t, 0.5, even.LOS, 1e-9)
+
+	all := (&Result{Wins: 3}).Stats()
+	assert.True(t, math.IsInf(all.Elo, 1))
+	assert.Contains(t, all.String(), "inf +/- ")
+
+	assert.Equal(t, "no games", (&Result{}).Stats().String())
+}
+
+func TestElo(t *testing.T) {
+	assert.InDelta(t, 0, Elo(0.5), 1e-9)
+	assert.InDelta(t, 190.8, Elo(0.75), 0.1)
+	assert.InDelta(t, 0.75, ExpectedScore(Elo(0.75)), 1e-9)
+}
+
+func TestSPRT(t *testing.T) {
+	s := &SPRT{Elo0: 0, Elo1: 10, Alpha: 0.05, Beta: 0.05}
+	assert.Nil(t, s.Validate())
+
+	status := s.Status(0, 0, 0)
+	assert.InDelta(t, -2.944, status.Lower, 0.001)
+	assert.InDelta(t, 2.944, status.Upper, 0.001)
+	assert.Equal(t, SPRTContinue, status.Decision)
+
+	// Clearly stronger
+	status = s.Status(600, 300, 400)
+	assert.Greater(t, status.LLR, status.Upper)
+	assert.Equal(t, SPRTAcceptH1, status.Decision)
+	assert.Contains(t, status.String(), "H1 accepted")
+
+	// Clearly not
+	status = s.Status(400, 300, 600)
+	assert.Equal(t, SPRTAcceptH0, status.Decision)
+
+	// Too early to say
+	status = s.Status(12, 20, 10)
+	assert.Equal(t, SPRTContinue, status.Decision)
+
+	assert.NotNil(t, (&SPRT{Elo0: 5, Elo1: 0, Alpha: 0.05, Beta: 0.05}).Validate())
+	assert.NotNil(t, (&SPRT{Elo0: 0, Elo1: 5}).Validate())
+}
+
+func TestResultReport(t *testing.T) {
+	r := &Result{Names: [2]string{"new", "old"}, Wins: 30, Draws: 50, Losses: 20, SPRT: &SPRT{Elo0: 0, Elo1: 5, Alpha: 0.05, Beta: 0.05}}
+	report := r.Report()
+	assert.Contains(t, report, "Score of new - old: 30 - 20 - 50  [0.550] 100\n")
+	assert.Contains(t, report, "Elo difference: 34.9 +/- 48.5")
+	assert.Contains(t, report, "SPRT [0, 5]: LLR: ")
+}
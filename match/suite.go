@@ -0,0 +1,268 @@
+package match
+
+/**
+Test suites, ex: WAC or STS.
+
+Each position of the suite is searched by the engine with a time or depth
+limit.  The move is solved when it is one of the bm moves and none of the am
+moves.  The time to solution is when the engine's main line started with a
+solving move and kept it until the end of the search.
+*/
+
+import (
+	"context"
+	"fmt"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"github.com/samlotti/chess_anaylzer/uci"
+	"os"
+	"sync"
+	"time"
+)
+
+// Suite - the settings of a test suite run.
+type Suite struct {
+	Engine      *EngineConfig
+	Positions   []*ai.Epd
+	TimeControl *TimeControl // a time per move (st=) or a depth
+	TimeMargin  time.Duration
+
+	// OnPosition - called with each position when searched.
+	OnPosition func(res *SuiteResult, report *SuiteReport)
+}
+
+// SuiteResult - the search of a position.
+type SuiteResult struct {
+	Epd       *ai.Epd
+	Move      string // the engine move in SAN
+	Uci       string
+	Solved    bool
+	SolveTime time.Duration // when the engine settled on the solution
+	TimeMs    int
+	Depth     int
+	ScoreCP   int
+	Err       error // the move could not be checked, ex: an illegal move
+}
+
+// SuiteReport - the results of the suite.
+type SuiteReport struct {
+	Engine  string
+	Results []*SuiteResult
+	Solved  int
+}
+
+// LoadEpd - reads an epd file.
+func LoadEpd(path string) ([]*ai.Epd, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	epds, err := ai.ReadEpd(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return epds, nil
+}
+
+// SolveRate - the part of the positions solved, 0 to 1.
+func (r *SuiteReport) SolveRate() float64 {
+	if len(r.Results) == 0 {
+		return 0
+	}
+	return float64(r.Solved) / float64(len(r.Results))
+}
+
+// MeanSolveTime - the mean time to solution of the positions solved.
+func (r *SuiteReport) MeanSolveTime() time.Duration {
+	if r.Solved == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, res := range r.Results {
+		if res.Solved {
+			total += res.SolveTime
+		}
+	}
+	return total / time.Duration(r.Solved)
+}
+
+// String - ex: zahak solved 250 of 300 (83.3 %), mean time to solution 0.42s
+func (r *SuiteReport) String() string {
+	return fmt.Sprintf("%s solved %d of %d (%.1f %%), mean time to solution %.2fs",
+		r.Engine, r.Solved, len(r.Results), r.SolveRate()*100, r.MeanSolveTime().Seconds())
+}
+
+// String - ex: WAC.001 Qg6 solved 0.12s
+func (r *SuiteResult) String() string {
+	switch {
+	case r.Err != nil:
+		return fmt.Sprintf("%s error: %s", r.Epd.Id, r.Err)
+	case r.Solved:
+		return fmt.Sprintf("%s %s solved %.2fs", r.Epd.Id, r.Move, r.SolveTime.Seconds())
+	}
+	return fmt.Sprintf("%s %s failed", r.Epd.Id, r.Move)
+}
+
+// suiteSearch - the main lines of a search.
+type suiteSearch struct {
+	lock     sync.Mutex
+	epd      *ai.Epd
+	start    time.Time
+	solvedAt time.Duration
+	solving  bool
+	last     *uci.UciInfo
+	bestMove chan struct{}
+}
+
+// info - tracks when the main line started with a solving move.
+func (s *suiteSearch) info(info *uci.UciInfo) {
+	if info.Err != nil || info.MPv > 1 || len(info.Moves) == 0 {
+		return
+	}
+	ok, _ := s.epd.Solves(info.Moves[0])
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.last = info
+	if ok && !s.solving {
+		s.solvedAt = time.Since(s.start)
+	}
+	s.solving = ok
+}
+
+// Run - searches every position, an error is returned when the engine fails.
+// The results so far are returned along with the error.
+func (s *Suite) Run(ctx context.Context) (*SuiteReport, error) {
+	if s.Engine == nil {
+		return nil, fmt.Errorf("a suite needs an engine")
+	}
+	if s.TimeControl == nil || s.TimeControl.IsClock() {
+		return nil, fmt.Errorf("a suite needs a time per move or a depth")
+	}
+	margin := s.TimeMargin
+	if margin == 0 {
+		margin = DefaultTimeMargin
+	}
+
+	report := &SuiteReport{Engine: s.Engine.Name}
+	p, err := startPlayer(ctx, s.Engine, s.TimeControl)
+	if err != nil {
+		return report, err
+	}
+	defer func() {
+		p.engine.SetAsyncChannel(nil)
+		uci.UciManager().Return(p.engine)
+	}()
+
+	var current *suiteSearch
+	var lock sync.Mutex
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		for {
+			select {
+			case cb := <-p.callbacks:
+				lock.Lock()
+				search := current
+				lock.Unlock()
+				if search == nil {
+					continue
+				}
+				if cb.Info != nil {
+					search.info(cb.Info)
+				}
+				if cb.BestMove != nil {
+					select {
+					case search.bestMove <- struct{}{}:
+					default:
+					}
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	for _, epd := range s.Positions {
+		search := &suiteSearch{epd: epd, bestMove: make(chan struct{}, 1)}
+		lock.Lock()
+		current = search
+		lock.Unlock()
+
+		res, err := s.search(ctx, p.engine, search, margin)
+		if err != nil {
+			return report, err
+		}
+		report.Results = append(report.Results, res)
+		if res.Solved {
+			report.Solved++
+		}
+		if s.OnPosition != nil {
+			s.OnPosition(res, report)
+		}
+	}
+	return report, nil
+}
+
+// search - the engine move for the position.
+func (s *Suite) search(ctx context.Context, engine uci.Engine, search *suiteSearch, margin time.Duration) (*SuiteResult, error) {
+	res := &SuiteResult{Epd: search.epd}
+
+	if err := engine.SendUciNewGame(ctx); err != nil {
+		return nil, err
+	}
+	if err := engine.SetPositionFen(search.epd.Fen); err != nil {
+		return nil, err
+	}
+	opts := uci.NewGoOptions()
+	wait := DepthTimeout
+	if s.TimeControl.MoveTimeMs > 0 {
+		opts.MoveTime = s.TimeControl.MoveTimeMs
+		wait = time.Duration(s.TimeControl.MoveTimeMs)*time.Millisecond + margin
+	} else {
+		opts.Depth = s.TimeControl.Depth
+	}
+
+	search.start = time.Now()
+	if err := engine.SendGo(opts); err != nil {
+		return nil, err
+	}
+	bm, err := engine.WaitMoveUpTo(ctx, wait)
+	res.TimeMs = int(time.Since(search.start).Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", search.epd.Id, err)
+	}
+	if bm == nil || len(bm.BestMove) == 0 {
+		return nil, fmt.Errorf("%s: the engine did not move", search.epd.Id)
+	}
+
+	// The infos before the bestmove have been seen
+	select {
+	case <-search.bestMove:
+	case <-time.After(time.Second):
+	}
+
+	res.Uci = bm.BestMove
+	brd := search.epd.Board()
+	mv, err := ai.MoveFromUci(brd, bm.BestMove)
+	if err != nil {
+		res.Move = bm.BestMove
+		res.Err = err
+		return res, nil
+	}
+	res.Move = ai.SanForMove(brd, mv)
+	res.Solved, res.Err = search.epd.Solves(bm.BestMove)
+
+	search.lock.Lock()
+	defer search.lock.Unlock()
+	if search.last != nil {
+		res.Depth = search.last.Depth
+		res.ScoreCP = search.last.ScoreCP
+	}
+	if res.Solved {
+		res.SolveTime = time.Duration(res.TimeMs) * time.Millisecond
+		if search.solving && search.last != nil && search.last.Moves[0] == bm.BestMove {
+			res.SolveTime = search.solvedAt
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,78 @@
+package match
+
+import (
+	"context"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"github.com/samlotti/chess_anaylzer/uci/fakeengine"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const testSuite = `# two positions
+r1bqkb1r/pppp1ppp/2n2n2/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - bm Qxf7#; id "scholar";
+rnbqkbnr/pppp1ppp/8/4p3/6P1/5P2/PPPPP2P/RNBQKBNR b KQkq - bm Qh4#; id "fool";
+`
+
+func TestSuiteRun(t *testing.T) {
+	epds, err := ai.ReadEpd(testSuite)
+	assert.Nil(t, err)
+
+	script := fakeengine.DefaultScript()
+	script.Positions[epds[0].Fen] = &fakeengine.Response{
+		DelayMs: 50,
+		Lines: []string{
+			"info depth 1 score cp 50 multipv 1 pv h5e5",
+			"info depth 2 score cp 15000 multipv 1 pv h5f7",
+			"info depth 3 score mate 1 multipv 1 pv h5f7",
+			"bestmove h5f7",
+		},
+	}
+	script.Positions[epds[1].Fen] = &fakeengine.Response{
+		Lines: []string{"info depth 1 score cp 10 multipv 1 pv d8e7", "bestmove d8e7"},
+	}
+	useScripts(t, map[string]*fakeengine.Script{"one": script})
+
+	var seen []string
+	tc, _ := ParseTimeControl("st=2")
+	s := &Suite{
+		Engine:      &EngineConfig{Name: "one"},
+		Positions:   epds,
+		TimeControl: tc,
+		OnPosition: func(res *SuiteResult, report *SuiteReport) {
+			seen = append(seen, res.String())
+		},
+	}
+	report, err := s.Run(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(report.Results))
+	assert.Equal(t, 1, report.Solved)
+	assert.Equal(t, 0.5, report.SolveRate())
+
+	res := report.Results[0]
+	assert.True(t, res.Solved)
+	assert.Equal(t, "Qxf7#", res.Move)
+	assert.Equal(t, 3, res.Depth)
+	assert.GreaterOrEqual(t, res.SolveTime, 80*time.Millisecond)
+	assert.Less(t, res.SolveTime, time.Duration(res.TimeMs)*time.Millisecond)
+
+	assert.False(t, report.Results[1].Solved)
+	assert.Equal(t, "Qe7", report.Results[1].Move)
+	assert.Equal(t, "fool Qe7 failed", seen[1])
+	assert.Contains(t, report.String(), "one solved 1 of 2 (50.0 %)")
+}
+
+func TestSuiteIllegalMove(t *testing.T) {
+	epds, _ := ai.ReadEpd(testSuite)
+	useScripts(t, map[string]*fakeengine.Script{"one": fakeengine.DefaultScript()})
+
+	tc, _ := ParseTimeControl("depth=1")
+	report, err := (&Suite{Engine: &EngineConfig{Name: "one"}, Positions: epds[1:], TimeControl: tc}).Run(context.Background())
+	assert.Nil(t, err)
+	assert.False(t, report.Results[0].Solved)
+	assert.NotNil(t, report.Results[0].Err)
+
+	tc, _ = ParseTimeControl("10+1")
+	_, err = (&Suite{Engine: &EngineConfig{Name: "one"}, Positions: epds, TimeControl: tc}).Run(context.Background())
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,86 @@
+package match
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeControl - the limits of every move.
+// Either a clock (Base and Inc), a fixed time per move or a depth.
+type TimeControl struct {
+	BaseMs     int
+	IncMs      int
+	MoveTimeMs int
+	Depth      int
+}
+
+// ParseTimeControl
+//
+//	60+0.6    60 seconds for the game, 0.6 seconds added per move
+//	st=0.5    0.5 seconds per move
+//	depth=10  search to depth 10
+func ParseTimeControl(str string) (*TimeControl, error) {
+	str = strings.TrimSpace(str)
+	switch {
+	case strings.HasPrefix(str, "st="):
+		ms, err := secondsToMs(strings.TrimPrefix(str, "st="))
+		if err != nil || ms <= 0 {
+			return nil, fmt.Errorf("invalid time per move: %s", str)
+		}
+		return &TimeControl{MoveTimeMs: ms}, nil
+	case strings.HasPrefix(str, "depth="):
+		depth, err := strconv.Atoi(strings.TrimPrefix(str, "depth="))
+		if err != nil || depth <= 0 {
+			return nil, fmt.Errorf("invalid depth: %s", str)
+		}
+		return &TimeControl{Depth: depth}, nil
+	}
+
+	sects := strings.SplitN(str, "+", 2)
+	base, err := secondsToMs(sects[0])
+	if err != nil || base <= 0 {
+		return nil, fmt.Errorf("invalid time control: %s", str)
+	}
+	tc := &TimeControl{BaseMs: base}
+	if len(sects) == 2 {
+		tc.IncMs, err = secondsToMs(sects[1])
+		if err != nil || tc.IncMs < 0 {
+			return nil, fmt.Errorf("invalid increment: %s", str)
+		}
+	}
+	return tc, nil
+}
+
+func secondsToMs(str string) (int, error) {
+	sec, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int(sec * 1000), nil
+}
+
+// IsClock - the game is played with a clock
+func (tc *TimeControl) IsClock() bool {
+	return tc.BaseMs > 0
+}
+
+// String - as the pgn TimeControl tag
+func (tc *TimeControl) String() string {
+	switch {
+	case tc.IsClock():
+		str := msToSeconds(tc.BaseMs)
+		if tc.IncMs > 0 {
+			str += "+" + msToSeconds(tc.IncMs)
+		}
+		return str
+	case tc.MoveTimeMs > 0:
+		return "0+" + msToSeconds(tc.MoveTimeMs)
+	}
+	return "-"
+}
+
+func msToSeconds(ms int) string {
+	return strconv.FormatFloat(time.Duration(ms*int(time.Millisecond)).Seconds(), 'f', -1, 64)
+}
@@ -0,0 +1,32 @@
+package match
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseTimeControl(t *testing.T) {
+	tc, err := ParseTimeControl("60+0.6")
+	assert.Nil(t, err)
+	assert.Equal(t, &TimeControl{BaseMs: 60000, IncMs: 600}, tc)
+	assert.Equal(t, "60+0.6", tc.String())
+
+	tc, err = ParseTimeControl("0.5")
+	assert.Nil(t, err)
+	assert.Equal(t, &TimeControl{BaseMs: 500}, tc)
+
+	tc, err = ParseTimeControl("st=0.25")
+	assert.Nil(t, err)
+	assert.Equal(t, &TimeControl{MoveTimeMs: 250}, tc)
+	assert.Equal(t, "0+0.25", tc.String())
+
+	tc, err = ParseTimeControl("depth=8")
+	assert.Nil(t, err)
+	assert.Equal(t, &TimeControl{Depth: 8}, tc)
+	assert.Equal(t, "-", tc.String())
+
+	for _, str := range []string{"", "abc", "10+x", "st=0", "depth=-1"} {
+		_, err = ParseTimeControl(str)
+		assert.NotNil(t, err, str)
+	}
+}
@@ -0,0 +1,477 @@
+/*
+ Analysis board.
+
+ The board has no chess rules of its own, the server knows the legal moves
+ (/chess/board/moves), plays them (/chess/board/move), reads the pgn
+ (/chess/board/pgn) and writes the engine lines in san (/chess/board/san).
+ The position shown is analyzed with the server-sent events of
+ /chess/ai/fen/events.
+*/
+(function () {
+    "use strict";
+
+    const START_FEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1";
+    const FILES = "abcdefgh";
+    const GLYPHS = {p: "♟", n: "♞", b: "♝", r: "♜", q: "♛", k: "♚"};
+
+    // The game: the positions with the move that led to each, 0 is the start
+    let positions = [{fen: START_FEN}];
+    let current = 0;
+    let flipped = false;
+
+    // The position shown
+    let legal = [];
+    let status = null;
+    let selected = null;
+
+    let events = null;
+    let pvLines = {};
+    let sanCache = {};
+
+    const $ = (id) => document.getElementById(id);
+
+    // -- server calls
+
+    async function getJSON(url, options) {
+        const resp = await fetch(url, options);
+        const body = await resp.json();
+        if (!resp.ok) {
+            throw new Error(body.error ? body.error.message : resp.statusText);
+        }
+        return body;
+    }
+
+    function boardUrl(name, args) {
+        return "/chess/board/" + name + "?" + new URLSearchParams(args).toString();
+    }
+
+    // -- the position
+
+    function fen() {
+        return positions[current].fen;
+    }
+
+    async function show(index) {
+        if (index < 0 || index >= positions.length) {
+            return;
+        }
+        current = index;
+        selected = null;
+        legal = [];
+        status = null;
+        draw();
+        drawMoves();
+        try {
+            const res = await getJSON(boardUrl("moves", {fen: fen()}));
+            if (index !== current) {
+                return;
+            }
+            legal = res.moves;
+            status = res;
+            showError("");
+        } catch (e) {
+            showError(e.message);
+        }
+        draw();
+        analyze();
+    }
+
+    async function play(uci) {
+        if (uci.length === 4 && !legal.some((m) => m.uci === uci)) {
+            uci += $("promotion").value;
+        }
+        if (!legal.some((m) => m.uci === uci)) {
+            selected = null;
+            draw();
+            return;
+        }
+        try {
+            const res = await getJSON(boardUrl("move", {fen: fen(), move: uci}));
+            const next = positions[current + 1];
+            if (next && next.uci === res.move.uci) {
+                // The move of the game, keep the moves after it
+                show(current + 1);
+                return;
+            }
+            positions = positions.slice(0, current + 1);
+            positions.push({fen: res.fen, uci: res.move.uci, san: res.move.san});
+            show(positions.length - 1);
+        } catch (e) {
+            showError(e.message);
+        }
+    }
+
+    async function setFen(value) {
+        try {
+            await getJSON(boardUrl("moves", {fen: value}));
+            positions = [{fen: value}];
+            show(0);
+        } catch (e) {
+            showError(e.message);
+        }
+    }
+
+    async function loadPgn(pgn) {
+        try {
+            const res = await getJSON("/chess/board/pgn", {
+                method: "POST",
+                body: new URLSearchParams({pgn: pgn}),
+            });
+            positions = [{fen: res.initialFen}].concat(res.plies.map((p) => ({fen: p.fen, uci: p.uci, san: p.san})));
+            show(0);
+        } catch (e) {
+            showError(e.message);
+        }
+    }
+
+    // -- drawing
+
+    function pieceAt(placement) {
+        const board = {};
+        placement.split("/").forEach((row, idx) => {
+            const rank = 8 - idx;
+            let file = 0;
+            for (const c of row) {
+                if (c >= "1" && c <= "8") {
+                    file += Number(c);
+                } else {
+                    board[FILES[file] + rank] = c;
+                    file++;
+                }
+            }
+        });
+        return board;
+    }
+
+    function sideToMove() {
+        return fen().split(" ")[1] === "b" ? "b" : "w";
+    }
+
+    function draw() {
+        const el = $("board");
+        el.innerHTML = "";
+        const pieces = pieceAt(fen().split(" ")[0]);
+        const last = positions[current].uci;
+        const targets = selected ? legal.filter((m) => m.uci.startsWith(selected)).map((m) => m.uci.substring(2, 4)) : [];
+
+        for (let row = 0; row < 8; row++) {
+            for (let col = 0; col < 8; col++) {
+                const file = flipped ? 7 - col : col;
+                const rank = flipped ? row + 1 : 8 - row;
+                const sq = FILES[file] + rank;
+
+                const div = document.createElement("div");
+                div.className = "square " + ((file + rank) % 2 === 0 ? "light" : "dark");
+                div.dataset.square = sq;
+                if (last && (last.substring(0, 2) === sq || last.substring(2, 4) === sq)) {
+                    div.classList.add("last");
+                }
+                if (sq === selected) {
+                    div.classList.add("selected");
+                }
+                if (targets.includes(sq)) {
+                    div.classList.add("target");
+                }
+
+                const piece = pieces[sq];
+                if (piece) {
+                    const color = piece === piece.toUpperCase() ? "w" : "b";
+                    if (piece.toLowerCase() === "k" && status && status.inCheck && color === sideToMove()) {
+                        div.classList.add("check");
+                    }
+                    const span = document.createElement("span");
+                    span.className = "piece " + color;
+                    span.textContent = GLYPHS[piece.toLowerCase()];
+                    div.appendChild(span);
+                }
+                if (row === 7) {
+                    div.appendChild(coord("file", FILES[file]));
+                }
+                if (col === 0) {
+                    div.appendChild(coord("rank", String(rank)));
+                }
+                el.appendChild(div);
+            }
+        }
+
+        let text = sideToMove() === "w" ? "White to move" : "Black to move";
+        if (status && status.status !== "ongoing") {
+            text = status.status;
+        }
+        $("status").textContent = text;
+        $("fen").value = fen();
+    }
+
+    function coord(kind, text) {
+        const span = document.createElement("span");
+        span.className = "coord " + kind;
+        span.textContent = text;
+        return span;
+    }
+
+    function drawMoves() {
+        const el = $("moves");
+        el.innerHTML = "";
+        positions.forEach((pos, idx) => {
+            if (idx === 0) {
+                return;
+            }
+            const plyFen = positions[idx - 1].fen.split(" ");
+            const white = plyFen[1] !== "b";
+            if (white || idx === 1) {
+                const num = document.createElement("span");
+                num.className = "num";
+                num.textContent = (plyFen[5] || "1") + (white ? "." : "...");
+                el.appendChild(num);
+            }
+            const span = document.createElement("span");
+            span.className = "move" + (idx === current ? " current" : "");
+            span.textContent = pos.san;
+            span.addEventListener("click", () => show(idx));
+            el.appendChild(span);
+        });
+        const cur = el.querySelector(".current");
+        if (cur) {
+            cur.scrollIntoView({block: "nearest"});
+        }
+    }
+
+    // -- the analysis
+
+    function analyze() {
+        if (events) {
+            events.close();
+            events = null;
+        }
+        pvLines = {};
+        drawLines();
+        if (!$("analyze").checked || !status || status.status !== "ongoing") {
+            return;
+        }
+
+        const analyzed = fen();
+        const args = new URLSearchParams({
+            fen: analyzed,
+            lines: $("lines").value,
+            depth: $("depth").value,
+            tsec: $("tsec").value,
+        });
+        const source = new EventSource("/chess/ai/fen/events?" + args.toString());
+        events = source;
+
+        source.addEventListener("queued", (e) => {
+            $("pvLines").textContent = "Waiting, place " + JSON.parse(e.data).queuePosition + " in the queue";
+        });
+        source.addEventListener("info", (e) => {
+            const info = JSON.parse(e.data).info;
+            if (!info || !info.moves || info.moves.length === 0 || info.isUserMove) {
+                return;
+            }
+            pvLines[info.pv || 1] = info;
+            drawLines();
+            toSan(analyzed, info.moves);
+        });
+        const end = () => {
+            source.close();
+            if (events === source) {
+                events = null;
+            }
+        };
+        source.addEventListener("done", end);
+        source.addEventListener("error", (e) => {
+            if (e.data) {
+                showError(JSON.parse(e.data).error);
+            }
+            end();
+        });
+    }
+
+    // whiteScore - the score of the line for white, a mate is 100 pawns
+    function whiteScore(info) {
+        let cp = info.mateIn ? (info.mateIn > 0 ? 10000 : -10000) : info.score;
+        return sideToMove() === "w" ? cp : -cp;
+    }
+
+    function scoreText(info) {
+        if (info.mateIn) {
+            const mate = sideToMove() === "w" ? info.mateIn : -info.mateIn;
+            return "#" + mate;
+        }
+        const cp = whiteScore(info);
+        return (cp > 0 ? "+" : "") + (cp / 100).toFixed(2);
+    }
+
+    function drawLines() {
+        const el = $("pvLines");
+        el.innerHTML = "";
+        const keys = Object.keys(pvLines).map(Number).sort((a, b) => a - b);
+        keys.forEach((key) => {
+            const info = pvLines[key];
+            const div = document.createElement("div");
+            div.className = "line";
+            const score = document.createElement("span");
+            score.className = "score";
+            score.textContent = scoreText(info);
+            div.appendChild(score);
+            const san = sanCache[fen() + " " + info.moves.join(" ")];
+            div.appendChild(document.createTextNode("d" + info.depth + "  " + (san || info.moves).join(" ")));
+            el.appendChild(div);
+        });
+
+        const best = pvLines[keys[0]];
+        if (best) {
+            drawEval(whiteScore(best), scoreText(best));
+        } else {
+            drawEval(0, "");
+        }
+    }
+
+    function drawEval(cp, text) {
+        // The chance to win of white, white is on the side of the board it plays from
+        const win = 50 + 50 * (2 / (1 + Math.exp(-0.00368208 * cp)) - 1);
+        $("evalWhite").style.height = win + "%";
+        $("evalWhite").style.top = flipped ? "0" : "";
+        $("evalWhite").style.bottom = flipped ? "" : "0";
+        $("evalText").textContent = text;
+    }
+
+    // toSan - the line in san, asked once for each line
+    async function toSan(forFen, moves) {
+        const key = forFen + " " + moves.join(" ");
+        if (key in sanCache) {
+            return;
+        }
+        sanCache[key] = null;
+        try {
+            const res = await getJSON(boardUrl("san", {fen: forFen, moves: moves.join(" ")}));
+            sanCache[key] = res.plies.map((p) => p.san);
+            if (forFen === fen()) {
+                drawLines();
+            }
+        } catch (e) {
+            // The uci is shown
+        }
+    }
+
+    function showError(msg) {
+        $("error").textContent = msg;
+    }
+
+    // -- dragging and clicking
+
+    let drag = null;
+
+    function squareAt(x, y) {
+        const el = document.elementFromPoint(x, y);
+        const sq = el && el.closest(".square");
+        return sq ? sq.dataset.square : null;
+    }
+
+    function canMoveFrom(sq) {
+        return legal.some((m) => m.uci.startsWith(sq));
+    }
+
+    $("board").addEventListener("pointerdown", (e) => {
+        const sq = squareAt(e.clientX, e.clientY);
+        if (!sq) {
+            return;
+        }
+        if (selected && selected !== sq && !canMoveFrom(sq)) {
+            // The second click of a move
+            play(selected + sq);
+            return;
+        }
+        if (!canMoveFrom(sq)) {
+            selected = null;
+            draw();
+            return;
+        }
+        selected = sq;
+        draw();
+
+        const piece = document.querySelector('.square[data-square="' + sq + '"] .piece');
+        const ghost = piece.cloneNode(true);
+        ghost.className = ghost.className.replace("piece", "drag");
+        ghost.style.left = e.clientX + "px";
+        ghost.style.top = e.clientY + "px";
+        document.body.appendChild(ghost);
+        piece.style.visibility = "hidden";
+        drag = {from: sq, ghost: ghost};
+        e.preventDefault();
+    });
+
+    document.addEventListener("pointermove", (e) => {
+        if (drag) {
+            drag.ghost.style.left = e.clientX + "px";
+            drag.ghost.style.top = e.clientY + "px";
+        }
+    });
+
+    document.addEventListener("pointerup", (e) => {
+        if (!drag) {
+            return;
+        }
+        const from = drag.from;
+        drag.ghost.remove();
+        drag = null;
+        const to = squareAt(e.clientX, e.clientY);
+        if (to && to !== from) {
+            play(from + to);
+        } else {
+            // Dropped back, the piece stays selected for a click move
+            draw();
+        }
+    });
+
+    // -- controls
+
+    $("first").addEventListener("click", () => show(0));
+    $("prev").addEventListener("click", () => show(current - 1));
+    $("next").addEventListener("click", () => show(current + 1));
+    $("last").addEventListener("click", () => show(positions.length - 1));
+    $("flip").addEventListener("click", flip);
+    $("setFen").addEventListener("click", () => setFen($("fen").value.trim()));
+    $("reset").addEventListener("click", () => setFen(START_FEN));
+    $("loadPgn").addEventListener("click", () => loadPgn($("pgn").value));
+    ["analyze", "lines", "depth", "tsec"].forEach((id) => $(id).addEventListener("change", analyze));
+
+    function flip() {
+        flipped = !flipped;
+        draw();
+        drawLines();
+    }
+
+    document.addEventListener("keydown", (e) => {
+        if (e.target.tagName === "INPUT" || e.target.tagName === "TEXTAREA" || e.target.tagName === "SELECT") {
+            return;
+        }
+        switch (e.key) {
+            case "ArrowLeft":
+                show(current - 1);
+                break;
+            case "ArrowRight":
+                show(current + 1);
+                break;
+            case "Home":
+                show(0);
+                break;
+            case "End":
+                show(positions.length - 1);
+                break;
+            case "f":
+                flip();
+                break;
+            default:
+                return;
+        }
+        e.preventDefault();
+    });
+
+    // A fen or pgn in the url, board.html?fen=...
+    const params = new URLSearchParams(location.search);
+    if (params.get("fen")) {
+        setFen(params.get("fen"));
+    } else {
+        show(0);
+    }
+})();
@@ -1,28 +1,259 @@
 package uci
 
-import "fmt"
+import (
+	"context"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"log/slog"
+	"path/filepath"
+	"sync"
+)
+
+// TransportFactory - creates the transport for the named engine,
+// a nil transport runs the local binary.
+type TransportFactory func(engine string) (Transport, error)
+
+// EngineFactory - creates the named engine, not started.
+type EngineFactory func(engine string) (Engine, error)
+
+// Protocol - how an engine is spoken to.
+type Protocol int
+
+const (
+	ProtocolUci  Protocol = 0
+	ProtocolCecp Protocol = 1
+)
 
 type _UciManager struct {
+	lock       sync.Mutex
+	transports TransportFactory
+	engines    EngineFactory
+	protocols  map[string]Protocol
+	binaries   map[string]string
+
+	// transcriptDir - if set every engine session is recorded here
+	transcriptDir string
+}
+
+// SetTranscriptDir - records a transcript of every engine session to a new
+// file in the directory, empty turns the recording off.
+func (m *_UciManager) SetTranscriptDir(dir string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.transcriptDir = dir
+}
+
+// TranscriptDir - the directory of the transcripts, empty when not recorded.
+func (m *_UciManager) TranscriptDir() string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.transcriptDir
+}
+
+// SetTransportFactory - replaces how engines are reached,
+// nil runs the local binaries in the Environment.EnginePath.
+func (m *_UciManager) SetTransportFactory(f TransportFactory) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.transports = f
+}
+
+// SetEngineFactory - replaces how engines are created, the engine
+// is started by GetEngine.  nil uses the protocol of the engine.
+func (m *_UciManager) SetEngineFactory(f EngineFactory) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.engines = f
+}
+
+// SetProtocol - the protocol of the engine, ProtocolUci if not set.
+func (m *_UciManager) SetProtocol(engine string, protocol Protocol) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.protocols == nil {
+		m.protocols = make(map[string]Protocol)
+	}
+	m.protocols[engine] = protocol
+}
+
+// GetProtocol - the protocol of the engine.
+func (m *_UciManager) GetProtocol(engine string) Protocol {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.protocols[engine]
+}
+
+// SetBinary - the binary of the engine, relative to the Environment.EnginePath
+// unless absolute.  The binary is the name of the engine if not set.
+func (m *_UciManager) SetBinary(engine string, binary string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.binaries == nil {
+		m.binaries = make(map[string]string)
+	}
+	m.binaries[engine] = binary
+}
+
+// BinaryPath - the path of the binary of the engine.
+func (m *_UciManager) BinaryPath(engine string) string {
+	m.lock.Lock()
+	binary, ok := m.binaries[engine]
+	m.lock.Unlock()
+	if !ok || len(binary) == 0 {
+		binary = engine
+	}
+	if filepath.IsAbs(binary) {
+		return binary
+	}
+	return common.Environment.EnginePath + binary
+}
+
+// GetEngine - starts the engine and sets up a new game.
+// The engine is created by the engine factory or for its protocol.
+func (m *_UciManager) GetEngine(ctx context.Context, engine string) (Engine, error) {
+	m.lock.Lock()
+	f := m.engines
+	m.lock.Unlock()
+
+	if f == nil {
+		// A nil process must not become a non nil Engine
+		if m.GetProtocol(engine) == ProtocolCecp {
+			c, err := m.GetCecp(ctx, engine)
+			if err != nil {
+				return nil, err
+			}
+			return c, nil
+		}
+		u, err := m.GetUci(ctx, engine)
+		if err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+
+	e, err := f(engine)
+	if err != nil {
+		return nil, err
+	}
+	log := engineLogger(ctx, engine, nil)
+	if l, ok := e.(interface{ SetLogger(*slog.Logger) }); ok {
+		l.SetLogger(log)
+	}
+	err = e.Start(ctx)
+	if err == nil {
+		err = e.SendUciNewGame(ctx)
+	}
+	if err != nil {
+		e.Terminate()
+		return nil, err
+	}
+	log.Info("engine started")
+	return e, nil
+}
+
+// engineLogger - the logger of the context with the engine and its transcript.
+func engineLogger(ctx context.Context, engine string, transcript *Transcript) *slog.Logger {
+	log := common.Logger(ctx).With(common.LogEngine, engine)
+	if path := transcript.Path(); len(path) > 0 {
+		log = log.With(common.LogTranscript, path)
+	}
+	return log
+}
+
+// newTransport - the transport to the engine, nil for the local binary.
+// Also the transcript to record to, if recording.
+func (m *_UciManager) newTransport(ctx context.Context, engine string) (Transport, *Transcript, error) {
+	m.lock.Lock()
+	f := m.transports
+	dir := m.transcriptDir
+	m.lock.Unlock()
+
+	var t Transport
+	if f != nil {
+		var err error
+		t, err = f(engine)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var transcript *Transcript
+	if len(dir) > 0 {
+		var err error
+		transcript, err = CreateTranscript(TranscriptPath(dir, engine))
+		if err != nil {
+			// The analysis does not depend on it
+			common.Logger(ctx).Warn("cannot record transcript", common.LogEngine, engine, "err", err)
+		}
+	}
+	return t, transcript, nil
+}
+
+func (m *_UciManager) newUci(ctx context.Context, engine string) (*UciProcess, error) {
+	t, transcript, err := m.newTransport(ctx, engine)
+	if err != nil {
+		return nil, err
+	}
+
+	var u *UciProcess
+	if t == nil {
+		u = NewUci(engine)
+	} else {
+		u = NewUciTransport(engine, t)
+	}
+	if transcript != nil {
+		u.SetTranscript(transcript)
+	}
+	u.SetLogger(engineLogger(ctx, engine, transcript))
+	return u, nil
 }
 
-func (m *_UciManager) GetUci(engine string) (*UciProcess, error) {
-	if Verbose {
-		fmt.Printf("Get UCI: %s\n", engine)
+// GetUci - starts the engine and sends ucinewgame.
+func (m *_UciManager) GetUci(ctx context.Context, engine string) (*UciProcess, error) {
+	u, err := m.newUci(ctx, engine)
+	if err != nil {
+		return nil, err
+	}
+	err = u.Start(ctx)
+	if err == nil {
+		err = u.SendUciNewGame(ctx)
 	}
-	u := NewUci(engine)
-	err := u.Start()
 	if err != nil {
+		u.Terminate()
 		return nil, err
 	}
-	err = u.SendUciNewGame()
-	return u, err
+	u.log.Info("engine started")
+	return u, nil
 }
 
-func (m *_UciManager) Return(uci *UciProcess) {
-	uci.Terminate()
-	if Verbose {
-		fmt.Printf("End UCI\n")
+// GetCecp - starts the xboard engine and sets up a new game.
+func (m *_UciManager) GetCecp(ctx context.Context, engine string) (*CecpProcess, error) {
+	t, transcript, err := m.newTransport(ctx, engine)
+	if err != nil {
+		return nil, err
 	}
+
+	var c *CecpProcess
+	if t == nil {
+		c = NewCecp(engine)
+	} else {
+		c = NewCecpTransport(engine, t)
+	}
+	if transcript != nil {
+		c.SetTranscript(transcript)
+	}
+	c.SetLogger(engineLogger(ctx, engine, transcript))
+
+	if err = c.Start(ctx); err != nil {
+		c.Terminate()
+		return nil, err
+	}
+	c.log.Info("engine started")
+	return c, nil
+}
+
+// Return - ends the engine.
+func (m *_UciManager) Return(engine Engine) {
+	engine.Terminate()
 }
 
 var _manager *_UciManager = &_UciManager{}
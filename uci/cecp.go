@@ -0,0 +1,857 @@
+package uci
+
+/**
+CECP (xboard / winboard) engines.
+
+https://www.gnu.org/software/xboard/engine-intf.html
+
+Driven like a UciProcess so the analyzer works unchanged.  The position is
+set with setboard and the search runs in analyze mode, the post output is
+converted to UciInfo with the pv as coordinate moves.  Analyze mode has no
+bestmove, a stop (or reaching the depth of the go) leaves analyze mode and
+the first move of the last pv is the bestmove.  There are no searchmoves,
+the move is played and the reply analyzed, the pv then starts with the move.
+*/
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	ai "github.com/samlotti/chess_anaylzer/chessboard"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CecpFeatureTimeout - engines that do not know protover 2 send no features,
+// the handshake ends after this wait.
+const CecpFeatureTimeout = 2 * time.Second
+
+// cecpMateScore - mate in N is posted as 100000 + N
+const cecpMateScore = 100000
+
+// cecpFeatures - the features the adapter acts on, the others are rejected
+// so the engine does not wait for commands that are never sent.
+var cecpFeatures = map[string]bool{
+	"myname":   true,
+	"setboard": true,
+	"ping":     true,
+	"analyze":  true,
+	"memory":   true,
+	"smp":      true,
+	"option":   true,
+	"done":     true,
+}
+
+type CecpProcess struct {
+	lock      sync.Mutex
+	Engine    string
+	transport Transport
+	state     UciState
+	stdin     io.ReadCloser
+	stdout    io.WriteCloser
+	sendLock  sync.Mutex
+
+	// features - sent by the engine during the handshake
+	features     map[string]string
+	featuresDone chan struct{} // closed on feature done=1
+	featuresWait bool          // feature done=0, wait for done=1 without the CecpFeatureTimeout
+	handshake    bool          // the handshake completed
+
+	fen        string // the position set
+	boardFen   string // the position the engine has
+	searchMove string // played before the search, see SendGo
+	depth      int    // the search ends at this depth, 0 = until stopped
+	searching  bool
+	lastInfo   *UciInfo
+
+	pingNum   int
+	pong      chan int          // the number of every pong
+	stalePong int               // the post output is from a stopped search until this pong
+	bestmove  chan *UciBestMove // one entry for every completed search
+	exited    chan struct{}     // closed when the engine process has ended
+
+	exitErr    *EngineExitError
+	terminated bool
+
+	// emitLock - keeps the callbacks of the monitor and a stop in order
+	emitLock sync.Mutex
+	callback chan *UciCallback
+
+	transcript *Transcript
+	log        *slog.Logger
+
+	// Options - the engine options, from feature option="..."
+	Options map[string]string
+}
+
+// NewCecp - creates a new instance of the xboard engine!
+// The engine binary is in the Environment.EnginePath
+func NewCecp(engine string) *CecpProcess {
+	return NewCecpTransport(engine, NewExecTransport(UciManager().BinaryPath(engine)))
+}
+
+// NewCecpTransport - creates a new instance of the xboard engine reached through the transport.
+func NewCecpTransport(engine string, transport Transport) *CecpProcess {
+	return &CecpProcess{
+		Engine:       engine,
+		transport:    transport,
+		state:        UciNotStarted,
+		features:     make(map[string]string),
+		featuresDone: make(chan struct{}),
+		pong:         make(chan int, 1),
+		bestmove:     make(chan *UciBestMove, 1),
+		exited:       make(chan struct{}),
+		Options:      make(map[string]string),
+		log:          common.Log().With(common.LogEngine, engine),
+	}
+}
+
+// SetTranscript - records every line sent and received, call before Start.
+// The transcript is closed when the engine ends.
+func (p *CecpProcess) SetTranscript(t *Transcript) {
+	p.transcript = t
+}
+
+// SetLogger - the logger of the engine, call before Start.
+func (p *CecpProcess) SetLogger(l *slog.Logger) {
+	p.log = l
+}
+
+// Start - starts the engine and waits for the protover 2 features.
+// If the context has no deadline the HandshakeTimeout is used.
+func (p *CecpProcess) Start(ctx context.Context) error {
+	var err error
+
+	p.stdin, p.stdout, err = p.transport.Start()
+	if err != nil {
+		p.setState(UciFailed)
+		p.transcript.Note(fmt.Sprintf("%s: %s", transcriptExited, err))
+		_ = p.transcript.Close()
+		return err
+	}
+	p.setState(UciRunning)
+
+	go p.monitor()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, HandshakeTimeout)
+		defer cancel()
+	}
+
+	if err = p.send("xboard"); err != nil {
+		return err
+	}
+	if err = p.send("protover 2"); err != nil {
+		return err
+	}
+
+	if err = p.waitFeatures(ctx); err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	setboard := p.features["setboard"]
+	analyze := p.features["analyze"]
+	p.lock.Unlock()
+	switch setboard {
+	case "1":
+	case "":
+		return fmt.Errorf("engine %s does not support setboard, it sent no feature setboard=1 (a protover 1 engine?)", p.Engine)
+	default:
+		return fmt.Errorf("engine %s does not support setboard, it sent feature setboard=%s, the position can not be set", p.Engine, setboard)
+	}
+	if analyze == "0" {
+		return fmt.Errorf("engine %s does not support analyze", p.Engine)
+	}
+
+	if err = p.newGame(); err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	p.handshake = true
+	p.lock.Unlock()
+
+	return p.WaitReady(ctx)
+}
+
+// waitFeatures - waits for feature done=1
+func (p *CecpProcess) waitFeatures(ctx context.Context) error {
+	timer := time.NewTimer(CecpFeatureTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-p.featuresDone:
+			return nil
+		case <-p.exited:
+			return p.exitError()
+		case <-ctx.Done():
+			return fmt.Errorf("timeout waiting for features: %w", ctx.Err())
+		case <-timer.C:
+			p.lock.Lock()
+			wait := p.featuresWait
+			p.lock.Unlock()
+			if !wait {
+				// protover 1 engine
+				return nil
+			}
+		}
+	}
+}
+
+// newGame - a new game with the engine in force mode, so it never moves.
+func (p *CecpProcess) newGame() error {
+	p.lock.Lock()
+	p.boardFen = ""
+	p.lock.Unlock()
+
+	for _, cmd := range []string{"new", "force", "post", "easy"} {
+		if err := p.send(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitReady - sends a ping and waits for the pong.
+// Waits at most ReadyTimeout.  Engines without the ping feature are always ready.
+func (p *CecpProcess) WaitReady(ctx context.Context) error {
+	p.lock.Lock()
+	if p.features["ping"] != "1" {
+		p.lock.Unlock()
+		return nil
+	}
+	p.pingNum++
+	num := p.pingNum
+	p.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, ReadyTimeout)
+	defer cancel()
+
+	if err := p.send(fmt.Sprintf("ping %d", num)); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case n := <-p.pong:
+			if n == num {
+				return nil
+			}
+		case <-p.exited:
+			return p.exitError()
+		case <-ctx.Done():
+			return fmt.Errorf("timeout waiting for engine pong: %w", ctx.Err())
+		}
+	}
+}
+
+// monitor - reads the engine output, see UciProcess.monitor
+func (p *CecpProcess) monitor() {
+	defer func() {
+		if r := recover(); r != nil {
+			p.log.Error("engine monitor failed", "panic", r)
+		}
+		p.waitExit()
+		close(p.exited)
+	}()
+	scanner := bufio.NewScanner(p.stdin)
+	for scanner.Scan() {
+		txt := scanner.Text()
+		p.transcript.Received(txt)
+		p.log.Debug("from engine", "line", txt)
+
+		sects := strings.Fields(txt)
+		if len(sects) == 0 {
+			continue
+		}
+
+		switch {
+		case sects[0] == "feature":
+			p.addFeatures(txt)
+		case sects[0] == "pong" && len(sects) > 1:
+			if n, err := strconv.Atoi(sects[1]); err == nil {
+				p.lock.Lock()
+				if n == p.stalePong {
+					p.stalePong = 0
+				}
+				p.lock.Unlock()
+				select {
+				case p.pong <- n:
+				default:
+					// a stale pong, replace it
+					select {
+					case <-p.pong:
+					default:
+					}
+					p.pong <- n
+				}
+			}
+		case strings.HasPrefix(txt, "Error") || strings.HasPrefix(txt, "Illegal move"):
+			p.emit(&UciCallback{Raw: txt, Err: fmt.Errorf("%s", txt)}, false)
+		default:
+			if info := p.parseThinking(txt); info != nil {
+				p.addInfo(txt, info)
+			}
+		}
+	}
+}
+
+// waitExit - collects the exit of the process, see UciProcess.waitExit
+func (p *CecpProcess) waitExit() {
+	err := p.transport.Wait()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	defer p.transcript.Close()
+
+	p.searching = false
+	if p.terminated {
+		p.state = UciStopped
+		p.transcript.Note(transcriptTerminated)
+		p.log.Debug("engine ended")
+		return
+	}
+
+	p.state = UciFailed
+	p.exitErr = &EngineExitError{
+		Engine: p.Engine,
+		Err:    err,
+		Stderr: p.transport.Stderr(),
+	}
+	p.transcript.Note(fmt.Sprintf("%s: %s", transcriptExited, p.exitErr))
+	p.log.Warn("engine exited unexpectedly", "err", p.exitErr)
+}
+
+// ExitError - the reason the engine ended on its own, nil while running
+// or if it was terminated.
+func (p *CecpProcess) ExitError() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.exitErr == nil {
+		return nil
+	}
+	return p.exitErr
+}
+
+func (p *CecpProcess) exitError() error {
+	if err := p.ExitError(); err != nil {
+		return err
+	}
+	return fmt.Errorf("engine %s has been terminated", p.Engine)
+}
+
+// addFeatures - feature myname="Crafty 25.2" setboard=1 ping=1 done=1
+// The cecpFeatures are accepted, options are kept in the Options.
+func (p *CecpProcess) addFeatures(txt string) {
+	for _, kv := range parseFeatures(strings.TrimPrefix(txt, "feature")) {
+		name, val := kv[0], kv[1]
+		if !cecpFeatures[name] {
+			_ = p.send("rejected " + name)
+			continue
+		}
+		_ = p.send("accepted " + name)
+
+		p.lock.Lock()
+		switch name {
+		case "option":
+			// option="MultiPV -spin 1 1 500"
+			sects := strings.SplitN(val, " ", 2)
+			if len(sects) == 2 {
+				p.Options[sects[0]] = sects[1]
+			}
+		case "done":
+			if val == "1" {
+				select {
+				case <-p.featuresDone:
+				default:
+					close(p.featuresDone)
+				}
+			} else {
+				p.featuresWait = true
+			}
+		default:
+			p.features[name] = val
+		}
+		p.lock.Unlock()
+	}
+}
+
+// parseFeatures - the name=value pairs, values may be quoted.
+func parseFeatures(txt string) [][2]string {
+	var res [][2]string
+	txt = strings.TrimSpace(txt)
+	for len(txt) > 0 {
+		eq := strings.Index(txt, "=")
+		if eq < 0 {
+			break
+		}
+		name := strings.TrimSpace(txt[:eq])
+		txt = txt[eq+1:]
+
+		var val string
+		if strings.HasPrefix(txt, "\"") {
+			end := strings.Index(txt[1:], "\"")
+			if end < 0 {
+				end = len(txt) - 1
+			}
+			val = txt[1 : end+1]
+			txt = txt[min(end+2, len(txt)):]
+		} else {
+			end := strings.Index(txt, " ")
+			if end < 0 {
+				end = len(txt)
+			}
+			val = txt[:end]
+			txt = txt[end:]
+		}
+		res = append(res, [2]string{name, val})
+		txt = strings.TrimSpace(txt)
+	}
+	return res
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// parseThinking - the post output while analyzing
+//
+//	ply score time nodes pv
+//	9 156 1084 48000 Nf3 Nc6 Nc3 Nf6
+//	9 156 1084 48000 12 44280 0	Nf3 Nc6 Nc3 Nf6
+//
+// The time is in centiseconds, after a tab the fields before it are extensions.
+// Returns nil for other lines.
+func (p *CecpProcess) parseThinking(txt string) *UciInfo {
+	head, pv := txt, ""
+	if tab := strings.Index(txt, "\t"); tab >= 0 {
+		head, pv = txt[:tab], txt[tab+1:]
+	}
+	sects := strings.Fields(head)
+	if len(sects) < 4 {
+		return nil
+	}
+
+	var nums [4]int
+	for i := 0; i < 4; i++ {
+		str := sects[i]
+		if i == 0 {
+			// Some engines mark the ply, ex: 12. or 12&
+			str = strings.TrimRight(str, ".&*+-")
+		}
+		n, err := strconv.Atoi(str)
+		if err != nil {
+			return nil
+		}
+		nums[i] = n
+	}
+	if len(pv) == 0 {
+		pv = strings.Join(sects[4:], " ")
+	}
+
+	info := &UciInfo{
+		Depth:   nums[0],
+		MPv:     1,
+		ScoreCP: nums[1],
+	}
+	if nums[1] >= cecpMateScore {
+		info.MateIn = nums[1] - cecpMateScore
+		info.ScoreCP = 15000 + info.MateIn
+	} else if nums[1] <= -cecpMateScore {
+		info.MateIn = nums[1] + cecpMateScore
+		info.ScoreCP = -15000 + info.MateIn
+	}
+	if nums[2] > 0 {
+		info.Nps = nums[3] * 100 / nums[2]
+	}
+
+	p.lock.Lock()
+	fen, searchMove := p.boardFen, p.searchMove
+	p.lock.Unlock()
+
+	info.Moves = pvToCoordinates(fen, pv)
+	if len(searchMove) > 0 {
+		// The score is for the reply
+		info.Moves = append([]string{searchMove}, info.Moves...)
+		info.ScoreCP = -info.ScoreCP
+		info.MateIn = -info.MateIn
+	}
+	return info
+}
+
+// pvToCoordinates - the pv in SAN (or coordinates) as coordinate moves,
+// stops at the first move that cannot be read.
+func pvToCoordinates(fen string, pv string) []string {
+	brd := ai.NewBoard()
+	if ai.ParseFen(brd, fen) != nil {
+		return nil
+	}
+
+	var moves []string
+	for _, tok := range strings.Fields(pv) {
+		tok = strings.Trim(tok, "(){}[]<>")
+		if len(tok) == 0 || tok == "HT" || strings.HasSuffix(tok, ".") || isMoveNumber(tok) {
+			continue
+		}
+		mv, err := ai.MoveFromSAN(brd, tok)
+		if err != nil {
+			mv, err = ai.MoveFromUci(brd, tok)
+		}
+		if err != nil {
+			break
+		}
+		moves = append(moves, ai.MoveToString(mv))
+		if brd.MakeMove(mv, tok) != nil {
+			break
+		}
+	}
+	return moves
+}
+
+// isMoveNumber - 12. or 12...
+func isMoveNumber(tok string) bool {
+	tok = strings.TrimRight(tok, ".")
+	_, err := strconv.Atoi(tok)
+	return err == nil
+}
+
+// addInfo - passes the info on while searching, ends the search at its depth.
+func (p *CecpProcess) addInfo(txt string, info *UciInfo) {
+	p.lock.Lock()
+	searching := p.searching && p.stalePong == 0
+	if searching {
+		p.lastInfo = info
+	}
+	depthReached := p.depth > 0 && info.Depth >= p.depth
+	p.lock.Unlock()
+
+	if !searching {
+		return
+	}
+	p.emit(&UciCallback{Raw: txt, Info: info}, true)
+
+	if depthReached {
+		p.finishSearch()
+	}
+}
+
+// emit - sends to the callback, with onlySearching it is dropped
+// once the search has ended.
+func (p *CecpProcess) emit(data *UciCallback, onlySearching bool) {
+	p.emitLock.Lock()
+	defer p.emitLock.Unlock()
+
+	p.lock.Lock()
+	callback := p.callback
+	searching := p.searching
+	p.lock.Unlock()
+
+	if callback == nil || (onlySearching && !searching) {
+		return
+	}
+	callback <- data
+}
+
+// finishSearch - leaves analyze mode, the bestmove is the first move of the last pv.
+// Returns nil if not searching.
+func (p *CecpProcess) finishSearch() *UciBestMove {
+	p.emitLock.Lock()
+	defer p.emitLock.Unlock()
+
+	p.lock.Lock()
+	if !p.searching {
+		p.lock.Unlock()
+		return nil
+	}
+	p.searching = false
+	info := p.lastInfo
+	callback := p.callback
+	p.lock.Unlock()
+
+	_ = p.send("exit")
+	p.syncOutput()
+
+	raw := "bestmove 0000"
+	if info != nil && len(info.Moves) > 0 {
+		raw = "bestmove " + info.Moves[0]
+		if len(info.Moves) > 1 {
+			raw += " ponder " + info.Moves[1]
+		}
+	}
+	bm := UciBestMoveParse(raw)
+
+	if callback != nil {
+		callback <- &UciCallback{Raw: raw, BestMove: bm}
+	}
+
+	select {
+	case <-p.bestmove:
+	default:
+	}
+	p.bestmove <- bm
+	return bm
+}
+
+// syncOutput - the engine may post a little more before it sees the exit,
+// with the ping feature that output is skipped up to the pong.
+func (p *CecpProcess) syncOutput() {
+	p.lock.Lock()
+	if p.features["ping"] != "1" {
+		p.lock.Unlock()
+		return
+	}
+	p.pingNum++
+	num := p.pingNum
+	p.stalePong = num
+	p.lock.Unlock()
+
+	_ = p.send(fmt.Sprintf("ping %d", num))
+}
+
+// send - Sends a line to the chess engine.
+func (p *CecpProcess) send(line string) error {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+
+	p.transcript.Sent(line)
+	p.log.Debug("to engine", "line", line)
+	if _, err := fmt.Fprint(p.stdout, line+"\n"); err != nil {
+		select {
+		case <-p.exited:
+			return p.exitError()
+		case <-time.After(StopTimeout):
+			return err
+		}
+	}
+	return nil
+}
+
+// Terminate - Terminate the process
+func (p *CecpProcess) Terminate() {
+	p.lock.Lock()
+	p.terminated = true
+	p.state = UciStopped
+	p.lock.Unlock()
+
+	if p.stdout == nil {
+		// never started
+		return
+	}
+	_ = p.stdout.Close()
+	_ = p.stdin.Close()
+	_ = p.transport.Kill()
+}
+
+func (p *CecpProcess) GetState() UciState {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.state
+}
+
+func (p *CecpProcess) setState(state UciState) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.state = state
+}
+
+// IsSearching - true while analyzing
+func (p *CecpProcess) IsSearching() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.searching
+}
+
+// IsReadyForMove - handshake completed, running and not searching.
+func (p *CecpProcess) IsReadyForMove() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.handshake && p.state == UciRunning && !p.searching
+}
+
+func (p *CecpProcess) checkReady() error {
+	select {
+	case <-p.exited:
+		return p.exitError()
+	default:
+	}
+	if !p.IsReadyForMove() {
+		return fmt.Errorf("engine not in ready state")
+	}
+	return nil
+}
+
+// SetPositionFen - Will set the position
+// The engine must be in the ready state
+func (p *CecpProcess) SetPositionFen(fen string) error {
+	if err := p.checkReady(); err != nil {
+		return err
+	}
+	p.lock.Lock()
+	p.fen = fen
+	p.lock.Unlock()
+	return p.setBoard(fen)
+}
+
+func (p *CecpProcess) setBoard(fen string) error {
+	p.lock.Lock()
+	same := p.boardFen == fen
+	p.lock.Unlock()
+	if same {
+		return nil
+	}
+
+	if err := p.send("setboard " + fen); err != nil {
+		return err
+	}
+	p.lock.Lock()
+	p.boardFen = fen
+	p.lock.Unlock()
+	return nil
+}
+
+// SetOption - set the options for the engine.
+// Hash and Threads are sent as memory and cores, options the engine
+// does not have are ignored.
+func (p *CecpProcess) SetOption(name string, val string) error {
+	p.lock.Lock()
+	_, hasOption := p.Options[name]
+	memory := p.features["memory"] == "1"
+	smp := p.features["smp"] == "1"
+	p.lock.Unlock()
+
+	switch {
+	case hasOption:
+		return p.send(fmt.Sprintf("option %s=%s", name, val))
+	case name == "Hash" && memory:
+		return p.send("memory " + val)
+	case name == "Threads" && smp:
+		return p.send("cores " + val)
+	}
+	p.log.Debug("option not supported", "option", name)
+	return nil
+}
+
+// SendGo - starts analyzing, pass in options to configure the search.
+// Analyze mode has no time limits, the caller stops the search.
+// Use WaitBestMove or WaitMoveUpTo for the result.
+func (p *CecpProcess) SendGo(opts *GoOptions) error {
+	if err := p.checkReady(); err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	fen := p.fen
+	p.lock.Unlock()
+
+	searchFen := fen
+	if len(opts.SearchMove) > 0 {
+		brd := ai.NewBoard()
+		if err := ai.ParseFen(brd, fen); err != nil {
+			return err
+		}
+		mv, err := ai.MoveFromUci(brd, opts.SearchMove)
+		if err != nil {
+			return err
+		}
+		if err = brd.MakeMove(mv, opts.SearchMove); err != nil {
+			return err
+		}
+		searchFen = ai.BoardToFen(brd, 0)
+	}
+	if err := p.setBoard(searchFen); err != nil {
+		return err
+	}
+
+	// Not searching, so anything left over is from an earlier search.
+	select {
+	case <-p.bestmove:
+	default:
+	}
+
+	p.lock.Lock()
+	p.searching = true
+	p.searchMove = opts.SearchMove
+	p.depth = opts.Depth
+	p.lastInfo = nil
+	p.lock.Unlock()
+
+	return p.send("analyze")
+}
+
+// SendStop - Tell the engine to stop analyzing.
+func (p *CecpProcess) SendStop() error {
+	p.finishSearch()
+	return nil
+}
+
+// Stop - Tell the engine to stop analyzing and return the bestmove.
+// Returns nil if no search is running.
+func (p *CecpProcess) Stop(ctx context.Context) (*UciBestMove, error) {
+	if bm := p.finishSearch(); bm != nil {
+		return bm, nil
+	}
+	// It may have completed on its own, pass that bestmove on
+	select {
+	case bm := <-p.bestmove:
+		return bm, nil
+	default:
+		return nil, nil
+	}
+}
+
+// WaitBestMove - wait for the search to complete, see UciProcess.WaitBestMove
+func (p *CecpProcess) WaitBestMove(ctx context.Context) (*UciBestMove, error) {
+	select {
+	case bm := <-p.bestmove:
+		return bm, nil
+	case <-p.exited:
+		return nil, p.exitError()
+	case <-ctx.Done():
+	}
+
+	bm, err := p.Stop(context.Background())
+	if err != nil {
+		return bm, err
+	}
+	return bm, ctx.Err()
+}
+
+// WaitMoveUpTo - wait for the move to complete, or stop then return the bestmove.
+// Reaching the timeout is not an error.
+func (p *CecpProcess) WaitMoveUpTo(ctx context.Context, timeout time.Duration) (*UciBestMove, error) {
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	bm, err := p.WaitBestMove(tctx)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return bm, nil
+	}
+	return bm, err
+}
+
+func (p *CecpProcess) SetAsyncChannel(callbacks chan *UciCallback) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.callback = callbacks
+}
+
+// SendUciNewGame - starts a new game and returns when it is ready.
+// Named after the uci command so both engine kinds are used the same way.
+func (p *CecpProcess) SendUciNewGame(ctx context.Context) error {
+	if err := p.newGame(); err != nil {
+		return err
+	}
+	return p.WaitReady(ctx)
+}
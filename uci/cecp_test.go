@@ -0,0 +1,255 @@
+package uci
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const cecpStartFen = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+// fakeCecp - a minimal xboard engine, analyze posts the lines of the board.
+type fakeCecp struct {
+	features string
+	thinking map[string][]string
+
+	lock     sync.Mutex
+	commands []string
+}
+
+func (f *fakeCecp) run(in io.Reader, out io.Writer) {
+	// Written apart from the reading, like an engine on an os pipe
+	lines := make(chan string, 100)
+	defer close(lines)
+	go func() {
+		for line := range lines {
+			fmt.Fprintln(out, line)
+		}
+	}()
+
+	board := ""
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		cmd := scanner.Text()
+		f.lock.Lock()
+		f.commands = append(f.commands, cmd)
+		f.lock.Unlock()
+
+		switch {
+		case strings.HasPrefix(cmd, "setboard "):
+			board = strings.TrimPrefix(cmd, "setboard ")
+		case cmd == "protover 2":
+			lines <- f.features
+		case strings.HasPrefix(cmd, "ping "):
+			lines <- "pong " + strings.TrimPrefix(cmd, "ping ")
+		case cmd == "analyze":
+			for _, line := range f.thinking[board] {
+				lines <- line
+			}
+		case cmd == "quit":
+			return
+		}
+	}
+}
+
+func (f *fakeCecp) Commands() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string{}, f.commands...)
+}
+
+// fakeCecpTransport - runs the fake through pipes.
+type fakeCecpTransport struct {
+	fake *fakeCecp
+	inR  *io.PipeReader
+	outW *io.PipeWriter
+	done chan struct{}
+}
+
+func (t *fakeCecpTransport) Start() (io.ReadCloser, io.WriteCloser, error) {
+	var inW *io.PipeWriter
+	var outR *io.PipeReader
+	t.inR, inW = io.Pipe()
+	outR, t.outW = io.Pipe()
+	t.done = make(chan struct{})
+	go func() {
+		t.fake.run(t.inR, t.outW)
+		_ = t.outW.Close()
+		_ = t.inR.Close()
+		close(t.done)
+	}()
+	return outR, inW, nil
+}
+
+func (t *fakeCecpTransport) Wait() error {
+	<-t.done
+	return nil
+}
+
+func (t *fakeCecpTransport) Kill() error {
+	_ = t.inR.Close()
+	_ = t.outW.Close()
+	return nil
+}
+
+func (t *fakeCecpTransport) Stderr() string {
+	return ""
+}
+
+func newFakeCecp(t *testing.T, thinking map[string][]string) (*CecpProcess, *fakeCecp) {
+	f := &fakeCecp{
+		features: `feature myname="fake 1.0" setboard=1 ping=1 analyze=1 option="MultiPV -spin 1 1 500" done=1`,
+		thinking: thinking,
+	}
+	c := NewCecpTransport("fake", &fakeCecpTransport{fake: f})
+	assert.Nil(t, c.Start(context.Background()))
+	t.Cleanup(c.Terminate)
+	return c, f
+}
+
+func TestCecpAnalyze(t *testing.T) {
+	c, f := newFakeCecp(t, map[string][]string{
+		cecpStartFen: {
+			"1 20 0 10 e4",
+			"2. 15 5 500 1. e4 e5",
+			"Error (unknown command): hint",
+		},
+	})
+	assert.Equal(t, "-spin 1 1 500", c.Options["MultiPV"])
+
+	assert.Nil(t, c.SetPositionFen(cecpStartFen))
+	assert.Nil(t, c.SetOption("MultiPV", "3"))
+	assert.Nil(t, c.SetOption("Contempt", "10"))
+
+	cb := make(chan *UciCallback, 10)
+	c.SetAsyncChannel(cb)
+	assert.Nil(t, c.SendGo(NewGoOptions()))
+	assert.True(t, c.IsSearching())
+
+	bm, err := c.WaitMoveUpTo(context.Background(), 200*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, "e2e4", bm.BestMove)
+	assert.Equal(t, "e7e5", bm.Ponder)
+	assert.True(t, c.IsReadyForMove())
+
+	var infos []*UciInfo
+	var errs []error
+	var bestMoves []*UciBestMove
+	for len(cb) > 0 {
+		data := <-cb
+		if data.Info != nil {
+			infos = append(infos, data.Info)
+		}
+		if data.Err != nil {
+			errs = append(errs, data.Err)
+		}
+		if data.BestMove != nil {
+			bestMoves = append(bestMoves, data.BestMove)
+		}
+	}
+	assert.Equal(t, 2, len(infos))
+	assert.Equal(t, 2, infos[1].Depth)
+	assert.Equal(t, 15, infos[1].ScoreCP)
+	assert.Equal(t, 10000, infos[1].Nps)
+	assert.Equal(t, []string{"e2e4", "e7e5"}, infos[1].Moves)
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, 1, len(bestMoves))
+
+	cmds := f.Commands()
+	assert.Contains(t, cmds, "accepted setboard")
+	assert.Contains(t, cmds, "setboard "+cecpStartFen)
+	assert.Contains(t, cmds, "option MultiPV=3")
+	assert.NotContains(t, cmds, "option Contempt=10")
+	assert.Contains(t, cmds, "exit")
+}
+
+func TestCecpDepthAndSearchMove(t *testing.T) {
+	afterD4 := "rnbqkbnr/pppppppp/8/8/3P4/8/PPP1PPPP/RNBQKBNR b KQkq d3 0 1"
+	c, f := newFakeCecp(t, map[string][]string{
+		cecpStartFen: {"1 30 1 100 Nf3"},
+		afterD4: {
+			"1 30 1 100 Nf6",
+			"2 -100002 2 200 12 44280 0\te5 dxe5",
+			"3 -100002 3 300 e5 dxe5",
+		},
+	})
+
+	assert.Nil(t, c.SetPositionFen(cecpStartFen))
+	assert.Nil(t, c.SendGo(&GoOptions{Depth: 2, SearchMove: "d2d4"}))
+
+	start := time.Now()
+	bm, err := c.WaitMoveUpTo(context.Background(), 5*time.Second)
+	assert.Nil(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, "d2d4", bm.BestMove)
+	assert.Equal(t, "e7e5", bm.Ponder)
+
+	assert.Contains(t, f.Commands(), "setboard "+afterD4)
+
+	// The next search is on the position again
+	assert.Nil(t, c.SendGo(&GoOptions{Depth: 1}))
+	bm, err = c.WaitMoveUpTo(context.Background(), 5*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "g1f3", bm.BestMove)
+	assert.Equal(t, 2, strings.Count(strings.Join(f.Commands(), "\n"), "setboard "+cecpStartFen))
+}
+
+func TestCecpParseThinking(t *testing.T) {
+	c := NewCecpTransport("fake", nil)
+	c.boardFen = cecpStartFen
+
+	info := c.parseThinking("4 -100003 12 4000 1. f3 e5 2. g4 Qh4#")
+	assert.Equal(t, 4, info.Depth)
+	assert.Equal(t, -3, info.MateIn)
+	assert.Equal(t, -15003, info.ScoreCP)
+	assert.Equal(t, []string{"f2f3", "e7e5", "g2g4", "d8h4"}, info.Moves)
+
+	// The pv ends at a move that cannot be read
+	info = c.parseThinking("1 0 0 0 e4 Ke3")
+	assert.Equal(t, []string{"e2e4"}, info.Moves)
+
+	assert.Nil(t, c.parseThinking("tellics say hello"))
+	assert.Nil(t, c.parseThinking("1 20"))
+}
+
+func TestCecpFeatures(t *testing.T) {
+	f := &fakeCecp{features: `feature myname="fake 1.0" setboard=1 ping=1 usermove=1 san=1 colors=1 sigint=1 done=1`}
+	c := NewCecpTransport("fake", &fakeCecpTransport{fake: f})
+	assert.Nil(t, c.Start(context.Background()))
+	defer c.Terminate()
+
+	cmds := f.Commands()
+	for _, name := range []string{"myname", "setboard", "ping", "done"} {
+		assert.Contains(t, cmds, "accepted "+name)
+	}
+	for _, name := range []string{"usermove", "san", "colors", "sigint"} {
+		assert.Contains(t, cmds, "rejected "+name)
+		assert.NotContains(t, cmds, "accepted "+name)
+	}
+}
+
+func TestCecpWithoutSetboard(t *testing.T) {
+	f := &fakeCecp{features: `feature myname="fake 1.0" setboard=0 ping=1 done=1`}
+	c := NewCecpTransport("fake", &fakeCecpTransport{fake: f})
+	err := c.Start(context.Background())
+	defer c.Terminate()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "setboard=0")
+	assert.NotContains(t, f.Commands(), "new")
+}
+
+func TestParseFeatures(t *testing.T) {
+	res := parseFeatures(`myname="Crafty 25.2" setboard=1 option="Hash -spin 64 1 1024" done=0`)
+	assert.Equal(t, [][2]string{
+		{"myname", "Crafty 25.2"},
+		{"setboard", "1"},
+		{"option", "Hash -spin 64 1 1024"},
+		{"done", "0"},
+	}, res)
+}
@@ -0,0 +1,46 @@
+package main
+
+/**
+ucireplay - plays back a recorded engine transcript as an engine binary.
+
+	go build -o ../engines/ucireplay ./uci/cmd/ucireplay
+	UCIREPLAY_TRANSCRIPT=zahak-20260102-150405-1.uci ucireplay
+
+Transcripts are recorded with UciManager().SetTranscriptDir.
+*/
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/samlotti/chess_anaylzer/uci"
+	"os"
+)
+
+func main() {
+	path := flag.String("transcript", os.Getenv("UCIREPLAY_TRANSCRIPT"), "the transcript to play back")
+	flag.Parse()
+
+	if len(*path) == 0 {
+		fmt.Fprintln(os.Stderr, "no transcript, use -transcript or UCIREPLAY_TRANSCRIPT")
+		os.Exit(1)
+	}
+
+	entries, err := uci.LoadTranscript(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	err = uci.NewReplay(entries).Run(os.Stdin, os.Stdout)
+
+	var exitErr *uci.ReplayExitError
+	if errors.As(err, &exitErr) {
+		fmt.Fprintln(os.Stderr, exitErr.Reason)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
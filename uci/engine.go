@@ -0,0 +1,61 @@
+package uci
+
+import (
+	"context"
+	"time"
+)
+
+// Engine - a chess engine as used by the analyzer.
+// UciProcess and CecpProcess are the implementations, others (fakes,
+// remote engines) are added with UciManager().SetEngineFactory.
+type Engine interface {
+	// Start - starts the engine and waits until it is ready.
+	Start(ctx context.Context) error
+
+	// WaitReady - returns when the engine has processed the commands sent.
+	WaitReady(ctx context.Context) error
+
+	// SendUciNewGame - starts a new game and returns when it is ready.
+	SendUciNewGame(ctx context.Context) error
+
+	// SetPositionFen - sets the position to search.
+	SetPositionFen(fen string) error
+
+	// SetOption - sets an engine option, ex: MultiPV.
+	SetOption(name string, val string) error
+
+	// SendGo - starts the search, use WaitBestMove or WaitMoveUpTo for the result.
+	SendGo(opts *GoOptions) error
+
+	// SendStop - tells the engine to stop searching, does not wait.
+	SendStop() error
+
+	// Stop - stops the search and returns its bestmove, nil if not searching.
+	Stop(ctx context.Context) (*UciBestMove, error)
+
+	// WaitBestMove - waits for the search to complete, stops it if the context ends.
+	WaitBestMove(ctx context.Context) (*UciBestMove, error)
+
+	// WaitMoveUpTo - waits for the search up to the timeout, then stops it.
+	WaitMoveUpTo(ctx context.Context, timeout time.Duration) (*UciBestMove, error)
+
+	// IsReadyForMove - started and not searching.
+	IsReadyForMove() bool
+
+	// IsSearching - true while a search is running.
+	IsSearching() bool
+
+	// SetAsyncChannel - the info and bestmove output is sent to the channel, nil to stop.
+	SetAsyncChannel(callbacks chan *UciCallback)
+
+	GetState() UciState
+
+	// ExitError - why the engine ended on its own, nil while running.
+	ExitError() error
+
+	// Terminate - ends the engine.
+	Terminate()
+}
+
+var _ Engine = (*UciProcess)(nil)
+var _ Engine = (*CecpProcess)(nil)
@@ -0,0 +1,119 @@
+package uci
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"github.com/samlotti/chess_anaylzer/uci/fakeengine"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGetEngineProtocol(t *testing.T) {
+	m := &_UciManager{}
+	m.SetTransportFactory(func(engine string) (Transport, error) {
+		if engine == "crafty" {
+			return &fakeCecpTransport{fake: &fakeCecp{
+				features: `feature setboard=1 done=1`,
+			}}, nil
+		}
+		return fakeengine.New(fakeengine.DefaultScript()).Transport(), nil
+	})
+	m.SetProtocol("crafty", ProtocolCecp)
+
+	e, err := m.GetEngine(context.Background(), "crafty")
+	assert.Nil(t, err)
+	assert.IsType(t, &CecpProcess{}, e)
+	assert.True(t, e.IsReadyForMove())
+	m.Return(e)
+
+	e, err = m.GetEngine(context.Background(), "zahak")
+	assert.Nil(t, err)
+	assert.IsType(t, &UciProcess{}, e)
+	assert.True(t, e.IsReadyForMove())
+	m.Return(e)
+}
+
+func TestGetEngineFactory(t *testing.T) {
+	m := &_UciManager{}
+	m.SetEngineFactory(func(engine string) (Engine, error) {
+		if engine == "missing" {
+			return nil, fmt.Errorf("no engine %s", engine)
+		}
+		return NewUciTransport(engine, fakeengine.New(fakeengine.DefaultScript()).Transport()), nil
+	})
+
+	e, err := m.GetEngine(context.Background(), "fake")
+	assert.Nil(t, err)
+	assert.Nil(t, e.SetPositionFen(testFen))
+	assert.Nil(t, e.SendGo(NewGoOptions()))
+	bm, err := e.WaitBestMove(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "e2e4", bm.BestMove)
+	m.Return(e)
+
+	e, err = m.GetEngine(context.Background(), "missing")
+	assert.NotNil(t, err)
+	assert.Nil(t, e)
+}
+
+func TestBinaryPath(t *testing.T) {
+	m := &_UciManager{}
+	assert.Equal(t, common.Environment.EnginePath+"zahak", m.BinaryPath("zahak"))
+
+	m.SetBinary("zahak", "mac/zahak-darwin-amd64-8.0-avx")
+	assert.Equal(t, common.Environment.EnginePath+"mac/zahak-darwin-amd64-8.0-avx", m.BinaryPath("zahak"))
+
+	m.SetBinary("crafty", "/usr/games/crafty")
+	assert.Equal(t, "/usr/games/crafty", m.BinaryPath("crafty"))
+}
+
+// logBuffer - the engine logs from its own goroutines
+type logBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func TestEngineLogger(t *testing.T) {
+	m := &_UciManager{}
+	m.SetTransportFactory(func(engine string) (Transport, error) {
+		return fakeengine.New(fakeengine.DefaultScript()).Transport(), nil
+	})
+	m.SetTranscriptDir(t.TempDir())
+
+	out := &logBuffer{}
+	log := slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ctx := common.WithLogAttrs(common.WithLogger(context.Background(), log), common.LogRequest, "r42")
+
+	e, err := m.GetEngine(ctx, "fake")
+	assert.Nil(t, err)
+	u := e.(*UciProcess)
+	assert.True(t, len(u.transcript.Path()) > 0)
+	m.Return(e)
+	<-u.exited
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.True(t, len(lines) > 3)
+	for _, line := range lines {
+		assert.Contains(t, line, "request=r42 engine=fake transcript="+u.transcript.Path())
+	}
+	assert.Contains(t, out.String(), `msg="to engine" request=r42 engine=fake transcript=`+u.transcript.Path()+" line=uci")
+	assert.Contains(t, out.String(), `msg="engine started"`)
+	assert.Contains(t, out.String(), `msg="engine ended"`)
+}
@@ -0,0 +1,54 @@
+package main
+
+/**
+enginehost - serves the engines of this machine to remote analyzers.
+
+	ENGINEHOST_TOKEN=secret enginehost -listen :8282 -engines ../engines/ -allow zahak,stockfish
+
+The analyzer connects with uci.RemoteTransportFactory("host:8282", "secret").
+*/
+
+import (
+	"flag"
+	"fmt"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"github.com/samlotti/chess_anaylzer/uci/enginehost"
+	"os"
+	"strings"
+)
+
+func main() {
+	listen := flag.String("listen", ":8282", "the tcp address to serve on")
+	token := flag.String("token", os.Getenv("ENGINEHOST_TOKEN"), "the token clients must send")
+	path := flag.String("engines", common.Environment.EnginePath, "the directory of the engine binaries")
+	allow := flag.String("allow", "", "comma separated engines that can be used, empty allows all in the directory")
+	max := flag.Int("max", 0, "the max number of engines running at once, 0 = no limit")
+	flag.Parse()
+
+	if len(*token) == 0 {
+		fmt.Fprintln(os.Stderr, "no token, use -token or ENGINEHOST_TOKEN")
+		os.Exit(1)
+	}
+
+	common.Environment.EnginePath = *path
+	if !strings.HasSuffix(common.Environment.EnginePath, "/") {
+		common.Environment.EnginePath += "/"
+	}
+
+	server := &enginehost.Server{
+		Token:       *token,
+		MaxSessions: *max,
+	}
+	if len(*allow) > 0 {
+		server.Engines = make(map[string]bool)
+		for _, name := range strings.Split(*allow, ",") {
+			server.Engines[strings.TrimSpace(name)] = true
+		}
+	}
+
+	fmt.Printf("Engine host on %s, engines in %s\n", *listen, common.Environment.EnginePath)
+	if err := server.ListenAndServe(*listen); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
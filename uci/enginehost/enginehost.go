@@ -0,0 +1,250 @@
+package enginehost
+
+/**
+Serves the local engines over tcp to uci.NewRemoteTransport.
+
+Every connection starts its own engine, the connection is closed when
+the engine ends and the engine is ended when the connection closes.
+See uci/remote.go for the protocol.
+*/
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"github.com/samlotti/chess_anaylzer/uci"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HandshakeTimeout - max wait for the handshake line of a new connection
+const HandshakeTimeout = 10 * time.Second
+
+type Server struct {
+	// Token - clients must send it, empty is refused.
+	Token string
+
+	// Engines - the names that can be started, nil allows any name
+	// without a path.
+	Engines map[string]bool
+
+	// NewTransport - starts the engine, nil runs the binary in the Environment.EnginePath.
+	NewTransport uci.TransportFactory
+
+	// MaxSessions - the number of engines running at once, 0 = no limit.
+	MaxSessions int
+
+	lock     sync.Mutex
+	sessions int
+	listener net.Listener
+	conns    map[net.Conn]bool
+	closed   bool
+}
+
+// ListenAndServe - serves on the tcp address, ex: ":8282"
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve - serves the connections of the listener until Close.
+func (s *Server) Serve(l net.Listener) error {
+	if len(s.Token) == 0 {
+		return errors.New("an engine host needs a token")
+	}
+
+	s.lock.Lock()
+	s.listener = l
+	s.conns = make(map[net.Conn]bool)
+	s.lock.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		if !s.track(conn) {
+			_ = conn.Close()
+			continue
+		}
+		go s.handle(conn)
+	}
+}
+
+// Close - stops listening, ends the running engines and the connections in the handshake.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.untrack(conn)
+	defer conn.Close()
+
+	log := common.Log().With("client", conn.RemoteAddr().String())
+	engine, reader, err := s.handshake(conn)
+	if err != nil {
+		log.Info("engine host refused", "err", err)
+		_, _ = fmt.Fprintf(conn, "error %s\n", err)
+		return
+	}
+
+	if !s.acquire() {
+		_, _ = fmt.Fprintf(conn, "error engine host busy\n")
+		return
+	}
+	defer s.release()
+
+	t, err := s.transport(engine)
+	var out io.ReadCloser
+	var in io.WriteCloser
+	if err == nil {
+		out, in, err = t.Start()
+	}
+	if err != nil {
+		log.Error("engine host cannot start", common.LogEngine, engine, "err", err)
+		_, _ = fmt.Fprintf(conn, "error cannot start engine %s\n", engine)
+		return
+	}
+	if _, err = fmt.Fprintf(conn, "ok\n"); err != nil {
+		// The process is collected, or it is left behind as a zombie
+		_ = in.Close()
+		_ = t.Kill()
+		_ = t.Wait()
+		return
+	}
+	log = log.With(common.LogEngine, engine)
+	log.Info("engine host started")
+
+	// The client input, when the client goes away so does the engine.
+	// The copy also ends when the engine died and its input is closed.
+	client := &clientReader{r: reader}
+	go func() {
+		_, _ = io.Copy(in, client)
+		_ = in.Close()
+		_ = t.Kill()
+	}()
+
+	_, _ = io.Copy(conn, out)
+	waitErr := t.Wait()
+
+	// No exit when the engine ended because the client left
+	if !client.left.Load() {
+		exit := &uci.RemoteExit{Stderr: t.Stderr()}
+		if waitErr != nil {
+			exit.Err = waitErr.Error()
+		}
+		data, _ := json.Marshal(exit)
+		_, _ = fmt.Fprintf(conn, "%s%s\n", uci.RemoteExitPrefix, data)
+	}
+	log.Info("engine host ended")
+}
+
+// handshake - checks the token and engine of the first line.
+func (s *Server) handshake(conn net.Conn) (string, *bufio.Reader, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", nil, fmt.Errorf("no handshake")
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	token, engine, err := uci.ParseRemoteHandshake(line)
+	if err != nil {
+		return "", nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+		return "", nil, fmt.Errorf("invalid token")
+	}
+	if !s.allowed(engine) {
+		return "", nil, fmt.Errorf("unknown engine %s", engine)
+	}
+	return engine, reader, nil
+}
+
+func (s *Server) allowed(engine string) bool {
+	if s.Engines != nil {
+		return s.Engines[engine]
+	}
+	return !strings.ContainsAny(engine, "/\\") && !strings.HasPrefix(engine, ".")
+}
+
+func (s *Server) transport(engine string) (uci.Transport, error) {
+	if s.NewTransport != nil {
+		return s.NewTransport(engine)
+	}
+	return uci.NewExecTransport(uci.UciManager().BinaryPath(engine)), nil
+}
+
+// track - the connection is closed by Close, false once closed.
+func (s *Server) track(conn net.Conn) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = true
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.conns, conn)
+}
+
+// acquire - takes a session, false if all are in use.
+func (s *Server) acquire() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed || (s.MaxSessions > 0 && s.sessions >= s.MaxSessions) {
+		return false
+	}
+	s.sessions++
+	return true
+}
+
+func (s *Server) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sessions--
+}
+
+// clientReader - the client input, knows when the client closed the connection
+type clientReader struct {
+	r    io.Reader
+	left atomic.Bool
+}
+
+func (c *clientReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	if errors.Is(err, io.EOF) {
+		c.left.Store(true)
+	}
+	return n, err
+}
@@ -0,0 +1,238 @@
+package enginehost
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/samlotti/chess_anaylzer/uci"
+	"github.com/samlotti/chess_anaylzer/uci/fakeengine"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFen = "2r3k1/p4p2/3Rp2p/1p2P1pK/8/1P4P1/P3Q2P/1q6 b - - 0 1"
+
+// startHost - an engine host on a loopback port playing the script.
+func startHost(t *testing.T, script *fakeengine.Script) (*Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	s := &Server{
+		Token:   "secret",
+		Engines: map[string]bool{"fake": true},
+		NewTransport: func(engine string) (uci.Transport, error) {
+			return fakeengine.New(script).Transport(), nil
+		},
+	}
+	go func() {
+		_ = s.Serve(l)
+	}()
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	return s, l.Addr().String()
+}
+
+func TestRemoteEngine(t *testing.T) {
+	_, addr := startHost(t, fakeengine.DefaultScript())
+
+	u := uci.NewUciTransport("fake", uci.NewRemoteTransport(addr, "secret", "fake"))
+	assert.Nil(t, u.Start(context.Background()))
+	defer u.Terminate()
+
+	assert.Nil(t, u.SetPositionFen(testFen))
+	assert.Nil(t, u.SendGo(uci.NewGoOptions()))
+	bm, err := u.WaitMoveUpTo(context.Background(), 2*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "e2e4", bm.BestMove)
+}
+
+func TestRemoteEngineRefused(t *testing.T) {
+	s, addr := startHost(t, fakeengine.DefaultScript())
+
+	u := uci.NewUciTransport("fake", uci.NewRemoteTransport(addr, "wrong", "fake"))
+	err := u.Start(context.Background())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid token")
+
+	u = uci.NewUciTransport("stockfish", uci.NewRemoteTransport(addr, "secret", "stockfish"))
+	err = u.Start(context.Background())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unknown engine stockfish")
+
+	s.lock.Lock()
+	s.MaxSessions = 1
+	s.lock.Unlock()
+	first := uci.NewUciTransport("fake", uci.NewRemoteTransport(addr, "secret", "fake"))
+	assert.Nil(t, first.Start(context.Background()))
+	defer first.Terminate()
+	u = uci.NewUciTransport("fake", uci.NewRemoteTransport(addr, "secret", "fake"))
+	err = u.Start(context.Background())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "busy")
+}
+
+func TestRemoteEngineCrash(t *testing.T) {
+	script := fakeengine.DefaultScript()
+	script.Default = &fakeengine.Response{Crash: true, ExitCode: 5, Stderr: "out of memory"}
+	_, addr := startHost(t, script)
+
+	u := uci.NewUciTransport("fake", uci.NewRemoteTransport(addr, "secret", "fake"))
+	assert.Nil(t, u.Start(context.Background()))
+	defer u.Terminate()
+
+	assert.Nil(t, u.SetPositionFen(testFen))
+	assert.Nil(t, u.SendGo(uci.NewGoOptions()))
+	_, err := u.WaitMoveUpTo(context.Background(), 2*time.Second)
+
+	var exitErr *uci.EngineExitError
+	assert.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, "exit status 5", exitErr.Err.Error())
+	assert.Contains(t, exitErr.Stderr, "out of memory")
+}
+
+func TestParseRemoteHandshake(t *testing.T) {
+	token, engine, err := uci.ParseRemoteHandshake(uci.RemoteHandshake("secret", "zahak"))
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", token)
+	assert.Equal(t, "zahak", engine)
+
+	_, _, err = uci.ParseRemoteHandshake("enginehost 2 secret zahak")
+	assert.NotNil(t, err)
+	_, _, err = uci.ParseRemoteHandshake("uci")
+	assert.NotNil(t, err)
+}
+
+// waitTransport - tells when the process has been collected
+type waitTransport struct {
+	uci.Transport
+	waited chan struct{}
+}
+
+func (t *waitTransport) Wait() error {
+	defer close(t.waited)
+	return t.Transport.Wait()
+}
+
+func TestClientGoneBeforeOk(t *testing.T) {
+	transport := &waitTransport{
+		Transport: fakeengine.New(fakeengine.DefaultScript()).Transport(),
+		waited:    make(chan struct{}),
+	}
+	s := &Server{
+		Token:   "secret",
+		Engines: map[string]bool{"fake": true},
+		NewTransport: func(engine string) (uci.Transport, error) {
+			return transport, nil
+		},
+		conns: make(map[net.Conn]bool),
+	}
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.handle(server)
+		close(done)
+	}()
+	_, err := client.Write([]byte(uci.RemoteHandshake("secret", "fake") + "\n"))
+	assert.Nil(t, err)
+	// The ok can not be written
+	_ = client.Close()
+
+	select {
+	case <-transport.waited:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the engine was not collected")
+	}
+	<-done
+}
+
+// deadTransport - an engine that died, its input fails and it is collected once exit is closed
+type deadTransport struct {
+	out   *io.PipeReader
+	wrote chan struct{}
+	exit  chan struct{}
+}
+
+func (t *deadTransport) Start() (io.ReadCloser, io.WriteCloser, error) {
+	return t.out, t, nil
+}
+
+func (t *deadTransport) Write(b []byte) (int, error) {
+	close(t.wrote)
+	return 0, errors.New("broken pipe")
+}
+
+func (t *deadTransport) Close() error {
+	return nil
+}
+
+func (t *deadTransport) Wait() error {
+	<-t.exit
+	return errors.New("exit status 3")
+}
+
+func (t *deadTransport) Kill() error {
+	return nil
+}
+
+func (t *deadTransport) Stderr() string {
+	return "segmentation fault"
+}
+
+func TestExitAfterClientInput(t *testing.T) {
+	outR, outW := io.Pipe()
+	transport := &deadTransport{out: outR, wrote: make(chan struct{}), exit: make(chan struct{})}
+	s := &Server{
+		Token:   "secret",
+		Engines: map[string]bool{"fake": true},
+		NewTransport: func(engine string) (uci.Transport, error) {
+			return transport, nil
+		},
+		conns: make(map[net.Conn]bool),
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.handle(server)
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err := client.Write([]byte(uci.RemoteHandshake("secret", "fake") + "\n"))
+	assert.Nil(t, err)
+	reader := bufio.NewReader(client)
+	line, err := reader.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "ok\n", line)
+
+	// The client sends a line to the engine that died, before it is collected
+	_, err = client.Write([]byte("isready\n"))
+	assert.Nil(t, err)
+	<-transport.wrote
+	_ = outW.Close()
+	close(transport.exit)
+
+	line, err = reader.ReadString('\n')
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(line, uci.RemoteExitPrefix), line)
+	assert.Contains(t, line, "segmentation fault")
+}
+
+func TestCloseEndsHandshake(t *testing.T) {
+	s, addr := startHost(t, fakeengine.DefaultScript())
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// Accepted, no handshake sent yet
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, s.Close())
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NotContains(t, line, "busy")
+	var netErr net.Error
+	assert.False(t, errors.As(err, &netErr) && netErr.Timeout(), "the connection was left open")
+}
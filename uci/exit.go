@@ -0,0 +1,54 @@
+package uci
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MaxStderrCapture - the number of bytes of the engine stderr kept for errors.
+const MaxStderrCapture = 4096
+
+// EngineExitError - the engine process ended while it was in use.
+type EngineExitError struct {
+	Engine string
+	Err    error  // the result of the process wait, nil if it exited with status 0
+	Stderr string // the tail of the engine stderr
+}
+
+func (e *EngineExitError) Error() string {
+	msg := fmt.Sprintf("engine %s exited", e.Engine)
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %s", msg, e.Err)
+	}
+	if len(e.Stderr) > 0 {
+		msg = fmt.Sprintf("%s, stderr: %s", msg, strings.TrimSpace(e.Stderr))
+	}
+	return msg
+}
+
+func (e *EngineExitError) Unwrap() error {
+	return e.Err
+}
+
+// stderrTail - keeps the last MaxStderrCapture bytes written by the engine.
+type stderrTail struct {
+	lock sync.Mutex
+	buf  []byte
+}
+
+func (s *stderrTail) Write(data []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.buf = append(s.buf, data...)
+	if len(s.buf) > MaxStderrCapture {
+		s.buf = s.buf[len(s.buf)-MaxStderrCapture:]
+	}
+	return len(data), nil
+}
+
+func (s *stderrTail) String() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return string(s.buf)
+}
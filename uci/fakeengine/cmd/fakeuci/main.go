@@ -0,0 +1,44 @@
+package main
+
+/**
+fakeuci - the scripted UCI engine as a binary.
+
+	go build -o ../engines/fakeuci ./uci/fakeengine/cmd/fakeuci
+	FAKEUCI_SCRIPT=script.json fakeuci
+
+Without a script every position is answered with a one line search.
+*/
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/samlotti/chess_anaylzer/uci/fakeengine"
+	"os"
+)
+
+func main() {
+	path := flag.String("script", os.Getenv("FAKEUCI_SCRIPT"), "the json script to play")
+	flag.Parse()
+
+	script := fakeengine.DefaultScript()
+	if len(*path) > 0 {
+		var err error
+		script, err = fakeengine.LoadScript(*path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	err := fakeengine.New(script).Run(os.Stdin, os.Stdout, os.Stderr)
+
+	var exitErr *fakeengine.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.Code)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,320 @@
+package fakeengine
+
+/**
+A scripted UCI engine for tests.
+
+The script decides what is sent back for each go command, keyed by the
+fen of the position.  Lines are sent as written so malformed output can be
+tested, with an optional delay before each line.  A response can also hang
+until a stop, never send the bestmove or crash the engine.
+
+Usable in process, see Engine.Transport, or as the fakeuci binary.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response - what the engine sends back for a go command.
+type Response struct {
+	Lines      []string `json:"lines"`      // info lines, the bestmove line is held back until the end
+	DelayMs    int      `json:"delayMs"`    // delay before each line
+	Hang       bool     `json:"hang"`       // wait for a stop before sending the bestmove
+	NoBestMove bool     `json:"noBestMove"` // never send the bestmove, not even after a stop
+	Crash      bool     `json:"crash"`      // exit once the lines are sent
+	ExitCode   int      `json:"exitCode"`   // the exit code of the crash
+	Stderr     string   `json:"stderr"`     // written to stderr on the crash
+}
+
+// Script - the engine behaviour.
+type Script struct {
+	Name    string   `json:"name"`
+	Options []string `json:"options"` // sent during the handshake, without the "option name " prefix
+
+	// Positions - responses keyed by the fen after "position fen ".
+	// A key of "<fen> searchmoves <move>" is used first for a go with searchmoves.
+	Positions map[string]*Response `json:"positions"`
+
+	// Default - the response for positions not in the map.
+	Default *Response `json:"default"`
+
+	// CrashOnUci - exit with this code when the uci command is received, 0 = no crash.
+	CrashOnUci int `json:"crashOnUci"`
+}
+
+// DefaultScript - answers every position with a one line search.
+func DefaultScript() *Script {
+	return &Script{
+		Name:      "fakeengine",
+		Options:   []string{"MultiPV type spin default 1 min 1 max 500"},
+		Positions: map[string]*Response{},
+		Default: &Response{
+			Lines: []string{
+				"info depth 1 seldepth 1 score cp 10 nodes 20 nps 1000 time 1 multipv 1 pv e2e4",
+				"bestmove e2e4",
+			},
+		},
+	}
+}
+
+// LoadScript - reads a json script.
+func LoadScript(path string) (*Script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	script := &Script{}
+	if err = json.Unmarshal(data, script); err != nil {
+		return nil, fmt.Errorf("invalid script %s: %w", path, err)
+	}
+	if script.Positions == nil {
+		script.Positions = map[string]*Response{}
+	}
+	return script, nil
+}
+
+// ExitError - the engine crashed as scripted.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// Engine - runs a script, the commands received are recorded.
+type Engine struct {
+	script *Script
+
+	lock     sync.Mutex
+	commands []string
+	options  map[string]string
+}
+
+func New(script *Script) *Engine {
+	return &Engine{
+		script:  script,
+		options: map[string]string{},
+	}
+}
+
+// Commands - the commands received so far.
+func (e *Engine) Commands() []string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]string{}, e.commands...)
+}
+
+// Option - the value set with setoption.
+func (e *Engine) Option(name string) string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.options[name]
+}
+
+func (e *Engine) record(cmd string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.commands = append(e.commands, cmd)
+	if strings.HasPrefix(cmd, "setoption name ") {
+		sects := strings.SplitN(strings.TrimPrefix(cmd, "setoption name "), " value ", 2)
+		if len(sects) == 2 {
+			e.options[sects[0]] = sects[1]
+		}
+	}
+}
+
+// response - the scripted response for the position and go command.
+func (e *Engine) response(position string, goCmd string) *Response {
+	if idx := strings.Index(goCmd, "searchmoves "); idx >= 0 {
+		if r, ok := e.script.Positions[position+" "+goCmd[idx:]]; ok {
+			return r
+		}
+	}
+	if r, ok := e.script.Positions[position]; ok {
+		return r
+	}
+	if e.script.Default != nil {
+		return e.script.Default
+	}
+	return &Response{Lines: []string{"bestmove 0000"}}
+}
+
+// session - the state of one Run.
+type session struct {
+	engine *Engine
+	errOut io.Writer
+
+	outLock sync.Mutex
+	out     io.Writer
+
+	position string
+	stop     chan struct{} // closed to stop the running search
+	done     chan struct{} // closed when the running search has ended
+	crash    chan *ExitError
+}
+
+// Run - plays the script until quit, the end of the input or a crash.
+// A crash is returned as an *ExitError.
+func (e *Engine) Run(in io.Reader, out io.Writer, errOut io.Writer) error {
+	s := &session{
+		engine: e,
+		out:    out,
+		errOut: errOut,
+		crash:  make(chan *ExitError, 1),
+	}
+
+	lines := make(chan string)
+	ended := make(chan struct{})
+	defer close(ended)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			select {
+			case lines <- strings.TrimSpace(scanner.Text()):
+			case <-ended:
+				return
+			}
+		}
+	}()
+
+	defer s.stopSearch()
+
+	for {
+		select {
+		case crash := <-s.crash:
+			return crash
+		case line, ok := <-lines:
+			if !ok {
+				return nil
+			}
+			e.record(line)
+			if quit, err := s.command(line); quit || err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// command - handles one line, returns true to end the run.
+func (s *session) command(line string) (bool, error) {
+	cmd := strings.SplitN(line, " ", 2)[0]
+	switch cmd {
+	case "uci":
+		if s.engine.script.CrashOnUci != 0 {
+			return true, &ExitError{Code: s.engine.script.CrashOnUci}
+		}
+		s.println("id name " + s.engine.script.Name)
+		s.println("id author fakeengine")
+		for _, opt := range s.engine.script.Options {
+			s.println("option name " + opt)
+		}
+		s.println("uciok")
+	case "isready":
+		s.println("readyok")
+	case "position":
+		s.position = strings.TrimPrefix(strings.TrimPrefix(line, "position "), "fen ")
+	case "go":
+		s.stopSearch()
+		s.startSearch(s.engine.response(s.position, line))
+	case "stop":
+		s.stopSearch()
+	case "quit":
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *session) println(line string) {
+	s.outLock.Lock()
+	defer s.outLock.Unlock()
+	fmt.Fprintln(s.out, line)
+}
+
+func (s *session) startSearch(r *Response) {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.search(r, s.stop, s.done)
+}
+
+// stopSearch - stops the running search and waits for it to end.
+func (s *session) stopSearch() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+	s.done = nil
+}
+
+// search - sends the scripted lines.
+func (s *session) search(r *Response, stop chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	bestMove := ""
+	stopped := false
+	delay := time.Duration(r.DelayMs) * time.Millisecond
+	for _, line := range r.Lines {
+		if strings.HasPrefix(line, "bestmove") {
+			bestMove = line
+			continue
+		}
+		if stopped {
+			continue
+		}
+		if delay > 0 {
+			select {
+			case <-stop:
+				stopped = true
+				continue
+			case <-time.After(delay):
+			}
+		}
+		s.println(line)
+		if bestMove == "" {
+			bestMove = bestMoveFromInfo(line)
+		}
+	}
+
+	if r.Crash {
+		if len(r.Stderr) > 0 {
+			fmt.Fprintln(s.errOut, r.Stderr)
+		}
+		s.crash <- &ExitError{Code: r.ExitCode}
+		return
+	}
+
+	if r.Hang && !stopped {
+		<-stop
+	}
+
+	if r.NoBestMove {
+		return
+	}
+
+	if bestMove == "" {
+		bestMove = "bestmove 0000"
+	}
+	s.println(bestMove)
+}
+
+// bestMoveFromInfo - the first move of the pv, used when stopped before
+// the scripted bestmove.
+func bestMoveFromInfo(line string) string {
+	sects := strings.Split(line, " ")
+	for i, sect := range sects {
+		if sect == "pv" && i+1 < len(sects) {
+			return "bestmove " + sects[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package fakeengine
+
+import (
+	"io"
+	"sync"
+)
+
+// Transport - runs the engine in process, connected through pipes.
+// Satisfies the uci.Transport interface.
+type Transport struct {
+	engine *Engine
+
+	inR  *io.PipeReader
+	outW *io.PipeWriter
+
+	done   chan struct{}
+	err    error
+	stderr lockedBuffer
+}
+
+// Transport - a new transport that runs this engine.
+func (e *Engine) Transport() *Transport {
+	return &Transport{
+		engine: e,
+		done:   make(chan struct{}),
+	}
+}
+
+// Start - starts the engine, returns the engine output and input.
+func (t *Transport) Start() (io.ReadCloser, io.WriteCloser, error) {
+	var inW *io.PipeWriter
+	var outR *io.PipeReader
+	t.inR, inW = io.Pipe()
+	outR, t.outW = io.Pipe()
+
+	go func() {
+		t.err = t.engine.Run(t.inR, t.outW, &t.stderr)
+		_ = t.outW.Close()
+		_ = t.inR.Close()
+		close(t.done)
+	}()
+
+	return outR, inW, nil
+}
+
+// Wait - waits for the engine to end, a crash is an *ExitError.
+func (t *Transport) Wait() error {
+	<-t.done
+	return t.err
+}
+
+// Kill - ends the engine.
+func (t *Transport) Kill() error {
+	if t.inR == nil {
+		return nil
+	}
+	_ = t.inR.Close()
+	_ = t.outW.Close()
+	return nil
+}
+
+// Stderr - what the engine wrote to stderr.
+func (t *Transport) Stderr() string {
+	return t.stderr.String()
+}
+
+type lockedBuffer struct {
+	lock sync.Mutex
+	buf  []byte
+}
+
+func (b *lockedBuffer) Write(data []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.buf = append(b.buf, data...)
+	return len(data), nil
+}
+
+func (b *lockedBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return string(b.buf)
+}
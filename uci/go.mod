@@ -1,4 +1,4 @@
 module github.com/samlotti/chess_anaylzer/uci
 
-go 1.18
+go 1.21
 
@@ -0,0 +1,190 @@
+package uci
+
+/**
+Engines on another machine, served by an engine host, see uci/enginehost.
+
+The client opens a tcp connection and sends one line:
+
+	enginehost 1 <token> <engine>
+
+The host answers "ok" and from then on the connection is the engine
+input and output, or "error <reason>" and closes it.  When the engine
+ends on its own the host sends a last line with the exit, see RemoteExit.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RemoteProtocolVersion - the version in the handshake
+	RemoteProtocolVersion = "1"
+
+	// RemoteDialTimeout - max wait for the connection and the handshake
+	RemoteDialTimeout = 10 * time.Second
+
+	// remoteHello - the start of the handshake line
+	remoteHello = "enginehost"
+
+	// RemoteExitPrefix - the line sent by the host when the engine ended on its own.
+	RemoteExitPrefix = "#enginehost exit "
+)
+
+// RemoteExit - the exit of the engine on the host, sent as json after the RemoteExitPrefix.
+type RemoteExit struct {
+	Err    string `json:"err,omitempty"`
+	Stderr string `json:"stderr,omitempty"`
+}
+
+// ErrRemoteConnectionLost - the connection ended without the engine exit.
+var ErrRemoteConnectionLost = errors.New("connection to the engine host lost")
+
+// RemoteHandshake - the first line sent to the host.
+func RemoteHandshake(token string, engine string) string {
+	return fmt.Sprintf("%s %s %s %s", remoteHello, RemoteProtocolVersion, token, engine)
+}
+
+// ParseRemoteHandshake - the token and engine of the handshake line.
+func ParseRemoteHandshake(line string) (token string, engine string, err error) {
+	sects := strings.Fields(line)
+	if len(sects) != 4 || sects[0] != remoteHello {
+		return "", "", fmt.Errorf("invalid handshake")
+	}
+	if sects[1] != RemoteProtocolVersion {
+		return "", "", fmt.Errorf("unsupported version %s", sects[1])
+	}
+	return sects[2], sects[3], nil
+}
+
+// remoteTransport - an engine on an engine host.
+type remoteTransport struct {
+	addr   string
+	token  string
+	engine string
+
+	conn net.Conn
+	outW *io.PipeWriter
+	outR *io.PipeReader
+
+	lock   sync.Mutex
+	killed bool
+	exit   *RemoteExit
+	done   chan struct{}
+}
+
+// NewRemoteTransport - the engine on the engine host at addr (host:port).
+func NewRemoteTransport(addr string, token string, engine string) Transport {
+	return &remoteTransport{
+		addr:   addr,
+		token:  token,
+		engine: engine,
+		done:   make(chan struct{}),
+	}
+}
+
+// RemoteTransportFactory - every engine is on the engine host,
+// use with UciManager().SetTransportFactory.
+func RemoteTransportFactory(addr string, token string) TransportFactory {
+	return func(engine string) (Transport, error) {
+		return NewRemoteTransport(addr, token, engine), nil
+	}
+}
+
+func (t *remoteTransport) Start() (io.ReadCloser, io.WriteCloser, error) {
+	conn, err := net.DialTimeout("tcp", t.addr, RemoteDialTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(RemoteDialTimeout))
+	reader := bufio.NewReader(conn)
+	if _, err = fmt.Fprintf(conn, "%s\n", RemoteHandshake(t.token, t.engine)); err == nil {
+		var reply string
+		reply, err = reader.ReadString('\n')
+		reply = strings.TrimSpace(reply)
+		if err == nil && reply != "ok" {
+			err = fmt.Errorf("engine host %s: %s", t.addr, strings.TrimPrefix(reply, "error "))
+		}
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	t.conn = conn
+	t.outR, t.outW = io.Pipe()
+	go t.read(reader)
+
+	return t.outR, conn, nil
+}
+
+// read - passes the engine output on, keeps the exit line.
+func (t *remoteTransport) read(reader *bufio.Reader) {
+	defer close(t.done)
+	defer t.outW.Close()
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		txt := scanner.Text()
+		if strings.HasPrefix(txt, RemoteExitPrefix) {
+			exit := &RemoteExit{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(txt, RemoteExitPrefix)), exit); err != nil {
+				exit.Err = fmt.Sprintf("invalid exit from the engine host: %s", err)
+			}
+			t.lock.Lock()
+			t.exit = exit
+			t.lock.Unlock()
+			continue
+		}
+		if _, err := fmt.Fprintln(t.outW, txt); err != nil {
+			return
+		}
+	}
+}
+
+// Wait - the exit of the engine on the host.
+func (t *remoteTransport) Wait() error {
+	<-t.done
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.killed {
+		return nil
+	}
+	if t.exit == nil {
+		return ErrRemoteConnectionLost
+	}
+	if len(t.exit.Err) > 0 {
+		return errors.New(t.exit.Err)
+	}
+	return nil
+}
+
+// Kill - closes the connection, the host ends the engine.
+func (t *remoteTransport) Kill() error {
+	t.lock.Lock()
+	t.killed = true
+	t.lock.Unlock()
+	if t.conn == nil {
+		return nil
+	}
+	_ = t.outR.Close()
+	return t.conn.Close()
+}
+
+func (t *remoteTransport) Stderr() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.exit == nil {
+		return ""
+	}
+	return t.exit.Stderr
+}
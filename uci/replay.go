@@ -0,0 +1,175 @@
+package uci
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// transcriptExited - the note of an engine that ended on its own
+	transcriptExited = "exited"
+
+	// transcriptTerminated - the note of an engine that was terminated
+	transcriptTerminated = "terminated"
+)
+
+// ReplayMismatchError - the commands sent differ from the transcript.
+type ReplayMismatchError struct {
+	Entry    int // the transcript entry, 1 based
+	Expected string
+	Got      string
+}
+
+func (e *ReplayMismatchError) Error() string {
+	if len(e.Expected) == 0 {
+		return fmt.Sprintf("replay: unexpected command after the end of the transcript: %s", e.Got)
+	}
+	return fmt.Sprintf("replay: entry %d expected command %q, got %q", e.Entry, e.Expected, e.Got)
+}
+
+// ReplayExitError - the recorded engine ended on its own, replayed.
+type ReplayExitError struct {
+	Reason string
+}
+
+func (e *ReplayExitError) Error() string {
+	return "replayed exit: " + e.Reason
+}
+
+// Replay - a virtual engine that plays back a transcript.
+//
+// Every command must match the next recorded command, the lines received
+// after it are then sent back straight away.  The timing is not replayed,
+// a search the client stopped ends when the client sends its stop.
+type Replay struct {
+	entries []*TranscriptEntry
+}
+
+func NewReplay(entries []*TranscriptEntry) *Replay {
+	return &Replay{entries: entries}
+}
+
+// Run - plays the transcript until the end of the input.
+// Returns a *ReplayMismatchError if the commands differ and a
+// *ReplayExitError if the recorded engine exited.
+func (r *Replay) Run(in io.Reader, out io.Writer) error {
+	pos, err := r.output(out, 0)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		if pos >= len(r.entries) {
+			if line == "quit" {
+				return nil
+			}
+			return &ReplayMismatchError{Entry: pos + 1, Got: line}
+		}
+		if expected := r.entries[pos].Line; expected != line {
+			return &ReplayMismatchError{Entry: pos + 1, Expected: expected, Got: line}
+		}
+		pos, err = r.output(out, pos+1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// output - sends the received lines from pos up to the next command,
+// returns the position of the command.
+func (r *Replay) output(out io.Writer, pos int) (int, error) {
+	for ; pos < len(r.entries); pos++ {
+		e := r.entries[pos]
+		switch e.Dir {
+		case TranscriptSent:
+			return pos, nil
+		case TranscriptReceived:
+			if _, err := fmt.Fprintln(out, e.Line); err != nil {
+				return pos, err
+			}
+		case TranscriptNote:
+			if strings.HasPrefix(e.Line, transcriptExited) {
+				reason := strings.TrimSpace(strings.TrimPrefix(e.Line, transcriptExited+":"))
+				return pos, &ReplayExitError{Reason: reason}
+			}
+			if e.Line == transcriptTerminated {
+				// Nothing was recorded after this
+				return len(r.entries), nil
+			}
+		}
+	}
+	return pos, nil
+}
+
+// replayTransport - runs a Replay in process, connected through pipes.
+type replayTransport struct {
+	replay *Replay
+
+	inR  *io.PipeReader
+	outW *io.PipeWriter
+
+	done chan struct{}
+	err  error
+}
+
+// NewReplayTransport - a transport to an engine that plays back the transcript.
+func NewReplayTransport(entries []*TranscriptEntry) Transport {
+	return &replayTransport{
+		replay: NewReplay(entries),
+		done:   make(chan struct{}),
+	}
+}
+
+// ReplayTransportFactory - every engine plays back the transcript file,
+// use with UciManager().SetTransportFactory to reproduce a session offline.
+func ReplayTransportFactory(path string) (TransportFactory, error) {
+	entries, err := LoadTranscript(path)
+	if err != nil {
+		return nil, err
+	}
+	return func(engine string) (Transport, error) {
+		return NewReplayTransport(entries), nil
+	}, nil
+}
+
+func (t *replayTransport) Start() (io.ReadCloser, io.WriteCloser, error) {
+	var inW *io.PipeWriter
+	var outR *io.PipeReader
+	t.inR, inW = io.Pipe()
+	outR, t.outW = io.Pipe()
+
+	go func() {
+		t.err = t.replay.Run(t.inR, t.outW)
+		_ = t.outW.Close()
+		_ = t.inR.Close()
+		close(t.done)
+	}()
+
+	return outR, inW, nil
+}
+
+func (t *replayTransport) Wait() error {
+	<-t.done
+	return t.err
+}
+
+func (t *replayTransport) Kill() error {
+	if t.inR == nil {
+		return nil
+	}
+	_ = t.inR.Close()
+	_ = t.outW.Close()
+	return nil
+}
+
+func (t *replayTransport) Stderr() string {
+	return ""
+}
@@ -0,0 +1,186 @@
+package uci
+
+/**
+Transcripts of the lines exchanged with an engine.
+
+One line per entry:
+
+	2026-01-02T15:04:05.000123Z > position fen 8/8/8/8/8/8/8/K6k w - - 0 1
+	2026-01-02T15:04:05.000456Z < info depth 1 score cp 0 pv a1b1
+
+'>' is sent to the engine, '<' received from the engine and '!' is a note,
+like the exit of the engine.  A transcript can be replayed, see Replay.
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/samlotti/chess_anaylzer/chessboard/common"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TranscriptTimeFormat - the time stamp of every transcript line
+const TranscriptTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+type TranscriptDir byte
+
+const (
+	TranscriptSent     TranscriptDir = '>'
+	TranscriptReceived TranscriptDir = '<'
+	TranscriptNote     TranscriptDir = '!'
+)
+
+// TranscriptEntry - one line of a transcript.
+type TranscriptEntry struct {
+	Time time.Time
+	Dir  TranscriptDir
+	Line string
+}
+
+// Transcript - records the lines exchanged with an engine.
+type Transcript struct {
+	lock   sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	err    error
+	path   string
+}
+
+// NewTranscript - records to the writer, it is not closed.
+func NewTranscript(w io.Writer) *Transcript {
+	return &Transcript{w: w}
+}
+
+// CreateTranscript - records to a new file.
+func CreateTranscript(path string) (*Transcript, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Transcript{w: f, closer: f, path: path}, nil
+}
+
+// Path - the file of CreateTranscript, empty for a writer.
+func (t *Transcript) Path() string {
+	if t == nil {
+		return ""
+	}
+	return t.path
+}
+
+// transcriptSeq - keeps the names of engines started together apart
+var transcriptSeq int64
+
+// TranscriptPath - a new transcript file name for the engine in the directory.
+func TranscriptPath(dir string, engine string) string {
+	seq := atomic.AddInt64(&transcriptSeq, 1)
+	name := fmt.Sprintf("%s-%s-%d.uci", filepath.Base(engine), time.Now().Format("20060102-150405"), seq)
+	return filepath.Join(dir, name)
+}
+
+// Sent - records a line sent to the engine.
+func (t *Transcript) Sent(line string) {
+	t.write(TranscriptSent, line)
+}
+
+// Received - records a line received from the engine.
+func (t *Transcript) Received(line string) {
+	t.write(TranscriptReceived, line)
+}
+
+// Note - records something that is not part of the protocol.
+func (t *Transcript) Note(line string) {
+	t.write(TranscriptNote, line)
+}
+
+func (t *Transcript) write(dir TranscriptDir, line string) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.err != nil {
+		return
+	}
+	// An entry is always one line
+	line = strings.ReplaceAll(line, "\n", " ")
+	_, t.err = fmt.Fprintf(t.w, "%s %c %s\n", time.Now().UTC().Format(TranscriptTimeFormat), dir, line)
+	if t.err != nil {
+		common.Log().Warn("transcript write failed", common.LogTranscript, t.path, "err", t.err)
+	}
+}
+
+// Close - closes the file of CreateTranscript.
+func (t *Transcript) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closer == nil {
+		return t.err
+	}
+	err := t.closer.Close()
+	t.closer = nil
+	return err
+}
+
+// ReadTranscript - parses a transcript.
+func ReadTranscript(r io.Reader) ([]*TranscriptEntry, error) {
+	var entries []*TranscriptEntry
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		txt := scanner.Text()
+		if len(strings.TrimSpace(txt)) == 0 {
+			continue
+		}
+		entry, err := parseTranscriptLine(txt)
+		if err != nil {
+			return nil, fmt.Errorf("transcript line %d: %w", lineNum, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// LoadTranscript - parses the transcript file.
+func LoadTranscript(path string) ([]*TranscriptEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadTranscript(f)
+}
+
+func parseTranscriptLine(txt string) (*TranscriptEntry, error) {
+	sects := strings.SplitN(txt, " ", 3)
+	if len(sects) < 2 || len(sects[1]) != 1 {
+		return nil, fmt.Errorf("invalid entry: %s", txt)
+	}
+	ts, err := time.Parse(TranscriptTimeFormat, sects[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid time: %w", err)
+	}
+	entry := &TranscriptEntry{Time: ts, Dir: TranscriptDir(sects[1][0])}
+	switch entry.Dir {
+	case TranscriptSent, TranscriptReceived, TranscriptNote:
+	default:
+		return nil, fmt.Errorf("invalid direction: %s", sects[1])
+	}
+	if len(sects) == 3 {
+		entry.Line = sects[2]
+	}
+	return entry, nil
+}
@@ -0,0 +1,114 @@
+package uci
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/samlotti/chess_anaylzer/uci/fakeengine"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordSession - runs one search on the fake engine, returns the transcript.
+func recordSession(t *testing.T, script *fakeengine.Script) []*TranscriptEntry {
+	var buf bytes.Buffer
+	u := NewUciTransport("fake", fakeengine.New(script).Transport())
+	u.SetTranscript(NewTranscript(&buf))
+	assert.Nil(t, u.Start(context.Background()))
+
+	bm, err := searchOnce(u)
+	u.Terminate()
+	<-u.exited
+
+	if script.Default.Crash {
+		assert.NotNil(t, err)
+	} else {
+		assert.Nil(t, err)
+		assert.Equal(t, "e2e4", bm.BestMove)
+	}
+
+	entries, err := ReadTranscript(&buf)
+	assert.Nil(t, err)
+	return entries
+}
+
+func searchOnce(u *UciProcess) (*UciBestMove, error) {
+	if err := u.SetPositionFen(testFen); err != nil {
+		return nil, err
+	}
+	o := NewGoOptions()
+	o.Depth = 5
+	if err := u.SendGo(o); err != nil {
+		return nil, err
+	}
+	return u.WaitMoveUpTo(context.Background(), 2*time.Second)
+}
+
+func TestTranscriptRecorded(t *testing.T) {
+	entries := recordSession(t, fakeengine.DefaultScript())
+
+	var lines []string
+	for _, e := range entries {
+		assert.False(t, e.Time.IsZero())
+		lines = append(lines, string(e.Dir)+" "+e.Line)
+	}
+	assert.Equal(t, "> uci", lines[0])
+	assert.Contains(t, lines, "< uciok")
+	assert.Contains(t, lines, "> position fen "+testFen)
+	assert.Contains(t, lines, "> go depth 5")
+	assert.Contains(t, lines, "< bestmove e2e4")
+	assert.Equal(t, "! terminated", lines[len(lines)-1])
+}
+
+func TestTranscriptReplay(t *testing.T) {
+	entries := recordSession(t, fakeengine.DefaultScript())
+
+	u := NewUciTransport("replay", NewReplayTransport(entries))
+	assert.Nil(t, u.Start(context.Background()))
+	defer u.Terminate()
+
+	bm, err := searchOnce(u)
+	assert.Nil(t, err)
+	assert.Equal(t, "e2e4", bm.BestMove)
+	assert.Contains(t, u.Options, "MultiPV")
+}
+
+func TestTranscriptReplayMismatch(t *testing.T) {
+	entries := recordSession(t, fakeengine.DefaultScript())
+
+	u := NewUciTransport("replay", NewReplayTransport(entries))
+	assert.Nil(t, u.Start(context.Background()))
+	defer u.Terminate()
+
+	err := u.SetPositionFen("8/8/8/8/8/8/8/K6k w - - 0 1")
+	assert.Nil(t, err)
+	<-u.exited
+
+	var mismatch *ReplayMismatchError
+	assert.True(t, errors.As(u.ExitError(), &mismatch))
+	assert.Equal(t, "position fen "+testFen, mismatch.Expected)
+}
+
+func TestTranscriptReplayCrash(t *testing.T) {
+	script := fakeengine.DefaultScript()
+	script.Default.Crash = true
+	script.Default.ExitCode = 3
+	entries := recordSession(t, script)
+
+	u := NewUciTransport("replay", NewReplayTransport(entries))
+	assert.Nil(t, u.Start(context.Background()))
+	defer u.Terminate()
+
+	_, err := searchOnce(u)
+	var exitErr *ReplayExitError
+	assert.True(t, errors.As(err, &exitErr))
+	assert.Contains(t, exitErr.Reason, "exit status 3")
+}
+
+func TestReadTranscriptInvalid(t *testing.T) {
+	_, err := ReadTranscript(strings.NewReader("2026-01-02T15:04:05.000000Z > uci\nnot a transcript line\n"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+}
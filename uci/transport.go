@@ -0,0 +1,69 @@
+package uci
+
+import (
+	"io"
+	"os/exec"
+)
+
+// Transport - connects a UciProcess to an engine.
+// The default runs a local binary, see NewExecTransport.
+type Transport interface {
+	// Start - starts the engine, returns the engine output and input.
+	Start() (io.ReadCloser, io.WriteCloser, error)
+
+	// Wait - waits for the engine to end and returns why.
+	// Only called once the output has been read to the end.
+	Wait() error
+
+	// Kill - ends the engine.
+	Kill() error
+
+	// Stderr - the tail of the engine error output.
+	Stderr() string
+}
+
+// execTransport - runs a local engine binary.
+type execTransport struct {
+	path   string
+	cmd    *exec.Cmd
+	stderr stderrTail
+}
+
+// NewExecTransport - runs the engine binary at path.
+func NewExecTransport(path string) Transport {
+	return &execTransport{path: path}
+}
+
+func (t *execTransport) Start() (io.ReadCloser, io.WriteCloser, error) {
+	t.cmd = exec.Command(t.path)
+
+	out, err := t.cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	in, err := t.cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	t.cmd.Stderr = &t.stderr
+
+	if err = t.cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	return out, in, nil
+}
+
+func (t *execTransport) Wait() error {
+	return t.cmd.Wait()
+}
+
+func (t *execTransport) Kill() error {
+	if t.cmd == nil || t.cmd.Process == nil {
+		return nil
+	}
+	return t.cmd.Process.Kill()
+}
+
+func (t *execTransport) Stderr() string {
+	return t.stderr.String()
+}
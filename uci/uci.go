@@ -2,10 +2,12 @@ package uci
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/samlotti/chess_anaylzer/chessboard/common"
 	"io"
-	"os/exec"
+	"log/slog"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,8 +25,6 @@ http://chess.grantnet.us/index/3/
 
 */
 
-var Verbose = true
-
 type UciState int16
 
 const (
@@ -34,14 +34,20 @@ const (
 	UciFailed              = 3
 )
 
-type EngineState int16
-
 const (
-	ENotReady    EngineState = 0
-	EOk                      = 1
-	ECalculating             = 2
+	// HandshakeTimeout - max wait for uciok when starting the engine
+	HandshakeTimeout = 10 * time.Second
+
+	// ReadyTimeout - max wait for readyok after isready
+	ReadyTimeout = 2 * time.Second
+
+	// StopTimeout - max wait for the bestmove after a stop has been sent
+	StopTimeout = 2 * time.Second
 )
 
+// ErrStopTimeout - the engine did not answer a stop with a bestmove.
+var ErrStopTimeout = errors.New("engine did not stop after a stop has been sent")
+
 type UciBestMove struct {
 	Err      error
 	BestMove string
@@ -173,103 +179,156 @@ func UciInfoParse(info string) *UciInfo {
 }
 
 type UciProcess struct {
-	lock   sync.Mutex
-	Engine string
-	epath  string
-	cmd    *exec.Cmd
-	state  UciState
-	stdin  io.ReadCloser
-	stdout io.WriteCloser
-	estate EngineState
+	lock      sync.Mutex
+	Engine    string
+	transport Transport
+	state     UciState
+	stdin     io.ReadCloser
+	stdout    io.WriteCloser
+
+	// searching - true from the go command until the bestmove is received.
+	searching bool
+
+	// Signals from the monitor, see monitor()
+	uciok    chan struct{}     // closed when the uci handshake completes
+	readyok  chan struct{}     // one entry for every readyok
+	bestmove chan *UciBestMove // one entry for every completed search
+	exited   chan struct{}     // closed when the engine process has ended
+
+	exitErr    *EngineExitError // set if the engine ended on its own
+	terminated bool             // Terminate was called, the exit is expected
 
 	callback chan *UciCallback
 
+	// transcript - records the session if set, see SetTranscript
+	transcript *Transcript
+
+	// log - of the engine, see SetLogger
+	log *slog.Logger
+
 	// options - The engine options.
 	Options map[string]string
 }
 
 // NewUci - creates a new instance of the engine!
+// The engine binary is in the Environment.EnginePath
 func NewUci(engine string) *UciProcess {
-	p := UciProcess{Engine: engine, epath: common.Environment.EnginePath}
+	return NewUciTransport(engine, NewExecTransport(UciManager().BinaryPath(engine)))
+}
+
+// NewUciTransport - creates a new instance of the engine reached through the transport.
+func NewUciTransport(engine string, transport Transport) *UciProcess {
+	p := UciProcess{Engine: engine, transport: transport}
 	p.state = UciNotStarted
-	p.estate = ENotReady
+	p.uciok = make(chan struct{})
+	p.readyok = make(chan struct{}, 1)
+	p.bestmove = make(chan *UciBestMove, 1)
+	p.exited = make(chan struct{})
 	p.Options = make(map[string]string)
+	p.log = common.Log().With(common.LogEngine, engine)
 	return &p
 }
 
-func (p *UciProcess) Start() error {
-	// p.cmd = exec.Command("./engines/" + p.Engine)
-	p.cmd = exec.Command(p.epath + p.Engine)
+// SetTranscript - records every line sent and received, call before Start.
+// The transcript is closed when the engine ends.
+func (p *UciProcess) SetTranscript(t *Transcript) {
+	p.transcript = t
+}
+
+// SetLogger - the logger of the engine, call before Start.
+// The lines exchanged are logged at debug.
+func (p *UciProcess) SetLogger(l *slog.Logger) {
+	p.log = l
+}
+
+// Start - starts the engine and waits for the uci handshake.
+// If the context has no deadline the HandshakeTimeout is used.
+func (p *UciProcess) Start(ctx context.Context) error {
 	var err error
 
-	p.stdin, err = p.cmd.StdoutPipe()
+	p.stdin, p.stdout, err = p.transport.Start()
 	if err != nil {
-		return err
-	}
-	p.stdout, err = p.cmd.StdinPipe()
-	if err != nil {
-		return err
-	}
-
-	if err = p.cmd.Start(); err != nil {
-		p.state = UciFailed
+		p.setState(UciFailed)
+		p.transcript.Note(fmt.Sprintf("%s: %s", transcriptExited, err))
+		_ = p.transcript.Close()
 		return err
 	}
 
 	//fmt.Println("Command running")
 
-	p.state = UciRunning
+	p.setState(UciRunning)
 
 	go p.monitor()
 
-	p.send("uci")
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, HandshakeTimeout)
+		defer cancel()
+	}
 
-	p.send("isready")
+	if err = p.send("uci"); err != nil {
+		return err
+	}
 
-	err = p.WaitOk(10 * time.Second)
+	select {
+	case <-p.uciok:
+	case <-p.exited:
+		return p.exitError()
+	case <-ctx.Done():
+		return fmt.Errorf("timeout waiting for uciok: %w", ctx.Err())
+	}
 
-	return err
+	return p.WaitReady(ctx)
 }
 
-// WaitOk -  Spin loop, is there a better way.
-func (p *UciProcess) WaitOk(timeout time.Duration) error {
-	st := time.Now()
-	for {
-		if p.GetEState() == EOk {
-			return nil
-		}
+// WaitReady - sends isready and waits for the readyok.
+// Waits at most ReadyTimeout.
+func (p *UciProcess) WaitReady(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, ReadyTimeout)
+	defer cancel()
 
-		if time.Now().Sub(st) > timeout {
-			return fmt.Errorf("timeout waiting for engine ok")
-		}
+	// A stale readyok must not answer this request
+	select {
+	case <-p.readyok:
+	default:
+	}
 
-		// keep this low, else has big slowdown on pgn analysis
-		time.Sleep(10 * time.Millisecond)
+	if err := p.send("isready"); err != nil {
+		return err
+	}
 
+	select {
+	case <-p.readyok:
+		return nil
+	case <-p.exited:
+		return p.exitError()
+	case <-ctx.Done():
+		return fmt.Errorf("timeout waiting for engine ok: %w", ctx.Err())
 	}
 }
 
+// monitor - reads the engine output and signals the waiting calls.
+// When the output ends the process exit is collected, see waitExit.
 func (p *UciProcess) monitor() {
 	defer func() {
-		p.state = UciStopped
 		if r := recover(); r != nil {
-			fmt.Println("Recovered in f", r)
-			p.state = UciFailed
+			p.log.Error("engine monitor failed", "panic", r)
 		}
+		p.waitExit()
+		close(p.exited)
 	}()
 	scanner := bufio.NewScanner(p.stdin)
 	for scanner.Scan() {
 		//fmt.Println("Waiting for engine!")
 		txt := scanner.Text()
-		if Verbose {
-			fmt.Printf("From Engine: %s\n", txt)
-		}
+		p.transcript.Received(txt)
+		p.log.Debug("from engine", "line", txt)
 
 		//if p.callback == nil && len(txt) > 0 {
 		//	fmt.Printf("Response from UCI but no callback to listen: %s\n", txt)
 		//}
 
-		if p.callback != nil {
+		if callback := p.getCallback(); callback != nil {
 			// fmt.Println("sending to callback")
 			data := &UciCallback{
 				Raw:      txt,
@@ -285,16 +344,16 @@ func (p *UciProcess) monitor() {
 			if data.BestMove != nil ||
 				data.Info != nil ||
 				data.Err != nil {
-				p.callback <- data
+				callback <- data
 			}
 		}
 
 		if txt == "uciok" {
-			p.SetEState(EOk)
+			p.setUciOk()
 		}
 
 		if txt == "readyok" {
-			p.SetEState(EOk)
+			signal(p.readyok)
 		}
 
 		if strings.HasPrefix(txt, "bestmove") {
@@ -313,39 +372,131 @@ func (p *UciProcess) monitor() {
 	// fmt.Println("Scanner exited!")
 }
 
+// waitExit - collects the exit of the process.
+// Only called once the output has been read to the end.
+func (p *UciProcess) waitExit() {
+	err := p.transport.Wait()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	defer p.transcript.Close()
+
+	p.searching = false
+	if p.terminated {
+		p.state = UciStopped
+		p.transcript.Note(transcriptTerminated)
+		p.log.Debug("engine ended")
+		return
+	}
+
+	p.state = UciFailed
+	p.exitErr = &EngineExitError{
+		Engine: p.Engine,
+		Err:    err,
+		Stderr: p.transport.Stderr(),
+	}
+	p.transcript.Note(fmt.Sprintf("%s: %s", transcriptExited, p.exitErr))
+	p.log.Warn("engine exited unexpectedly", "err", p.exitErr)
+}
+
+// ExitError - the reason the engine ended on its own, nil while running
+// or if it was terminated.
+func (p *UciProcess) ExitError() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.exitErr == nil {
+		return nil
+	}
+	return p.exitErr
+}
+
+// exitError - the error to return to calls waiting on an engine that has ended.
+func (p *UciProcess) exitError() error {
+	if err := p.ExitError(); err != nil {
+		return err
+	}
+	return fmt.Errorf("engine %s has been terminated", p.Engine)
+}
+
+// signal - non blocking send, the channels have a buffer of one
+// and a pending signal is as good as a new one.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
 // send - Sends a line to the chess engine.
 func (p *UciProcess) send(line string) error {
-	// fmt.Printf("==== Send: %s\n", line)
+	p.transcript.Sent(line)
+	p.log.Debug("to engine", "line", line)
 	_, err := fmt.Fprint(p.stdout, line)
 	if err != nil {
-		return err
+		return p.sendError(err)
 	}
 	_, err = fmt.Fprint(p.stdout, "\n")
 	if err != nil {
-		return err
+		return p.sendError(err)
 	}
 	// fmt.Println("Sent!")
 	return nil
 }
 
+// sendError - a failed write is usually the engine going away,
+// if so report why it went away.
+func (p *UciProcess) sendError(err error) error {
+	select {
+	case <-p.exited:
+		return p.exitError()
+	case <-time.After(StopTimeout):
+		return err
+	}
+}
+
 // Terminate - Terminate the process
 func (p *UciProcess) Terminate() {
+	p.lock.Lock()
+	p.terminated = true
 	p.state = UciStopped
+	p.lock.Unlock()
+
+	if p.stdout == nil {
+		// never started
+		return
+	}
 	_ = p.stdout.Close()
 	_ = p.stdin.Close()
-	_ = p.cmd.Process.Kill()
+	_ = p.transport.Kill()
+}
+
+func (p *UciProcess) GetState() UciState {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.state
 }
 
-func (p *UciProcess) GetEState() EngineState {
+func (p *UciProcess) setState(state UciState) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
-	return p.estate
+	p.state = state
 }
 
-func (p *UciProcess) SetEState(state EngineState) {
+// IsSearching - true while a go command is running
+func (p *UciProcess) IsSearching() bool {
 	p.lock.Lock()
 	defer p.lock.Unlock()
-	p.estate = state
+	return p.searching
+}
+
+func (p *UciProcess) setUciOk() {
+	select {
+	case <-p.uciok:
+		// already closed
+	default:
+		close(p.uciok)
+	}
 }
 
 // SetPositionFen - Will set the position
@@ -358,7 +509,12 @@ func (p *UciProcess) SetPositionFen(fen string) error {
 }
 
 func (p *UciProcess) checkReady() error {
-	if p.GetEState() != EOk {
+	select {
+	case <-p.exited:
+		return p.exitError()
+	default:
+	}
+	if !p.IsReadyForMove() {
 		return fmt.Errorf("engine not in ready state")
 	}
 	return nil
@@ -368,6 +524,13 @@ type GoOptions struct {
 	Depth      int
 	SearchMove string
 	//Fen        string
+
+	// The time limits in milliseconds, 0 = not sent
+	MoveTime int
+	WTime    int
+	BTime    int
+	WInc     int
+	BInc     int
 }
 
 func NewGoOptions() *GoOptions {
@@ -376,12 +539,21 @@ func NewGoOptions() *GoOptions {
 
 // SendGo - Send the go command to the engine, pass in options to
 // configure the command.  default will run indefinitely.
+// Use WaitBestMove or WaitMoveUpTo for the result.
 func (p *UciProcess) SendGo(opts *GoOptions) error {
 	if err := p.checkReady(); err != nil {
 		return err
 	}
 
-	p.SetEState(ECalculating)
+	// Not searching, so anything left over is from an earlier search.
+	// The bestmove is published under the lock, see setBestMove.
+	p.lock.Lock()
+	select {
+	case <-p.bestmove:
+	default:
+	}
+	p.searching = true
+	p.lock.Unlock()
 
 	str := "go"
 
@@ -389,6 +561,22 @@ func (p *UciProcess) SendGo(opts *GoOptions) error {
 		str = fmt.Sprintf("%s depth %d", str, opts.Depth)
 	}
 
+	for _, limit := range []struct {
+		name string
+		ms   int
+	}{
+		{"wtime", opts.WTime},
+		{"btime", opts.BTime},
+		{"winc", opts.WInc},
+		{"binc", opts.BInc},
+		{"movetime", opts.MoveTime},
+	} {
+		if limit.ms > 0 {
+			str = fmt.Sprintf("%s %s %d", str, limit.name, limit.ms)
+		}
+	}
+
+	// searchmoves must be last, the moves run to the end of the line
 	if len(opts.SearchMove) > 0 {
 		str = fmt.Sprintf("%s searchmoves %s", str, opts.SearchMove)
 	}
@@ -401,49 +589,93 @@ func (p *UciProcess) SendGo(opts *GoOptions) error {
 
 }
 
-//// Stop - Tell the engine to stop calculating.
-//func (p *UciProcess) Stop() error {
-//	if p.GetEState() != EOk {
-//		return fmt.Errorf("engine not in ready state")
-//	}
-//	return nil
-//}
-
 // SendStop - Tell the engine to stop calculating.
+// Does not wait, use Stop to wait for the bestmove.
 func (p *UciProcess) SendStop() error {
 	return p.send("stop")
 }
 
+// Stop - Tell the engine to stop calculating and wait for the bestmove.
+// Waits at most StopTimeout.  Returns nil if no search is running.
+func (p *UciProcess) Stop(ctx context.Context) (*UciBestMove, error) {
+	if !p.IsSearching() {
+		// It may have completed on its own, pass that bestmove on
+		select {
+		case bm := <-p.bestmove:
+			return bm, nil
+		default:
+			return nil, nil
+		}
+	}
+
+	if err := p.SendStop(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, StopTimeout)
+	defer cancel()
+
+	select {
+	case bm := <-p.bestmove:
+		return bm, nil
+	case <-p.exited:
+		return nil, p.exitError()
+	case <-ctx.Done():
+		p.log.Warn("engine did not stop after a stop has been sent")
+		return nil, ErrStopTimeout
+	}
+}
+
 // SetOption - set the options for the engine.
 // The options are stores in the engine Options map.
 func (p *UciProcess) SetOption(name string, val string) error {
 	return p.send(fmt.Sprintf("setoption name %s value %s", name, val))
 }
 
-// WaitMoveUpTo - wait for the move to complete, or send stop then wait a bit more
-func (p *UciProcess) WaitMoveUpTo(timeout time.Duration) error {
-	err := p.WaitOk(timeout)
-	if err == nil {
-		return nil
-
-	}
-
-	if Verbose {
-		fmt.Println("Timeout waiting for the engine, sending a stop.")
+// WaitBestMove - wait for the search to complete.
+// If the context ends first, the search is stopped and the context error
+// is returned along with the bestmove of the stopped search.
+func (p *UciProcess) WaitBestMove(ctx context.Context) (*UciBestMove, error) {
+	select {
+	case bm := <-p.bestmove:
+		return bm, nil
+	case <-p.exited:
+		return nil, p.exitError()
+	case <-ctx.Done():
 	}
 
-	p.SendStop()
-
-	err = p.WaitOk(2 * time.Second)
+	bm, err := p.Stop(context.Background())
 	if err != nil {
-		fmt.Println("Engine did not stop after a stop has been sent.")
+		return bm, err
 	}
+	return bm, ctx.Err()
+}
 
-	return err
+// WaitMoveUpTo - wait for the move to complete, or send stop then wait a bit more
+// Reaching the timeout is not an error, the engine was told to stop and answered.
+func (p *UciProcess) WaitMoveUpTo(ctx context.Context, timeout time.Duration) (*UciBestMove, error) {
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	bm, err := p.WaitBestMove(tctx)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		p.log.Debug("timeout waiting for the engine, sent a stop")
+		return bm, nil
+	}
+	return bm, err
 }
 
+// IsReadyForMove - handshake completed, running and not searching.
 func (p *UciProcess) IsReadyForMove() bool {
-	return p.GetEState() == EOk
+	select {
+	case <-p.uciok:
+	default:
+		return false
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.state == UciRunning && !p.searching
 }
 
 // addOption - parses the option line from the engine
@@ -451,6 +683,11 @@ func (p *UciProcess) IsReadyForMove() bool {
 func (p *UciProcess) addOption(txt string) {
 	txt = strings.TrimPrefix(txt, "option name ")
 	sects := strings.SplitN(txt, " ", 2)
+	if len(sects) < 2 {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	p.Options[sects[0]] = sects[1]
 }
 
@@ -459,15 +696,27 @@ info depth 15 seldepth 30 hashfull 58 tbhits 0 nodes 1720962 nps 1103672 score c
 bestmove f8g8 ponder h1f1
 */
 
-// setBestMove - parses the option line from the engine
-// ex: option name Ponder type check default false
+// setBestMove - parses the bestmove line from the engine and signals
+// the end of the search.
+// ex: bestmove f8g8 ponder h1f1
 func (p *UciProcess) setBestMove(txt string) {
-	// bestmove f8g8 ponder h1f1
-	//txt = strings.TrimPrefix(txt, "option name ")
-	//sects := strings.SplitN(txt, " ", 2)
-	//p.Options[sects[0]] = sects[1]
+	bm := UciBestMoveParse(txt)
 
-	p.SetEState(EOk)
+	// The bestmove is in the channel before the search is seen as ended,
+	// so Stop finds it and the next SendGo drains it.
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	select {
+	case p.bestmove <- bm:
+	default:
+		// Nobody collected the previous one, replace it.
+		select {
+		case <-p.bestmove:
+		default:
+		}
+		p.bestmove <- bm
+	}
+	p.searching = false
 }
 
 // addMoveInfo - parses the option line from the engine
@@ -482,16 +731,22 @@ func (p *UciProcess) addMoveInfo(txt string) {
 }
 
 func (p *UciProcess) SetAsyncChannel(callbacks chan *UciCallback) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	p.callback = callbacks
 }
 
+func (p *UciProcess) getCallback() chan *UciCallback {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.callback
+}
+
 // SendUciNewGame - sends message and returns when it is ready.
-func (p *UciProcess) SendUciNewGame() error {
-	p.SetEState(ENotReady)
+func (p *UciProcess) SendUciNewGame(ctx context.Context) error {
 	err := p.send("ucinewgame")
-	err = p.send("isready")
-	if err == nil {
-		err = p.WaitMoveUpTo(2 * time.Second)
+	if err != nil {
+		return err
 	}
-	return err
+	return p.WaitReady(ctx)
 }
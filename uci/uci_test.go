@@ -1,23 +1,46 @@
 package uci
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"github.com/samlotti/chess_anaylzer/uci/fakeengine"
 	"github.com/stretchr/testify/assert"
 	"testing"
 	"time"
 )
 
+const testFen = "2q1rr1k/3bbnnp/p2p1pp1/2pPp3/PpP1P1P1/1P2BNNP/2BQ1PRK/7R b - -"
+
+// newFakeUci - a started engine playing the script in process.
+func newFakeUci(t *testing.T, script *fakeengine.Script) (*UciProcess, *fakeengine.Engine) {
+	e := fakeengine.New(script)
+	u := NewUciTransport("fake", e.Transport())
+	err := u.Start(context.Background())
+	assert.Nil(t, err)
+	t.Cleanup(u.Terminate)
+	return u, e
+}
+
 func TestSegments(t *testing.T) {
 
-	u := NewUci("zahak")
+	script := fakeengine.DefaultScript()
+	script.Positions[testFen] = &fakeengine.Response{
+		Lines: []string{
+			"info depth 14 seldepth 27 score cp -124 nodes 1720962 nps 1103672 multipv 1 pv c8c7 f3e1",
+			"info depth 15 seldepth 30 score cp -120 nodes 1720962 nps 1103672 multipv 1 pv f8g8 h1f1",
+			"bestmove f8g8 ponder h1f1",
+		},
+	}
+	u, e := newFakeUci(t, script)
 	assert.NotNil(t, u)
 
-	err := u.Start()
-	assert.Nil(t, err)
-	err = u.WaitOk(1 * time.Second)
+	err := u.WaitReady(context.Background())
 	assert.Nil(t, err)
 
 	err = // u.SetPositionFen("r2qnrnk/p2b2b1/1p1p2pp/2pPpp2/1PP1P3/PRNBB3/3QNPPP/5RK1 w - -")
-		u.SetPositionFen("2q1rr1k/3bbnnp/p2p1pp1/2pPp3/PpP1P1P1/1P2BNNP/2BQ1PRK/7R b - -")
+		u.SetPositionFen(testFen)
 	assert.Nil(t, err)
 
 	err = u.SetOption("MultiPV", "15")
@@ -30,16 +53,148 @@ func TestSegments(t *testing.T) {
 
 	err = u.SendGo(o)
 	assert.Nil(t, err)
+	assert.False(t, u.IsReadyForMove())
 
-	err = u.WaitMoveUpTo(5 * time.Second)
+	bm, err := u.WaitMoveUpTo(context.Background(), 5*time.Second)
 	assert.Nil(t, err)
+	assert.Equal(t, "f8g8", bm.BestMove)
+	assert.Equal(t, "h1f1", bm.Ponder)
 
 	assert.True(t, u.IsReadyForMove())
+	assert.Equal(t, "15", e.Option("MultiPV"))
+	assert.Equal(t, "type spin default 1 min 1 max 500", u.Options["MultiPV"])
+	assert.Contains(t, e.Commands(), "go depth 15")
 
 	u.Terminate()
 
 }
 
+func TestCallbacks(t *testing.T) {
+
+	script := fakeengine.DefaultScript()
+	script.Positions[testFen] = &fakeengine.Response{
+		Lines: []string{
+			"info depth 1 score cp 20 multipv 1 pv c8c7",
+			"info depth x score cp 20 multipv 1 pv c8c7",
+			"bestmove c8c7",
+		},
+	}
+	u, _ := newFakeUci(t, script)
+
+	cb := make(chan *UciCallback, 10)
+	u.SetAsyncChannel(cb)
+	assert.Nil(t, u.SetPositionFen(testFen))
+	assert.Nil(t, u.SendGo(NewGoOptions()))
+	_, err := u.WaitBestMove(context.Background())
+	assert.Nil(t, err)
+
+	assert.Equal(t, 3, len(cb))
+	first := <-cb
+	assert.Nil(t, first.Info.Err)
+	assert.Equal(t, 20, first.Info.ScoreCP)
+	malformed := <-cb
+	assert.NotNil(t, malformed.Info.Err)
+	last := <-cb
+	assert.Equal(t, "c8c7", last.BestMove.BestMove)
+
+}
+
+func TestTimeoutSendsStop(t *testing.T) {
+
+	script := fakeengine.DefaultScript()
+	script.Default = &fakeengine.Response{
+		Lines: []string{"info depth 1 score cp 20 multipv 1 pv e2e4", "bestmove e2e4"},
+		Hang:  true,
+	}
+	u, e := newFakeUci(t, script)
+
+	assert.Nil(t, u.SendGo(NewGoOptions()))
+	bm, err := u.WaitMoveUpTo(context.Background(), 50*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, "e2e4", bm.BestMove)
+	assert.Contains(t, e.Commands(), "stop")
+	assert.True(t, u.IsReadyForMove())
+
+}
+
+func TestCancelSendsStop(t *testing.T) {
+
+	script := fakeengine.DefaultScript()
+	script.Default = &fakeengine.Response{
+		Lines:   []string{"info depth 1 score cp 20 multipv 1 pv d2d4", "info depth 2 score cp 20 multipv 1 pv e2e4"},
+		DelayMs: 50,
+		Hang:    true,
+	}
+	u, e := newFakeUci(t, script)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.Nil(t, u.SendGo(NewGoOptions()))
+	go func() {
+		time.Sleep(75 * time.Millisecond)
+		cancel()
+	}()
+	bm, err := u.WaitMoveUpTo(ctx, 5*time.Second)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, "d2d4", bm.BestMove)
+	assert.Contains(t, e.Commands(), "stop")
+	assert.True(t, u.IsReadyForMove())
+
+}
+
+func TestStopWithoutBestMove(t *testing.T) {
+
+	script := fakeengine.DefaultScript()
+	script.Default = &fakeengine.Response{Hang: true, NoBestMove: true}
+	u, _ := newFakeUci(t, script)
+
+	assert.Nil(t, u.SendGo(NewGoOptions()))
+	_, err := u.WaitMoveUpTo(context.Background(), 10*time.Millisecond)
+	assert.ErrorIs(t, err, ErrStopTimeout)
+
+	// Still searching as far as we know
+	assert.NotNil(t, u.SendGo(NewGoOptions()))
+
+}
+
+func TestCrashDuringSearch(t *testing.T) {
+
+	script := fakeengine.DefaultScript()
+	script.Default = &fakeengine.Response{
+		Lines:    []string{"info depth 1 score cp 20 multipv 1 pv e2e4"},
+		Crash:    true,
+		ExitCode: 3,
+		Stderr:   "segfault in search",
+	}
+	u, _ := newFakeUci(t, script)
+
+	assert.Nil(t, u.SendGo(NewGoOptions()))
+	_, err := u.WaitMoveUpTo(context.Background(), 5*time.Second)
+
+	var exitErr *EngineExitError
+	assert.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, "fake", exitErr.Engine)
+	assert.Equal(t, "exit status 3", exitErr.Err.Error())
+	assert.Equal(t, "segfault in search\n", exitErr.Stderr)
+	assert.Equal(t, UciState(UciFailed), u.GetState())
+
+	// Everything after reports the exit
+	err = u.SetPositionFen(testFen)
+	assert.True(t, errors.As(err, &exitErr))
+
+}
+
+func TestCrashOnStart(t *testing.T) {
+
+	script := fakeengine.DefaultScript()
+	script.CrashOnUci = 1
+	u := NewUciTransport("fake", fakeengine.New(script).Transport())
+
+	err := u.Start(context.Background())
+	var exitErr *EngineExitError
+	assert.True(t, errors.As(err, &exitErr))
+
+}
+
 func TestParseInfo1(t *testing.T) {
 
 	u := UciInfoParse(
@@ -80,3 +235,59 @@ func TestParseBestmove1(t *testing.T) {
 	assert.Equal(t, "g7h6", u.Ponder)
 
 }
+
+func TestEngineExitError(t *testing.T) {
+
+	var st stderrTail
+	st.Write([]byte("first line\n"))
+	st.Write(make([]byte, MaxStderrCapture))
+	st.Write([]byte("segfault in search\n"))
+	assert.Equal(t, MaxStderrCapture, len(st.String()))
+
+	e := &EngineExitError{Engine: "zahak", Err: fmt.Errorf("exit status 3"), Stderr: "segfault in search\n"}
+	assert.Equal(t, "engine zahak exited: exit status 3, stderr: segfault in search", e.Error())
+	assert.Equal(t, "exit status 3", errors.Unwrap(e).Error())
+
+}
+
+func TestGoTimeLimits(t *testing.T) {
+	u, e := newFakeUci(t, fakeengine.DefaultScript())
+
+	assert.Nil(t, u.SetPositionFen(testFen))
+	assert.Nil(t, u.SendGo(&GoOptions{WTime: 60000, BTime: 59000, WInc: 1000, BInc: 1000, SearchMove: "c8c7"}))
+	_, err := u.WaitBestMove(context.Background())
+	assert.Nil(t, err)
+	assert.Contains(t, e.Commands(), "go wtime 60000 btime 59000 winc 1000 binc 1000 searchmoves c8c7")
+}
+
+func TestBestMoveAfterSearchEnds(t *testing.T) {
+	const otherFen = "8/8/8/8/8/4k3/8/4K2R w K - 0 1"
+	script := fakeengine.DefaultScript()
+	script.Positions[otherFen] = &fakeengine.Response{Lines: []string{"bestmove h1h8"}}
+	u, _ := newFakeUci(t, script)
+
+	for i := 0; i < 500; i++ {
+		// Stop as soon as the search has ended gets its bestmove
+		assert.Nil(t, u.SetPositionFen(testFen))
+		assert.Nil(t, u.SendGo(NewGoOptions()))
+		for u.IsSearching() {
+			runtime.Gosched()
+		}
+		bm, err := u.Stop(context.Background())
+		assert.Nil(t, err)
+		if assert.NotNil(t, bm, "attempt %d", i) {
+			assert.Equal(t, "e2e4", bm.BestMove)
+		}
+
+		// A new search never gets the bestmove of the one before
+		assert.Nil(t, u.SendGo(NewGoOptions()))
+		for u.IsSearching() {
+			runtime.Gosched()
+		}
+		assert.Nil(t, u.SetPositionFen(otherFen))
+		assert.Nil(t, u.SendGo(NewGoOptions()))
+		bm, err = u.WaitBestMove(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, "h1h8", bm.BestMove, "attempt %d", i)
+	}
+}